@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/admin"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/agent"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/engine"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/i18n"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/logging"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/pipeline"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/probe"
+)
+
+// localVantage names the coordinator's own measurements in the merge.
+const localVantage = "local"
+
+const agentUsage = `usage: mcis agent [--listen ADDR] [--name NAME] [--concurrency N]
+
+Runs a probe agent: a search started elsewhere with --agent http://<this host>:<port>
+sends its candidates here and merges the latencies measured from this vantage point.
+
+`
+
+// agentOptions holds the flags of "mcis agent".
+type agentOptions struct {
+	listen string
+	name   string
+	concur int
+	auth   admin.Auth
+}
+
+func newAgentFlagSet() (*flag.FlagSet, *agentOptions) {
+	var ao agentOptions
+	hostname, _ := os.Hostname()
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, agentUsage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&ao.listen, "listen", ":9090", "Address to serve probe requests on")
+	fs.StringVar(&ao.name, "name", hostname, "Vantage point name reported to the coordinator (used by --agent-weight)")
+	fs.IntVar(&ao.concur, "concurrency", 50, "Probe concurrency")
+	registerAuthFlags(fs, &ao.auth)
+	return fs, &ao
+}
+
+// agentCommand implements "mcis agent" and returns the exit code.
+func agentCommand(args []string) int {
+	fs, ao := newAgentFlagSet()
+	if err := parseFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "error: unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	auth := ao.auth
+	if !auth.Enabled() {
+		fmt.Fprintln(os.Stderr, "agent: warning: no --auth-token or --tls-ca; anyone who can reach this port can make it probe arbitrary IPs")
+	}
+	srv := admin.New(ao.listen)
+	if err := srv.SetAuth(auth); err != nil {
+		fmt.Fprintln(os.Stderr, "error: agent:", err)
+		return 2
+	}
+	srv.Handle(agent.ProbePath, agent.NewHandler(ao.name, ao.concur, nil))
+	fmt.Fprintf(os.Stderr, "agent: %s serving probes on %s\n", ao.name, ao.listen)
+	if err := srv.ListenAndServe(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "error: agent:", err)
+		return 1
+	}
+	return 0
+}
+
+// agentSetup is what a coordinating run needs to use its agents.
+type agentSetup struct {
+	merger *agent.Merger
+	client *agent.Client
+}
+
+// setupAgents validates the agent flags, or returns nil when no agent is
+// configured.
+func setupAgents(o *options) (*agentSetup, error) {
+	if len(o.agents) == 0 {
+		return nil, nil
+	}
+	auth := o.auth
+	tlsCfg, err := auth.ClientTLS()
+	if err != nil {
+		return nil, err
+	}
+	weights := make(map[string]float64, len(o.agentWeights))
+	for _, kv := range o.agentWeights {
+		name, val, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid --agent-weight %q (want NAME=WEIGHT)", kv)
+		}
+		w, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --agent-weight %q: %w", kv, err)
+		}
+		weights[name] = w
+	}
+	merger, err := agent.NewMerger(o.agentMerge, weights)
+	if err != nil {
+		return nil, err
+	}
+	return &agentSetup{merger: merger, client: agent.NewClient(o.agentTimeout, auth.Token, tlsCfg)}, nil
+}
+
+// probeAgents re-probes the candidates from every agent and re-ranks them by
+// the merged latency. Candidates that failed from any vantage point are
+// dropped; an agent that cannot be reached is left out of the merge.
+func probeAgents(ctx context.Context, o *options, agents *agentSetup, probeCfg probe.Config, top []engine.TopResult) []engine.TopResult {
+	req := agent.ProbeRequest{Probe: probeCfg, IPs: make([]netip.Addr, len(top))}
+	for i, r := range top {
+		req.IPs[i] = r.IP
+	}
+
+	responses := make([]*agent.ProbeResponse, len(o.agents))
+	var wg sync.WaitGroup
+	for i, url := range o.agents {
+		wg.Go(func() {
+			var resp agent.ProbeResponse
+			err := pipeline.Protect(func() (err error) {
+				resp, err = agents.client.Probe(ctx, url, req)
+				return err
+			})
+			if err != nil {
+				workerPanics.add("agent "+url, err)
+				i18n.Fprintf(os.Stderr, "agent: %s: %v (left out of the merge)\n", url, err)
+				return
+			}
+			if resp.Agent == "" {
+				resp.Agent = url
+			}
+			responses[i] = &resp
+		})
+	}
+	wg.Wait()
+
+	merged := top[:0:0]
+	dropped := 0
+	for i, r := range top {
+		samples := []agent.Sample{{Vantage: localVantage, OK: r.OK, MS: float64(r.TotalMS)}}
+		r.VantageMS = map[string]float64{localVantage: float64(r.TotalMS)}
+		for _, resp := range responses {
+			if resp == nil {
+				continue
+			}
+			ar := resp.Results[i]
+			samples = append(samples, agent.Sample{Vantage: resp.Agent, OK: ar.OK, MS: float64(ar.TotalMS)})
+			if ar.OK {
+				r.VantageMS[resp.Agent] = float64(ar.TotalMS)
+			}
+		}
+		ms, ok := agents.merger.Merge(samples)
+		if !ok {
+			dropped++
+			continue
+		}
+		r.ScoreMS = ms
+		merged = append(merged, r)
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].ScoreMS < merged[j].ScoreMS })
+
+	vantages := 1 + countNonNil(responses)
+	if dropped > 0 {
+		i18n.Fprintf(os.Stderr, "agent: merged %d vantage points (%s), dropped %d candidates that failed somewhere\n",
+			vantages, o.agentMerge, dropped)
+	} else {
+		slog.Debug("merged the agent probes", logging.Phase("agents"), "vantage_points", vantages, "merge", o.agentMerge)
+	}
+	return merged
+}
+
+func countNonNil(responses []*agent.ProbeResponse) int {
+	n := 0
+	for _, r := range responses {
+		if r != nil {
+			n++
+		}
+	}
+	return n
+}
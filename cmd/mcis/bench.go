@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	mrand "math/rand"
+	"net/netip"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/cidr"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/color"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/engine"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/simulate"
+)
+
+const benchUsage = `usage: mcis bench [bench flags] [search flags...]
+
+Runs the search against a latency model instead of the network, for every
+strategy, budget and seed, and compares how good the IPs are that each one
+finds, and how soon. The model replays a --dataset of recorded probes (the
+--all-results or -out jsonl file of earlier runs), or is that of --simulate
+(--simulate-model, or synthetic subnets).
+
+A strategy is a name and the search flags it changes; the other search
+flags apply to every strategy. Without --strategy the search flags are
+benchmarked as they are. Uniform random sampling of the same budget runs
+as a baseline.
+
+Example:
+  mcis bench --dataset all.jsonl --cidr-file ipv4cidr.txt --budgets 500,2000 \
+    --strategy "wide=--heads 8 --beam 64" --strategy "deep=--split-step-v4 4"
+
+`
+
+// benchOptions holds the flags of "mcis bench".
+type benchOptions struct {
+	options // search flags, common to all strategies
+
+	dataset    string
+	strategies repeatStringFlag
+	budgets    string
+	runs       int
+	baseline   bool
+}
+
+func newBenchFlagSet() (*flag.FlagSet, *benchOptions) {
+	var bo benchOptions
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, benchUsage)
+		fs.PrintDefaults()
+	}
+	registerFlags(fs, &bo.options)
+	fs.StringVar(&bo.dataset, "dataset", "", "Replay these recorded probe results (JSON Lines of --all-results or -out jsonl) instead of the --simulate model")
+	fs.Var(&bo.strategies, "strategy", "Strategy to compare, as NAME=FLAGS with the search flags it changes, e.g. \"wide=--heads 8 --beam 64\" (repeatable)")
+	fs.StringVar(&bo.budgets, "budgets", "", "Comma-separated probe budgets to run every strategy with (default --budget)")
+	fs.IntVar(&bo.runs, "runs", 3, "Runs per strategy and budget, with the seeds --seed, --seed+1, ...; the table shows their mean")
+	fs.BoolVar(&bo.baseline, "baseline", true, "Also run uniform random sampling of the same budget (--baseline=false to skip)")
+	return fs, &bo
+}
+
+// benchStrategy is a strategy to benchmark; o is nil for the random
+// baseline.
+type benchStrategy struct {
+	name string
+	o    *options
+}
+
+// benchRow holds the mean results of a strategy at a budget.
+type benchRow struct {
+	strategy string
+	budget   int
+	bestMS   float64 // score of the best IP found
+	topMS    float64 // mean score of the top IPs
+	trueMS   float64 // mean latency of the subnets of the top IPs in the model
+	okShare  float64 // share of the probes that succeeded
+	// firstGood is the mean number of probes until the first IP at or
+	// under --good-ms, over the found runs of them.
+	firstGood float64
+	found     int
+	runs      int
+}
+
+// benchCommand implements "mcis bench" and returns the exit code.
+func benchCommand(args []string) int {
+	fs, bo := newBenchFlagSet()
+	if err := parseFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "error: unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		return 2
+	}
+	if err := tuneDefaults(fs, &bo.options); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 2
+	}
+	if err := setupLogging(bo.logLevel, bo.logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 2
+	}
+	budgets, err := benchBudgets(bo)
+	if err == nil && bo.runs <= 0 {
+		err = fmt.Errorf("--runs must be > 0, got %d", bo.runs)
+	}
+	if err == nil && bo.dataset != "" && bo.simulateModel != "" {
+		err = errors.New("--dataset and --simulate-model cannot be combined")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 2
+	}
+	strategies, err := benchStrategies(args, bo)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 2
+	}
+	sim, prefixes, err := benchModel(bo)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error: bench:", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	seed := bo.seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	fmt.Fprintf(os.Stderr, "bench: %d strategies x %d budgets x %d runs over %d prefixes (seed %d)\n", len(strategies), len(budgets), bo.runs, len(prefixes), seed)
+	var rows []benchRow
+	for _, b := range budgets {
+		for _, s := range strategies {
+			row, err := benchStrategyRuns(ctx, &bo.options, sim, prefixes, s, b, seed, bo.runs)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: bench: %s at budget %d: %v\n", s.name, b, err)
+				return 1
+			}
+			rows = append(rows, row)
+		}
+	}
+	writeBenchTable(os.Stdout, rows)
+	return 0
+}
+
+// benchBudgets returns the budgets of --budgets, or --budget.
+func benchBudgets(bo *benchOptions) ([]int, error) {
+	if bo.budgets == "" {
+		return []int{bo.budget}, nil
+	}
+	var budgets []int
+	for _, s := range strings.Split(bo.budgets, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("--budgets: %q is not a budget > 0", s)
+		}
+		budgets = append(budgets, n)
+	}
+	return budgets, nil
+}
+
+// benchStrategies returns the strategies of --strategy, each with the search
+// flags of args changed by its own, and the baseline.
+func benchStrategies(args []string, bo *benchOptions) ([]benchStrategy, error) {
+	var strategies []benchStrategy
+	for _, spec := range bo.strategies {
+		name, flags, ok := strings.Cut(spec, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("--strategy %q: want NAME=FLAGS", spec)
+		}
+		if name == "random" || slices.ContainsFunc(strategies, func(s benchStrategy) bool { return s.name == name }) {
+			return nil, fmt.Errorf("--strategy %s: the name is taken", name)
+		}
+		fs, so := newBenchFlagSet()
+		fs.SetOutput(io.Discard)
+		fs.Usage = func() {}
+		if err := parseFlags(fs, append(slices.Clone(args), strings.Fields(flags)...)); err != nil {
+			return nil, fmt.Errorf("--strategy %s: %w", name, err)
+		}
+		if fs.NArg() > 0 {
+			return nil, fmt.Errorf("--strategy %s: unexpected arguments: %s", name, strings.Join(fs.Args(), " "))
+		}
+		if err := tuneDefaults(fs, &so.options); err != nil {
+			return nil, fmt.Errorf("--strategy %s: %w", name, err)
+		}
+		strategies = append(strategies, benchStrategy{name: name, o: &so.options})
+	}
+	if len(strategies) == 0 {
+		strategies = append(strategies, benchStrategy{name: "default", o: &bo.options})
+	}
+	for _, s := range strategies {
+		if err := checkFlags(s.o); err != nil {
+			return nil, fmt.Errorf("--strategy %s: %w", s.name, err)
+		}
+	}
+	if bo.baseline {
+		strategies = append(strategies, benchStrategy{name: "random"})
+	}
+	return strategies, nil
+}
+
+// benchModel returns the model the strategies are run against and the
+// prefixes they search: those of --cidr and --cidr-file, or else the
+// subnets of the --dataset.
+func benchModel(bo *benchOptions) (*simulate.Model, []netip.Prefix, error) {
+	prefixes, err := cidrFlags(&bo.options)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bo.cidrFile != "" {
+		ps, err := cidr.ReadRangesFile(bo.cidrFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		prefixes = append(prefixes, ps...)
+	}
+
+	if bo.dataset == "" {
+		if len(prefixes) == 0 {
+			return nil, nil, errors.New("no CIDR provided (use --cidr, --cidr-file or --dataset)")
+		}
+		o := bo.options
+		o.simulate = true
+		sim, err := simulationModel(&o)
+		return sim, prefixes, err
+	}
+
+	samples, err := readDataset(bo.dataset)
+	if err != nil {
+		return nil, nil, err
+	}
+	rules := simulate.Fit(samples)
+	if len(rules) == 0 {
+		return nil, nil, fmt.Errorf("%s: no probe results", bo.dataset)
+	}
+	if len(prefixes) == 0 {
+		for _, r := range rules {
+			prefixes = append(prefixes, r.Prefix)
+		}
+	}
+	// Subnets without a recorded probe never answer: the replay knows
+	// nothing better of them.
+	sim := simulate.New(simulate.Config{
+		Rules:   rules,
+		Unknown: &simulate.Subnet{Latency: bo.timeout, Loss: 1, Colo: "SIM"},
+		Seed:    bo.seed,
+		Timeout: bo.timeout,
+	})
+	return sim, prefixes, nil
+}
+
+// readDataset reads the probe results of a JSON Lines file of results.
+func readDataset(path string) ([]simulate.Sample, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	var samples []simulate.Sample
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		var r engine.TopResult
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if !r.IP.IsValid() {
+			return nil, fmt.Errorf("%s:%d: no ip", path, line)
+		}
+		s := simulate.Sample{IP: r.IP, OK: r.OK, MS: float64(r.TotalMS), Colo: r.Trace["colo"]}
+		if r.DownloadOK {
+			s.Mbps = r.DownloadMbps
+		}
+		samples = append(samples, s)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return samples, nil
+}
+
+// benchStrategyRuns runs s at budget runs times and averages the results.
+func benchStrategyRuns(ctx context.Context, base *options, sim *simulate.Model, prefixes []netip.Prefix, s benchStrategy, budget int, seed int64, runs int) (benchRow, error) {
+	row := benchRow{strategy: s.name, budget: budget, runs: runs}
+	o := s.o
+	if o == nil {
+		o = base
+	}
+	timeoutMS := float64(o.timeout.Milliseconds())
+	for i := range runs {
+		var top []engine.TopResult
+		var probes, ok int64
+		first := 0
+		n := 0
+		onResult := func(r engine.TopResult) {
+			n++
+			if first == 0 && r.OK && r.ScoreMS <= o.goodMS {
+				first = n
+			}
+		}
+		if s.o == nil {
+			top, probes, ok = randomSearch(ctx, sim, prefixes, budget, o.topN, seed+int64(i), timeoutMS, onResult)
+		} else {
+			cfg := engineConfig(o)
+			cfg.Budget = budget
+			cfg.Seed = seed + int64(i)
+			cfg.TraceProbe = sim.Trace
+			cfg.Limiter = nil // the model answers at once; --pps only slows the bench
+			cfg.OnResult = onResult
+			res, err := engine.New(cfg, probeConfig(o)).Run(ctx, engine.Request{CIDRs: prefixes, Probe: probeConfig(o)})
+			if err != nil {
+				return row, err
+			}
+			top, probes, ok = res.Top, res.Stats.Probes, res.Stats.OK
+		}
+		if err := ctx.Err(); err != nil {
+			return row, err
+		}
+
+		best := 2 * timeoutMS
+		var sum, truth float64
+		for _, r := range top {
+			best = min(best, r.ScoreMS)
+			sum += r.ScoreMS
+			truth += float64(sim.Lookup(r.IP).Latency) / float64(time.Millisecond)
+		}
+		if len(top) > 0 {
+			row.topMS += sum / float64(len(top)) / float64(runs)
+			row.trueMS += truth / float64(len(top)) / float64(runs)
+		}
+		row.bestMS += best / float64(runs)
+		if probes > 0 {
+			row.okShare += float64(ok) / float64(probes) / float64(runs)
+		}
+		if first > 0 {
+			row.firstGood += float64(first)
+			row.found++
+		}
+	}
+	if row.found > 0 {
+		row.firstGood /= float64(row.found)
+	}
+	return row, nil
+}
+
+// randomSearch is the baseline: it probes budget uniformly random IPs of
+// prefixes and keeps the best topN.
+func randomSearch(ctx context.Context, sim *simulate.Model, prefixes []netip.Prefix, budget, topN int, seed int64, timeoutMS float64, onResult func(engine.TopResult)) (top []engine.TopResult, probes, ok int64) {
+	// Draw the prefixes by their number of addresses.
+	weights := make([]float64, len(prefixes))
+	total := 0.0
+	for i, p := range prefixes {
+		total += math.Exp2(float64(p.Addr().BitLen() - p.Bits()))
+		weights[i] = total
+	}
+	r := mrand.New(mrand.NewSource(seed))
+	best := engine.NewTopNCollector(topN)
+	for ; probes < int64(budget) && ctx.Err() == nil; probes++ {
+		i, _ := slices.BinarySearch(weights, r.Float64()*total)
+		p := prefixes[min(i, len(prefixes)-1)]
+		ip := cidr.RandomAddr(p, r)
+		pr := sim.Trace(ctx, ip)
+		res := engine.TopResult{IP: ip, Prefix: p, OK: pr.OK, Status: pr.Status, Error: pr.Error, TotalMS: pr.TotalMS, ScoreMS: float64(pr.TotalMS), Trace: pr.Trace}
+		if !pr.OK {
+			res.ScoreMS = timeoutMS * 2
+		} else {
+			ok++
+		}
+		onResult(res)
+		best.Consider(res)
+	}
+	return best.Snapshot(), probes, ok
+}
+
+// writeBenchTable writes the comparison of rows, marking the strategy with
+// the best IPs, those of the lowest true latency, at each budget.
+func writeBenchTable(w io.Writer, rows []benchRow) {
+	width := len("STRATEGY")
+	winner := make(map[int]benchRow)
+	for _, r := range rows {
+		width = max(width, len(r.strategy))
+		if wr, ok := winner[r.budget]; !ok || r.trueMS < wr.trueMS {
+			winner[r.budget] = r
+		}
+	}
+	fmt.Fprintf(w, "  %-*s %8s %9s %9s %9s %6s %12s\n", width, "STRATEGY", "BUDGET", "BEST_MS", "TOP_MS", "TRUE_MS", "OK%", "FIRST_GOOD")
+	for _, r := range rows {
+		mark := "  "
+		if wr := winner[r.budget]; wr.strategy == r.strategy {
+			mark = color.Wrap(w, color.Green, "*") + " "
+		}
+		first := "-"
+		if r.found > 0 {
+			first = fmt.Sprintf("%.0f", r.firstGood)
+			if r.found < r.runs {
+				first += fmt.Sprintf(" (%d/%d)", r.found, r.runs)
+			}
+		}
+		fmt.Fprintf(w, "%s%-*s %8d %9.1f %9.1f %9.1f %6.1f %12s\n", mark, width, r.strategy, r.budget, r.bestMS, r.topMS, r.trueMS, 100*r.okShare, first)
+	}
+}
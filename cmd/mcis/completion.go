@@ -0,0 +1,508 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/agent"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/color"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/dns"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/i18n"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/icmpping"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/logging"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/notify"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/publish"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/tune"
+)
+
+const completionUsage = `usage: mcis completion <bash|zsh|fish|powershell>
+
+Examples:
+  source <(mcis completion bash)
+  mcis completion zsh > "${fpath[1]}/_mcis"
+  mcis completion fish > ~/.config/fish/completions/mcis.fish
+  mcis completion powershell | Out-String | Invoke-Expression
+`
+
+// completionShells are the shells "mcis completion" can generate scripts for.
+var completionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+// subcommand describes a subcommand for completion.
+type subcommand struct {
+	name string
+	desc string
+	// flagSet returns the flags of the subcommand, if it has any.
+	flagSet func() *flag.FlagSet
+	// args are the words accepted as its first argument.
+	args []string
+	// searchArgs are the args that are followed by search flags.
+	searchArgs []string
+}
+
+var subcommands = []subcommand{
+	{
+		name:       "service",
+		desc:       "Manage the Windows service",
+		flagSet:    func() *flag.FlagSet { fs, _ := newServiceFlagSet(); return fs },
+		args:       serviceActions,
+		searchArgs: []string{"install", "run"},
+	},
+	{
+		name:    "healthcheck",
+		desc:    "Check the outcome of the last run",
+		flagSet: func() *flag.FlagSet { fs, _ := newHealthcheckFlagSet(); return fs },
+	},
+	{
+		name:    "verify",
+		desc:    "Re-test the published DNS records",
+		flagSet: func() *flag.FlagSet { fs, _ := newVerifyFlagSet(); return fs },
+	},
+	{
+		name:    "prune",
+		desc:    "Delete the managed DNS records",
+		flagSet: func() *flag.FlagSet { fs, _ := newPruneFlagSet(); return fs },
+	},
+	{
+		name:    "agent",
+		desc:    "Serve probes for a coordinating search",
+		flagSet: func() *flag.FlagSet { fs, _ := newAgentFlagSet(); return fs },
+	},
+	{
+		name:    "update",
+		desc:    "Replace this binary with the latest release",
+		flagSet: func() *flag.FlagSet { fs, _ := newUpdateFlagSet(); return fs },
+	},
+	{
+		name:    "bench",
+		desc:    "Compare search strategies on a latency model",
+		flagSet: func() *flag.FlagSet { fs, _ := newBenchFlagSet(); return fs },
+	},
+	{
+		name:       "config",
+		desc:       "Validate the configuration without searching",
+		args:       configActions,
+		searchArgs: configActions,
+	},
+	{
+		name: "completion",
+		desc: "Generate a shell completion script",
+		args: completionShells,
+	},
+}
+
+// completionFlag is a flag as seen by the completion scripts.
+type completionFlag struct {
+	name   string
+	desc   string
+	isBool bool
+	values []string // fixed set of values, if any
+	file   bool     // the value is a path
+}
+
+// flagValues lists the accepted values of flags with a fixed set.
+var flagValues = map[string][]string{
+	"agent-merge":  {agent.MergeMax, agent.MergeWeighted},
+	"color":        color.Modes,
+	"dns-provider": dns.ProviderNames,
+	"etcd-format":  {publish.FormatJSON, publish.FormatText},
+	"git-format":   {publish.FormatJSON, publish.FormatText},
+	"icmp-socket":  icmpping.SocketKinds,
+	"kv-format":    {publish.FormatJSON, publish.FormatText},
+	"lang":         append([]string{"auto"}, i18n.Languages...),
+	"log-format":   logging.Formats,
+	"link":         {"auto", string(tune.LinkEthernet), string(tune.LinkWiFi), string(tune.LinkCellular)},
+	"smtp-tls":     {notify.SMTPStartTLS, notify.SMTPTLS, notify.SMTPPlain},
+	"out":          {"jsonl", "csv", "text"},
+}
+
+// fileFlags are flags whose value is a path.
+var fileFlags = map[string]bool{
+	"all-results":       true,
+	"cidr-file":         true,
+	"config":            true,
+	"dns-ca":            true,
+	"external-dns-file": true,
+	"git-deploy-key":    true,
+	"git-workdir":       true,
+	"lock-file":         true,
+	"mqtt-ca":           true,
+	"out-file":          true,
+	"probe-cache":       true,
+	"report-file":       true,
+	"simulate-model":    true,
+	"state":             true,
+	"status-file":       true,
+	"tls-ca":            true,
+	"tls-cert":          true,
+	"tls-key":           true,
+	"webhook-template":  true,
+}
+
+// completionFlags describes every flag of fs.
+func completionFlags(fs *flag.FlagSet) []completionFlag {
+	var flags []completionFlag
+	if fs == nil {
+		return nil
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, completionFlag{
+			name:   f.Name,
+			desc:   shortUsage(f.Usage),
+			isBool: ok && b.IsBoolFlag(),
+			values: flagValues[f.Name],
+			file:   fileFlags[f.Name],
+		})
+	})
+	return flags
+}
+
+// shortUsage trims a flag usage string to its first clause.
+func shortUsage(s string) string {
+	for _, sep := range []string{" (", "; ", ". "} {
+		if i := strings.Index(s, sep); i > 0 {
+			s = s[:i]
+		}
+	}
+	return s
+}
+
+// completionSpec is everything the generated scripts complete.
+type completionSpec struct {
+	search []completionFlag
+	subs   []completionSub
+}
+
+type completionSub struct {
+	subcommand
+	flags []completionFlag
+}
+
+func newCompletionSpec() completionSpec {
+	var o options
+	search := flag.NewFlagSet("mcis", flag.ContinueOnError)
+	registerFlags(search, &o)
+	spec := completionSpec{search: completionFlags(search)}
+	for _, c := range subcommands {
+		sub := completionSub{subcommand: c}
+		if c.flagSet != nil {
+			sub.flags = completionFlags(c.flagSet())
+		}
+		spec.subs = append(spec.subs, sub)
+	}
+	return spec
+}
+
+// completionCommand implements "mcis completion <shell>".
+func completionCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprint(os.Stderr, completionUsage)
+		return 2
+	}
+	spec := newCompletionSpec()
+	switch args[0] {
+	case "bash":
+		writeBashCompletion(os.Stdout, spec)
+	case "zsh":
+		writeZshCompletion(os.Stdout, spec)
+	case "fish":
+		writeFishCompletion(os.Stdout, spec)
+	case "powershell", "pwsh":
+		writePowerShellCompletion(os.Stdout, spec)
+	default:
+		fmt.Fprintf(os.Stderr, "error: unsupported shell %q (supported: %s)\n", args[0], strings.Join(completionShells, ", "))
+		return 2
+	}
+	return 0
+}
+
+// allFlags returns the flags of every command, deduplicated by name.
+func (s completionSpec) allFlags() []completionFlag {
+	seen := map[string]bool{}
+	var all []completionFlag
+	add := func(flags []completionFlag) {
+		for _, f := range flags {
+			if !seen[f.name] {
+				seen[f.name] = true
+				all = append(all, f)
+			}
+		}
+	}
+	add(s.search)
+	for _, sub := range s.subs {
+		add(sub.flags)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].name < all[j].name })
+	return all
+}
+
+func flagWords(flags []completionFlag) []string {
+	words := make([]string, len(flags))
+	for i, f := range flags {
+		words[i] = "--" + f.name
+	}
+	return words
+}
+
+func (s completionSpec) subcommandNames() []string {
+	names := make([]string, len(s.subs))
+	for i, c := range s.subs {
+		names[i] = c.name
+	}
+	return names
+}
+
+func writeBashCompletion(w io.Writer, s completionSpec) {
+	fmt.Fprint(w, `# bash completion for mcis
+_mcis() {
+    local cur prev sub arg i words
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev="${COMP_WORDS[COMP_CWORD-1]}"
+    sub=""
+    [ "$COMP_CWORD" -gt 1 ] && sub="${COMP_WORDS[1]}"
+
+    case "$prev" in
+`)
+	var plain []string
+	for _, f := range s.allFlags() {
+		pat := "--" + f.name + "|-" + f.name
+		switch {
+		case f.isBool:
+		case len(f.values) > 0:
+			fmt.Fprintf(w, "        %s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", pat, strings.Join(f.values, " "))
+		case f.file:
+			fmt.Fprintf(w, "        %s) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", pat)
+		default:
+			plain = append(plain, pat)
+		}
+	}
+	if len(plain) > 0 {
+		fmt.Fprintf(w, "        %s) return ;;\n", strings.Join(plain, "|"))
+	}
+	fmt.Fprintf(w, "    esac\n\n    local search=%q\n    case \"$sub\" in\n", strings.Join(flagWords(s.search), " "))
+	for _, c := range s.subs {
+		flags := strings.Join(flagWords(c.flags), " ")
+		if len(c.args) == 0 {
+			fmt.Fprintf(w, "        %s) words=%q ;;\n", c.name, flags)
+			continue
+		}
+		searchCase := ""
+		if len(c.searchArgs) > 0 {
+			searchCase = fmt.Sprintf("                %s) words=\"$search\" ;;\n", strings.Join(c.searchArgs, "|"))
+		}
+		fmt.Fprintf(w, `        %s)
+            arg=""
+            for ((i = 2; i < COMP_CWORD; i++)); do
+                case "${COMP_WORDS[i]}" in
+                    %s) arg="${COMP_WORDS[i]}"; break ;;
+                esac
+            done
+            case "$arg" in
+                "") words=%q ;;
+%s                *) words="" ;;
+            esac
+            ;;
+`, c.name, strings.Join(c.args, "|"), strings.TrimSpace(strings.Join(c.args, " ")+" "+flags), searchCase)
+	}
+	fmt.Fprintf(w, `        *)
+            words="$search"
+            [ "$COMP_CWORD" -eq 1 ] && words=%q" $words"
+            ;;
+    esac
+    COMPREPLY=($(compgen -W "$words" -- "$cur"))
+}
+complete -o default -F _mcis mcis
+`, strings.Join(s.subcommandNames(), " "))
+}
+
+// zshQuote escapes desc for use inside a single-quoted _arguments spec.
+func zshQuote(desc string) string {
+	r := strings.NewReplacer(`'`, `'\''`, `\`, `\\`, `[`, `\[`, `]`, `\]`, `:`, `\:`)
+	return r.Replace(desc)
+}
+
+func zshFlagSpecs(w io.Writer, array string, flags []completionFlag) {
+	fmt.Fprintf(w, "    local -a %s\n    %s=(\n", array, array)
+	for _, f := range flags {
+		spec := fmt.Sprintf("--%s[%s]", f.name, zshQuote(f.desc))
+		switch {
+		case f.isBool:
+		case len(f.values) > 0:
+			spec += fmt.Sprintf(":%s:(%s)", f.name, strings.Join(f.values, " "))
+		case f.file:
+			spec += ":file:_files"
+		default:
+			spec += ":" + f.name + ":"
+		}
+		fmt.Fprintf(w, "        '%s'\n", spec)
+	}
+	fmt.Fprint(w, "    )\n")
+}
+
+func writeZshCompletion(w io.Writer, s completionSpec) {
+	fmt.Fprint(w, "#compdef mcis\n\n_mcis() {\n    local -a commands\n    commands=(\n")
+	for _, c := range s.subs {
+		fmt.Fprintf(w, "        '%s:%s'\n", c.name, zshQuote(c.desc))
+	}
+	fmt.Fprint(w, "    )\n")
+	zshFlagSpecs(w, "search_flags", s.search)
+	for _, c := range s.subs {
+		zshFlagSpecs(w, c.name+"_flags", c.flags)
+	}
+	fmt.Fprint(w, "\n    if (( CURRENT > 2 )); then\n        case $words[2] in\n")
+	for _, c := range s.subs {
+		fmt.Fprintf(w, "            %s)\n                shift words; (( CURRENT-- ))\n", c.name)
+		switch {
+		case len(c.args) == 0:
+			fmt.Fprintf(w, "                _arguments $%s_flags\n", c.name)
+		case len(c.searchArgs) == 0:
+			fmt.Fprintf(w, "                _arguments $%s_flags '1:%s:(%s)'\n", c.name, c.name, strings.Join(c.args, " "))
+		default:
+			conds := make([]string, len(c.searchArgs))
+			for i, a := range c.searchArgs {
+				conds[i] = "$words[1] == " + a
+			}
+			fmt.Fprintf(w, "                local state\n                _arguments -C $%s_flags '1:%s:(%s)' '*:: :->search'\n", c.name, c.name, strings.Join(c.args, " "))
+			fmt.Fprintf(w, "                [[ $state == search && ( %s ) ]] && _arguments $search_flags\n", strings.Join(conds, " || "))
+		}
+		fmt.Fprint(w, "                return\n                ;;\n")
+	}
+	fmt.Fprint(w, `        esac
+    fi
+    (( CURRENT == 2 )) && _describe -t commands command commands
+    _arguments $search_flags
+}
+
+_mcis "$@"
+`)
+}
+
+// fishQuote single-quotes s for fish.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+func fishFlags(w io.Writer, cond string, flags []completionFlag) {
+	for _, f := range flags {
+		opt := "-l " + f.name
+		if len(f.name) == 1 {
+			opt = "-s " + f.name
+		}
+		switch {
+		case f.isBool:
+		case len(f.values) > 0:
+			opt += " -x -a " + fishQuote(strings.Join(f.values, " "))
+		case f.file:
+			opt += " -r -F"
+		default:
+			opt += " -x"
+		}
+		fmt.Fprintf(w, "complete -c mcis -n %s %s -d %s\n", fishQuote(cond), opt, fishQuote(f.desc))
+	}
+}
+
+func writeFishCompletion(w io.Writer, s completionSpec) {
+	fmt.Fprint(w, "# fish completion for mcis\ncomplete -c mcis -f\n\n")
+	for _, c := range s.subs {
+		fmt.Fprintf(w, "complete -c mcis -n '__fish_use_subcommand' -a %s -d %s\n", c.name, fishQuote(c.desc))
+	}
+	fmt.Fprintln(w)
+	fishFlags(w, "not __fish_seen_subcommand_from "+strings.Join(s.subcommandNames(), " "), s.search)
+	for _, c := range s.subs {
+		fmt.Fprintln(w)
+		seen := "__fish_seen_subcommand_from " + c.name
+		if len(c.args) == 0 {
+			fishFlags(w, seen, c.flags)
+			continue
+		}
+		args := strings.Join(c.args, " ")
+		noArg := seen + "; and not __fish_seen_subcommand_from " + args
+		fmt.Fprintf(w, "complete -c mcis -n %s -a %s\n", fishQuote(noArg), fishQuote(args))
+		fishFlags(w, noArg, c.flags)
+		if len(c.searchArgs) > 0 {
+			fishFlags(w, seen+"; and __fish_seen_subcommand_from "+strings.Join(c.searchArgs, " "), s.search)
+		}
+	}
+}
+
+// psQuote single-quotes s for PowerShell.
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func psList(words []string) string {
+	q := make([]string, len(words))
+	for i, w := range words {
+		q[i] = psQuote(w)
+	}
+	return "@(" + strings.Join(q, ", ") + ")"
+}
+
+func writePowerShellCompletion(w io.Writer, s completionSpec) {
+	fmt.Fprint(w, "# PowerShell completion for mcis\nRegister-ArgumentCompleter -Native -CommandName mcis -ScriptBlock {\n")
+	fmt.Fprint(w, "    param($wordToComplete, $commandAst, $cursorPosition)\n\n    $values = @{\n")
+	var plain []string
+	for _, f := range s.allFlags() {
+		switch {
+		case f.isBool:
+		case len(f.values) > 0:
+			fmt.Fprintf(w, "        '--%s' = %s\n", f.name, psList(f.values))
+		default:
+			// Returning nothing falls back to path completion.
+			plain = append(plain, "--"+f.name)
+		}
+	}
+	fmt.Fprintf(w, "    }\n    $takesValue = %s\n    $subcommands = %s\n    $searchFlags = %s\n",
+		psList(plain), psList(s.subcommandNames()), psList(flagWords(s.search)))
+	fmt.Fprint(w, "    $subFlags = @{\n")
+	for _, c := range s.subs {
+		fmt.Fprintf(w, "        %s = %s\n", psQuote(c.name), psList(flagWords(c.flags)))
+	}
+	fmt.Fprint(w, "    }\n    $subArgs = @{\n")
+	for _, c := range s.subs {
+		if len(c.args) > 0 {
+			fmt.Fprintf(w, "        %s = %s\n", psQuote(c.name), psList(c.args))
+		}
+	}
+	fmt.Fprint(w, "    }\n    $subSearchArgs = @{\n")
+	for _, c := range s.subs {
+		if len(c.searchArgs) > 0 {
+			fmt.Fprintf(w, "        %s = %s\n", psQuote(c.name), psList(c.searchArgs))
+		}
+	}
+	fmt.Fprint(w, `    }
+
+    $words = @($commandAst.CommandElements | ForEach-Object { $_.ToString() })
+    if ($wordToComplete -ne '') { $words = @($words[0..($words.Count - 2)]) }
+    $prev = ($words[-1] -replace '^-+', '--')
+    $sub = if ($words.Count -gt 1) { $words[1] } else { '' }
+
+    if ($values.ContainsKey($prev)) {
+        $candidates = $values[$prev]
+    } elseif ($takesValue -contains $prev) {
+        return
+    } elseif ($subFlags.ContainsKey($sub)) {
+        $accepted = $subArgs[$sub]
+        if (-not $accepted) {
+            $candidates = $subFlags[$sub]
+        } else {
+            $arg = $words | Select-Object -Skip 2 | Where-Object { $accepted -contains $_ } | Select-Object -First 1
+            if (-not $arg) { $candidates = $accepted + $subFlags[$sub] }
+            elseif ($subSearchArgs[$sub] -contains $arg) { $candidates = $searchFlags }
+            else { $candidates = @() }
+        }
+    } else {
+        $candidates = $searchFlags
+        if ($words.Count -eq 1) { $candidates = $subcommands + $candidates }
+    }
+
+    $candidates | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+    }
+}
+`)
+}
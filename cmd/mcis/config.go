@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/color"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/i18n"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/tune"
+)
+
+// pendingOptions holds options reloaded by SIGHUP until the run loop picks
+// them up; reloaded wakes the loop while it waits for the next run.
+var (
+	pendingOptions atomic.Pointer[options]
+	reloaded       = make(chan struct{}, 1)
+)
+
+// loadOptions builds the search options from the flag defaults, then the
+// --config file, then the MCIPS_* environment variables, then args, so flags
+// on the command line win over the environment and both over the file.
+func loadOptions(args []string, handling flag.ErrorHandling) (*options, error) {
+	o, _, err := parseOptions(args, handling)
+	return o, err
+}
+
+// parseOptions is loadOptions that also returns the flag set holding the
+// options, to tell which of them were set.
+func parseOptions(args []string, handling flag.ErrorHandling) (*options, *flag.FlagSet, error) {
+	var first options
+	fs := flag.NewFlagSet("mcis", handling)
+	registerFlags(fs, &first)
+	if err := applyEnv(fs); err != nil {
+		return nil, nil, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	if first.configFile == "" {
+		first.cmdline = args
+		return &first, fs, applyOptions(fs, &first)
+	}
+
+	o := &options{}
+	fs = flag.NewFlagSet("mcis", handling)
+	registerFlags(fs, o)
+	if err := applyConfigFile(fs, first.configFile); err != nil {
+		return nil, nil, err
+	}
+	if err := applyEnv(fs); err != nil {
+		return nil, nil, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	o.cmdline = args
+	return o, fs, applyOptions(fs, o)
+}
+
+// applyOptions applies the settings that take effect as soon as the flags
+// are parsed: the credential helpers, the message language and the tuned
+// defaults.
+func applyOptions(fs *flag.FlagSet, o *options) error {
+	if err := resolveSecrets(fs); err != nil {
+		return err
+	}
+	if err := i18n.Set(o.lang); err != nil {
+		return fmt.Errorf("--lang: %w", err)
+	}
+	if err := color.Setup(o.color); err != nil {
+		return fmt.Errorf("--color: %w", err)
+	}
+	return tuneDefaults(fs, o)
+}
+
+// hardware is the machine the flag defaults are tuned to.
+var hardware = sync.OnceValue(tune.Detect)
+
+// tuneDefaults retunes the flags that were left at their defaults when
+// --link overrides the detected link type.
+func tuneDefaults(fs *flag.FlagSet, o *options) error {
+	link, err := tune.ParseLink(o.link)
+	if err != nil {
+		return fmt.Errorf("--link: %w", err)
+	}
+	if link == tune.LinkUnknown {
+		return nil
+	}
+	h := hardware()
+	h.Link = link
+	tuned := h.Defaults()
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if !set["concurrency"] {
+		o.concur = tuned.Concurrency
+	}
+	if !set["pps"] {
+		o.pps = tuned.PPS
+	}
+	if !set["download-concurrency"] {
+		o.dlConcurrency = tuned.DownloadConcurrency
+	}
+	return nil
+}
+
+// applyConfigFile sets flags from a config file of "name = value" lines (the
+// flag names without dashes). Blank lines and lines starting with # are
+// skipped; repeatable flags such as cidr may appear several times.
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			// A bare name enables a boolean flag.
+			name, value = line, "true"
+		}
+		name = strings.TrimLeft(strings.TrimSpace(name), "-")
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		if name == "config" {
+			return fmt.Errorf("config: %s:%d: config files cannot include other config files", path, n)
+		}
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("config: %s:%d: unknown setting %q", path, n, name)
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("config: %s:%d: %s: %w", path, n, name, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	return nil
+}
+
+// restartOnly lists settings that are read once at startup; a reload that
+// changes them is applied to the search but warns that it needs a restart.
+// old is the options of the previous reload, so each change warns once.
+func restartOnly(old, cur *options) []string {
+	var changed []string
+	if old.debugAddr != cur.debugAddr {
+		changed = append(changed, "debug-addr")
+	}
+	if old.healthAddr != cur.healthAddr {
+		changed = append(changed, "health-addr")
+	}
+	if old.logFormat != cur.logFormat {
+		changed = append(changed, "log-format")
+	}
+	if old.auth != cur.auth {
+		changed = append(changed, "auth-token/tls-*")
+	}
+	if old.leaderElect != cur.leaderElect || old.leaseName != cur.leaseName || old.leaseNamespace != cur.leaseNamespace {
+		changed = append(changed, "leader-elect")
+	}
+	return changed
+}
+
+// watchReload re-reads --config (and the original command line) on SIGHUP
+// and hands the new options to the run loop. A run in progress finishes with
+// the old settings; the next one, and the wait before it, use the new ones.
+// An invalid config is reported and ignored.
+func watchReload(o *options, done <-chan struct{}) {
+	if o.configFile == "" {
+		return
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		last := o
+		for {
+			select {
+			case <-done:
+				return
+			case <-hup:
+			}
+			next, err := loadOptions(o.cmdline, flag.ContinueOnError)
+			if err == nil && next.coloAllow != "" && next.coloExclude != "" {
+				err = fmt.Errorf("cannot use both --colo and --colo-exclude; use only one")
+			}
+			if err == nil {
+				_, err = setupNotifiers(next)
+			}
+			if err != nil {
+				i18n.Fprintln(os.Stderr, "error: reload:", err)
+				continue
+			}
+			if changed := restartOnly(last, next); len(changed) > 0 {
+				i18n.Fprintf(os.Stderr, "reload: changes to %s take effect after a restart\n", strings.Join(changed, ", "))
+			}
+			last = next
+			pendingOptions.Store(next)
+			select {
+			case reloaded <- struct{}{}:
+			default:
+			}
+			i18n.Fprintf(os.Stderr, "reload: loaded %s\n", next.configFile)
+		}
+	}()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"os"
+	"sync/atomic"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/admin"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/engine"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/i18n"
+)
+
+// activeEngine is the engine of the search currently running, if any.
+var activeEngine atomic.Pointer[engine.Engine]
+
+// startDebugServer serves the diagnostics endpoints in the background until
+// ctx is canceled. A listen error is reported but does not stop the search;
+// an invalid --tls-* setting does.
+func startDebugServer(ctx context.Context, addr string, auth admin.Auth) error {
+	expvar.Publish("search", expvar.Func(func() any {
+		if eng := activeEngine.Load(); eng != nil {
+			return eng.Progress()
+		}
+		return nil
+	}))
+
+	srv := admin.New(addr)
+	if err := srv.SetAuth(auth, "/healthz"); err != nil {
+		return err
+	}
+	srv.EnableDebug()
+	srv.Handle("/healthz", tracker)
+	go func() {
+		if err := srv.ListenAndServe(ctx); err != nil {
+			i18n.Fprintln(os.Stderr, "error: debug server:", err)
+		}
+	}()
+	i18n.Fprintf(os.Stderr, "debug: serving pprof and runtime stats on %s/debug/\n", listenURL(addr, auth))
+	return nil
+}
+
+// listenURL returns the base URL of a listener on addr, for log messages.
+func listenURL(addr string, auth admin.Auth) string {
+	if auth.CertFile != "" {
+		return "https://" + addr
+	}
+	return "http://" + addr
+}
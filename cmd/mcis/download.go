@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/engine"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/logging"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/pipeline"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/probe"
+)
+
+// speedTestWarmup is the part of the budget (1/speedTestWarmup) the search
+// runs before speed tests start: at first, nearly every result enters the
+// top results only to drop out again.
+const speedTestWarmup = 4
+
+// speedTests is the download speed test stage. It runs alongside the
+// search: once the search is past its warm-up, a candidate that answered
+// the latency probe is queued as soon as it enters the first --download-top
+// results, and tested if it is still among them when a worker is free, so
+// most of the speed tests are done by the time the search ends. finish then
+// cancels the tests of candidates that dropped out again and queues the
+// final ones not tested yet. At most --download-concurrency tests run at a
+// time, during the search and after it.
+type speedTests struct {
+	ctx      context.Context
+	eng      searchView
+	top      int
+	timeout  time.Duration
+	download func(context.Context, netip.Addr) probe.DownloadResult
+	queue    chan *speedTest
+	wg       sync.WaitGroup
+
+	mu     sync.Mutex
+	tests  map[netip.Addr]*speedTest
+	closed bool
+}
+
+// searchView is what speedTests needs of the running search, as
+// *engine.Engine provides it.
+type searchView interface {
+	Top() []engine.TopResult
+	Progress() engine.Progress
+}
+
+// speedTest is the speed test of one IP; res is set once done is closed.
+// final is set, under speedTests.mu, once finish claims it.
+type speedTest struct {
+	ip     netip.Addr
+	final  bool
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	res    probe.DownloadResult
+}
+
+func newSpeedTests(ctx context.Context, o *options, eng searchView, download func(context.Context, netip.Addr) probe.DownloadResult) *speedTests {
+	s := &speedTests{
+		ctx:      ctx,
+		eng:      eng,
+		top:      o.dlTop,
+		timeout:  o.dlTimeout,
+		download: download,
+		queue:    make(chan *speedTest, o.dlTop),
+		tests:    make(map[netip.Addr]*speedTest),
+	}
+	for range max(1, o.dlConcurrency) {
+		s.wg.Go(s.worker)
+	}
+	return s
+}
+
+func (s *speedTests) worker() {
+	for t := range s.queue {
+		if s.dropped(t) {
+			t.res = probe.DownloadResult{IP: t.ip, Error: "no longer a candidate", When: time.Now()}
+			t.cancel()
+			close(t.done)
+			continue
+		}
+		if err := t.ctx.Err(); err != nil {
+			t.res = probe.DownloadResult{IP: t.ip, Error: err.Error(), When: time.Now()}
+		} else {
+			dctx, dcancel := context.WithTimeout(t.ctx, s.timeout)
+			err := pipeline.Protect(func() error {
+				t.res = s.download(dctx, t.ip)
+				return nil
+			})
+			dcancel()
+			if err != nil {
+				workerPanics.add("download "+t.ip.String(), err)
+				t.res = probe.DownloadResult{IP: t.ip, Error: err.Error(), When: time.Now()}
+			}
+		}
+		t.cancel()
+		close(t.done)
+	}
+}
+
+// dropped reports whether t, queued during the search, is no longer among
+// the first --download-top results, and forgets it so that finish queues it
+// again if it gets back among them.
+func (s *speedTests) dropped(t *speedTest) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t.final {
+		return false
+	}
+	n := 0
+	for _, r := range s.eng.Top() {
+		if n == s.top {
+			break
+		}
+		if !r.OK {
+			continue
+		}
+		if r.IP == t.ip {
+			return false
+		}
+		n++
+	}
+	delete(s.tests, t.ip)
+	return true
+}
+
+// offer is the engine's OnTop: it queues the speed test of a candidate that
+// entered the first --download-top results. It never blocks the search; a
+// candidate that finds the queue full is tested by finish if it is still a
+// candidate then.
+func (s *speedTests) offer(r engine.TopResult, rank int) {
+	if !r.OK || rank >= s.top {
+		return
+	}
+	if p := s.eng.Progress(); p.Completed < int64(p.Budget/speedTestWarmup) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || s.tests[r.IP] != nil {
+		return
+	}
+	t := s.newTest(r.IP)
+	select {
+	case s.queue <- t:
+		s.tests[r.IP] = t
+		slog.Debug("download test queued during the search", logging.Phase("download"), "rank", rank+1, logging.IP(r.IP))
+	default:
+		t.cancel()
+	}
+}
+
+func (s *speedTests) newTest(ip netip.Addr) *speedTest {
+	ctx, cancel := context.WithCancel(s.ctx)
+	return &speedTest{ip: ip, ctx: ctx, cancel: cancel, done: make(chan struct{})}
+}
+
+// finish ends the stage with the final candidates: the tests of other IPs
+// are canceled, and those of the candidates that answered the latency probe
+// and were not tested yet are queued. It returns the ranks in candidates of
+// the tested ones and their tests. The new tests are queued without holding
+// s.mu, which the workers take to check their tests.
+func (s *speedTests) finish(candidates []engine.TopResult) ([]int, []*speedTest) {
+	s.mu.Lock()
+	keep := make(map[netip.Addr]bool, len(candidates))
+	for _, r := range candidates {
+		keep[r.IP] = r.OK
+	}
+	for ip, t := range s.tests {
+		if !keep[ip] {
+			t.cancel()
+		}
+	}
+	var ranks []int
+	var tests, queue []*speedTest
+	for i, r := range candidates {
+		if !r.OK {
+			continue
+		}
+		t := s.tests[r.IP]
+		if t == nil {
+			t = s.newTest(r.IP)
+			s.tests[r.IP] = t
+			queue = append(queue, t)
+		}
+		t.final = true
+		ranks = append(ranks, i)
+		tests = append(tests, t)
+	}
+	// offer sees closed and queues nothing more.
+	s.closed = true
+	s.mu.Unlock()
+
+	for _, t := range queue {
+		select {
+		case s.queue <- t:
+		case <-s.ctx.Done():
+			t.res = probe.DownloadResult{IP: t.ip, Error: s.ctx.Err().Error(), When: time.Now()}
+			t.cancel()
+			close(t.done)
+		}
+	}
+	close(s.queue)
+	return ranks, tests
+}
+
+// stop cancels the tests that finish has not claimed, e.g. when the run ends
+// without a download test, and waits for the workers.
+func (s *speedTests) stop() {
+	s.mu.Lock()
+	if !s.closed {
+		for _, t := range s.tests {
+			t.cancel()
+		}
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// downloadTest completes the download speed test of the first --download-top
+// results, reusing the tests run during the search.
+func downloadTest(o *options, dlCfg probe.DownloadConfig, tests *speedTests, top []engine.TopResult) {
+	if tests == nil {
+		return
+	}
+	dlTop := min(o.dlTop, len(top))
+
+	dlURL := o.dlURL
+	if dlURL == "" {
+		dlURL = "https://speed.cloudflare.com/__down"
+	}
+	ranks, pending := tests.finish(top[:dlTop])
+	slog.Debug("testing the download speed", logging.Phase("download"), "url", dlURL, "top", dlTop, "max_bytes", dlCfg.Bytes)
+
+	// The tests finish in any order; record each as it does.
+	finished := make(chan int, len(pending))
+	for i, t := range pending {
+		go func() {
+			<-t.done
+			finished <- i
+		}()
+	}
+	for n := range len(pending) {
+		i := <-finished
+		r, dr := &top[ranks[i]], pending[i].res
+		phase(fmt.Sprintf("download tests: %d/%d done", n+1, dlTop))
+		r.DownloadOK = dr.OK
+		r.DownloadBytes = dr.Bytes
+		r.DownloadMS = dr.TotalMS
+		r.DownloadMbps = dr.Mbps
+		r.DownloadError = dr.Error
+		slog.Debug("download tested", logging.Phase("download"), "rank", ranks[i]+1, logging.IP(r.IP),
+			"ok", dr.OK, "mbps", dr.Mbps, "ms", dr.TotalMS, "bytes", dr.Bytes, "error", dr.Error)
+	}
+}
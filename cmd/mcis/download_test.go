@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/engine"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/probe"
+)
+
+// fakeSearch is a search past its warm-up whose top results are top.
+type fakeSearch struct {
+	mu  sync.Mutex
+	top []engine.TopResult
+}
+
+func (f *fakeSearch) Top() []engine.TopResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.top
+}
+
+func (f *fakeSearch) Progress() engine.Progress { return engine.Progress{Completed: 100, Budget: 100} }
+
+func candidates(from, n int) []engine.TopResult {
+	out := make([]engine.TopResult, n)
+	for i := range out {
+		out[i] = engine.TopResult{IP: netip.MustParseAddr(fmt.Sprintf("192.0.2.%d", from+i)), OK: true}
+	}
+	return out
+}
+
+// TestSpeedTestsFinishFullQueue fills the queue with candidates that have
+// dropped out by the end of the search; finish must still queue the final
+// ones and test them all.
+func TestSpeedTestsFinishFullQueue(t *testing.T) {
+	for _, concurrency := range []int{1, 2} {
+		t.Run(fmt.Sprint("concurrency=", concurrency), func(t *testing.T) {
+			o := &options{dlTop: 5, dlConcurrency: concurrency, dlTimeout: time.Second}
+			search := &fakeSearch{}
+			var mu sync.Mutex
+			tested := make(map[netip.Addr]int)
+			release := make(chan struct{})
+			download := func(ctx context.Context, ip netip.Addr) probe.DownloadResult {
+				<-release
+				mu.Lock()
+				tested[ip]++
+				mu.Unlock()
+				return probe.DownloadResult{IP: ip, OK: true, Mbps: 100}
+			}
+			s := newSpeedTests(context.Background(), o, search, download)
+			defer s.stop()
+
+			// The stale candidates are in the top while queued, and hold
+			// the workers in their downloads until the search ends.
+			stale := candidates(1, o.dlTop)
+			search.top = stale
+			for i, r := range stale {
+				s.offer(r, i)
+			}
+			final := candidates(100, o.dlTop)
+			search.mu.Lock()
+			search.top = final
+			search.mu.Unlock()
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				close(release)
+				top := append([]engine.TopResult(nil), final...)
+				downloadTest(o, probe.DownloadConfig{}, s, top)
+				for _, r := range top {
+					if !r.DownloadOK {
+						t.Errorf("%s was not tested: %q", r.IP, r.DownloadError)
+					}
+				}
+			}()
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("finish did not return")
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, r := range final {
+				if tested[r.IP] != 1 {
+					t.Errorf("%s tested %d times, want 1", r.IP, tested[r.IP])
+				}
+			}
+		})
+	}
+}
+
+// TestSpeedTestsFinishCanceled ends the run while finish waits for room in
+// the queue.
+func TestSpeedTestsFinishCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	o := &options{dlTop: 2, dlConcurrency: 1, dlTimeout: time.Second}
+	search := &fakeSearch{}
+	block := make(chan struct{})
+	defer close(block)
+	download := func(ctx context.Context, ip netip.Addr) probe.DownloadResult {
+		select {
+		case <-block:
+		case <-ctx.Done():
+		}
+		return probe.DownloadResult{IP: ip, Error: "canceled"}
+	}
+	s := newSpeedTests(ctx, o, search, download)
+	defer s.stop()
+	search.top = candidates(1, o.dlTop)
+	for i, r := range search.top {
+		s.offer(r, i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cancel()
+		downloadTest(o, probe.DownloadConfig{}, s, candidates(100, 4))
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("finish did not return after the run was canceled")
+	}
+}
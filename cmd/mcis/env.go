@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix starts the environment variable of every flag: --dns-provider
+// is MCIPS_DNS_PROVIDER, so that containers can be configured without a
+// command line or config file.
+const envPrefix = "MCIPS_"
+
+// envName returns the environment variable of the flag name.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyEnv sets the flags of fs whose environment variables are set and
+// names the variable in the usage of each flag, for --help. A repeatable
+// flag such as cidr takes one value per line of its variable.
+func applyEnv(fs *flag.FlagSet) error {
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envName(f.Name)
+		if !strings.Contains(f.Usage, name) {
+			f.Usage += " [$" + name + "]"
+		}
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		values := []string{v}
+		if _, repeatable := f.Value.(*repeatStringFlag); repeatable {
+			values = strings.FieldsFunc(v, func(r rune) bool { return r == '\n' })
+		}
+		for _, v := range values {
+			if err := fs.Set(f.Name, strings.TrimSpace(v)); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid value %q: %w", name, v, err))
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// parseFlags parses args into fs on top of the environment variables and
+// runs the credential helpers, reporting an invalid variable or a failed
+// helper the way fs reports an invalid flag.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := applyEnv(fs); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return err
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := resolveSecrets(fs); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return err
+	}
+	return nil
+}
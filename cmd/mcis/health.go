@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/admin"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/health"
+)
+
+// tracker holds the outcome of the latest run for /healthz.
+var tracker *health.Tracker
+
+// healthMaxAge resolves --health-max-age: by default a run may be two
+// intervals old, or a day when not running periodically.
+func healthMaxAge(maxAge, interval time.Duration) time.Duration {
+	if maxAge > 0 {
+		return maxAge
+	}
+	if interval > 0 {
+		return 2 * interval
+	}
+	return 24 * time.Hour
+}
+
+// startHealth creates the tracker and serves /healthz on --health-addr.
+// /healthz itself stays reachable without --auth-token for container probes.
+func startHealth(ctx context.Context, o *options) error {
+	tracker = health.NewTracker(healthMaxAge(o.healthMaxAge, o.interval))
+	if o.healthAddr == "" {
+		return nil
+	}
+	srv := admin.New(o.healthAddr)
+	if err := srv.SetAuth(o.auth, "/healthz"); err != nil {
+		return err
+	}
+	srv.Handle("/healthz", tracker)
+	go func() {
+		if err := srv.ListenAndServe(ctx); err != nil {
+			fmt.Fprintln(os.Stderr, "health server error:", err)
+		}
+	}()
+	return nil
+}
+
+// recordStatus stores the outcome of a run in the tracker and --status-file.
+func recordStatus(o *options, st health.Status) {
+	if tracker != nil {
+		tracker.Record(st)
+	}
+	if o.statusFile != "" {
+		if err := health.WriteFile(o.statusFile, st); err != nil {
+			fmt.Fprintln(os.Stderr, "error: write status file:", err)
+		}
+	}
+}
+
+// healthcheckOptions holds the flags of "mcis healthcheck".
+type healthcheckOptions struct {
+	statusFile string
+	url        string
+	maxAge     time.Duration
+	interval   time.Duration
+	quiet      bool
+}
+
+func newHealthcheckFlagSet() (*flag.FlagSet, *healthcheckOptions) {
+	var hc healthcheckOptions
+	fs := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	fs.StringVar(&hc.statusFile, "status-file", "", "Status file written by a search run with --status-file")
+	fs.StringVar(&hc.url, "url", "", "Query a /healthz endpoint instead (e.g. http://127.0.0.1:8080/healthz)")
+	fs.DurationVar(&hc.maxAge, "max-age", 0, "Maximum age of the last successful run (0 = 2x --interval, or 24h)")
+	fs.DurationVar(&hc.interval, "interval", 0, "The --interval of the checked process, used for the default --max-age")
+	fs.BoolVar(&hc.quiet, "q", false, "Only set the exit code")
+	return fs, &hc
+}
+
+// healthcheckCommand implements "mcis healthcheck": exit 0 when the last run
+// recorded in --status-file (or reported by --url) is healthy, 1 otherwise.
+func healthcheckCommand(args []string) int {
+	fs, hc := newHealthcheckFlagSet()
+	if err := parseFlags(fs, args); err != nil {
+		return 2
+	}
+
+	var err error
+	switch {
+	case hc.url != "":
+		err = checkURL(hc.url)
+	case hc.statusFile != "":
+		var st health.Status
+		st, err = health.ReadFile(hc.statusFile)
+		if err == nil {
+			err = health.Check(st, healthMaxAge(hc.maxAge, hc.interval), time.Now())
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "error: healthcheck needs --status-file or --url")
+		return 2
+	}
+
+	if err != nil {
+		if !hc.quiet {
+			fmt.Fprintln(os.Stderr, "unhealthy:", err)
+		}
+		return 1
+	}
+	if !hc.quiet {
+		fmt.Println("healthy")
+	}
+	return 0
+}
+
+func checkURL(url string) error {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
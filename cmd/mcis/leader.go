@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/i18n"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/k8s"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/logging"
+)
+
+// electLeader takes the --leader-elect lease. In periodic mode it waits until
+// this replica leads or waitCtx is canceled; a single run reports false at
+// once when another replica leads, so a CronJob overlapping a running leader
+// exits cleanly. Once leading, the lease is renewed until ctx is canceled and
+// lost is called if that fails.
+func electLeader(waitCtx, ctx context.Context, o *options, lost func()) (release func(), leading bool, err error) {
+	client, err := k8s.InCluster()
+	if err != nil {
+		return nil, false, fmt.Errorf("leader election: %w", err)
+	}
+	identity := os.Getenv("POD_NAME")
+	if identity == "" {
+		identity, _ = os.Hostname()
+	}
+	le := client.NewLeaderElector(o.leaseNamespace, o.leaseName, identity, o.leaseDuration, o.leaseRenew)
+
+	if o.interval > 0 {
+		err := le.Wait(waitCtx, o.leaseDuration/2, func(err error) {
+			if err != nil {
+				i18n.Fprintln(os.Stderr, "error: leader election:", err)
+			} else {
+				slog.Debug("waiting for the lease", logging.Phase("leader"), "lease", o.leaseName, "holder", le.Holder())
+			}
+		})
+		if err != nil {
+			return nil, false, nil
+		}
+	} else {
+		ok, err := le.TryAcquire(ctx)
+		if err != nil {
+			return nil, false, fmt.Errorf("leader election: %w", err)
+		}
+		if !ok {
+			i18n.Fprintf(os.Stderr, "leader: lease %s is held by %s, skipping this run\n", o.leaseName, le.Holder())
+			return nil, false, nil
+		}
+	}
+	i18n.Fprintf(os.Stderr, "leader: %s acquired lease %s/%s\n", identity, client.Namespace(), o.leaseName)
+
+	holdCtx, stopHold := context.WithCancel(ctx)
+	held := make(chan struct{})
+	go func() {
+		defer close(held)
+		le.Hold(holdCtx, func() {
+			i18n.Fprintf(os.Stderr, "error: leader: lost lease %s, aborting\n", o.leaseName)
+			lost()
+		})
+	}()
+	return func() {
+		// Release must not overlap a renewal: both use the lease last seen.
+		stopHold()
+		<-held
+		rctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := le.Release(rctx); err != nil {
+			i18n.Fprintln(os.Stderr, "error: leader: release lease:", err)
+		}
+	}, true, nil
+}
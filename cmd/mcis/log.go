@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/logging"
+)
+
+// logLevel is the level of the log lines; a reload of --config can move it.
+var logLevel slog.LevelVar
+
+// setupLogging sends the log lines at level and above to stderr, in format.
+func setupLogging(level slog.Level, format string) error {
+	h, err := logging.NewHandler(os.Stderr, format, &logLevel)
+	if err != nil {
+		return fmt.Errorf("--log-format: %w", err)
+	}
+	logLevel.Set(level)
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// levelFlag is a boolean flag that lowers *cur to level when set, as -v
+// does to debug and -vv to trace. Clearing it restores the info level if it
+// had lowered it.
+type levelFlag struct {
+	cur   *slog.Level
+	level slog.Level
+}
+
+func (f levelFlag) IsBoolFlag() bool { return true }
+
+func (f levelFlag) String() string {
+	return strconv.FormatBool(f.cur != nil && *f.cur <= f.level)
+}
+
+func (f levelFlag) Set(s string) error {
+	on, err := strconv.ParseBool(s)
+	switch {
+	case err != nil:
+		return err
+	case on:
+		*f.cur = min(*f.cur, f.level)
+	case *f.cur <= f.level:
+		*f.cur = slog.LevelInfo
+	}
+	return nil
+}
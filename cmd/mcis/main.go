@@ -2,22 +2,22 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
-	"fmt"
-	"net/netip"
-	"net/url"
+	"log/slog"
 	"os"
 	"os/signal"
-	"sort"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/dns"
-	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/engine"
-	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/output"
-	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/probe"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/admin"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/color"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/i18n"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/icmpping"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/logging"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/notify"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/publish"
 )
 
 type repeatStringFlag []string
@@ -28,358 +28,599 @@ func (r *repeatStringFlag) Set(v string) error {
 	return nil
 }
 
-func main() {
-	var (
-		cidrs     repeatStringFlag
-		cidrFile  string
-		budget    int
-		topN      int
-		concur    int
-		heads     int
-		beam      int
-		timeout   time.Duration
-		host      string
-		sni       string
-		hostHdr   string
-		path      string
-		dlTop     int
-		dlBytes   int64
-		dlTimeout time.Duration
-		dlURL     string
-		outFmt    string
-		outPath   string
-		splitV4   int
-		splitV6   int
-		minSplit  int
-		maxBitsV4 int
-		maxBitsV6 int
-		seed      int64
-		verbose   bool
-
-		// DNS upload flags
-		dnsProvider    string
-		dnsToken       string
-		dnsZone        string
-		dnsSubdomain   string
-		dnsUploadCount int
-		dnsTeamID      string
-
-		// New engine parameters
-		diversityWeight float64
-		splitInterval   int
-
-		// Probe rounds configuration
-		rounds    int
-		skipFirst int
-
-		// Colo filter
-		coloAllow   string
-		coloExclude string
-	)
-
-	flag.Var(&cidrs, "cidr", "CIDR to search (repeatable). Example: 1.1.0.0/16 or 2606:4700::/32")
-	flag.StringVar(&cidrFile, "cidr-file", "", "Path to a file containing CIDRs (one per line, # comment supported)")
-	flag.IntVar(&budget, "budget", 2000, "Total probe budget (number of IPs to probe)")
-	flag.IntVar(&topN, "top", 20, "Top N IPs to output")
-	flag.IntVar(&concur, "concurrency", 200, "Probe concurrency")
-	flag.IntVar(&heads, "heads", 4, "Number of search heads (diversification)")
-	flag.IntVar(&beam, "beam", 32, "Beam width per head (kept candidate prefixes)")
-	flag.DurationVar(&timeout, "timeout", 3*time.Second, "Per-probe timeout")
-	flag.StringVar(&host, "host", "example.com", "Host name used for BOTH TLS SNI and HTTP Host header (recommended)")
-	flag.StringVar(&sni, "sni", "", "TLS SNI server name (deprecated: use --host)")
-	flag.StringVar(&hostHdr, "host-header", "", "HTTP Host header (deprecated: use --host)")
-	flag.StringVar(&path, "path", "/cdn-cgi/trace", "HTTP path to request")
-	flag.IntVar(&dlTop, "download-top", 5, "After search, run download speed test for top N IPs (0 to disable)")
-	flag.Int64Var(&dlBytes, "download-bytes", 0, "Download test size in bytes; 0 = 50M for default endpoint, no limit for custom URL (default: 0)")
-	flag.DurationVar(&dlTimeout, "download-timeout", 45*time.Second, "Per-IP download test timeout")
-	flag.StringVar(&dlURL, "download-url", "", "Custom download test URL (e.g. https://myhost.com/path/to/file). Overrides default speed.cloudflare.com")
-	flag.StringVar(&outFmt, "out", "jsonl", "Output format: jsonl|csv|text")
-	flag.StringVar(&outPath, "out-file", "", "Write output to file (default: stdout)")
-	flag.IntVar(&splitV4, "split-step-v4", 2, "When splitting an IPv4 prefix, increase prefix bits by this step")
-	flag.IntVar(&splitV6, "split-step-v6", 4, "When splitting an IPv6 prefix, increase prefix bits by this step")
-	flag.IntVar(&minSplit, "min-samples-split", 5, "Minimum samples on a prefix before it can be split")
-	flag.IntVar(&maxBitsV4, "max-bits-v4", 24, "Maximum IPv4 prefix bits to drill down to")
-	flag.IntVar(&maxBitsV6, "max-bits-v6", 56, "Maximum IPv6 prefix bits to drill down to")
-	flag.Int64Var(&seed, "seed", 0, "Random seed (0 = time-based)")
-	flag.BoolVar(&verbose, "v", false, "Verbose progress to stderr")
+// options holds every command-line setting of a search run.
+type options struct {
+	cidrs      repeatStringFlag
+	cidrFile   string
+	budget     int
+	budgetV6   int
+	topN       int
+	concur     int
+	concurV6   int
+	heads      int
+	beam       int
+	timeout    time.Duration
+	host       string
+	sni        string
+	hostHdr    string
+	path       string
+	dlTop      int
+	dlBytes    int64
+	dlTimeout  time.Duration
+	dlURL      string
+	outFmt     string
+	outPath    string
+	reportFile string
+	splitV4    int
+	splitV6    int
+	minSplit   int
+	maxBitsV4  int
+	maxBitsV6  int
+	seed       int64
+	logLevel   slog.Level
+	logFormat  string
+	lang       string
+	color      string
+	goodMS     float64
+	explain    bool
+
+	// Opt-in usage telemetry
+	telemetry    bool
+	telemetryURL string
 
 	// DNS upload flags
-	flag.StringVar(&dnsProvider, "dns-provider", "", "DNS provider for uploading results (cloudflare|vercel)")
-	flag.StringVar(&dnsToken, "dns-token", "", "DNS provider API token (or use CF_API_TOKEN/VERCEL_TOKEN env)")
-	flag.StringVar(&dnsZone, "dns-zone", "", "DNS zone ID (Cloudflare) or domain (Vercel) (or use CF_ZONE_ID env)")
-	flag.StringVar(&dnsSubdomain, "dns-subdomain", "", "Subdomain to update (e.g., 'cf' for cf.example.com)")
-	flag.IntVar(&dnsUploadCount, "dns-upload-count", 0, "Number of IPs to upload (default: same as --download-top)")
-	flag.StringVar(&dnsTeamID, "dns-team-id", "", "Vercel Team ID (optional, or use VERCEL_TEAM_ID env)")
+	dnsProvider    string
+	dnsToken       string
+	dnsZone        string
+	dnsSubdomain   string
+	dnsUploadCount int
+	dnsTeamID      string
+	dnsMinIPs      int
+	dnsOnInterrupt bool
+	dnsTargets     repeatStringFlag
+	dnsStagger     time.Duration
+	dnsRate        float64
+	dnsAPIs        repeatStringFlag
+	dnsCA          string
 
 	// New engine parameters
-	flag.Float64Var(&diversityWeight, "diversity-weight", 0.3, "Weight for head diversity (0-1, higher = more exploration)")
-	flag.IntVar(&splitInterval, "split-interval", 20, "Check for split opportunities every N samples")
+	diversityWeight float64
+	splitInterval   int
+	dedupeFPRate    float64
 
 	// Probe rounds configuration
-	flag.IntVar(&rounds, "rounds", 6, "Number of probe rounds per IP (default: 6)")
-	flag.IntVar(&skipFirst, "skip-first", 1, "Skip first N rounds when calculating average (default: 1, skips handshake overhead)")
-
-	// Colo filter (CDN node filter by trace colo)
-	flag.StringVar(&coloAllow, "colo", "", "Comma-separated colo whitelist; only these CDN nodes enter results (e.g. HKG,SJC)")
-	flag.StringVar(&coloExclude, "colo-exclude", "", "Comma-separated colo blacklist; exclude these CDN nodes from results (e.g. LAX,DFW)")
+	rounds    int
+	skipFirst int
+
+	// Download speed tests run at the same time
+	dlConcurrency int
+
+	// Slow down while timeouts and resets spike, or cap the probe rate
+	backoff bool
+	pps     float64
+	link    string
+
+	// Save the search to this file and resume it after an interruption
+	stateFile string
+
+	// Search with raw SYN probes or ICMP pings
+	syn         bool
+	icmp        bool
+	icmpSockets int
+	icmpSocket  string
+
+	// Simulated probes and uploads
+	simulate      bool
+	simulateModel string
+
+	// Probe result cache
+	probeCache    string
+	probeCacheTTL time.Duration
+
+	// allResults receives every probe result, not only the top ones
+	allResults string
+
+	// Colo filter
+	coloAllow   string
+	coloExclude string
+
+	// Diagnostics
+	debugAddr string
+
+	// Periodic mode
+	interval time.Duration
+
+	// Health reporting
+	healthAddr   string
+	statusFile   string
+	healthMaxAge time.Duration
+
+	// Run lock
+	lockFile   string
+	lockMaxAge time.Duration
+
+	// Run time limit
+	maxRuntime        time.Duration
+	maxRuntimeReserve time.Duration
+
+	// Distributed probing
+	agents       repeatStringFlag
+	agentMerge   string
+	agentWeights repeatStringFlag
+	agentTimeout time.Duration
+
+	// Kubernetes leader election
+	leaderElect    bool
+	leaseName      string
+	leaseNamespace string
+	leaseDuration  time.Duration
+	leaseRenew     time.Duration
+
+	// Listener authentication
+	auth admin.Auth
+
+	// Notifications
+	notifyTop       int
+	telegramToken   string
+	telegramChat    string
+	slackWebhook    string
+	discordWebhook  string
+	webhookURL      string
+	webhookTemplate string
+	webhookEvents   string
+	webhookHeaders  repeatStringFlag
+	smtpAddr        string
+	smtpTLS         string
+	smtpUser        string
+	smtpPassword    string
+	smtpFrom        string
+	smtpTo          string
+	ntfyTopic       string
+	ntfyToken       string
+	pushoverToken   string
+	pushoverUser    string
+	mqttBroker      string
+	mqttTopic       string
+	mqttClientID    string
+	mqttUser        string
+	mqttPassword    string
+	mqttCA          string
+	mqttHADiscovery bool
+	mqttHAPrefix    string
+
+	// Publishing
+	kvAccount        string
+	kvNamespace      string
+	kvKey            string
+	kvToken          string
+	kvFormat         string
+	archiveURL       string
+	archiveEndpoint  string
+	archiveRegion    string
+	archiveKey       string
+	archiveAccessKey string
+	archiveSecretKey string
+	gitRepo          string
+	gitBranch        string
+	gitPath          string
+	gitFormat        string
+	gitDeployKey     string
+	gitWorkDir       string
+	gitAuthor        string
+	gitMessage       string
+	extDNSName       string
+	extDNSFile       string
+	extDNSApply      bool
+	extDNSResource   string
+	extDNSNamespace  string
+	extDNSTTL        int
+	consulService    string
+	consulAddr       string
+	consulToken      string
+	consulPort       int
+	consulTags       string
+	etcdEndpoint     string
+	etcdKey          string
+	etcdFormat       string
+	etcdUser         string
+	etcdPassword     string
+
+	// Alerting
+	grafanaURL          string
+	grafanaToken        string
+	grafanaDashboard    string
+	grafanaTags         string
+	grafanaRotations    bool
+	pagerDutyKey        string
+	opsgenieKey         string
+	opsgenieURL         string
+	alertmanagerURL     string
+	alertUploadFailures int
+
+	// Config file
+	configFile string
+	cmdline    []string // the arguments the options were parsed from, for reloads
+}
 
-	flag.Parse()
+func registerFlags(fs *flag.FlagSet, o *options) {
+	fs.StringVar(&o.configFile, "config", "", "Read settings from this file of 'flag = value' lines; flags on the command line win. Reloaded on SIGHUP")
+	fs.Var(&o.cidrs, "cidr", "CIDRs, IPs or ranges to search, comma-separated (repeatable). Example: 1.1.0.0/16, 2606:4700::/32 or 1.0.0.1-1.0.0.255")
+	fs.StringVar(&o.cidrFile, "cidr-file", "", "Path to a file containing CIDRs, IPs or ranges (one or more per line, # comment supported)")
+	fs.IntVar(&o.budget, "budget", 2000, "Total probe budget (number of IPs to probe)")
+	fs.IntVar(&o.budgetV6, "budget-v6", 0, "Part of --budget given to the IPv6 search when the CIDRs mix IPv4 and IPv6; the rest goes to IPv4 (0 = half)")
+	fs.IntVar(&o.topN, "top", 20, "Top N IPs to output")
+	tuned := hardware().Defaults()
+	fs.IntVar(&o.concur, "concurrency", tuned.Concurrency, "Probe concurrency (the default is tuned to the CPUs, memory and --link)")
+	fs.IntVar(&o.concurV6, "concurrency-v6", 0, "Part of --concurrency given to the IPv6 search when the CIDRs mix IPv4 and IPv6; the rest goes to IPv4 (0 = half)")
+	fs.StringVar(&o.stateFile, "state", "", "Save the search state to this file every 10s; an interrupted search resumes from it on the next run with the same CIDRs (deleted once a search completes)")
+	fs.BoolVar(&o.syn, "syn", false, "Search with raw SYN probes (Linux, root or CAP_NET_RAW) and HTTP-probe only the best IPs; falls back to HTTP probes when unavailable")
+	fs.BoolVar(&o.icmp, "icmp", false, "Search with ICMP pings and HTTP-probe only the best IPs; falls back to HTTP probes when ICMP sockets are not permitted")
+	fs.IntVar(&o.icmpSockets, "icmp-sockets", 4, "ICMP sockets per address family shared by all --icmp pings")
+	fs.StringVar(&o.icmpSocket, "icmp-socket", icmpping.SocketAuto, "Kind of the --icmp sockets: auto (unprivileged datagram sockets where permitted, raw otherwise), dgram or raw; a forced kind that cannot be opened fails the search instead of falling back to HTTP probes")
+	fs.BoolVar(&o.simulate, "simulate", false, "Run the whole pipeline without network traffic: probes and download tests draw from a latency model, DNS uploads go to in-memory providers, and agents, publishers, the archive and notifications are skipped")
+	fs.StringVar(&o.simulateModel, "simulate-model", "", "Latency model of --simulate: lines of 'PREFIX latency=80ms [jitter=10ms] [loss=0.05] [colo=HKG] [mbps=200]' (default: synthetic subnets)")
+	fs.StringVar(&o.probeCache, "probe-cache", "", "Keep successful probe results in this file and skip re-probing IPs measured within --probe-cache-ttl (also used by verify)")
+	fs.DurationVar(&o.probeCacheTTL, "probe-cache-ttl", time.Hour, "How long cached probe results are reused")
+	fs.BoolVar(&o.backoff, "backoff", true, "Automatically probe fewer IPs at a time while timeouts and connection resets spike (--backoff=false to disable)")
+	fs.Float64Var(&o.pps, "pps", tuned.PPS, "Maximum probes started per second, shared by all probe workers of the search (0 = unlimited; the default is tuned to --link)")
+	fs.StringVar(&o.link, "link", "auto", "Link type the defaults of --concurrency, --pps and --download-concurrency are tuned to: auto (detect), ethernet, wifi or cellular")
+	fs.IntVar(&o.heads, "heads", 4, "Number of search heads (diversification)")
+	fs.IntVar(&o.beam, "beam", 32, "Beam width per head (kept candidate prefixes)")
+	fs.DurationVar(&o.timeout, "timeout", 3*time.Second, "Per-probe timeout")
+	fs.StringVar(&o.host, "host", "example.com", "Host name used for BOTH TLS SNI and HTTP Host header (recommended)")
+	fs.StringVar(&o.sni, "sni", "", "TLS SNI server name (deprecated: use --host)")
+	fs.StringVar(&o.hostHdr, "host-header", "", "HTTP Host header (deprecated: use --host)")
+	fs.StringVar(&o.path, "path", "/cdn-cgi/trace", "HTTP path to request")
+	fs.IntVar(&o.dlTop, "download-top", 5, "After search, run download speed test for top N IPs (0 to disable)")
+	fs.Int64Var(&o.dlBytes, "download-bytes", 0, "Download test size in bytes; 0 = 50M for default endpoint, no limit for custom URL (default: 0)")
+	fs.DurationVar(&o.dlTimeout, "download-timeout", 45*time.Second, "Per-IP download test timeout")
+	fs.IntVar(&o.dlConcurrency, "download-concurrency", tuned.DownloadConcurrency, "Number of download speed tests run at the same time (they share the bandwidth; the default is tuned to the machine)")
+	fs.StringVar(&o.dlURL, "download-url", "", "Custom download test URL (e.g. https://myhost.com/path/to/file). Overrides default speed.cloudflare.com")
+	fs.StringVar(&o.outFmt, "out", "jsonl", "Output format: jsonl|csv|text")
+	fs.StringVar(&o.outPath, "out-file", "", "Write output to file (default: stdout)")
+	fs.StringVar(&o.reportFile, "report-file", "", "Also write an HTML report of the results to this file")
+	fs.StringVar(&o.allResults, "all-results", "", "Stream every probe result (not only the top ones) to this file as JSON Lines")
+	fs.IntVar(&o.splitV4, "split-step-v4", 2, "When splitting an IPv4 prefix, increase prefix bits by this step")
+	fs.IntVar(&o.splitV6, "split-step-v6", 4, "When splitting an IPv6 prefix, increase prefix bits by this step")
+	fs.IntVar(&o.minSplit, "min-samples-split", 5, "Minimum samples on a prefix before it can be split")
+	fs.IntVar(&o.maxBitsV4, "max-bits-v4", 24, "Maximum IPv4 prefix bits to drill down to")
+	fs.IntVar(&o.maxBitsV6, "max-bits-v6", 56, "Maximum IPv6 prefix bits to drill down to")
+	fs.Int64Var(&o.seed, "seed", 0, "Random seed (0 = time-based)")
+	fs.Var(levelFlag{&o.logLevel, slog.LevelDebug}, "v", "Log the progress of the run to stderr (debug level)")
+	fs.Var(levelFlag{&o.logLevel, logging.LevelTrace}, "vv", "Log the progress and every probe to stderr (trace level)")
+	fs.StringVar(&o.logFormat, "log-format", "text", "Format of the -v/-vv log lines: "+strings.Join(logging.Formats, "|"))
+	fs.StringVar(&o.lang, "lang", "auto", "Language of the messages, run summaries and HTML report: auto|en|zh-CN (auto = from $LANG)")
+	fs.StringVar(&o.color, "color", color.Auto, "Color the terminal output: "+strings.Join(color.Modes, "|")+" (auto = only on a terminal, and never with $NO_COLOR set)")
+	fs.Float64Var(&o.goodMS, "good-ms", 200, "Score in ms at or under which -out text shows a result in green; slower ones are yellow, failures red")
+	fs.BoolVar(&o.explain, "explain", false, "Break the score of each result down (latency, failure penalty, agents, jitter, subnet estimates, speed rank): a line under each result of -out text, an \"explain\" object in jsonl and debug")
+	fs.BoolVar(&o.telemetry, "telemetry", false, "Opt in to send an anonymous usage report after each run: duration, sample count, probe strategy and the kinds of DNS providers and publishers, never IPs, domains or credentials (-v logs each report)")
+	fs.StringVar(&o.telemetryURL, "telemetry-url", telemetryURL, "Endpoint of the --telemetry reports")
 
-	// Colo: at most one of allow vs exclude
-	if coloAllow != "" && coloExclude != "" {
-		fmt.Fprintln(os.Stderr, "error: cannot use both --colo and --colo-exclude; use only one")
-		os.Exit(1)
-	}
+	// DNS upload flags
+	fs.StringVar(&o.dnsProvider, "dns-provider", "", "DNS provider for uploading results (cloudflare|vercel)")
+	fs.StringVar(&o.dnsToken, "dns-token", "", "DNS provider API token (or use CF_API_TOKEN/VERCEL_TOKEN env)")
+	fs.StringVar(&o.dnsZone, "dns-zone", "", "DNS zone ID (Cloudflare) or domain (Vercel) (or use CF_ZONE_ID/VERCEL_DOMAIN env)")
+	fs.StringVar(&o.dnsSubdomain, "dns-subdomain", "", "Subdomain to update (e.g., 'cf' for cf.example.com)")
+	fs.IntVar(&o.dnsUploadCount, "dns-upload-count", 0, "Number of IPs to upload (default: same as --download-top)")
+	fs.StringVar(&o.dnsTeamID, "dns-team-id", "", "Vercel Team ID (optional, or use VERCEL_TEAM_ID env)")
+	fs.IntVar(&o.dnsMinIPs, "dns-min-ips", 0, "Skip DNS upload unless at least N download-tested IPs qualify (0 = any; after an interrupt: the full upload count)")
+	fs.BoolVar(&o.dnsOnInterrupt, "dns-on-interrupt", false, "After Ctrl-C, still download-test the best-so-far IPs and upload them if enough qualify")
+	fs.Var(&o.dnsTargets, "dns-target", "Additional upload target [PROVIDER:][ZONE/]SUBDOMAIN (repeatable; provider and zone default to --dns-provider/--dns-zone)")
+	fs.DurationVar(&o.dnsStagger, "dns-stagger", 0, "Pause between uploads to targets of the same DNS provider")
+	fs.Float64Var(&o.dnsRate, "dns-rate", 4, "DNS API requests per second per provider, shared by all targets and runs (0 = unlimited)")
+	registerDNSAPIFlags(fs, o)
 
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-	defer cancel()
+	// New engine parameters
+	fs.Float64Var(&o.diversityWeight, "diversity-weight", 0.3, "Weight for head diversity (0-1, higher = more exploration)")
+	fs.IntVar(&o.splitInterval, "split-interval", 20, "Check for split opportunities every N samples")
+	fs.Float64Var(&o.dedupeFPRate, "dedupe-fp-rate", 0.001, "False-positive rate of the bloom filter that replaces the exact set of sampled IPs above 4M budget (0-1)")
 
-	// Unify host: by default use --host for both SNI and Host header.
-	if sni == "" {
-		sni = host
-	}
-	if hostHdr == "" {
-		hostHdr = host
-	}
+	// Probe rounds configuration
+	fs.IntVar(&o.rounds, "rounds", 6, "Number of probe rounds per IP (default: 6)")
+	fs.IntVar(&o.skipFirst, "skip-first", 1, "Skip first N rounds when calculating average (default: 1, skips handshake overhead)")
 
-	// Parse colo lists (comma-separated, trim spaces)
-	parseColoList := func(s string) []string {
-		if s == "" {
-			return nil
-		}
-		parts := strings.Split(s, ",")
-		out := make([]string, 0, len(parts))
-		for _, p := range parts {
-			p = strings.TrimSpace(p)
-			if p != "" {
-				out = append(out, p)
-			}
-		}
-		return out
-	}
+	// Colo filter (CDN node filter by trace colo)
+	fs.StringVar(&o.coloAllow, "colo", "", "Comma-separated colo whitelist; only these CDN nodes enter results (e.g. HKG,SJC)")
+	fs.StringVar(&o.coloExclude, "colo-exclude", "", "Comma-separated colo blacklist; exclude these CDN nodes from results (e.g. LAX,DFW)")
+
+	// Diagnostics
+	fs.StringVar(&o.debugAddr, "debug-addr", "", "Serve pprof, expvar and runtime stats on this address (e.g. 127.0.0.1:6060; empty = disabled)")
+
+	// Periodic mode
+	fs.DurationVar(&o.interval, "interval", 0, "Keep running and repeat the search every interval (e.g. 6h; 0 = run once)")
+
+	// Health reporting
+	fs.StringVar(&o.healthAddr, "health-addr", "", "Serve /healthz on this address (e.g. :8080; empty = disabled)")
+	fs.StringVar(&o.statusFile, "status-file", "", "Write the outcome of each run to this JSON file (read by 'mcis healthcheck')")
+	fs.DurationVar(&o.healthMaxAge, "health-max-age", 0, "Report unhealthy when the last successful run is older than this (0 = 2x --interval, or 24h)")
+
+	// Notifications
+	fs.IntVar(&o.notifyTop, "notify-top", 5, "Number of best IPs listed in run notifications")
+	fs.StringVar(&o.telegramToken, "telegram-token", "", "Telegram bot token for run notifications (or use TELEGRAM_BOT_TOKEN env)")
+	fs.StringVar(&o.telegramChat, "telegram-chat", "", "Telegram chat ID (or @channel) that receives a summary after each run")
+	fs.StringVar(&o.slackWebhook, "slack-webhook", "", "Slack incoming webhook URL that receives a summary after each run (or use SLACK_WEBHOOK_URL env)")
+	fs.StringVar(&o.discordWebhook, "discord-webhook", "", "Discord webhook URL that receives a summary embed after each run (or use DISCORD_WEBHOOK_URL env)")
+	fs.StringVar(&o.webhookURL, "webhook-url", "", "POST a JSON payload to this URL on run events")
+	fs.StringVar(&o.webhookTemplate, "webhook-template", "", "File with a Go text/template for the --webhook-url payload (default: the event and the whole summary)")
+	fs.StringVar(&o.webhookEvents, "webhook-events", "", "Comma-separated events that trigger --webhook-url ("+strings.Join(notify.EventNames, "|")+"; default: all)")
+	fs.Var(&o.webhookHeaders, "webhook-header", "Extra 'Name: value' header for --webhook-url requests (repeatable)")
+	fs.StringVar(&o.smtpAddr, "smtp-addr", "", "SMTP server host:port for emailing a summary and the results after each run")
+	fs.StringVar(&o.smtpTLS, "smtp-tls", "", "SMTP TLS mode: starttls|tls|none (default: tls on port 465, starttls otherwise)")
+	fs.StringVar(&o.smtpUser, "smtp-user", "", "SMTP username (empty = no authentication)")
+	fs.StringVar(&o.smtpPassword, "smtp-password", "", "SMTP password (or use SMTP_PASSWORD env)")
+	fs.StringVar(&o.smtpFrom, "smtp-from", "", "Sender address of the emails, e.g. 'mcis <mcis@example.com>'")
+	fs.StringVar(&o.smtpTo, "smtp-to", "", "Comma-separated recipient addresses")
+	fs.StringVar(&o.ntfyTopic, "ntfy-topic", "", "ntfy topic (name on ntfy.sh, or full URL) that receives a push after each run")
+	fs.StringVar(&o.ntfyToken, "ntfy-token", "", "ntfy access token for protected topics (or use NTFY_TOKEN env)")
+	fs.StringVar(&o.pushoverToken, "pushover-token", "", "Pushover application token (or use PUSHOVER_TOKEN env)")
+	fs.StringVar(&o.pushoverUser, "pushover-user", "", "Pushover user or group key that receives a push after each run (or use PUSHOVER_USER env)")
+	fs.StringVar(&o.mqttBroker, "mqtt-broker", "", "MQTT broker (mqtt://host:1883 or mqtts://host:8883) that receives the summary and selected IPs after each run")
+	fs.StringVar(&o.mqttTopic, "mqtt-topic", "mcis", "MQTT topic prefix; publishes <prefix>/summary and <prefix>/ips")
+	fs.StringVar(&o.mqttClientID, "mqtt-client-id", "", "MQTT client ID (default: mcis-<hostname>)")
+	fs.StringVar(&o.mqttUser, "mqtt-user", "", "MQTT username")
+	fs.StringVar(&o.mqttPassword, "mqtt-password", "", "MQTT password (or use MQTT_PASSWORD env)")
+	fs.StringVar(&o.mqttCA, "mqtt-ca", "", "CA certificate file (PEM) for verifying an mqtts broker (default: system roots)")
+	fs.BoolVar(&o.mqttHADiscovery, "mqtt-ha-discovery", false, "Announce the run sensors (best latency, last upload, ...) to Home Assistant via MQTT discovery")
+	fs.StringVar(&o.mqttHAPrefix, "mqtt-ha-prefix", "homeassistant", "Home Assistant MQTT discovery prefix")
+	fs.StringVar(&o.kvNamespace, "kv-namespace", "", "Cloudflare Workers KV namespace ID to write the selected IPs to")
+	fs.StringVar(&o.kvKey, "kv-key", "ips", "Workers KV key for the selected IPs")
+	fs.StringVar(&o.kvAccount, "kv-account", "", "Cloudflare account ID of the KV namespace (or use CF_ACCOUNT_ID env)")
+	fs.StringVar(&o.kvToken, "kv-token", "", "Cloudflare API token with Workers KV write access (or use CF_API_TOKEN env)")
+	fs.StringVar(&o.kvFormat, "kv-format", publish.FormatJSON, "Format of the KV value: json (array of IPs) or text (one IP per line)")
+	fs.StringVar(&o.archiveURL, "archive-url", "", "Upload --out-file and --report-file after each run to this bucket: s3://bucket[/prefix] or gs://bucket[/prefix]")
+	fs.StringVar(&o.archiveEndpoint, "archive-endpoint", "", "Endpoint of an S3-compatible service (MinIO, R2, ...), e.g. https://minio.local:9000")
+	fs.StringVar(&o.archiveRegion, "archive-region", "", "Bucket region (or use AWS_REGION env; default us-east-1, auto for gs://)")
+	fs.StringVar(&o.archiveKey, "archive-key", publish.DefaultArchiveKey, "Object key template below the prefix (fields: .Date .Time .Finished .Host .Name)")
+	fs.StringVar(&o.archiveAccessKey, "archive-access-key", "", "Access key ID, or an HMAC key for GCS (or use AWS_ACCESS_KEY_ID env)")
+	fs.StringVar(&o.archiveSecretKey, "archive-secret-key", "", "Secret access key (or use AWS_SECRET_ACCESS_KEY env)")
+	fs.StringVar(&o.gitRepo, "git-repo", "", "Commit the selected IPs to this Git repository and push (e.g. git@github.com:org/infra.git)")
+	fs.StringVar(&o.gitBranch, "git-branch", "main", "Branch of --git-repo to commit to")
+	fs.StringVar(&o.gitPath, "git-path", "ips.json", "File in --git-repo that receives the selected IPs")
+	fs.StringVar(&o.gitFormat, "git-format", publish.FormatJSON, "Format of --git-path: json (array of IPs) or text (one IP per line)")
+	fs.StringVar(&o.gitDeployKey, "git-deploy-key", "", "SSH private key (deploy key) for --git-repo (or use GIT_DEPLOY_KEY env)")
+	fs.StringVar(&o.gitWorkDir, "git-workdir", "", "Directory that keeps the clone of --git-repo between runs (default: in the user cache directory)")
+	fs.StringVar(&o.gitAuthor, "git-author", "", "Commit author as 'Name <email>' (default: mcis <mcis@localhost>)")
+	fs.StringVar(&o.gitMessage, "git-message", publish.DefaultGitMessage, "Commit message template (fields: .IPs .Top .Finished .ResultsFile)")
+	fs.StringVar(&o.extDNSName, "external-dns-name", "", "Hand the selected IPs to Kubernetes external-dns as a DNSEndpoint for this name (e.g. cf.example.com)")
+	fs.StringVar(&o.extDNSFile, "external-dns-file", "", "Write the DNSEndpoint manifest (YAML) to this file")
+	fs.BoolVar(&o.extDNSApply, "external-dns-apply", false, "Create or update the DNSEndpoint in the cluster with the pod's service account")
+	fs.StringVar(&o.extDNSResource, "external-dns-resource", "mcis", "Name of the DNSEndpoint resource")
+	fs.StringVar(&o.extDNSNamespace, "external-dns-namespace", "", "Namespace of the DNSEndpoint (default: the namespace of the pod)")
+	fs.IntVar(&o.extDNSTTL, "external-dns-ttl", 0, "Record TTL in seconds (0: external-dns default)")
+	fs.StringVar(&o.consulService, "consul-service", "", "Register the selected IPs as instances of this Consul service")
+	fs.StringVar(&o.consulAddr, "consul-addr", "", "Consul agent HTTP address (or use CONSUL_HTTP_ADDR env; default "+publish.ConsulDefaultAddr+")")
+	fs.StringVar(&o.consulToken, "consul-token", "", "Consul ACL token (or use CONSUL_HTTP_TOKEN env)")
+	fs.IntVar(&o.consulPort, "consul-port", 443, "Port of the registered service instances")
+	fs.StringVar(&o.consulTags, "consul-tags", "", "Comma-separated tags of the registered service instances")
+	fs.StringVar(&o.etcdEndpoint, "etcd-endpoint", "", "Write the selected IPs to etcd at this client URL (e.g. http://127.0.0.1:2379)")
+	fs.StringVar(&o.etcdKey, "etcd-key", "/mcis/ips", "etcd key for the selected IPs")
+	fs.StringVar(&o.etcdFormat, "etcd-format", publish.FormatJSON, "Format of the etcd value: json (array of IPs) or text (one IP per line)")
+	fs.StringVar(&o.etcdUser, "etcd-user", "", "etcd user, when auth is enabled")
+	fs.StringVar(&o.etcdPassword, "etcd-password", "", "etcd password (or use ETCD_PASSWORD env)")
+	fs.StringVar(&o.grafanaURL, "grafana-url", "", "Grafana base URL to post an annotation of each run to (e.g. https://grafana.example.com)")
+	fs.StringVar(&o.grafanaToken, "grafana-token", "", "Grafana service account token with annotations:write (or use GRAFANA_TOKEN env)")
+	fs.StringVar(&o.grafanaDashboard, "grafana-dashboard", "", "UID of the dashboard to annotate (default: organization-wide annotations)")
+	fs.StringVar(&o.grafanaTags, "grafana-tags", "", "Comma-separated extra tags for the Grafana annotations")
+	fs.BoolVar(&o.grafanaRotations, "grafana-only-rotations", false, "Annotate only runs that changed the published IPs")
+	fs.StringVar(&o.pagerDutyKey, "alert-pagerduty-key", "", "PagerDuty Events v2 routing key for paging when no IPs qualify or uploads keep failing (or use PAGERDUTY_ROUTING_KEY env)")
+	fs.StringVar(&o.opsgenieKey, "alert-opsgenie-key", "", "Opsgenie API key for the same alerts (or use OPSGENIE_API_KEY env)")
+	fs.StringVar(&o.opsgenieURL, "alert-opsgenie-url", "", "Opsgenie API base URL (default https://api.opsgenie.com; EU: https://api.eu.opsgenie.com)")
+	fs.StringVar(&o.alertmanagerURL, "alert-alertmanager", "", "Alertmanager base URL for the same alerts (e.g. http://alertmanager:9093)")
+	fs.IntVar(&o.alertUploadFailures, "alert-upload-failures", 3, "Page when the DNS upload failed on this many consecutive runs")
+
+	// Run lock
+	fs.StringVar(&o.lockFile, "lock-file", "", "Refuse to start a run while another process holds this lock file (empty = no lock)")
+	fs.DurationVar(&o.lockMaxAge, "lock-max-age", 0, "Take over a lock older than this even if its process seems alive (0 = only when the process is gone)")
+
+	// Run time limit
+	fs.DurationVar(&o.maxRuntime, "max-runtime", 0, "Finish each run within this time (e.g. 25m; 0 = no limit): sampling stops early so the best IPs so far are still verified, written out and uploaded")
+	fs.DurationVar(&o.maxRuntimeReserve, "max-runtime-reserve", time.Minute, "Part of --max-runtime kept for verifying, download-testing, writing and uploading the results")
+
+	// Distributed probing
+	fs.Var(&o.agents, "agent", "URL of a probe agent ('mcis agent') that re-probes the top results from its vantage point (repeatable)")
+	fs.StringVar(&o.agentMerge, "agent-merge", "max", "How agent latencies are merged: max (worst vantage point) | weighted (weighted mean)")
+	fs.Var(&o.agentWeights, "agent-weight", "Weight of a vantage point for --agent-merge weighted, as NAME=WEIGHT ('local' = this host; repeatable)")
+	fs.DurationVar(&o.agentTimeout, "agent-timeout", 2*time.Minute, "Timeout for each agent to probe all candidates")
+
+	// Kubernetes leader election
+	fs.BoolVar(&o.leaderElect, "leader-elect", false, "Only scan and upload while holding a Kubernetes Lease, so one of several replicas is active")
+	fs.StringVar(&o.leaseName, "leader-elect-lease", "mcis", "Name of the Lease used by --leader-elect")
+	fs.StringVar(&o.leaseNamespace, "leader-elect-namespace", "", "Namespace of the Lease (default: the pod's namespace)")
+	fs.DurationVar(&o.leaseDuration, "leader-elect-duration", 30*time.Second, "How long a Lease stays valid without renewal")
+	fs.DurationVar(&o.leaseRenew, "leader-elect-renew-deadline", 20*time.Second, "Give up leadership and abort when the Lease could not be renewed for this long; must be shorter than --leader-elect-duration")
+
+	// Listener authentication
+	registerAuthFlags(fs, &o.auth)
+}
 
-	// Build engine config
-	cfg := engine.Config{
-		Budget:          budget,
-		TopN:            topN,
-		Concurrency:     concur,
-		Heads:           heads,
-		Beam:            beam,
-		SplitStepV4:     splitV4,
-		SplitStepV6:     splitV6,
-		MinSamplesSplit: minSplit,
-		MaxBitsV4:       maxBitsV4,
-		MaxBitsV6:       maxBitsV6,
-		Seed:            seed,
-		Verbose:         verbose,
-		DiversityWeight: diversityWeight,
-		SplitInterval:   splitInterval,
-		ColoAllow:       parseColoList(coloAllow),
-		ColoBlock:       parseColoList(coloExclude),
-	}
+// registerDNSAPIFlags registers the flags that point the DNS providers at
+// another API endpoint, shared by the search, verify and prune.
+func registerDNSAPIFlags(fs *flag.FlagSet, o *options) {
+	fs.Var(&o.dnsAPIs, "dns-api", "Base URL of a DNS provider's API as PROVIDER=URL, e.g. behind a reverse proxy or egress gateway (repeatable; a bare URL is for --dns-provider)")
+	fs.StringVar(&o.dnsCA, "dns-ca", "", "CA certificate file (PEM) for verifying the DNS provider APIs (default: system roots)")
+}
 
-	probeCfg := probe.Config{
-		Timeout:    timeout,
-		SNI:        sni,
-		HostHeader: hostHdr,
-		Path:       path,
-		Rounds:     rounds,
-		SkipFirst:  skipFirst,
-	}
+// registerAuthFlags registers the flags that protect the HTTP listeners.
+func registerAuthFlags(fs *flag.FlagSet, a *admin.Auth) {
+	fs.StringVar(&a.Token, "auth-token", "", "Bearer token required by the HTTP listeners except /healthz, and sent to agents")
+	fs.StringVar(&a.CertFile, "tls-cert", "", "Serve the HTTP listeners over HTTPS with this certificate (also the client certificate for agents)")
+	fs.StringVar(&a.KeyFile, "tls-key", "", "Private key of --tls-cert")
+	fs.StringVar(&a.CAFile, "tls-ca", "", "Require client certificates signed by this CA (mTLS), and verify agents against it")
+}
 
-	req := engine.Request{
-		CIDRs:    []string(cidrs),
-		CIDRFile: cidrFile,
-		Probe:    probeCfg,
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "service":
+			os.Exit(serviceCommand(os.Args[2:]))
+		case "healthcheck":
+			os.Exit(healthcheckCommand(os.Args[2:]))
+		case "verify":
+			os.Exit(verifyCommand(os.Args[2:]))
+		case "prune":
+			os.Exit(pruneCommand(os.Args[2:]))
+		case "agent":
+			os.Exit(agentCommand(os.Args[2:]))
+		case "completion":
+			os.Exit(completionCommand(os.Args[2:]))
+		case "config":
+			os.Exit(configCommand(os.Args[2:]))
+		case "update":
+			os.Exit(updateCommand(os.Args[2:]))
+		case "bench":
+			os.Exit(benchCommand(os.Args[2:]))
+		}
 	}
 
-	// Create and run engine
-	eng := engine.New(cfg, probeCfg)
-	res, err := eng.Run(ctx, req)
+	opts, err := loadOptions(os.Args[1:], flag.ExitOnError)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "error:", err)
-		os.Exit(1)
+		i18n.Fprintln(os.Stderr, "error:", err)
+		os.Exit(2)
 	}
+	os.Exit(searchMain(opts))
+}
 
-	// Download speed test
-	if dlTop < 0 {
-		dlTop = 0
+// searchMain runs the search described by o with signal handling, health
+// reporting and diagnostics, and returns the process exit code.
+func searchMain(o *options) int {
+	if err := checkFlags(o); err != nil {
+		i18n.Fprintln(os.Stderr, "error:", err)
+		return exitError
 	}
-	if dlTop > 0 {
-		if dlTop > len(res.Top) {
-			dlTop = len(res.Top)
-		}
-		// Default Bytes=0 (no limit); when no custom URL use 50M.
-		if dlBytes == 0 && dlURL == "" {
-			dlBytes = 50_000_000
-		}
-		dlCfg := probe.DownloadConfig{
-			Timeout: dlTimeout,
-			Bytes:   dlBytes,
-		}
-		if dlURL != "" {
-			u, err := url.Parse(dlURL)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, "error: invalid --download-url:", err)
-				os.Exit(1)
-			}
-			if u.Hostname() == "" {
-				fmt.Fprintln(os.Stderr, "error: --download-url must include a hostname (e.g. https://myhost.com/path/to/file)")
-				os.Exit(1)
-			}
-			dlCfg.SNI = u.Hostname()
-			dlCfg.HostName = u.Hostname()
-			dlCfg.Path = u.Path
-			if u.RawQuery != "" {
-				dlCfg.Path = u.Path + "?" + u.RawQuery
-			}
-			dlCfg.CustomURL = true
-		}
-		dlp := probe.NewDownloadProber(dlCfg)
-		if verbose {
-			if dlURL != "" {
-				bytesDesc := fmt.Sprintf("max %d bytes", dlCfg.Bytes)
-				if dlCfg.Bytes == 0 {
-					bytesDesc = "full file (no limit)"
-				}
-				fmt.Fprintf(os.Stderr, "download: using custom URL host=%s path=%s (top %d IPs, %s)\n",
-					dlCfg.HostName, dlCfg.Path, dlTop, bytesDesc)
-			} else {
-				fmt.Fprintf(os.Stderr, "download: using default speed.cloudflare.com/__down (top %d IPs, %d bytes)\n",
-					dlTop, dlBytes)
-			}
-		}
-		for i := 0; i < dlTop; i++ {
-			r := &res.Top[i]
-			dctx, dcancel := context.WithTimeout(ctx, dlTimeout)
-			dr := dlp.Download(dctx, r.IP)
-			dcancel()
-			r.DownloadOK = dr.OK
-			r.DownloadBytes = dr.Bytes
-			r.DownloadMS = dr.TotalMS
-			r.DownloadMbps = dr.Mbps
-			r.DownloadError = dr.Error
-			if verbose {
-				fmt.Fprintf(os.Stderr, "download: rank=%d ip=%s ok=%v mbps=%.2f ms=%d bytes=%d err=%s\n",
-					i+1, r.IP.String(), dr.OK, dr.Mbps, dr.TotalMS, dr.Bytes, dr.Error)
-			}
-		}
+	if err := setupLogging(o.logLevel, o.logFormat); err != nil {
+		i18n.Fprintln(os.Stderr, "error:", err)
+		return exitError
 	}
 
-	// DNS upload
-	if dnsProvider != "" {
-		if dnsSubdomain == "" {
-			fmt.Fprintln(os.Stderr, "error: --dns-subdomain is required when --dns-provider is set")
-			os.Exit(1)
-		}
-		if dlTop <= 0 {
-			fmt.Fprintln(os.Stderr, "error: --download-top must be > 0 when using DNS upload")
-			os.Exit(1)
+	// The first SIGINT/SIGTERM only stops sampling: the best-so-far results are
+	// still written out (and optionally uploaded). A second signal aborts everything.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	scanCtx, scanCancel := context.WithCancel(ctx)
+	defer scanCancel()
+
+	var interrupted atomic.Bool
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	go func() {
+		<-sigCh
+		interrupted.Store(true)
+		i18n.Fprintln(os.Stderr, "interrupt: stopping search and flushing best-so-far results (press Ctrl-C again to abort)")
+		scanCancel()
+		<-sigCh
+		i18n.Fprintln(os.Stderr, "interrupt: aborting")
+		cancel()
+	}()
+
+	if err := startHealth(ctx, o); err != nil {
+		i18n.Fprintln(os.Stderr, "error: health server:", err)
+		return exitError
+	}
+	if o.debugAddr != "" {
+		if err := startDebugServer(ctx, o.debugAddr, o.auth); err != nil {
+			i18n.Fprintln(os.Stderr, "error: debug server:", err)
+			return exitError
 		}
+	}
 
-		dnsCfg := dns.Config{
-			Provider:    dnsProvider,
-			Token:       dnsToken,
-			Zone:        dnsZone,
-			Subdomain:   dnsSubdomain,
-			UploadCount: dnsUploadCount,
-			TeamID:      dnsTeamID,
-		}
+	superviseSystemd(ctx)
 
-		provider, err := dns.NewProvider(dnsCfg)
+	var lostLease atomic.Bool
+	if o.leaderElect {
+		release, leading, err := electLeader(scanCtx, ctx, o, func() {
+			lostLease.Store(true)
+			cancel()
+		})
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "error:", err)
-			os.Exit(1)
+			i18n.Fprintln(os.Stderr, "error:", err)
+			return exitError
 		}
-
-		// Collect IPs from download-tested results only
-		type dlResult struct {
-			IP   netip.Addr
-			Mbps float64
-		}
-		var candidates []dlResult
-		for i := 0; i < dlTop && i < len(res.Top); i++ {
-			r := res.Top[i]
-			if r.DownloadOK {
-				candidates = append(candidates, dlResult{IP: r.IP, Mbps: r.DownloadMbps})
-			}
+		if !leading {
+			return exitOK
 		}
+		defer release()
+	}
 
-		// Sort by download speed (highest first)
-		sort.Slice(candidates, func(i, j int) bool {
-			return candidates[i].Mbps > candidates[j].Mbps
-		})
+	watchReload(o, ctx.Done())
+
+	err := runLoop(ctx, scanCtx, o, interrupted.Load)
+	notifyStopping()
+	switch {
+	case lostLease.Load():
+		return exitError
+	case err != nil:
+		i18n.Fprintln(os.Stderr, "error:", err)
+		return exitError
+	case interrupted.Load():
+		return exitInterrupted
+	}
+	if st, ok := tracker.Last(); ok && !st.ScanOK {
+		i18n.Fprintln(os.Stderr, "error:", st.Error)
+		return exitNoResults
+	}
+	return exitOK
+}
 
-		// Determine how many IPs to upload
-		uploadN := dnsCfg.UploadCount
-		if uploadN <= 0 {
-			uploadN = dlTop
+// Exit codes of a search run.
+const (
+	exitOK          = 0
+	exitError       = 1 // invalid flags, or the scan, output or upload failed
+	exitNoResults   = 3 // the scan finished but no probed IP responded
+	exitInterrupted = 130
+)
+
+// runLoop runs the search once, or every --interval until scanCtx is canceled.
+// In periodic mode a failed run is reported and retried at the next tick.
+// Options reloaded by SIGHUP apply from the next run on.
+func runLoop(ctx, scanCtx context.Context, o *options, interrupted func() bool) error {
+	for {
+		if next := pendingOptions.Swap(nil); next != nil {
+			o = next
+			logLevel.Set(o.logLevel)
 		}
-		if uploadN > len(candidates) {
-			uploadN = len(candidates)
+		notifiers, err := setupNotifiers(o)
+		if err != nil {
+			return err
 		}
-
-		// Collect IPs to upload
-		var ipsToUpload []netip.Addr
-		for i := 0; i < uploadN; i++ {
-			ipsToUpload = append(ipsToUpload, candidates[i].IP)
+		if o.simulate {
+			notifiers = nil
 		}
-
-		if len(ipsToUpload) > 0 {
-			if verbose {
-				fmt.Fprintf(os.Stderr, "dns: uploading %d IPs to %s (subdomain: %s), sorted by download speed...\n",
-					len(ipsToUpload), provider.Name(), dnsSubdomain)
-				for i, ip := range ipsToUpload {
-					fmt.Fprintf(os.Stderr, "  %d. %s (%.2f Mbps)\n", i+1, ip.String(), candidates[i].Mbps)
-				}
-			}
-			if err := dns.Upload(ctx, provider, dnsSubdomain, ipsToUpload, verbose); err != nil {
-				fmt.Fprintln(os.Stderr, "dns upload error:", err)
-				os.Exit(1)
-			}
-		} else {
-			if verbose {
-				fmt.Fprintln(os.Stderr, "dns: no successful download-tested IPs to upload")
-			}
+		rep, err := run(ctx, scanCtx, o, interrupted)
+		recordStatus(o, rep.status(err))
+		notifyRun(ctx, o, notifiers, rep, err)
+		sendTelemetry(ctx, o, rep, err)
+		if o.interval <= 0 || scanCtx.Err() != nil {
+			return err
 		}
-	}
-
-	// Output
-	var w *os.File = os.Stdout
-	if outPath != "" {
-		f, err := os.Create(outPath)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "error:", err)
-			os.Exit(1)
+			i18n.Fprintln(os.Stderr, "error:", err)
 		}
-		defer func() {
-			_ = f.Close()
-		}()
-		w = f
-	}
 
-	switch outFmt {
-	case "jsonl":
-		if err := output.WriteJSONL(w, res.Top); err != nil {
-			fmt.Fprintln(os.Stderr, "error:", err)
-			os.Exit(1)
-		}
-	case "csv":
-		if err := output.WriteCSV(w, res.Top); err != nil {
-			fmt.Fprintln(os.Stderr, "error:", err)
-			os.Exit(1)
-		}
-	case "text":
-		if err := output.WriteText(w, res.Top); err != nil {
-			fmt.Fprintln(os.Stderr, "error:", err)
-			os.Exit(1)
+		finished := time.Now()
+		idle.Store(true)
+	wait:
+		for {
+			next := finished.Add(o.interval)
+			slog.Debug("waiting for the next run", logging.Phase("idle"), slog.Time("next", next))
+			phase("idle: next run at " + next.Format(time.RFC3339))
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-scanCtx.Done():
+				timer.Stop()
+				idle.Store(false)
+				return nil
+			case <-timer.C:
+				break wait
+			case <-reloaded:
+				timer.Stop()
+				if cur := pendingOptions.Load(); cur != nil && cur.interval > 0 {
+					o = cur // reschedule with the new interval
+					continue
+				}
+				break wait // periodic mode was turned off: run once more, then stop
+			}
 		}
-	case "debug":
-		enc := json.NewEncoder(w)
-		enc.SetIndent("", "  ")
-		_ = enc.Encode(res)
-	default:
-		fmt.Fprintln(os.Stderr, "error: unknown -out:", outFmt)
-		os.Exit(1)
+		idle.Store(false)
 	}
 }
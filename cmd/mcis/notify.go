@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/i18n"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/logging"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/mqtt"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/notify"
+)
+
+// notifyTimeout bounds the delivery of all notifications of one run.
+const notifyTimeout = 30 * time.Second
+
+// alertState survives config reloads, so that consecutive upload failures
+// keep counting and open pages get resolved.
+var alertState notify.AlertState
+
+// prevScores holds the scores of the previous run's results, for the deltas
+// in the next summary.
+var prevScores map[netip.Addr]float64
+
+// prevPublished holds the IPs of the last run that published any, to tell
+// rotations apart from runs that kept the same IPs.
+var prevPublished []netip.Addr
+
+// setupNotifiers creates the notifiers enabled by the flags.
+func setupNotifiers(o *options) ([]notify.Notifier, error) {
+	var ns []notify.Notifier
+
+	token := o.telegramToken
+	if token == "" {
+		token = os.Getenv("TELEGRAM_BOT_TOKEN")
+	}
+	switch {
+	case token != "" && o.telegramChat != "":
+		ns = append(ns, notify.NewTelegram(token, o.telegramChat))
+	case o.telegramChat != "":
+		return nil, errors.New("--telegram-chat needs a bot token (--telegram-token or TELEGRAM_BOT_TOKEN)")
+	case o.telegramToken != "":
+		return nil, errors.New("--telegram-token needs --telegram-chat")
+	}
+
+	webhook := o.slackWebhook
+	if webhook == "" {
+		webhook = os.Getenv("SLACK_WEBHOOK_URL")
+	}
+	if webhook != "" {
+		ns = append(ns, notify.NewSlack(webhook))
+	}
+
+	webhook = o.discordWebhook
+	if webhook == "" {
+		webhook = os.Getenv("DISCORD_WEBHOOK_URL")
+	}
+	if webhook != "" {
+		ns = append(ns, notify.NewDiscord(webhook))
+	}
+
+	if o.webhookURL != "" {
+		w, err := setupWebhook(o)
+		if err != nil {
+			return nil, err
+		}
+		ns = append(ns, w)
+	} else if o.webhookTemplate != "" || o.webhookEvents != "" || len(o.webhookHeaders) > 0 {
+		return nil, errors.New("--webhook-template, --webhook-events and --webhook-header need --webhook-url")
+	}
+
+	if o.smtpAddr != "" {
+		password := o.smtpPassword
+		if password == "" {
+			password = os.Getenv("SMTP_PASSWORD")
+		}
+		e, err := notify.NewEmail(notify.EmailConfig{
+			Addr:     o.smtpAddr,
+			TLS:      o.smtpTLS,
+			Username: o.smtpUser,
+			Password: password,
+			From:     o.smtpFrom,
+			To:       strings.FieldsFunc(o.smtpTo, func(r rune) bool { return r == ',' || r == ' ' }),
+		})
+		if err != nil {
+			return nil, err
+		}
+		ns = append(ns, e)
+	} else if o.smtpTo != "" {
+		return nil, errors.New("--smtp-to needs --smtp-addr")
+	}
+
+	if o.ntfyTopic != "" {
+		token := o.ntfyToken
+		if token == "" {
+			token = os.Getenv("NTFY_TOKEN")
+		}
+		ns = append(ns, notify.NewNtfy(o.ntfyTopic, token))
+	}
+	poToken, poUser := o.pushoverToken, o.pushoverUser
+	if poToken == "" {
+		poToken = os.Getenv("PUSHOVER_TOKEN")
+	}
+	if poUser == "" {
+		poUser = os.Getenv("PUSHOVER_USER")
+	}
+	switch {
+	case poToken != "" && poUser != "":
+		ns = append(ns, notify.NewPushover(poToken, poUser))
+	case o.pushoverToken != "" || o.pushoverUser != "":
+		return nil, errors.New("pushover needs both an application token (--pushover-token or PUSHOVER_TOKEN) and a user key (--pushover-user or PUSHOVER_USER)")
+	}
+
+	if o.mqttBroker != "" {
+		m, err := setupMQTT(o)
+		if err != nil {
+			return nil, err
+		}
+		ns = append(ns, m)
+	}
+
+	if o.grafanaURL != "" {
+		token := o.grafanaToken
+		if token == "" {
+			token = os.Getenv("GRAFANA_TOKEN")
+		}
+		if token == "" {
+			return nil, errors.New("--grafana-url needs a service account token (--grafana-token or GRAFANA_TOKEN)")
+		}
+		tags := strings.FieldsFunc(o.grafanaTags, func(r rune) bool { return r == ',' || r == ' ' })
+		ns = append(ns, notify.NewGrafana(o.grafanaURL, token, o.grafanaDashboard, tags, o.grafanaRotations))
+	}
+
+	var pagers []notify.Pager
+	pdKey := o.pagerDutyKey
+	if pdKey == "" {
+		pdKey = os.Getenv("PAGERDUTY_ROUTING_KEY")
+	}
+	if pdKey != "" {
+		pagers = append(pagers, notify.NewPagerDuty(pdKey))
+	}
+	ogKey := o.opsgenieKey
+	if ogKey == "" {
+		ogKey = os.Getenv("OPSGENIE_API_KEY")
+	}
+	if ogKey != "" {
+		pagers = append(pagers, notify.NewOpsgenie(ogKey, o.opsgenieURL))
+	}
+	if o.alertmanagerURL != "" {
+		pagers = append(pagers, notify.NewAlertmanager(o.alertmanagerURL))
+	}
+	if len(pagers) > 0 {
+		ns = append(ns, notify.NewAlerting(pagers, o.alertUploadFailures, &alertState))
+	}
+
+	return ns, nil
+}
+
+// setupMQTT creates the --mqtt-broker notifier.
+func setupMQTT(o *options) (*notify.MQTT, error) {
+	opts := mqtt.Options{
+		Broker:   o.mqttBroker,
+		ClientID: o.mqttClientID,
+		Username: o.mqttUser,
+		Password: o.mqttPassword,
+	}
+	if opts.Password == "" {
+		opts.Password = os.Getenv("MQTT_PASSWORD")
+	}
+	if opts.ClientID == "" {
+		host, _ := os.Hostname()
+		opts.ClientID = "mcis-" + host
+	}
+	if o.mqttCA != "" {
+		pem, err := os.ReadFile(o.mqttCA)
+		if err != nil {
+			return nil, fmt.Errorf("--mqtt-ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("--mqtt-ca: no certificates in %s", o.mqttCA)
+		}
+		opts.TLS = &tls.Config{RootCAs: pool}
+	}
+	haPrefix := ""
+	if o.mqttHADiscovery {
+		haPrefix = strings.TrimSuffix(o.mqttHAPrefix, "/")
+	}
+	return notify.NewMQTT(opts, strings.TrimSuffix(o.mqttTopic, "/"), haPrefix), nil
+}
+
+// setupWebhook creates the --webhook-url notifier.
+func setupWebhook(o *options) (*notify.Webhook, error) {
+	text := notify.DefaultWebhookTemplate
+	if o.webhookTemplate != "" {
+		data, err := os.ReadFile(o.webhookTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("--webhook-template: %w", err)
+		}
+		text = string(data)
+	}
+	tmpl, err := notify.ParseWebhookTemplate(text)
+	if err != nil {
+		return nil, fmt.Errorf("--webhook-template: %w", err)
+	}
+	header := http.Header{}
+	for _, h := range o.webhookHeaders {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("--webhook-header %q: want 'Name: value'", h)
+		}
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	events := strings.FieldsFunc(o.webhookEvents, func(r rune) bool { return r == ',' || r == ' ' })
+	return notify.NewWebhook(o.webhookURL, tmpl, events, header)
+}
+
+// runSummary converts the report and the run error into a notification.
+func runSummary(o *options, rep *runReport, err error) notify.Summary {
+	st := rep.status(err)
+	host, _ := os.Hostname()
+	s := notify.Summary{
+		Host:     host,
+		Finished: st.Finished,
+		Duration: time.Duration(st.DurationMS) * time.Millisecond,
+		// A failed upload or publish fails the run, but the search itself was fine.
+		OK:            st.ScanOK && (st.Error == "" || rep.uploadErr != nil || rep.publishErr != nil),
+		Interrupted:   rep.interrupted,
+		UploadEnabled: rep.uploadEnabled,
+		UploadOK:      st.UploadOK,
+		Targets:       rep.targets,
+		Uploaded:      rep.uploaded,
+		Published:     rep.published,
+		Rotated:       len(rep.published) > 0 && !notify.SameIPs(rep.published, prevPublished),
+		Top:           []notify.Entry{},
+	}
+	if rep.scanned {
+		s.ResultsFile = o.outPath
+	}
+	if !s.OK {
+		s.Error = st.Error
+	}
+	if rep.uploadErr != nil {
+		s.UploadError = rep.uploadErr.Error()
+	}
+	if rep.minIPs > 0 {
+		s.Qualifying, s.Required = rep.qualifying, rep.minIPs
+	}
+	for _, t := range rep.top {
+		if len(s.Top) >= o.notifyTop {
+			break
+		}
+		if !t.OK {
+			continue
+		}
+		e := notify.Entry{IP: t.IP, ScoreMS: t.ScoreMS, PrevMS: prevScores[t.IP], Colo: t.Trace["colo"]}
+		if t.DownloadOK {
+			e.DownloadMbps = t.DownloadMbps
+		}
+		s.Top = append(s.Top, e)
+	}
+	return s
+}
+
+// notifyRun sends the summary of a run to every notifier. Delivery errors are
+// reported but never fail the run.
+func notifyRun(ctx context.Context, o *options, notifiers []notify.Notifier, rep *runReport, err error) {
+	if len(notifiers) > 0 {
+		s := runSummary(o, rep, err)
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), notifyTimeout)
+		defer cancel()
+		for _, n := range notifiers {
+			if err := n.Notify(ctx, s); err != nil {
+				i18n.Fprintf(os.Stderr, "error: notify %s: %v\n", n.Name(), err)
+			} else {
+				slog.Debug("sent the run summary", logging.Phase("notify"), logging.Provider(n.Name()))
+			}
+		}
+	}
+
+	if len(rep.published) > 0 {
+		prevPublished = rep.published
+	}
+	if rep.scanned {
+		prevScores = make(map[netip.Addr]float64, len(rep.top))
+		for _, t := range rep.top {
+			if t.OK {
+				prevScores[t.IP] = t.ScoreMS
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/engine"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/i18n"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/pipeline"
+)
+
+// maxPanicReports bounds the panics a report describes; the rest are only
+// counted.
+const maxPanicReports = 10
+
+// panicLog collects the panics recovered in the workers of a run, which
+// fail their own task only, for the report at the end of the run.
+type panicLog struct {
+	mu    sync.Mutex
+	n     int64
+	descs []string
+}
+
+// workerPanics is the panic log of the run in progress.
+var workerPanics panicLog
+
+// add records err if it is a *pipeline.PanicError of task, and ignores it
+// otherwise.
+func (l *panicLog) add(task string, err error) {
+	var pe *pipeline.PanicError
+	if !errors.As(err, &pe) {
+		return
+	}
+	slog.Debug("task panicked", "task", task, "panic", pe.Value, "stack", string(pe.Stack))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.n++
+	if len(l.descs) < maxPanicReports {
+		l.descs = append(l.descs, fmt.Sprintf("%s: %v", task, pe))
+	}
+}
+
+// addStats records the probe panics of a search.
+func (l *panicLog) addStats(st engine.Stats) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.n += st.Panics
+	for _, desc := range st.PanicErrors {
+		if len(l.descs) < maxPanicReports {
+			l.descs = append(l.descs, desc)
+		}
+	}
+}
+
+// report writes the recorded panics to w, if any, and clears the log.
+func (l *panicLog) report(w io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.n == 0 {
+		return
+	}
+	i18n.Fprintf(w, "warning: %d tasks panicked and were recorded as failures (-v logs the stacks):\n", l.n)
+	for _, desc := range l.descs {
+		fmt.Fprintln(w, "  "+desc)
+	}
+	if more := l.n - int64(len(l.descs)); more > 0 {
+		i18n.Fprintf(w, "  ... and %d more\n", more)
+	}
+	l.n, l.descs = 0, nil
+}
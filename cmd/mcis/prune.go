@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/dns"
+)
+
+const pruneUsage = `usage: mcis prune --dns-provider P[,P...] --dns-subdomain SUB [flags]
+
+Deletes every A and AAAA record of the subdomain, i.e. all records that DNS
+upload manages, at each listed provider. With several providers the tokens and
+zones come from their environment variables (CF_API_TOKEN, CF_ZONE_ID,
+VERCEL_TOKEN, VERCEL_DOMAIN, VERCEL_TEAM_ID).
+
+`
+
+// pruneOptions holds the flags of "mcis prune".
+type pruneOptions struct {
+	providers string
+	dns       options // DNS flags shared with the search
+	yes       bool
+}
+
+func newPruneFlagSet() (*flag.FlagSet, *pruneOptions) {
+	var po pruneOptions
+	fs := flag.NewFlagSet("prune", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, pruneUsage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&po.providers, "dns-provider", "", "Comma-separated DNS providers to prune ("+strings.Join(dns.ProviderNames, "|")+")")
+	fs.StringVar(&po.dns.dnsToken, "dns-token", "", "DNS provider API token (single provider only)")
+	fs.StringVar(&po.dns.dnsZone, "dns-zone", "", "DNS zone ID (Cloudflare) or domain (Vercel) (single provider only)")
+	fs.StringVar(&po.dns.dnsSubdomain, "dns-subdomain", "", "Subdomain whose records are deleted (e.g., 'cf' for cf.example.com)")
+	fs.StringVar(&po.dns.dnsTeamID, "dns-team-id", "", "Vercel Team ID (optional, or use VERCEL_TEAM_ID env)")
+	registerDNSAPIFlags(fs, &po.dns)
+	fs.BoolVar(&po.yes, "yes", false, "Delete without asking (required when stdin is not a terminal)")
+	fs.Var(levelFlag{&po.dns.logLevel, slog.LevelDebug}, "v", "Log the progress to stderr (debug level)")
+	return fs, &po
+}
+
+// pruneCommand implements "mcis prune" and returns the exit code.
+func pruneCommand(args []string) int {
+	fs, po := newPruneFlagSet()
+	if err := parseFlags(fs, args); err != nil {
+		return 2
+	}
+	names := strings.FieldsFunc(po.providers, func(r rune) bool { return r == ',' || r == ' ' })
+	switch {
+	case fs.NArg() > 0:
+		fmt.Fprintf(os.Stderr, "error: unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		return 2
+	case len(names) == 0 || po.dns.dnsSubdomain == "":
+		fmt.Fprintln(os.Stderr, "error: prune needs --dns-provider and --dns-subdomain")
+		return 2
+	case len(names) > 1 && (po.dns.dnsToken != "" || po.dns.dnsZone != ""):
+		fmt.Fprintln(os.Stderr, "error: --dns-token and --dns-zone are ambiguous with several providers; use the environment variables")
+		return 2
+	}
+
+	if err := setupLogging(po.dns.logLevel, "text"); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 2
+	}
+
+	if len(names) == 1 {
+		po.dns.dnsProvider = names[0] // for a bare --dns-api URL
+	}
+	var providers []dns.Provider
+	for _, name := range names {
+		p, err := dns.NewProvider(dnsConfig(&po.dns, name))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return 2
+		}
+		providers = append(providers, p)
+	}
+
+	sub := po.dns.dnsSubdomain
+	if !po.yes {
+		ok, err := confirm(fmt.Sprintf("Delete all A and AAAA records of %q at %s?", sub, strings.Join(names, ", ")))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return 2
+		}
+		if !ok {
+			fmt.Fprintln(os.Stderr, "prune: aborted")
+			return 1
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	failed := false
+	for _, p := range providers {
+		if err := dns.Prune(ctx, p, sub); err != nil {
+			fmt.Fprintf(os.Stderr, "error: prune %s: %v\n", p.Name(), err)
+			failed = true
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "prune: deleted the records of %q at %s\n", sub, p.Name())
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// confirm asks a yes/no question on the terminal.
+func confirm(question string) (bool, error) {
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false, errors.New("stdin is not a terminal; pass --yes to confirm")
+	}
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	var answer string
+	_, _ = fmt.Fscanln(os.Stdin, &answer)
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/k8s"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/logging"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/publish"
+)
+
+// setupPublishers creates the publishers enabled by the flags.
+func setupPublishers(o *options) ([]publish.Publisher, error) {
+	var ps []publish.Publisher
+
+	if o.kvNamespace != "" {
+		token := o.kvToken
+		if token == "" {
+			token = os.Getenv("CF_API_TOKEN")
+		}
+		account := o.kvAccount
+		if account == "" {
+			account = os.Getenv("CF_ACCOUNT_ID")
+		}
+		kv, err := publish.NewWorkersKV(publish.WorkersKVConfig{
+			Token:     token,
+			AccountID: account,
+			Namespace: o.kvNamespace,
+			Key:       o.kvKey,
+			Format:    o.kvFormat,
+			Limiter:   dnsLimiter("cloudflare", o.dnsRate),
+		})
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, kv)
+	}
+
+	if o.gitRepo != "" {
+		key := o.gitDeployKey
+		if key == "" {
+			key = os.Getenv("GIT_DEPLOY_KEY")
+		}
+		g, err := publish.NewGit(publish.GitConfig{
+			Repo:      o.gitRepo,
+			Branch:    o.gitBranch,
+			Path:      o.gitPath,
+			Format:    o.gitFormat,
+			DeployKey: key,
+			WorkDir:   o.gitWorkDir,
+			Author:    o.gitAuthor,
+			Message:   o.gitMessage,
+		})
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, g)
+	}
+
+	if o.extDNSName != "" {
+		cfg := publish.ExternalDNSConfig{
+			DNSName:   o.extDNSName,
+			Name:      o.extDNSResource,
+			Namespace: o.extDNSNamespace,
+			TTL:       o.extDNSTTL,
+			File:      o.extDNSFile,
+		}
+		if o.extDNSApply {
+			client, err := k8s.InCluster()
+			if err != nil {
+				return nil, fmt.Errorf("--external-dns-apply: %w", err)
+			}
+			cfg.Client = client
+		}
+		e, err := publish.NewExternalDNS(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%w: set --external-dns-file or --external-dns-apply", err)
+		}
+		ps = append(ps, e)
+	}
+
+	if o.consulService != "" {
+		cfg := publish.ConsulConfig{
+			Addr:    o.consulAddr,
+			Token:   o.consulToken,
+			Service: o.consulService,
+			Port:    o.consulPort,
+			Tags:    strings.FieldsFunc(o.consulTags, func(r rune) bool { return r == ',' || r == ' ' }),
+		}
+		if cfg.Addr == "" {
+			cfg.Addr = os.Getenv("CONSUL_HTTP_ADDR")
+		}
+		if cfg.Token == "" {
+			cfg.Token = os.Getenv("CONSUL_HTTP_TOKEN")
+		}
+		c, err := publish.NewConsul(cfg)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, c)
+	}
+
+	if o.etcdEndpoint != "" {
+		password := o.etcdPassword
+		if password == "" {
+			password = os.Getenv("ETCD_PASSWORD")
+		}
+		e, err := publish.NewEtcd(publish.EtcdConfig{
+			Endpoint: o.etcdEndpoint,
+			Key:      o.etcdKey,
+			Format:   o.etcdFormat,
+			Username: o.etcdUser,
+			Password: password,
+		})
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, e)
+	}
+
+	return ps, nil
+}
+
+// setupArchive creates the --archive-url publisher, or returns nil.
+func setupArchive(o *options) (*publish.Archive, error) {
+	if o.archiveURL == "" {
+		return nil, nil
+	}
+	cfg := publish.ArchiveConfig{
+		URL:          o.archiveURL,
+		Endpoint:     o.archiveEndpoint,
+		Region:       o.archiveRegion,
+		Key:          o.archiveKey,
+		AccessKey:    o.archiveAccessKey,
+		SecretKey:    o.archiveSecretKey,
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if cfg.AccessKey == "" {
+		cfg.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if cfg.SecretKey == "" {
+		cfg.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if cfg.Region == "" {
+		cfg.Region = os.Getenv("AWS_REGION")
+	}
+	return publish.NewArchive(cfg, o.outPath, o.reportFile)
+}
+
+// archiveRun uploads the files of the run to --archive-url, if set.
+func archiveRun(ctx context.Context, o *options, a *publish.Archive, rep *runReport, ips []netip.Addr) error {
+	if a == nil {
+		return nil
+	}
+	phase("archiving to " + a.Name())
+	if err := a.Publish(ctx, publishResult(o, rep, ips)); err != nil {
+		rep.publishErr = errors.Join(rep.publishErr, err)
+		return fmt.Errorf("archive: %w", err)
+	}
+	slog.Debug("uploaded the results", logging.Phase("archive"), logging.Provider(a.Name()))
+	return nil
+}
+
+// publishResult builds what the publishers receive.
+func publishResult(o *options, rep *runReport, ips []netip.Addr) publish.Result {
+	return publish.Result{IPs: ips, Top: rep.top, Finished: time.Now(), ResultsFile: o.outPath}
+}
+
+// publishAll runs every publisher; one failing does not stop the others.
+func publishAll(ctx context.Context, o *options, publishers []publish.Publisher, r publish.Result) error {
+	var errs []error
+	for _, p := range publishers {
+		if err := p.Publish(ctx, r); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+			continue
+		}
+		slog.Debug("published the IPs", logging.Phase("publish"), logging.Provider(p.Name()), "count", len(r.IPs))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,810 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/dns"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/engine"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/health"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/i18n"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/icmpping"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/lock"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/logging"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/output"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/probe"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/probecache"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/ratelimit"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/synscan"
+)
+
+// parseColoList parses a comma-separated colo list, trimming spaces.
+func parseColoList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// run performs one search: scan, download test, output and DNS upload.
+// scanCtx only bounds the sampling phase; once it is canceled the best-so-far
+// results are still written out, and uploaded when --dns-on-interrupt is set.
+// ctx bounds everything else. interrupted reports whether scanCtx was canceled
+// by a signal. With --max-runtime the sampling ends --max-runtime-reserve
+// before the limit and the rest of the run is canceled at it.
+func run(ctx, scanCtx context.Context, o *options, interrupted func() bool) (*runReport, error) {
+	rep := &runReport{start: time.Now(), strategy: "http", uploadEnabled: o.dnsProvider != "" || len(o.dnsTargets) > 0}
+	slog.Debug("tuned the defaults", "hardware", hardware().String(), "concurrency", o.concur, "pps", o.pps, "download_concurrency", o.dlConcurrency)
+	// A worker that panics fails its own task; the run reports them all
+	// at its end.
+	defer workerPanics.report(os.Stderr)
+
+	if err := checkFlags(o); err != nil {
+		return rep, err
+	}
+	cfg := engineConfig(o)
+	if o.maxRuntime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, rep.start.Add(o.maxRuntime))
+		defer cancel()
+		cfg.Deadline = rep.start.Add(o.maxRuntime - o.maxRuntimeReserve)
+	}
+	sim, err := simulationModel(o)
+	if err != nil {
+		return rep, err
+	}
+
+	switch {
+	case sim != nil:
+		i18n.Fprintln(os.Stderr, "simulate: probing a latency model; DNS records are kept in memory, and agents, publishers, the archive and notifications are skipped")
+		cfg.TraceProbe = sim.Trace
+	case o.syn:
+		sc, err := synscan.Open(synscan.Config{Timeout: o.timeout})
+		if err != nil {
+			i18n.Fprintf(os.Stderr, "syn: warning: %v; searching with HTTP probes\n", err)
+			if kind := icmpping.Detect().Socket(); kind != "" {
+				i18n.Fprintf(os.Stderr, "syn: %s ICMP sockets are permitted; --icmp searches faster than HTTP probes\n", kind)
+			}
+		} else {
+			defer sc.Close()
+			cfg.Coarse = sc.Probe
+			rep.strategy = "syn"
+		}
+	case o.icmp:
+		pool, err := icmpping.Open(icmpping.Config{Timeout: o.timeout, Sockets: o.icmpSockets, Socket: o.icmpSocket})
+		switch {
+		case err != nil && o.icmpSocket != icmpping.SocketAuto:
+			return rep, fmt.Errorf("--icmp-socket %s: %w", o.icmpSocket, err)
+		case err != nil:
+			i18n.Fprintf(os.Stderr, "icmp: warning: %v; searching with HTTP probes\n", err)
+		default:
+			slog.Debug("icmp sockets", logging.Phase("icmp"), "kind", pool.Socket())
+			defer pool.Close()
+			cfg.Coarse = pool.Probe
+			rep.strategy = "icmp"
+		}
+	}
+
+	probeCfg := probeConfig(o)
+
+	prefixes, err := cidrFlags(o)
+	if err != nil {
+		return rep, err
+	}
+	req := engine.Request{
+		CIDRs:    prefixes,
+		CIDRFile: o.cidrFile,
+		Probe:    probeCfg,
+	}
+
+	// Validate the download and DNS settings before scanning, so a typo
+	// does not surface only after a long search.
+	dlCfg, err := downloadConfig(o)
+	if err != nil {
+		return rep, err
+	}
+	agents, err := setupAgents(o)
+	if err != nil {
+		return rep, err
+	}
+	publishers, err := setupPublishers(o)
+	if err != nil {
+		return rep, err
+	}
+	archive, err := setupArchive(o)
+	if err != nil {
+		return rep, err
+	}
+	if err := checkUploadFlags(o, len(publishers) > 0); err != nil {
+		return rep, err
+	}
+	if sim != nil {
+		// Checked above, but a simulation sends nothing.
+		agents, publishers, archive = nil, nil, nil
+	}
+	var targets []dnsTarget
+	if rep.uploadEnabled {
+		targets, err = dnsTargets(o)
+		if err != nil {
+			return rep, err
+		}
+		for _, t := range targets {
+			rep.targets = append(rep.targets, t.String())
+			if !slices.Contains(rep.providers, t.provider.Name()) {
+				rep.providers = append(rep.providers, t.provider.Name())
+			}
+		}
+	}
+
+	if o.lockFile != "" {
+		l, err := lock.Acquire(o.lockFile, o.lockMaxAge)
+		if err != nil {
+			var held *lock.HeldError
+			if errors.As(err, &held) {
+				return rep, fmt.Errorf("another scan is running: %w", err)
+			}
+			return rep, fmt.Errorf("acquire lock: %w", err)
+		}
+		defer func() {
+			if err := l.Release(); err != nil {
+				i18n.Fprintln(os.Stderr, "error: release lock:", err)
+			}
+		}()
+	}
+
+	// Opened under the lock, so that the cache is saved before it is
+	// released and the next run reads it whole.
+	cache, err := openProbeCache(o)
+	if err != nil {
+		return rep, err
+	}
+	if cache != nil && sim == nil {
+		defer saveProbeCache(cache)
+		cfg.Cache = cache
+	}
+
+	notifyReady()
+	phase("scanning")
+
+	var spill *resultSpill
+	if o.allResults != "" {
+		if spill, err = openSpill(o.allResults); err != nil {
+			return rep, err
+		}
+		cfg.OnResult = spill.add
+	}
+
+	if o.stateFile != "" {
+		if cfg.Resume, err = loadState(o.stateFile); err != nil {
+			return rep, err
+		}
+		if cfg.Resume != nil {
+			rep.resumed = true
+			i18n.Fprintf(os.Stderr, "state: resuming the search from %s after %d probes\n", o.stateFile, cfg.Resume.Completed+cfg.Resume.CompletedV6)
+		}
+		cfg.Checkpoint = stateSaver(o.stateFile)
+	}
+
+	// The speed tests start while the search runs.
+	var tests *speedTests
+	if o.dlTop > 0 {
+		cfg.OnTop = func(r engine.TopResult, rank int) { tests.offer(r, rank) }
+	}
+
+	// Create and run engine
+	eng := engine.New(cfg, probeCfg)
+	if o.dlTop > 0 {
+		download := probe.NewDownloadProber(dlCfg).Download
+		if sim != nil {
+			download = simulatedDownload(sim, dlCfg)
+		}
+		tests = newSpeedTests(ctx, o, eng, download)
+		defer tests.stop()
+	}
+	activeEngine.Store(eng)
+	res, err := eng.Run(scanCtx, req)
+	failed := spill.close()
+	if err != nil {
+		return rep, err
+	}
+	if res.Expired {
+		i18n.Fprintf(os.Stderr, "max-runtime: stopped sampling after %d probes to finish within %s\n", res.Stats.Probes, o.maxRuntime)
+	}
+	if o.stateFile != "" && !interrupted() && !res.Expired {
+		// The search is complete; the next one starts afresh.
+		if err := os.Remove(o.stateFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			i18n.Fprintln(os.Stderr, "error: remove search state:", err)
+		}
+	}
+	logStats(res.Stats)
+	workerPanics.addStats(res.Stats)
+	rep.probes = res.Stats.Probes
+	rep.interrupted = interrupted()
+	if agents != nil && len(res.Top) > 0 && !rep.interrupted {
+		phase(fmt.Sprintf("probing %d candidates from %d agents", len(res.Top), len(o.agents)))
+		res.Top = probeAgents(ctx, o, agents, probeCfg, res.Top)
+	}
+	rep.scanned = true
+	rep.top = res.Top
+
+	if rep.interrupted && !o.dnsOnInterrupt {
+		// Keep what we have; skip the slow download test and the upload.
+		finishExplain(o, res.Top)
+		if err := writeOutput(o, res); err != nil {
+			return rep, err
+		}
+		if err := writeReport(o, res); err != nil {
+			return rep, err
+		}
+		return rep, errors.Join(failed, archiveRun(ctx, o, archive, rep, nil))
+	}
+
+	downloadTest(o, dlCfg, tests, res.Top)
+	finishExplain(o, res.Top)
+
+	// Write results before uploading so a failed upload never loses them.
+	if err := writeOutput(o, res); err != nil {
+		return rep, err
+	}
+	if err := writeReport(o, res); err != nil {
+		return rep, err
+	}
+
+	var ips []netip.Addr
+	if len(targets) > 0 || len(publishers) > 0 {
+		ips = selectIPs(o, rep)
+	}
+	if len(targets) > 0 && len(ips) > 0 {
+		phase(fmt.Sprintf("uploading to %d DNS targets", len(targets)))
+		if rep.uploadErr = uploadDNS(ctx, o, targets, ips); rep.uploadErr != nil {
+			failed = fmt.Errorf("dns upload: %w", rep.uploadErr)
+		} else {
+			rep.uploaded = ips
+		}
+	}
+	// Publishing is independent of DNS: it proceeds after a failed upload.
+	if len(publishers) > 0 && len(ips) > 0 {
+		phase(fmt.Sprintf("publishing to %d targets", len(publishers)))
+		if rep.publishErr = publishAll(ctx, o, publishers, publishResult(o, rep, ips)); rep.publishErr != nil {
+			failed = errors.Join(failed, fmt.Errorf("publish: %w", rep.publishErr))
+		}
+	}
+	if len(rep.uploaded) > 0 || (len(publishers) > 0 && len(ips) > 0 && rep.publishErr == nil) {
+		rep.published = ips
+	}
+	// The files are archived whether or not any IP qualified.
+	failed = errors.Join(failed, archiveRun(ctx, o, archive, rep, ips))
+	if failed != nil {
+		return rep, failed
+	}
+	phase("done")
+	return rep, nil
+}
+
+// engineConfig builds the engine configuration from the flags.
+func engineConfig(o *options) engine.Config {
+	return engine.Config{
+		Budget:          o.budget,
+		TopN:            o.topN,
+		Concurrency:     o.concur,
+		Heads:           o.heads,
+		Beam:            o.beam,
+		SplitStepV4:     o.splitV4,
+		SplitStepV6:     o.splitV6,
+		MinSamplesSplit: o.minSplit,
+		MaxBitsV4:       o.maxBitsV4,
+		MaxBitsV6:       o.maxBitsV6,
+		Seed:            o.seed,
+		DiversityWeight: o.diversityWeight,
+		SplitInterval:   o.splitInterval,
+		DedupeFPRate:    o.dedupeFPRate,
+		ColoAllow:       parseColoList(o.coloAllow),
+		ColoBlock:       parseColoList(o.coloExclude),
+		DisableBackoff:  !o.backoff,
+		Limiter:         ratelimit.New(o.pps, 1),
+		BudgetV6:        o.budgetV6,
+		ConcurrencyV6:   o.concurV6,
+		Explain:         o.explain,
+	}
+}
+
+// checkFlags rejects flag combinations and values that no run can use.
+func checkFlags(o *options) error {
+	if o.coloAllow != "" && o.coloExclude != "" {
+		return errors.New("cannot use both --colo and --colo-exclude; use only one")
+	}
+	if o.pps < 0 {
+		return fmt.Errorf("--pps must be >= 0, got %g", o.pps)
+	}
+	if o.simulateModel != "" && !o.simulate {
+		return errors.New("--simulate-model needs --simulate")
+	}
+	if o.syn && o.icmp {
+		return errors.New("--syn and --icmp cannot be combined")
+	}
+	if !slices.Contains(icmpping.SocketKinds, o.icmpSocket) {
+		return fmt.Errorf("unknown --icmp-socket %q, want one of %s", o.icmpSocket, strings.Join(icmpping.SocketKinds, ", "))
+	}
+	switch o.outFmt {
+	case "jsonl", "csv", "text", "debug":
+	default:
+		return fmt.Errorf("unknown -out: %s", o.outFmt)
+	}
+	if o.leaderElect && (o.leaseRenew <= 0 || o.leaseRenew >= o.leaseDuration) {
+		return fmt.Errorf("--leader-elect-renew-deadline (%s) must be positive and shorter than --leader-elect-duration (%s)", o.leaseRenew, o.leaseDuration)
+	}
+	if o.budgetV6 > 0 && o.budgetV6 >= o.budget {
+		return fmt.Errorf("--budget-v6 (%d) must be less than --budget (%d), which it is part of", o.budgetV6, o.budget)
+	}
+	if o.concurV6 > 0 && o.concurV6 >= o.concur {
+		return fmt.Errorf("--concurrency-v6 (%d) must be less than --concurrency (%d), which it is part of", o.concurV6, o.concur)
+	}
+	if o.maxRuntime < 0 || o.maxRuntimeReserve < 0 {
+		return errors.New("--max-runtime and --max-runtime-reserve must be >= 0")
+	}
+	if o.maxRuntime > 0 && o.maxRuntimeReserve >= o.maxRuntime {
+		return fmt.Errorf("--max-runtime-reserve (%s) must be shorter than --max-runtime (%s)", o.maxRuntimeReserve, o.maxRuntime)
+	}
+	if _, err := logging.NewHandler(io.Discard, o.logFormat, nil); err != nil {
+		return fmt.Errorf("--log-format: %w", err)
+	}
+	if err := checkTelemetry(o); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkUploadFlags checks the flags that DNS uploads and, when publishing,
+// the publishers depend on.
+func checkUploadFlags(o *options, publishing bool) error {
+	upload := o.dnsProvider != "" || len(o.dnsTargets) > 0
+	if (upload || publishing) && o.dlTop <= 0 {
+		return errors.New("--download-top must be > 0 when using DNS upload or publishing")
+	}
+	if upload && o.dnsSubdomain == "" && len(o.dnsTargets) == 0 {
+		return errors.New("--dns-subdomain or --dns-target is required when --dns-provider is set")
+	}
+	for _, s := range o.dnsAPIs {
+		name, _ := splitDNSAPI(s)
+		switch {
+		case name == "" && o.dnsProvider == "":
+			return fmt.Errorf("--dns-api %q: name the provider as PROVIDER=URL without --dns-provider", s)
+		case name != "" && !slices.Contains(dns.ProviderNames, name):
+			return fmt.Errorf("--dns-api %q: unknown provider %q (supported: %s)", s, name, strings.Join(dns.ProviderNames, ", "))
+		}
+	}
+	return nil
+}
+
+// probeConfig builds the probe configuration from the flags.
+func probeConfig(o *options) probe.Config {
+	// Unify host: by default use --host for both SNI and Host header.
+	sni := o.sni
+	if sni == "" {
+		sni = o.host
+	}
+	hostHdr := o.hostHdr
+	if hostHdr == "" {
+		hostHdr = o.host
+	}
+	return probe.Config{
+		Timeout:    o.timeout,
+		SNI:        sni,
+		HostHeader: hostHdr,
+		Path:       o.path,
+		Rounds:     o.rounds,
+		SkipFirst:  o.skipFirst,
+	}
+}
+
+// runReport summarizes one run.
+type runReport struct {
+	start time.Time
+	// scanned is true once the search finished, possibly cut short by an interrupt.
+	scanned     bool
+	interrupted bool
+	top         []engine.TopResult
+	// strategy is the probe of the search, after any fallback to HTTP;
+	// probes counts them.
+	strategy string
+	probes   int64
+	resumed  bool
+
+	uploadEnabled bool
+	targets       []string
+	providers     []string // kinds of the DNS providers of targets
+	uploaded      []netip.Addr
+	uploadErr     error
+	// published holds the IPs that reached DNS or the publishers.
+	published  []netip.Addr
+	publishErr error
+	// minIPs is set when the upload was skipped because only qualifying of
+	// the download-tested IPs met the thresholds.
+	qualifying int
+	minIPs     int
+}
+
+// status converts the report and the run error into a health status.
+func (r *runReport) status(err error) health.Status {
+	// A scan where no address answered is as useless as one that failed.
+	reachable := false
+	for _, t := range r.top {
+		if t.OK {
+			reachable = true
+			break
+		}
+	}
+	st := health.Status{
+		Finished:      time.Now(),
+		DurationMS:    time.Since(r.start).Milliseconds(),
+		ScanOK:        r.scanned && reachable,
+		UploadEnabled: r.uploadEnabled,
+		UploadOK:      len(r.uploaded) > 0 && r.uploadErr == nil,
+		Results:       len(r.top),
+		Uploaded:      r.uploaded,
+	}
+	if err != nil {
+		st.Error = err.Error()
+	} else if r.scanned && !reachable {
+		st.Error = "no probed IP responded"
+	}
+	if len(r.top) > 0 {
+		st.BestIP = r.top[0].IP
+		st.BestMS = r.top[0].ScoreMS
+	}
+	return st
+}
+
+// downloadConfig builds the download test configuration from the flags.
+func downloadConfig(o *options) (probe.DownloadConfig, error) {
+	// Default Bytes=0 (no limit); when no custom URL use 50M.
+	dlBytes := o.dlBytes
+	if dlBytes == 0 && o.dlURL == "" {
+		dlBytes = 50_000_000
+	}
+	dlCfg := probe.DownloadConfig{
+		Timeout: o.dlTimeout,
+		Bytes:   dlBytes,
+	}
+	if o.dlURL != "" {
+		u, err := url.Parse(o.dlURL)
+		if err != nil {
+			return dlCfg, fmt.Errorf("invalid --download-url: %w", err)
+		}
+		if u.Hostname() == "" {
+			return dlCfg, errors.New("--download-url must include a hostname (e.g. https://myhost.com/path/to/file)")
+		}
+		dlCfg.SNI = u.Hostname()
+		dlCfg.HostName = u.Hostname()
+		dlCfg.Path = u.Path
+		if u.RawQuery != "" {
+			dlCfg.Path = u.Path + "?" + u.RawQuery
+		}
+		dlCfg.CustomURL = true
+	}
+	return dlCfg, nil
+}
+
+// dnsConfig builds the DNS upload configuration of provider from the flags.
+func dnsConfig(o *options, provider string) dns.Config {
+	return dns.Config{
+		Provider:    provider,
+		Token:       o.dnsToken,
+		Zone:        o.dnsZone,
+		Subdomain:   o.dnsSubdomain,
+		UploadCount: o.dnsUploadCount,
+		TeamID:      o.dnsTeamID,
+		APIBase:     dnsAPIBase(o, provider),
+		CAFile:      o.dnsCA,
+	}
+}
+
+// dnsAPIBase returns the --dns-api URL of provider, or "" for its public
+// API.
+func dnsAPIBase(o *options, provider string) string {
+	base := ""
+	for _, s := range o.dnsAPIs {
+		if name, u := splitDNSAPI(s); name == provider || name == "" && provider == o.dnsProvider {
+			base = u
+		}
+	}
+	return base
+}
+
+// splitDNSAPI splits a --dns-api value into its provider, "" for a bare URL,
+// and URL.
+func splitDNSAPI(s string) (provider, u string) {
+	if name, rest, ok := strings.Cut(s, "="); ok && !strings.Contains(name, "/") {
+		return name, rest
+	}
+	return "", s
+}
+
+// selectIPs returns the fastest download-tested IPs, which are uploaded and
+// published. After an interrupt they are only selected when the full upload
+// count (or --dns-min-ips) qualified; a shortfall is recorded in rep.
+func selectIPs(o *options, rep *runReport) []netip.Addr {
+	top := rep.top
+	// Collect IPs from download-tested results only
+	type dlResult struct {
+		IP   netip.Addr
+		Mbps float64
+	}
+	var candidates []dlResult
+	for i := 0; i < o.dlTop && i < len(top); i++ {
+		r := top[i]
+		if r.DownloadOK {
+			candidates = append(candidates, dlResult{IP: r.IP, Mbps: r.DownloadMbps})
+		}
+	}
+
+	// Sort by download speed (highest first)
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Mbps > candidates[j].Mbps
+	})
+
+	// Determine how many IPs to upload
+	uploadN := o.dnsUploadCount
+	if uploadN <= 0 {
+		uploadN = o.dlTop
+	}
+
+	minIPs := o.dnsMinIPs
+	if minIPs <= 0 && rep.interrupted {
+		minIPs = uploadN
+	}
+	if minIPs > 0 && len(candidates) < minIPs {
+		i18n.Fprintf(os.Stderr, "dns: only %d qualifying IPs (need %d), skipping upload\n", len(candidates), minIPs)
+		rep.qualifying, rep.minIPs = len(candidates), minIPs
+		return nil
+	}
+
+	if uploadN > len(candidates) {
+		uploadN = len(candidates)
+	}
+
+	// Collect IPs to upload
+	var ipsToUpload []netip.Addr
+	for i := 0; i < uploadN; i++ {
+		ipsToUpload = append(ipsToUpload, candidates[i].IP)
+	}
+
+	if len(ipsToUpload) == 0 {
+		slog.Debug("no download-tested IPs to upload", logging.Phase("dns"))
+		rep.qualifying, rep.minIPs = 0, max(minIPs, 1)
+		return nil
+	}
+
+	for i, ip := range ipsToUpload {
+		slog.Debug("selected by download speed", logging.Phase("dns"), "rank", i+1, logging.IP(ip), "mbps", candidates[i].Mbps)
+	}
+	return ipsToUpload
+}
+
+// finishExplain completes the --explain breakdowns of top with what the
+// agents and the download test added after the search.
+func finishExplain(o *options, top []engine.TopResult) {
+	type tested struct {
+		x    *engine.Explain
+		mbps float64
+	}
+	var speeds []tested
+	for i := range top {
+		r := &top[i]
+		if r.Explain == nil {
+			continue
+		}
+		r.Explain.AgentsMS = r.ScoreMS - r.Explain.LatencyMS - r.Explain.FailurePenaltyMS
+		if i < o.dlTop && r.DownloadOK {
+			speeds = append(speeds, tested{r.Explain, r.DownloadMbps})
+		}
+	}
+	// Fastest first, as selectIPs picks them.
+	sort.SliceStable(speeds, func(i, j int) bool { return speeds[i].mbps > speeds[j].mbps })
+	for i, s := range speeds {
+		s.x.SpeedRank = i + 1
+	}
+}
+
+// uploadDNS uploads the selected IPs to every DNS target.
+func uploadDNS(ctx context.Context, o *options, targets []dnsTarget, ips []netip.Addr) error {
+	if slog.Default().Enabled(ctx, slog.LevelDebug) {
+		names := make([]string, len(targets))
+		for i, t := range targets {
+			names[i] = t.String()
+		}
+		slog.DebugContext(ctx, "uploading", logging.Phase("dns"), "count", len(ips), "targets", strings.Join(names, ", "))
+	}
+	return uploadTargets(ctx, o, targets, ips)
+}
+
+// writeOutput writes the results in the --out format to --out-file or stdout.
+func writeOutput(o *options, res engine.Response) error {
+	var w *os.File = os.Stdout
+	if o.outPath != "" {
+		f, err := os.Create(o.outPath)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		w = f
+	}
+
+	switch o.outFmt {
+	case "jsonl":
+		return output.WriteJSONL(w, res.Top)
+	case "csv":
+		return output.WriteCSV(w, res.Top)
+	case "text":
+		return output.WriteText(w, res.Top, o.goodMS)
+	case "debug":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(res)
+		return nil
+	default:
+		return fmt.Errorf("unknown -out: %s", o.outFmt)
+	}
+}
+
+// openProbeCache opens --probe-cache; the cache is nil without it.
+func openProbeCache(o *options) (*probecache.Cache, error) {
+	if o.probeCache == "" {
+		return nil, nil
+	}
+	c, err := probecache.Open(o.probeCache, o.probeCacheTTL)
+	if err != nil {
+		return nil, fmt.Errorf("--probe-cache: %w", err)
+	}
+	return c, nil
+}
+
+// saveProbeCache writes the cache back; a failure only costs the next run
+// its cache hits, so it is reported but does not fail the run.
+func saveProbeCache(c *probecache.Cache) {
+	slog.Debug("saving the probe cache", "hits", c.Hits())
+	if err := c.Save(); err != nil {
+		i18n.Fprintln(os.Stderr, "error: save probe cache:", err)
+	}
+}
+
+// loadState reads the search state saved to --state; a missing file means
+// there is nothing to resume.
+func loadState(path string) (*engine.State, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("--state: %w", err)
+	}
+	st := new(engine.State)
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("--state %s: %w", path, err)
+	}
+	return st, nil
+}
+
+// stateSaver returns the engine's checkpoint callback, which replaces the
+// file at path atomically. A failed save only loses progress if the run is
+// interrupted before the next one, so it is reported and the search goes
+// on.
+func stateSaver(path string) func(*engine.State) {
+	return func(st *engine.State) {
+		err := func() error {
+			data, err := json.Marshal(st)
+			if err != nil {
+				return err
+			}
+			tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+			if err != nil {
+				return err
+			}
+			defer os.Remove(tmp.Name())
+			if _, err := tmp.Write(data); err != nil {
+				_ = tmp.Close()
+				return err
+			}
+			if err := tmp.Close(); err != nil {
+				return err
+			}
+			return os.Rename(tmp.Name(), path)
+		}()
+		if err != nil {
+			i18n.Fprintln(os.Stderr, "error: save search state:", err)
+		}
+	}
+}
+
+// resultSpill streams every probe result to --all-results as JSON Lines, so
+// the full results of a long run never have to fit in memory.
+type resultSpill struct {
+	path string
+	f    *os.File
+	w    *bufio.Writer
+	enc  *json.Encoder
+	err  error
+}
+
+func openSpill(path string) (*resultSpill, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("--all-results: %w", err)
+	}
+	w := bufio.NewWriterSize(f, 1<<16)
+	return &resultSpill{path: path, f: f, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+// add writes r; after a write error the remaining results are dropped.
+func (s *resultSpill) add(r engine.TopResult) {
+	if s.err == nil {
+		s.err = s.enc.Encode(r)
+	}
+}
+
+// close flushes the file and returns the first error; a nil s does nothing.
+func (s *resultSpill) close() error {
+	if s == nil {
+		return nil
+	}
+	err := errors.Join(s.err, s.w.Flush(), s.f.Close())
+	if err != nil {
+		return fmt.Errorf("--all-results %s: %w", s.path, err)
+	}
+	return nil
+}
+
+// logStats logs the aggregates of all probes of the search.
+func logStats(st engine.Stats) {
+	if st.Probes == 0 {
+		return
+	}
+	attrs := []any{logging.Phase("search"), "probes", st.Probes, "ok", st.OK}
+	if st.OK > 0 {
+		attrs = append(attrs, "min_ms", st.MinMS, "p50_ms", st.P50MS, "p90_ms", st.P90MS, "p99_ms", st.P99MS, "max_ms", st.MaxMS)
+	}
+	if st.Panics > 0 {
+		attrs = append(attrs, "panics", st.Panics)
+	}
+	slog.Debug("search finished", attrs...)
+}
+
+// writeReport writes the --report-file HTML report.
+func writeReport(o *options, res engine.Response) error {
+	if o.reportFile == "" {
+		return nil
+	}
+	f, err := os.Create(o.reportFile)
+	if err != nil {
+		return err
+	}
+	if err := output.WriteHTML(f, res.Top); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
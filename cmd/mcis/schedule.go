@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/dns"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/i18n"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/pipeline"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/ratelimit"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/simulate"
+)
+
+// dnsTarget is one subdomain that receives the uploaded IPs.
+type dnsTarget struct {
+	provider  dns.Provider
+	zone      string // as given, for messages; empty when taken from the environment
+	subdomain string
+}
+
+func (t dnsTarget) String() string {
+	if t.zone == "" {
+		return t.provider.Name() + ":" + t.subdomain
+	}
+	return t.provider.Name() + ":" + t.zone + "/" + t.subdomain
+}
+
+// dnsLimiters holds one rate budget per DNS provider. They outlive a run so
+// that back-to-back periodic runs share the budget too.
+var (
+	dnsLimitersMu sync.Mutex
+	dnsLimiters   = map[string]*ratelimit.Limiter{}
+)
+
+// dnsLimiter returns the shared limiter of a provider for --dns-rate.
+func dnsLimiter(provider string, rate float64) *ratelimit.Limiter {
+	dnsLimitersMu.Lock()
+	defer dnsLimitersMu.Unlock()
+	l, ok := dnsLimiters[provider]
+	if !ok || l.Rate() != rate { // new provider, or --dns-rate changed on reload
+		l = ratelimit.New(rate, int(math.Ceil(rate)))
+		dnsLimiters[provider] = l
+	}
+	return l
+}
+
+// dnsTargets builds the upload targets: --dns-subdomain at --dns-provider and
+// every --dns-target [PROVIDER:][ZONE/]SUBDOMAIN. A target without a provider
+// uses --dns-provider; one without a zone uses --dns-zone when the provider is
+// --dns-provider, and the provider's environment variable otherwise. The same
+// goes for --dns-token.
+func dnsTargets(o *options) ([]dnsTarget, error) {
+	type spec struct{ provider, zone, subdomain string }
+	var specs []spec
+	if o.dnsProvider != "" && o.dnsSubdomain != "" {
+		specs = append(specs, spec{o.dnsProvider, o.dnsZone, o.dnsSubdomain})
+	}
+	for _, s := range o.dnsTargets {
+		var sp spec
+		rest := s
+		if p, r, ok := strings.Cut(rest, ":"); ok {
+			sp.provider, rest = p, r
+		}
+		if z, r, ok := strings.Cut(rest, "/"); ok {
+			sp.zone, rest = z, r
+		}
+		sp.subdomain = rest
+		if sp.provider == "" {
+			sp.provider = o.dnsProvider
+		}
+		if sp.provider == "" || sp.subdomain == "" {
+			return nil, fmt.Errorf("--dns-target %q: want [PROVIDER:][ZONE/]SUBDOMAIN (the provider defaults to --dns-provider)", s)
+		}
+		if sp.zone == "" && sp.provider == o.dnsProvider {
+			sp.zone = o.dnsZone
+		}
+		specs = append(specs, sp)
+	}
+
+	targets := make([]dnsTarget, 0, len(specs))
+	for _, sp := range specs {
+		cfg := dnsConfig(o, sp.provider)
+		cfg.Zone, cfg.Subdomain = sp.zone, sp.subdomain
+		if sp.provider != o.dnsProvider {
+			cfg.Token = ""
+		}
+		cfg.Limiter = dnsLimiter(sp.provider, o.dnsRate)
+		var p dns.Provider
+		var err error
+		if o.simulate {
+			if !slices.Contains(dns.ProviderNames, sp.provider) {
+				return nil, fmt.Errorf("unknown DNS provider: %s (supported: %s)", sp.provider, strings.Join(dns.ProviderNames, ", "))
+			}
+			p = simulate.NewProvider(sp.provider)
+		} else if p, err = dns.NewProvider(cfg); err != nil {
+			return nil, err
+		}
+		targets = append(targets, dnsTarget{provider: p, zone: sp.zone, subdomain: sp.subdomain})
+	}
+	return targets, nil
+}
+
+// uploadTargets uploads ips to every target. Targets of different providers
+// are updated in parallel; those of the same provider one after another,
+// --dns-stagger apart, with their API requests paced by the provider's
+// shared --dns-rate budget. Every target is attempted even when one fails.
+func uploadTargets(ctx context.Context, o *options, targets []dnsTarget, ips []netip.Addr) error {
+	var order []string
+	byProvider := map[string][]dnsTarget{}
+	for _, t := range targets {
+		name := t.provider.Name()
+		if _, ok := byProvider[name]; !ok {
+			order = append(order, name)
+		}
+		byProvider[name] = append(byProvider[name], t)
+	}
+
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	i := 0
+	for _, name := range order {
+		group, first := byProvider[name], i
+		i += len(group)
+		wg.Go(func() {
+			for j, t := range group {
+				if j > 0 && o.dnsStagger > 0 {
+					select {
+					case <-ctx.Done():
+					case <-time.After(o.dnsStagger):
+					}
+				}
+				if err := ctx.Err(); err != nil {
+					errs[first+j] = fmt.Errorf("%s: %w", t, err)
+					continue
+				}
+				if len(targets) > 1 {
+					phase(fmt.Sprintf("uploading to %s", t))
+				}
+				err := pipeline.Protect(func() error { return dns.Upload(ctx, t.provider, t.subdomain, ips) })
+				if err != nil {
+					workerPanics.add("upload to "+t.String(), err)
+					errs[first+j] = fmt.Errorf("%s: %w", t, err)
+					continue
+				}
+				if len(targets) > 1 {
+					i18n.Fprintf(os.Stderr, "dns: updated %s\n", t)
+				}
+			}
+		})
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// secretHelperTimeout bounds a credential helper command.
+const secretHelperTimeout = 30 * time.Second
+
+// resolveSecrets runs the credential helpers of the secret flags of fs: a
+// value "!op read op://vault/cf/token" is replaced by the output of the
+// command after the "!", run by the shell, so that tokens need not be kept
+// in config files or environment variables. A value starting with "!!" is
+// the literal value after the first "!". The helpers run again on every
+// reload, which picks up rotated secrets.
+func resolveSecrets(fs *flag.FlagSet) error {
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if !secretFlags[f.Name] {
+			return
+		}
+		// A header of --webhook-header takes the helper in its value,
+		// "Authorization: !command".
+		if r, ok := f.Value.(*repeatStringFlag); ok {
+			for i, v := range *r {
+				name, value, _ := strings.Cut(v, ":")
+				if value = strings.TrimSpace(value); !strings.HasPrefix(value, "!") {
+					continue
+				}
+				s, err := resolveSecret(value)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("--%s %s: %w", f.Name, name, err))
+					continue
+				}
+				(*r)[i] = name + ": " + s
+			}
+			return
+		}
+		s, err := resolveSecret(f.Value.String())
+		if err == nil {
+			err = f.Value.Set(s)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("--%s: %w", f.Name, err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// resolveSecret returns the secret of a flag value: the output of its
+// credential helper for "!command", the value itself otherwise.
+func resolveSecret(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, "!!"):
+		return v[1:], nil
+	case strings.HasPrefix(v, "!"):
+		return runSecretHelper(v[1:])
+	}
+	return v, nil
+}
+
+// runSecretHelper runs command with the shell and returns its first line of
+// output.
+func runSecretHelper(command string) (string, error) {
+	if strings.TrimSpace(command) == "" {
+		return "", errors.New("empty credential helper command")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), secretHelperTimeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", fmt.Errorf("credential helper %q: %w", command, err)
+	}
+	secret, _, _ := strings.Cut(string(out), "\n")
+	secret = strings.TrimRight(secret, "\r")
+	if secret == "" {
+		return "", fmt.Errorf("credential helper %q printed nothing", command)
+	}
+	return secret, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/winsvc"
+)
+
+// serviceStopGrace is how long a stopping service may spend flushing results
+// before the remaining work is aborted.
+const serviceStopGrace = 20 * time.Second
+
+const serviceUsage = `usage: mcis service [--name NAME] <command> [search flags...]
+
+Commands:
+  install    register mcis as an auto-start Windows service running the given search flags
+  uninstall  remove the service
+  start      start the installed service
+  stop       stop the running service
+  run        run as the service (used by the service control manager)
+
+Example:
+  mcis service install --interval 6h --cidr-file C:\mcis\ipv4cidr.txt --out-file C:\mcis\result.jsonl
+`
+
+// serviceActions are the commands of "mcis service".
+var serviceActions = []string{"install", "uninstall", "start", "stop", "run"}
+
+func newServiceFlagSet() (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet("service", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, serviceUsage) }
+	name := fs.String("name", "mcis", "Windows service name")
+	return fs, name
+}
+
+// serviceCommand implements "mcis service ..." and returns the exit code.
+func serviceCommand(args []string) int {
+	fs, name := newServiceFlagSet()
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	action, searchArgs := fs.Arg(0), fs.Args()[1:]
+
+	var err error
+	switch action {
+	case "install":
+		err = installService(*name, searchArgs)
+	case "uninstall":
+		err = winsvc.Uninstall(*name)
+	case "start":
+		err = winsvc.Start(*name)
+	case "stop":
+		err = winsvc.Stop(*name)
+	case "run":
+		err = runService(*name, searchArgs)
+	default:
+		fs.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: service %s: %v\n", action, err)
+		return 1
+	}
+	return 0
+}
+
+// installService validates the search flags and registers the service.
+func installService(name string, searchArgs []string) error {
+	o, err := parseSearchArgs(searchArgs)
+	if err != nil {
+		return err
+	}
+	if o.interval <= 0 {
+		return errors.New("--interval is required: a service without it runs one search and stops")
+	}
+	if o.outPath == "" {
+		return errors.New("--out-file is required: a service has no console for stdout")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := append([]string{"service", "--name", name, "run"}, searchArgs...)
+	if err := winsvc.Install(name, "Monte Carlo IP Searcher", exe, args); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "service: installed %q; start it with: mcis service start\n", name)
+	return nil
+}
+
+// runService runs the periodic search under the service control manager,
+// sending everything written to stderr to the Windows event log.
+func runService(name string, searchArgs []string) error {
+	o, err := parseSearchArgs(searchArgs)
+	if err != nil {
+		return err
+	}
+
+	elog, err := winsvc.OpenEventLog(name)
+	if err != nil {
+		return err
+	}
+	defer elog.Close()
+	if err := redirectStderr(elog); err != nil {
+		return err
+	}
+
+	return winsvc.Run(name, func(stop context.Context) error {
+		// A stop request ends sampling like Ctrl-C does; the best-so-far results
+		// are flushed unless that takes longer than serviceStopGrace.
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			<-stop.Done()
+			select {
+			case <-time.After(serviceStopGrace):
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		_ = elog.Info("mcis service started")
+		err := runLoop(ctx, stop, o, func() bool { return stop.Err() != nil })
+		if err != nil {
+			_ = elog.Error("mcis service stopped: " + err.Error())
+			return err
+		}
+		_ = elog.Info("mcis service stopped")
+		return nil
+	})
+}
+
+// redirectStderr replaces os.Stderr with a pipe whose lines are written to
+// the event log; lines starting with "error" are logged as errors.
+func redirectStderr(elog *winsvc.EventLog) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	os.Stderr = w
+	go func() {
+		sc := bufio.NewScanner(r)
+		for sc.Scan() {
+			line := sc.Text()
+			if strings.HasPrefix(line, "error") {
+				_ = elog.Error(line)
+			} else {
+				_ = elog.Info(line)
+			}
+		}
+	}()
+	return nil
+}
+
+// parseSearchArgs parses search flags outside of the main command line.
+func parseSearchArgs(args []string) (*options, error) {
+	return loadOptions(args, flag.ContinueOnError)
+}
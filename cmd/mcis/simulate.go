@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/probe"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/simulate"
+)
+
+// simulatedBytes is the size of a simulated download test without
+// --download-bytes.
+const simulatedBytes = 50_000_000
+
+// simulationModel returns the latency model of --simulate, or nil without
+// it.
+func simulationModel(o *options) (*simulate.Model, error) {
+	if !o.simulate {
+		return nil, nil
+	}
+	var rules []simulate.Rule
+	if o.simulateModel != "" {
+		var err error
+		if rules, err = simulate.ReadRulesFile(o.simulateModel); err != nil {
+			return nil, fmt.Errorf("--simulate-model: %w", err)
+		}
+	}
+	return simulate.New(simulate.Config{Rules: rules, Seed: o.seed, Timeout: o.timeout}), nil
+}
+
+// simulatedDownload returns the download test of sim for downloads of
+// dlCfg.
+func simulatedDownload(sim *simulate.Model, dlCfg probe.DownloadConfig) func(context.Context, netip.Addr) probe.DownloadResult {
+	bytes := dlCfg.Bytes
+	if bytes <= 0 {
+		bytes = simulatedBytes
+	}
+	return func(ctx context.Context, ip netip.Addr) probe.DownloadResult {
+		return sim.Download(ctx, ip, bytes)
+	}
+}
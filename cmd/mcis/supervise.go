@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/systemd"
+)
+
+// lastActivity is when the run last made visible progress (unix nanoseconds).
+// The systemd watchdog is only fed while it is recent.
+var lastActivity atomic.Int64
+
+// idle is set while periodic mode waits for the next run; waiting counts as healthy.
+var idle atomic.Bool
+
+var readyOnce sync.Once
+
+// phase records progress and publishes msg as the systemd status line.
+func phase(msg string) {
+	lastActivity.Store(time.Now().UnixNano())
+	_, _ = systemd.Notify(systemd.Status(msg))
+}
+
+// notifyReady tells systemd that startup (flag parsing and validation) is done.
+func notifyReady() {
+	readyOnce.Do(func() {
+		if ok, err := systemd.Notify(systemd.Ready); err != nil {
+			fmt.Fprintln(os.Stderr, "systemd: notify error:", err)
+		} else if ok {
+			fmt.Fprintln(os.Stderr, "systemd: notified READY")
+		}
+	})
+}
+
+// notifyStopping tells systemd the process is shutting down.
+func notifyStopping() {
+	_, _ = systemd.Notify(systemd.Stopping)
+}
+
+// superviseSystemd feeds the systemd watchdog and keeps the status line up to
+// date while the process runs. Completed probes count as activity; when
+// nothing moves for a whole WatchdogSec the pings stop and systemd restarts
+// the service.
+func superviseSystemd(ctx context.Context) {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+	lastActivity.Store(time.Now().UnixNano())
+
+	go func() {
+		var lastCompleted int64 = -1
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				eng := activeEngine.Load()
+				if eng == nil {
+					continue
+				}
+				p := eng.Progress()
+				if p.Completed != lastCompleted && p.Completed < int64(p.Budget) {
+					lastCompleted = p.Completed
+					phase(fmt.Sprintf("scanning: %d/%d probed, best %.1fms (%s)",
+						p.Completed, p.Budget, p.Best.ScoreMS, p.Best.IP))
+				}
+			}
+		}
+	}()
+
+	interval, ok := systemd.WatchdogInterval()
+	if !ok {
+		return
+	}
+	go systemd.RunWatchdog(ctx, func() bool {
+		return idle.Load() || time.Since(time.Unix(0, lastActivity.Load())) < interval
+	})
+}
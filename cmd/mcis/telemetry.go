@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/url"
+	"runtime"
+	"slices"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/logging"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/telemetry"
+)
+
+// telemetryURL is the default --telemetry-url, set at build time like
+// version; builds without it send telemetry only to an explicit
+// --telemetry-url.
+var telemetryURL = ""
+
+// telemetryTimeout bounds the telemetry request of a run.
+const telemetryTimeout = 5 * time.Second
+
+// checkTelemetry checks the --telemetry flags.
+func checkTelemetry(o *options) error {
+	if !o.telemetry {
+		return nil
+	}
+	if o.telemetryURL == "" {
+		return errors.New("--telemetry needs --telemetry-url: this build has no default endpoint")
+	}
+	if u, err := url.Parse(o.telemetryURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.New("--telemetry-url must be an http(s) URL")
+	}
+	return nil
+}
+
+// telemetryReport builds the anonymous report of a run; see the telemetry
+// package for what it leaves out.
+func telemetryReport(o *options, rep *runReport, err error) telemetry.Report {
+	r := telemetry.Report{
+		Version:   currentVersion(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		DurationS: time.Since(rep.start).Seconds(),
+		Samples:   rep.probes,
+		Budget:    o.budget,
+		Strategy:  rep.strategy,
+		Agents:    len(o.agents),
+		Download:  o.dlTop > 0,
+		Periodic:  o.interval > 0,
+		Resumed:   rep.resumed,
+		Failed:    err != nil,
+		Upload:    rep.uploadEnabled,
+		Providers: rep.providers,
+	}
+	for kind, on := range map[string]bool{
+		"workers-kv":   o.kvNamespace != "",
+		"git":          o.gitRepo != "",
+		"external-dns": o.extDNSName != "",
+		"consul":       o.consulService != "",
+		"etcd":         o.etcdEndpoint != "",
+		"archive":      o.archiveURL != "",
+	} {
+		if on {
+			r.Publishers = append(r.Publishers, kind)
+		}
+	}
+	slices.Sort(r.Publishers)
+	return r
+}
+
+// sendTelemetry sends the report of a run if the user opted in. Failures
+// are only logged: telemetry never fails or delays a run by more than
+// telemetryTimeout.
+func sendTelemetry(ctx context.Context, o *options, rep *runReport, err error) {
+	if !o.telemetry || o.simulate {
+		return
+	}
+	r := telemetryReport(o, rep, err)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), telemetryTimeout)
+	defer cancel()
+	if err := telemetry.Send(ctx, o.telemetryURL, r); err != nil {
+		slog.Debug("telemetry not sent", logging.Phase("telemetry"), "error", err)
+		return
+	}
+	data, _ := json.Marshal(r)
+	slog.Debug("sent telemetry", logging.Phase("telemetry"), "report", string(data))
+}
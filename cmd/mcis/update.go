@@ -0,0 +1,155 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/selfupdate"
+)
+
+const updateUsage = `usage: mcis update [flags]
+
+Replaces this binary with that of the latest release (or --tag) for this
+platform, after checking the archive against its published SHA-256 checksum
+and its signature. Builds without a release signing key (development builds)
+need --public-key, or --insecure-no-signature to rely on the checksum alone.
+
+`
+
+// version is the release of this build, set by the release workflow with
+// -ldflags "-X main.version=v1.2.3".
+var version = ""
+
+// updateKey is the base64 Ed25519 key that signs the release archives, set
+// at build time like version with -X main.updateKey=...; builds without it
+// only install with --public-key or --insecure-no-signature.
+var updateKey = ""
+
+// currentVersion returns the release of this build, or the module version
+// of "go install", or "" for a development build, including the
+// v0.0.0-<date>-<commit> pseudo-versions that go build stamps from git.
+func currentVersion() string {
+	if version != "" {
+		return version
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "(devel)" && !strings.HasPrefix(bi.Main.Version, "v0.0.0-") {
+		return bi.Main.Version
+	}
+	return ""
+}
+
+// updateOptions holds the flags of "mcis update".
+type updateOptions struct {
+	check     bool
+	tag       string
+	force     bool
+	repo      string
+	api       string
+	publicKey string
+	insecure  bool
+	timeout   time.Duration
+}
+
+func newUpdateFlagSet() (*flag.FlagSet, *updateOptions) {
+	var uo updateOptions
+	fs := flag.NewFlagSet("update", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, updateUsage)
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&uo.check, "check", false, "Only report whether a newer release exists (exit 1 if so)")
+	fs.StringVar(&uo.tag, "tag", "", "Install this release, e.g. v1.4.0, even if it is older (default the latest)")
+	fs.BoolVar(&uo.force, "force", false, "Install even when this build is already at the release, or is a development build")
+	fs.StringVar(&uo.repo, "repo", selfupdate.DefaultRepo, "GitHub repository of the releases")
+	fs.StringVar(&uo.api, "api", selfupdate.DefaultAPI, "GitHub API base URL, e.g. of a mirror")
+	fs.StringVar(&uo.publicKey, "public-key", "", "Base64 Ed25519 key the release archives must be signed with (default the key built in, if any)")
+	fs.BoolVar(&uo.insecure, "insecure-no-signature", false, "Install even though no signing key is configured, checking the archive against its checksum only")
+	fs.DurationVar(&uo.timeout, "timeout", 5*time.Minute, "Time limit of the whole update")
+	return fs, &uo
+}
+
+// updateCommand implements "mcis update" and returns the exit code.
+func updateCommand(args []string) int {
+	fs, uo := newUpdateFlagSet()
+	if err := parseFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "error: unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		return 2
+	}
+	var key ed25519.PublicKey
+	if k := cmp.Or(uo.publicKey, updateKey); k != "" {
+		var err error
+		if key, err = selfupdate.ParsePublicKey(k); err != nil {
+			fmt.Fprintln(os.Stderr, "error: --public-key:", err)
+			return 2
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, uo.timeout)
+	defer cancel()
+
+	u := selfupdate.New(selfupdate.Config{API: uo.api, Repo: uo.repo, PublicKey: key})
+	rel, err := u.Release(ctx, uo.tag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error: update:", err)
+		return 1
+	}
+	cur := currentVersion()
+	newer := selfupdate.Newer(rel.Tag, cur)
+	shown := cmp.Or(cur, "a development build")
+	if uo.check {
+		if cur == "" {
+			fmt.Printf("mcis is a development build (latest release %s)\n", rel.Tag)
+			return 0
+		}
+		if !newer {
+			fmt.Printf("mcis %s is up to date (latest release %s)\n", cur, rel.Tag)
+			return 0
+		}
+		fmt.Printf("mcis %s is available (this is %s): %s\n", rel.Tag, cur, rel.URL)
+		return 1
+	}
+	switch {
+	case newer || uo.force || uo.tag != "" && uo.tag != cur:
+		// An explicit --tag may also go back to an older release.
+	case cur == "":
+		fmt.Fprintf(os.Stderr, "update: this is a development build; use --force to install %s\n", rel.Tag)
+		return 1
+	default:
+		fmt.Printf("mcis %s is up to date\n", cur)
+		return 0
+	}
+
+	if key == nil && !uo.insecure {
+		fmt.Fprintln(os.Stderr, "error: update: this build has no release signing key; pass --public-key, or --insecure-no-signature to check the checksum only")
+		return 1
+	}
+	bin, err := u.Fetch(ctx, rel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error: update:", err)
+		return 1
+	}
+	if key == nil {
+		fmt.Fprintln(os.Stderr, "update: warning: verified the checksum only; the signature is not checked (--insecure-no-signature)")
+	}
+	exe, err := selfupdate.Replace(bin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error: update:", err)
+		return 1
+	}
+	fmt.Printf("updated %s from %s to %s\n", exe, shown, rel.Tag)
+	return 0
+}
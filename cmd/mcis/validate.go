@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/cidr"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/icmpping"
+)
+
+const configUsage = `usage: mcis config validate [search flags...]
+
+Checks the search flags, together with --config and the MCIPS_* environment
+variables, without searching: the CIDRs, the search and health thresholds,
+the schedule, the download, notification and publishing settings, and that
+every DNS provider accepts its credentials. The effective configuration is
+printed to stdout in --config format, with secrets redacted.
+
+Exit status: 0 if every check passed, 1 if one failed, 2 on usage errors.
+`
+
+// configActions are the commands of "mcis config".
+var configActions = []string{"validate"}
+
+// dnsCheckTimeout bounds the API call that checks a DNS provider's
+// credentials.
+const dnsCheckTimeout = 15 * time.Second
+
+// secretFlags are flags whose values are not printed by "config validate".
+var secretFlags = map[string]bool{
+	"alert-opsgenie-key":  true,
+	"alert-pagerduty-key": true,
+	"archive-access-key":  true,
+	"archive-secret-key":  true,
+	"auth-token":          true,
+	"consul-token":        true,
+	"discord-webhook":     true,
+	"dns-token":           true,
+	"etcd-password":       true,
+	"grafana-token":       true,
+	"kv-token":            true,
+	"mqtt-password":       true,
+	"ntfy-token":          true,
+	"pushover-token":      true,
+	"slack-webhook":       true,
+	"smtp-password":       true,
+	"telegram-token":      true,
+	"webhook-header":      true,
+}
+
+// configCommand implements "mcis config validate" and returns the exit code.
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "validate" {
+		fmt.Fprint(os.Stderr, configUsage)
+		return 2
+	}
+	o, fs, err := parseOptions(args[1:], flag.ContinueOnError)
+	if err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "error:", err)
+		}
+		return 2
+	}
+
+	failed := false
+	check := func(name string, fn func() (string, error)) {
+		detail, err := fn()
+		switch {
+		case err != nil:
+			failed = true
+			fmt.Fprintf(os.Stderr, "FAIL  %s: %v\n", name, err)
+		case detail != "":
+			fmt.Fprintf(os.Stderr, "ok    %s: %s\n", name, detail)
+		default:
+			fmt.Fprintf(os.Stderr, "ok    %s\n", name)
+		}
+	}
+
+	check("flags", func() (string, error) { return "", checkFlags(o) })
+	check("ranges", func() (string, error) { return checkRanges(o) })
+	check("search", func() (string, error) {
+		cfg := engineConfig(o)
+		cfg.ApplyDefaults()
+		if err := cfg.Validate(); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("budget %d, top %d, concurrency %d, pps %g", cfg.Budget, cfg.TopN, cfg.Concurrency, o.pps), nil
+	})
+	check("schedule", func() (string, error) { return checkSchedule(o) })
+	if o.simulate {
+		check("simulate", func() (string, error) {
+			_, err := simulationModel(o)
+			return "no network traffic", err
+		})
+	}
+	if o.icmp && !o.simulate && slices.Contains(icmpping.SocketKinds, o.icmpSocket) {
+		check("icmp", func() (string, error) {
+			c := icmpping.Detect()
+			switch {
+			case c.Err != nil:
+				return "", c.Err
+			case o.icmpSocket == icmpping.SocketDgram && !c.Dgram:
+				return "", errors.New("datagram ICMP sockets are not permitted; use --icmp-socket auto or raw")
+			case o.icmpSocket == icmpping.SocketRaw && !c.Raw:
+				return "", errors.New("raw ICMP sockets are not permitted; use --icmp-socket auto or dgram")
+			case o.icmpSocket == icmpping.SocketAuto:
+				return c.Socket() + " sockets", nil
+			}
+			return o.icmpSocket + " sockets", nil
+		})
+	}
+	check("download", func() (string, error) {
+		_, err := downloadConfig(o)
+		return "", err
+	})
+	if len(o.agents) > 0 {
+		check("agents", func() (string, error) {
+			_, err := setupAgents(o)
+			return fmt.Sprintf("%d agents", len(o.agents)), err
+		})
+	}
+	check("notify", func() (string, error) {
+		ns, err := setupNotifiers(o)
+		return fmt.Sprintf("%d notifiers", len(ns)), err
+	})
+	check("publish", func() (string, error) {
+		publishers, err := setupPublishers(o)
+		if err != nil {
+			return "", err
+		}
+		if _, err := setupArchive(o); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d publishers", len(publishers)), checkUploadFlags(o, len(publishers) > 0)
+	})
+	if o.dnsProvider != "" || len(o.dnsTargets) > 0 {
+		targets, err := dnsTargets(o)
+		if err != nil {
+			check("dns", func() (string, error) { return "", err })
+		}
+		for _, t := range targets {
+			check("dns "+t.String(), func() (string, error) {
+				ctx, cancel := context.WithTimeout(context.Background(), dnsCheckTimeout)
+				defer cancel()
+				recs, err := t.provider.ListRecords(ctx, t.subdomain, false)
+				if err != nil {
+					return "", fmt.Errorf("credentials: %w", err)
+				}
+				return fmt.Sprintf("credentials accepted, %d A records", len(recs)), nil
+			})
+		}
+	}
+
+	writeEffectiveConfig(os.Stdout, fs)
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// checkRanges parses the CIDRs of the flags and --cidr-file.
+func checkRanges(o *options) (string, error) {
+	prefixes, err := cidrFlags(o)
+	if err != nil {
+		return "", err
+	}
+	if o.cidrFile != "" {
+		ps, err := cidr.ReadRangesFile(o.cidrFile)
+		if err != nil {
+			return "", fmt.Errorf("--cidr-file: %w", err)
+		}
+		prefixes = append(prefixes, ps...)
+	}
+	if len(prefixes) == 0 {
+		return "", errors.New("no CIDR provided (use --cidr or --cidr-file)")
+	}
+	v6 := 0
+	for _, p := range prefixes {
+		if p.Addr().Is6() {
+			v6++
+		}
+	}
+	return fmt.Sprintf("%d prefixes (%d IPv4, %d IPv6)", len(prefixes), len(prefixes)-v6, v6), nil
+}
+
+// cidrFlags parses the --cidr ranges.
+func cidrFlags(o *options) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range o.cidrs {
+		ps, err := cidr.ParseRanges(s)
+		if err != nil {
+			return nil, fmt.Errorf("--cidr: %w", err)
+		}
+		prefixes = append(prefixes, ps...)
+	}
+	return prefixes, nil
+}
+
+// checkSchedule checks --interval against the health thresholds that
+// depend on it.
+func checkSchedule(o *options) (string, error) {
+	switch {
+	case o.interval < 0:
+		return "", fmt.Errorf("--interval must be >= 0, got %s", o.interval)
+	case o.healthMaxAge < 0:
+		return "", fmt.Errorf("--health-max-age must be >= 0, got %s", o.healthMaxAge)
+	case o.interval > 0 && o.healthMaxAge > 0 && o.healthMaxAge <= o.interval:
+		return "", fmt.Errorf("--health-max-age %s is not longer than --interval %s: the service turns unhealthy before every run", o.healthMaxAge, o.interval)
+	case o.interval == 0:
+		return "run once", nil
+	}
+	return fmt.Sprintf("every %s, unhealthy after %s without a successful run", o.interval, healthMaxAge(o.healthMaxAge, o.interval)), nil
+}
+
+// writeEffectiveConfig writes the settings of fs that differ from their
+// defaults, whether set by flag, environment, --config or tuning, as a
+// --config file.
+func writeEffectiveConfig(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintln(w, "# effective configuration (settings at their defaults are omitted)")
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || f.Value.String() == f.DefValue {
+			return
+		}
+		values := []string{f.Value.String()}
+		if r, ok := f.Value.(*repeatStringFlag); ok {
+			values = *r
+		}
+		for _, v := range values {
+			if secretFlags[f.Name] {
+				v = "<redacted>"
+			}
+			fmt.Fprintf(w, "%s = %s\n", f.Name, v)
+		}
+	})
+}
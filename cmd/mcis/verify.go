@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/color"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/dns"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/logging"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/pipeline"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/probe"
+)
+
+const verifyUsage = `usage: mcis verify [verify flags] [search flags...]
+
+Resolves the managed subdomain, probes every published IP with the search
+probe settings (--host, --path, --timeout, --rounds, --colo, ...) and reports
+the degraded ones. Exit status: 0 all healthy, 1 some degraded, 2 usage error.
+
+Example:
+  mcis verify --dns-provider cloudflare --dns-subdomain cf --max-ms 300 \
+    --replace-threshold 2 --cidr-file ipv4cidr.txt
+
+`
+
+// verifyOptions holds the flags of "mcis verify".
+type verifyOptions struct {
+	options // search flags, used for probing and the replacement run
+
+	name             string
+	maxMS            float64
+	replaceThreshold int
+}
+
+func newVerifyFlagSet() (*flag.FlagSet, *verifyOptions) {
+	var vo verifyOptions
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, verifyUsage)
+		fs.PrintDefaults()
+	}
+	registerFlags(fs, &vo.options)
+	fs.StringVar(&vo.name, "name", "", "Domain name to resolve (default: --dns-subdomain in the --dns-zone of --dns-provider)")
+	fs.Float64Var(&vo.maxMS, "max-ms", 0, "Count a record as degraded when its average latency exceeds this (0 = only failures)")
+	fs.IntVar(&vo.replaceThreshold, "replace-threshold", 0, "Run a replacement search and upload when at least N records are degraded (0 = never)")
+	return fs, &vo
+}
+
+// verifyResult is the verdict for one published IP.
+type verifyResult struct {
+	probe.Result
+	colo   string
+	reason string // empty when healthy
+}
+
+// verifyCommand implements "mcis verify" and returns the exit code.
+func verifyCommand(args []string) int {
+	fs, vo := newVerifyFlagSet()
+	if err := parseFlags(fs, args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "error: unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		return 2
+	}
+	if err := tuneDefaults(fs, &vo.options); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 2
+	}
+	if err := setupLogging(vo.logLevel, vo.logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 2
+	}
+	if vo.name == "" && (vo.dnsProvider == "" || vo.dnsSubdomain == "") {
+		fmt.Fprintln(os.Stderr, "error: verify needs --name, or --dns-provider with --dns-subdomain")
+		return 2
+	}
+	if vo.replaceThreshold > 0 && vo.dnsProvider == "" {
+		fmt.Fprintln(os.Stderr, "error: --replace-threshold needs --dns-provider to upload the replacement")
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	results, err := verifyRecords(ctx, vo)
+	stop()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error: verify:", err)
+		return 1
+	}
+
+	degraded := 0
+	for _, r := range results {
+		state, c := "ok", color.Green
+		if r.reason != "" {
+			state, c = "degraded", color.Red
+			degraded++
+		}
+		fmt.Printf("%s %-39s colo=%-4s ms=%-6d %s\n", color.Wrap(os.Stdout, c, fmt.Sprintf("%-9s", state)), r.IP, r.colo, r.TotalMS, r.reason)
+	}
+	fmt.Fprintf(os.Stderr, "verify: %d/%d published records degraded\n", degraded, len(results))
+
+	if degraded == 0 {
+		return 0
+	}
+	if vo.replaceThreshold > 0 && degraded >= vo.replaceThreshold {
+		fmt.Fprintf(os.Stderr, "verify: %d degraded records reach --replace-threshold %d, running a replacement search\n", degraded, vo.replaceThreshold)
+		return searchMain(&vo.options)
+	}
+	return 1
+}
+
+// verifyRecords resolves the published records and probes each of them.
+func verifyRecords(ctx context.Context, vo *verifyOptions) ([]verifyResult, error) {
+	if vo.coloAllow != "" && vo.coloExclude != "" {
+		return nil, errors.New("cannot use both --colo and --colo-exclude; use only one")
+	}
+	name, err := verifyName(ctx, vo)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", name)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", name, err)
+	}
+	ips := make([]netip.Addr, 0, len(addrs))
+	for _, a := range addrs {
+		ips = append(ips, a.Unmap())
+	}
+	slices.SortFunc(ips, netip.Addr.Compare)
+	ips = slices.Compact(ips)
+	slog.Debug("resolved", logging.Phase("verify"), "name", name, "ips", len(ips))
+
+	allow, block := parseColoList(vo.coloAllow), parseColoList(vo.coloExclude)
+	probeCfg := probeConfig(&vo.options)
+	measure := probe.NewProber(probeCfg).ProbeHTTPTraceMulti
+	cache, err := openProbeCache(&vo.options)
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		defer saveProbeCache(cache)
+		measure = cache.Wrap(probeCfg, measure)
+	}
+	defer workerPanics.report(os.Stderr)
+	results := make([]verifyResult, len(ips))
+	var wg sync.WaitGroup
+	for i, ip := range ips {
+		wg.Go(func() {
+			var r verifyResult
+			err := pipeline.Protect(func() error {
+				r.Result = measure(ctx, ip)
+				return nil
+			})
+			if err != nil {
+				workerPanics.add("verify "+ip.String(), err)
+				r.Result = probe.Result{IP: ip, When: time.Now(), Error: err.Error()}
+			}
+			if r.Trace != nil {
+				r.colo = r.Trace["colo"]
+			}
+			switch {
+			case !r.OK:
+				r.reason = "probe failed: " + r.Error
+				if r.Error == "" {
+					r.reason = fmt.Sprintf("probe failed: status %d", r.Status)
+				}
+			case len(allow) > 0 && !slices.Contains(allow, r.colo):
+				r.reason = "colo " + r.colo + " not in --colo"
+			case slices.Contains(block, r.colo):
+				r.reason = "colo " + r.colo + " in --colo-exclude"
+			case vo.maxMS > 0 && float64(r.TotalMS) > vo.maxMS:
+				r.reason = fmt.Sprintf("latency %dms above --max-ms", r.TotalMS)
+			}
+			results[i] = r
+		})
+	}
+	wg.Wait()
+
+	// Degraded records first, then by latency.
+	sort.SliceStable(results, func(i, j int) bool {
+		if (results[i].reason != "") != (results[j].reason != "") {
+			return results[i].reason != ""
+		}
+		return results[i].TotalMS < results[j].TotalMS
+	})
+	return results, ctx.Err()
+}
+
+// verifyName returns --name, or the full name of --dns-subdomain.
+func verifyName(ctx context.Context, vo *verifyOptions) (string, error) {
+	if vo.name != "" {
+		return vo.name, nil
+	}
+	provider, err := dns.NewProvider(dnsConfig(&vo.options, vo.dnsProvider))
+	if err != nil {
+		return "", err
+	}
+	namer, ok := provider.(dns.Namer)
+	if !ok {
+		return "", fmt.Errorf("%s cannot derive the domain name; use --name", provider.Name())
+	}
+	return namer.FQDN(ctx, vo.dnsSubdomain)
+}
@@ -0,0 +1,127 @@
+// Package admin implements the optional HTTP listeners of a running mcis
+// process (pprof profiles, expvar, runtime statistics, health and probe
+// agents), optionally protected by a bearer token and TLS client certificates.
+package admin
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// Server is a small HTTP server for diagnostics endpoints.
+type Server struct {
+	addr    string
+	mux     *http.ServeMux
+	started time.Time
+
+	tls    *tls.Config
+	auth   Auth
+	public []string
+}
+
+// New creates a server that will listen on addr (e.g. "127.0.0.1:6060").
+func New(addr string) *Server {
+	return &Server{
+		addr:    addr,
+		mux:     http.NewServeMux(),
+		started: time.Now(),
+	}
+}
+
+// Handle registers an additional handler on the server.
+func (s *Server) Handle(pattern string, h http.Handler) {
+	s.mux.Handle(pattern, h)
+}
+
+// EnableDebug registers net/http/pprof under /debug/pprof/, expvar under
+// /debug/vars and a JSON runtime summary under /debug/runtime.
+func (s *Server) EnableDebug() {
+	s.mux.HandleFunc("/debug/pprof/", pprof.Index)
+	s.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	s.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	s.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	s.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	s.mux.Handle("/debug/vars", expvar.Handler())
+	s.mux.HandleFunc("/debug/runtime", s.serveRuntime)
+}
+
+// ListenAndServe serves until ctx is canceled.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	if s.tls != nil {
+		ln = tls.NewListener(ln, s.tls)
+	}
+	srv := &http.Server{
+		Handler:           s.authorize(s.mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// RuntimeStats is a compact snapshot of the Go runtime.
+type RuntimeStats struct {
+	Uptime       string  `json:"uptime"`
+	Goroutines   int     `json:"goroutines"`
+	GOMAXPROCS   int     `json:"gomaxprocs"`
+	NumCPU       int     `json:"num_cpu"`
+	HeapAlloc    uint64  `json:"heap_alloc_bytes"`
+	HeapInuse    uint64  `json:"heap_inuse_bytes"`
+	Sys          uint64  `json:"sys_bytes"`
+	TotalAlloc   uint64  `json:"total_alloc_bytes"`
+	Mallocs      uint64  `json:"mallocs"`
+	NumGC        uint32  `json:"num_gc"`
+	LastPauseMS  float64 `json:"last_gc_pause_ms"`
+	TotalPauseMS float64 `json:"total_gc_pause_ms"`
+}
+
+// ReadRuntimeStats collects a RuntimeStats snapshot.
+func ReadRuntimeStats(started time.Time) RuntimeStats {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	var lastPause uint64
+	if ms.NumGC > 0 {
+		lastPause = ms.PauseNs[(ms.NumGC+255)%256]
+	}
+	return RuntimeStats{
+		Uptime:       time.Since(started).Truncate(time.Second).String(),
+		Goroutines:   runtime.NumGoroutine(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		NumCPU:       runtime.NumCPU(),
+		HeapAlloc:    ms.HeapAlloc,
+		HeapInuse:    ms.HeapInuse,
+		Sys:          ms.Sys,
+		TotalAlloc:   ms.TotalAlloc,
+		Mallocs:      ms.Mallocs,
+		NumGC:        ms.NumGC,
+		LastPauseMS:  float64(lastPause) / 1e6,
+		TotalPauseMS: float64(ms.PauseTotalNs) / 1e6,
+	}
+}
+
+func (s *Server) serveRuntime(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(ReadRuntimeStats(s.started))
+}
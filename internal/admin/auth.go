@@ -0,0 +1,142 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Auth protects the listeners of a process and authenticates its requests to
+// other mcis processes (probe agents). The same token and certificates are
+// used in both directions, so one secret and one CA secure a whole setup.
+type Auth struct {
+	// Token is the bearer token required by, and sent to, mcis listeners.
+	Token string
+	// CertFile and KeyFile are the certificate served by listeners (HTTPS)
+	// and presented to agents as a client certificate.
+	CertFile string
+	KeyFile  string
+	// CAFile verifies client certificates (requiring them, i.e. mTLS) and
+	// the certificates of agents.
+	CAFile string
+}
+
+// Enabled reports whether any protection is configured.
+func (a Auth) Enabled() bool {
+	return a.Token != "" || a.CertFile != "" || a.CAFile != ""
+}
+
+func (a Auth) validate() error {
+	if (a.CertFile == "") != (a.KeyFile == "") {
+		return errors.New("--tls-cert and --tls-key must be given together")
+	}
+	if a.CAFile != "" && a.CertFile == "" {
+		return errors.New("--tls-ca needs --tls-cert and --tls-key")
+	}
+	return nil
+}
+
+func (a Auth) certPool() (*x509.CertPool, error) {
+	pem, err := os.ReadFile(a.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", a.CAFile)
+	}
+	return pool, nil
+}
+
+// ServerTLS returns the listener TLS configuration, or nil for plain HTTP.
+// With a CA, client certificates are verified when presented and required
+// by Server for every non-public path.
+func (a Auth) ServerTLS() (*tls.Config, error) {
+	if err := a.validate(); err != nil {
+		return nil, err
+	}
+	if a.CertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(a.CertFile, a.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if a.CAFile != "" {
+		pool, err := a.certPool()
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
+
+// ClientTLS returns the TLS configuration for requests to other mcis
+// listeners, or nil for the defaults.
+func (a Auth) ClientTLS() (*tls.Config, error) {
+	if err := a.validate(); err != nil {
+		return nil, err
+	}
+	if a.CAFile == "" {
+		return nil, nil
+	}
+	pool, err := a.certPool()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(a.CertFile, a.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// SetAuth protects every path of the server except public ones (e.g.
+// /healthz for container probes) with the token and, when a CA is set, a
+// verified client certificate.
+func (s *Server) SetAuth(a Auth, public ...string) error {
+	tlsCfg, err := a.ServerTLS()
+	if err != nil {
+		return err
+	}
+	s.tls = tlsCfg
+	s.auth = a
+	s.public = public
+	return nil
+}
+
+// authorize wraps h with the checks configured by SetAuth.
+func (s *Server) authorize(h http.Handler) http.Handler {
+	if !s.auth.Enabled() {
+		return h
+	}
+	want := []byte("Bearer " + s.auth.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(s.public, r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if s.auth.CAFile != "" && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			http.Error(w, "client certificate required", http.StatusUnauthorized)
+			return
+		}
+		if s.auth.Token != "" {
+			got := []byte(strings.TrimSpace(r.Header.Get("Authorization")))
+			if subtle.ConstantTimeCompare(got, want) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="mcis"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,169 @@
+// Package agent lets one search run (the coordinator) re-probe its candidates
+// from other vantage points. An agent is a small HTTP service that probes the
+// IPs it is sent; the coordinator merges the latencies of all vantage points
+// before it ranks, download-tests and uploads the results.
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/pipeline"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/probe"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/transport"
+)
+
+// ProbePath is the agent endpoint that probes a batch of IPs.
+const ProbePath = "/v1/probe"
+
+// Limits applied by agents so a single request cannot keep them busy forever.
+const (
+	MaxIPs    = 1024
+	MaxRounds = 20
+)
+
+// ProbeRequest asks an agent to probe IPs with the given settings.
+type ProbeRequest struct {
+	IPs   []netip.Addr `json:"ips"`
+	Probe probe.Config `json:"probe"`
+}
+
+// ProbeResponse carries the results of an agent, in request order.
+type ProbeResponse struct {
+	Agent   string         `json:"agent"`
+	Results []probe.Result `json:"results"`
+}
+
+// Handler serves ProbePath, probing with at most concurrency IPs at a time.
+type Handler struct {
+	name        string
+	concurrency int
+	dialer      *net.Dialer
+}
+
+// NewHandler creates the agent handler; name identifies the vantage point in
+// the coordinator's merge (and its --agent-weight). dialer (optional) makes
+// the probe connections; it is part of the agent's own setup, a request
+// cannot choose it.
+func NewHandler(name string, concurrency int, dialer *net.Dialer) *Handler {
+	if concurrency <= 0 {
+		concurrency = 50
+	}
+	return &Handler{name: name, concurrency: concurrency, dialer: dialer}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req ProbeRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "parse request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.IPs) > MaxIPs {
+		http.Error(w, fmt.Sprintf("too many IPs (max %d)", MaxIPs), http.StatusBadRequest)
+		return
+	}
+	req.Probe.Rounds = min(req.Probe.Rounds, MaxRounds)
+	req.Probe.Dialer = h.dialer
+
+	prober := probe.NewProber(req.Probe)
+	resp := ProbeResponse{Agent: h.name, Results: make([]probe.Result, len(req.IPs))}
+	sem := make(chan struct{}, h.concurrency)
+	var wg sync.WaitGroup
+	for i, ip := range req.IPs {
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			var res probe.Result
+			err := pipeline.Protect(func() error {
+				res = prober.ProbeHTTPTraceMulti(r.Context(), ip)
+				return nil
+			})
+			if err != nil {
+				// A panic fails this IP, not the agent.
+				res = probe.Result{IP: ip, When: time.Now(), Error: err.Error()}
+			}
+			// Latency from another vantage point is all the coordinator needs.
+			if colo := res.Trace["colo"]; colo != "" {
+				res.Trace = map[string]string{"colo": colo}
+			} else {
+				res.Trace = nil
+			}
+			resp.Results[i] = res
+		})
+	}
+	wg.Wait()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// Client sends probe requests to agents.
+type Client struct {
+	client *http.Client
+	token  string
+}
+
+// NewClient creates a client whose requests time out after timeout. token is
+// sent as a bearer token when set; tlsCfg (optional) carries the CA and
+// client certificate for agents that require mTLS.
+func NewClient(timeout time.Duration, token string, tlsCfg *tls.Config) *Client {
+	c := &http.Client{Timeout: timeout, Transport: transport.API()}
+	if tlsCfg != nil {
+		t := transport.API().Clone()
+		t.TLSClientConfig = tlsCfg
+		c.Transport = t
+	}
+	return &Client{client: c, token: token}
+}
+
+// Probe asks the agent at baseURL (e.g. "http://10.0.0.2:9090") to probe req.
+func (c *Client) Probe(ctx context.Context, baseURL string, req ProbeRequest) (ProbeResponse, error) {
+	var resp ProbeResponse
+	data, err := json.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(baseURL, "/")+ProbePath, bytes.NewReader(data))
+	if err != nil {
+		return resp, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	httpResp, err := c.client.Do(httpReq)
+	if err != nil {
+		return resp, err
+	}
+	defer httpResp.Body.Close()
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return resp, err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return resp, fmt.Errorf("agent error: status %d: %s", httpResp.StatusCode, bytes.TrimSpace(body))
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return resp, fmt.Errorf("parse response: %w", err)
+	}
+	if len(resp.Results) != len(req.IPs) {
+		return resp, errors.New("agent error: result count does not match the request")
+	}
+	return resp, nil
+}
@@ -0,0 +1,66 @@
+package agent
+
+import "fmt"
+
+// Merge modes.
+const (
+	// MergeMax ranks an IP by its worst latency over all vantage points.
+	MergeMax = "max"
+	// MergeWeighted ranks an IP by the weighted mean of its latencies.
+	MergeWeighted = "weighted"
+)
+
+// Sample is the latency of an IP as seen from one vantage point.
+type Sample struct {
+	Vantage string
+	OK      bool
+	MS      float64
+}
+
+// Merger combines the samples of an IP into one score.
+type Merger struct {
+	mode    string
+	weights map[string]float64
+}
+
+// NewMerger creates a merger for mode. weights maps vantage names to their
+// weight in MergeWeighted; unlisted vantage points weigh 1.
+func NewMerger(mode string, weights map[string]float64) (*Merger, error) {
+	switch mode {
+	case MergeMax, MergeWeighted:
+	default:
+		return nil, fmt.Errorf("unknown merge mode: %s (supported: %s, %s)", mode, MergeMax, MergeWeighted)
+	}
+	for name, w := range weights {
+		if w < 0 {
+			return nil, fmt.Errorf("negative weight for %s", name)
+		}
+	}
+	return &Merger{mode: mode, weights: weights}, nil
+}
+
+// Merge returns the combined latency of samples. An IP that failed from any
+// vantage point is not usable for everyone, so ok is false.
+func (m *Merger) Merge(samples []Sample) (ms float64, ok bool) {
+	var sum, total float64
+	for _, s := range samples {
+		if !s.OK {
+			return 0, false
+		}
+		switch m.mode {
+		case MergeMax:
+			ms = max(ms, s.MS)
+		case MergeWeighted:
+			w, set := m.weights[s.Vantage]
+			if !set {
+				w = 1
+			}
+			sum += w * s.MS
+			total += w
+		}
+	}
+	if m.mode == MergeWeighted && total > 0 {
+		ms = sum / total
+	}
+	return ms, len(samples) > 0
+}
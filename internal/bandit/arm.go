@@ -143,6 +143,56 @@ func (a *ArmNode) Stats() ArmStats {
 	}
 }
 
+// ArmState is the complete state of an arm, to save a search and resume it
+// later.
+type ArmState struct {
+	Prefix     netip.Prefix `json:"prefix"`
+	Alpha      float64      `json:"alpha"`
+	Beta       float64      `json:"beta"`
+	Mu         float64      `json:"mu"`
+	Lambda     float64      `json:"lambda"`
+	AlphaNG    float64      `json:"alpha_ng"`
+	BetaNG     float64      `json:"beta_ng"`
+	Samples    int          `json:"samples"`
+	Successes  int          `json:"successes"`
+	Failures   int          `json:"failures"`
+	SumLatency float64      `json:"sum_latency"`
+	SumSqDiff  float64      `json:"sum_sq_diff"`
+	IsSplit    bool         `json:"split,omitempty"`
+}
+
+// State returns a copy of the arm's state.
+func (a *ArmNode) State() ArmState {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return ArmState{
+		Prefix:     a.Prefix,
+		Alpha:      a.Alpha,
+		Beta:       a.Beta,
+		Mu:         a.Mu,
+		Lambda:     a.Lambda,
+		AlphaNG:    a.AlphaNG,
+		BetaNG:     a.BetaNG,
+		Samples:    a.Samples,
+		Successes:  a.Successes,
+		Failures:   a.Failures,
+		SumLatency: a.SumLatency,
+		SumSqDiff:  a.SumSqDiff,
+		IsSplit:    a.IsSplit,
+	}
+}
+
+// restore overwrites the arm's statistics with s.
+func (a *ArmNode) restore(s ArmState) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Alpha, a.Beta = s.Alpha, s.Beta
+	a.Mu, a.Lambda, a.AlphaNG, a.BetaNG = s.Mu, s.Lambda, s.AlphaNG, s.BetaNG
+	a.Samples, a.Successes, a.Failures = s.Samples, s.Successes, s.Failures
+	a.SumLatency, a.SumSqDiff = s.SumLatency, s.SumSqDiff
+	a.IsSplit = s.IsSplit
+}
+
 // GetPosteriorParams returns the posterior distribution parameters for Thompson Sampling.
 func (a *ArmNode) GetPosteriorParams() (alpha, beta, mu, lambda, alphaNG, betaNG float64) {
 	a.mu.RLock()
@@ -2,6 +2,7 @@ package bandit
 
 import (
 	"math"
+	"math/rand"
 	"net/netip"
 	"sync"
 )
@@ -22,11 +23,11 @@ type SearchHead struct {
 	mu sync.RWMutex
 }
 
-// NewSearchHead creates a new search head.
-func NewSearchHead(id int, seed int64, timeoutMS float64, historySize int) *SearchHead {
+// NewSearchHead creates a new search head sampling from src.
+func NewSearchHead(id int, src rand.Source, timeoutMS float64, historySize int) *SearchHead {
 	return &SearchHead{
 		ID:          id,
-		Sampler:     NewThompsonSampler(seed, timeoutMS),
+		Sampler:     NewThompsonSampler(src, timeoutMS),
 		History:     make([]netip.Prefix, 0, historySize),
 		historySize: historySize,
 	}
@@ -80,6 +81,10 @@ type HeadManagerConfig struct {
 	HistorySize     int
 	DiversityWeight float64
 	RepulsionDecay  float64
+
+	// NewSource, if set, creates the random source of each head from its
+	// seed instead of rand.NewSource, e.g. to script the draws in a test.
+	NewSource func(seed int64) rand.Source
 }
 
 // DefaultHeadManagerConfig returns sensible defaults.
@@ -96,11 +101,15 @@ func DefaultHeadManagerConfig() HeadManagerConfig {
 
 // NewHeadManager creates a new head manager with the specified number of heads.
 func NewHeadManager(cfg HeadManagerConfig) *HeadManager {
+	newSource := cfg.NewSource
+	if newSource == nil {
+		newSource = rand.NewSource
+	}
 	heads := make([]*SearchHead, cfg.NumHeads)
 	for i := 0; i < cfg.NumHeads; i++ {
 		// Each head gets a different seed for independent sampling
 		seed := cfg.BaseSeed + int64(i*9973)
-		heads[i] = NewSearchHead(i, seed, cfg.TimeoutMS, cfg.HistorySize)
+		heads[i] = NewSearchHead(i, newSource(seed), cfg.TimeoutMS, cfg.HistorySize)
 	}
 
 	return &HeadManager{
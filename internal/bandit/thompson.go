@@ -5,6 +5,8 @@ import (
 	"math/rand"
 	"net/netip"
 	"sync"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/cidr"
 )
 
 // ThompsonSampler implements Thompson Sampling for arm selection.
@@ -20,10 +22,10 @@ type ThompsonSampler struct {
 	timeoutMS float64
 }
 
-// NewThompsonSampler creates a new Thompson Sampler.
-func NewThompsonSampler(seed int64, timeoutMS float64) *ThompsonSampler {
+// NewThompsonSampler creates a new Thompson Sampler drawing from src.
+func NewThompsonSampler(src rand.Source, timeoutMS float64) *ThompsonSampler {
 	return &ThompsonSampler{
-		rng:            rand.New(rand.NewSource(seed)),
+		rng:            rand.New(src),
 		failurePenalty: 2.0, // Failed probes count as 2x timeout
 		timeoutMS:      timeoutMS,
 	}
@@ -213,7 +215,7 @@ func (s *ThompsonSampler) SampleIP(prefix netip.Prefix) netip.Addr {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	return sampleAddrFromPrefix(prefix, s.rng)
+	return cidr.RandomAddr(prefix, s.rng)
 }
 
 // SampleUniform returns a uniform random number in [0, 1).
@@ -222,62 +224,3 @@ func (s *ThompsonSampler) SampleUniform() float64 {
 	defer s.mu.Unlock()
 	return s.rng.Float64()
 }
-
-// sampleAddrFromPrefix generates a random address within a prefix.
-func sampleAddrFromPrefix(p netip.Prefix, rng *rand.Rand) netip.Addr {
-	p = p.Masked()
-
-	if p.Addr().Is4() {
-		return sampleAddr4(p, rng)
-	}
-	return sampleAddr6(p, rng)
-}
-
-func sampleAddr4(p netip.Prefix, rng *rand.Rand) netip.Addr {
-	a := p.Addr().As4()
-	hostBits := 32 - p.Bits()
-
-	if hostBits == 0 {
-		return p.Addr()
-	}
-
-	base := uint32(a[0])<<24 | uint32(a[1])<<16 | uint32(a[2])<<8 | uint32(a[3])
-	mask := uint32((1 << hostBits) - 1)
-	host := uint32(rng.Uint64()) & mask
-
-	ip := base | host
-	return netip.AddrFrom4([4]byte{
-		byte(ip >> 24),
-		byte(ip >> 16),
-		byte(ip >> 8),
-		byte(ip),
-	})
-}
-
-func sampleAddr6(p netip.Prefix, rng *rand.Rand) netip.Addr {
-	a := p.Addr().As16()
-	hostBits := 128 - p.Bits()
-
-	if hostBits == 0 {
-		return p.Addr()
-	}
-
-	// Generate random host portion
-	var result [16]byte
-	copy(result[:], a[:])
-
-	// Fill random bits from the end
-	bitsRemaining := hostBits
-	for i := 15; i >= 0 && bitsRemaining > 0; i-- {
-		if bitsRemaining >= 8 {
-			result[i] = byte(rng.Uint64())
-			bitsRemaining -= 8
-		} else {
-			mask := byte((1 << bitsRemaining) - 1)
-			result[i] = (result[i] & ^mask) | (byte(rng.Uint64()) & mask)
-			bitsRemaining = 0
-		}
-	}
-
-	return netip.AddrFrom16(result)
-}
@@ -1,7 +1,9 @@
 package bandit
 
 import (
+	"fmt"
 	"net/netip"
+	"slices"
 	"sort"
 	"sync"
 
@@ -136,7 +138,7 @@ func (t *ArmTree) findParentLocked(node *ArmNode, target netip.Prefix) *ArmNode
 	return node
 }
 
-// AllNodes returns all nodes in the tree.
+// AllNodes returns all nodes in the tree, ordered by prefix.
 func (t *ArmTree) AllNodes() []*ArmNode {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
@@ -145,10 +147,12 @@ func (t *ArmTree) AllNodes() []*ArmNode {
 	for _, node := range t.nodeMap {
 		nodes = append(nodes, node)
 	}
+	sortNodes(nodes)
 	return nodes
 }
 
-// LeafNodes returns all leaf nodes (nodes that haven't been split).
+// LeafNodes returns all leaf nodes (nodes that haven't been split), ordered
+// by prefix.
 func (t *ArmTree) LeafNodes() []*ArmNode {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
@@ -160,9 +164,16 @@ func (t *ArmTree) LeafNodes() []*ArmNode {
 			leaves = append(leaves, node)
 		}
 	}
+	sortNodes(leaves)
 	return leaves
 }
 
+// sortNodes orders nodes by prefix. The heads draw from their random source
+// once per node, so a fixed order is what makes a seeded search repeatable.
+func sortNodes(nodes []*ArmNode) {
+	slices.SortFunc(nodes, func(a, b *ArmNode) int { return cidr.Compare(a.Prefix, b.Prefix) })
+}
+
 // SplitNode splits a node into child prefixes.
 // Returns the created children, or nil if split is not possible.
 func (t *ArmTree) SplitNode(node *ArmNode) []*ArmNode {
@@ -249,7 +260,7 @@ func (t *ArmTree) GetSplitCandidates(limit int) []*ArmNode {
 	}
 
 	// Sort by priority (lowest first = best candidates)
-	sort.Slice(candidates, func(i, j int) bool {
+	sort.SliceStable(candidates, func(i, j int) bool {
 		return candidates[i].priority < candidates[j].priority
 	})
 
@@ -270,6 +281,34 @@ func (t *ArmTree) Update(prefix netip.Prefix, success bool, latencyMS, timeoutMS
 	node.Update(success, latencyMS, timeoutMS)
 }
 
+// Restore recreates the nodes in states below the roots, with their
+// statistics, e.g. from the ArmState of every node of an earlier search.
+// It fails if a prefix lies outside the roots.
+func (t *ArmTree) Restore(states []ArmState) error {
+	states = slices.Clone(states)
+	// Parents before children, so that each node finds its parent.
+	sort.SliceStable(states, func(i, j int) bool { return states[i].Prefix.Bits() < states[j].Prefix.Bits() })
+	for _, s := range states {
+		if !t.covers(s.Prefix) {
+			return fmt.Errorf("prefix %s is not in the searched CIDRs", s.Prefix)
+		}
+		t.GetOrCreateNode(s.Prefix).restore(s)
+	}
+	return nil
+}
+
+// covers reports whether prefix is one of the roots or inside one.
+func (t *ArmTree) covers(prefix netip.Prefix) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, root := range t.roots {
+		if root.Prefix.Bits() <= prefix.Bits() && root.Prefix.Contains(prefix.Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
 // Roots returns the root nodes.
 func (t *ArmTree) Roots() []*ArmNode {
 	t.mu.RLock()
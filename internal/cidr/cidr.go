@@ -1,65 +1,13 @@
 package cidr
 
 import (
-	"bufio"
+	"cmp"
 	"encoding/binary"
 	"fmt"
-	"io"
 	mrand "math/rand"
 	"net/netip"
-	"os"
-	"strings"
 )
 
-func ReadCIDRsFromFile(path string) ([]netip.Prefix, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer func() { _ = f.Close() }()
-	return ReadCIDRs(f)
-}
-
-func ReadCIDRs(r io.Reader) ([]netip.Prefix, error) {
-	var out []netip.Prefix
-	sc := bufio.NewScanner(r)
-	for sc.Scan() {
-		line := strings.TrimSpace(sc.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		// allow inline comments after space-#
-		if idx := strings.Index(line, "#"); idx >= 0 {
-			line = strings.TrimSpace(line[:idx])
-		}
-		p, err := netip.ParsePrefix(line)
-		if err != nil {
-			return nil, fmt.Errorf("parse cidr %q: %w", line, err)
-		}
-		out = append(out, p.Masked())
-	}
-	if err := sc.Err(); err != nil {
-		return nil, err
-	}
-	return out, nil
-}
-
-func ParseCIDRs(strs []string) ([]netip.Prefix, error) {
-	out := make([]netip.Prefix, 0, len(strs))
-	for _, s := range strs {
-		s = strings.TrimSpace(s)
-		if s == "" {
-			continue
-		}
-		p, err := netip.ParsePrefix(s)
-		if err != nil {
-			return nil, fmt.Errorf("parse cidr %q: %w", s, err)
-		}
-		out = append(out, p.Masked())
-	}
-	return out, nil
-}
-
 // SplitPrefix splits a prefix into sub-prefixes by increasing the prefix length by step.
 // For example, IPv4 /16 with step=2 yields 4 sub-prefixes of /18.
 func SplitPrefix(p netip.Prefix, step int) ([]netip.Prefix, error) {
@@ -87,77 +35,50 @@ func SplitPrefix(p netip.Prefix, step int) ([]netip.Prefix, error) {
 	return out, nil
 }
 
-// RandomAddr returns a uniformly random address inside prefix p.
-// It uses math/rand for speed; caller controls seed.
-func RandomAddr(p netip.Prefix, r *mrand.Rand) netip.Addr {
-	p = p.Masked()
-	if p.Addr().Is4() {
-		return randomAddr4(p, r)
-	}
-	return randomAddr6(p, r)
+// Compare orders prefixes by address, then by length, as the sort
+// functions of package slices expect.
+func Compare(a, b netip.Prefix) int {
+	return cmp.Or(a.Addr().Compare(b.Addr()), cmp.Compare(a.Bits(), b.Bits()))
 }
 
-func randomAddr4(p netip.Prefix, r *mrand.Rand) netip.Addr {
-	a := p.Addr().As4()
-	base := binary.BigEndian.Uint32(a[:])
-	hostBits := 32 - p.Bits()
-	var host uint32
-	if hostBits == 0 {
-		host = 0
-	} else {
-		host = uint32(r.Uint64() & ((uint64(1) << hostBits) - 1))
+// RandomAddr returns a uniformly random address inside prefix p.
+// It uses math/rand for speed; caller controls seed. The host bits are drawn
+// straight into the integer form of the address, so a draw costs one or two
+// calls to r and never allocates: sampling runs once per probe.
+func RandomAddr(p netip.Prefix, r *mrand.Rand) netip.Addr {
+	addr := p.Addr()
+	if addr.Is4() {
+		hostBits := 32 - p.Bits()
+		if hostBits <= 0 {
+			return addr
+		}
+		a := addr.As4()
+		mask := uint32(uint64(1)<<hostBits - 1)
+		v := binary.BigEndian.Uint32(a[:])&^mask | uint32(r.Uint64())&mask
+		binary.BigEndian.PutUint32(a[:], v)
+		return netip.AddrFrom4(a)
 	}
-	ip := base | host
-	var out [4]byte
-	binary.BigEndian.PutUint32(out[:], ip)
-	return netip.AddrFrom4(out)
-}
 
-func randomAddr6(p netip.Prefix, r *mrand.Rand) netip.Addr {
-	a := p.Addr().As16()
-	base := a[:]
 	hostBits := 128 - p.Bits()
 	if hostBits <= 0 {
-		return netip.AddrFrom16(a)
-	}
-
-	// Fill a random 128-bit value, then keep only host bits and OR into base.
-	var rand128 [16]byte
-	// Use math/rand to keep sampling reproducible under --seed (important for A/B tuning).
-	u0 := r.Uint64()
-	u1 := r.Uint64()
-	binary.BigEndian.PutUint64(rand128[0:8], u0)
-	binary.BigEndian.PutUint64(rand128[8:16], u1)
-
-	keepHostBits(&rand128, hostBits)
-	applyHostBits(base, rand128)
-	var out [16]byte
-	copy(out[:], base)
-	return netip.AddrFrom16(out)
-}
-
-// keepHostBits zeroes the top (128-hostBits) bits.
-func keepHostBits(b *[16]byte, hostBits int) {
-	topBits := 128 - hostBits
-	if topBits <= 0 {
-		return
-	}
-	fullBytes := topBits / 8
-	remBits := topBits % 8
-	for i := 0; i < fullBytes; i++ {
-		b[i] = 0
-	}
-	if remBits > 0 && fullBytes < 16 {
-		mask := byte(0xFF >> remBits)
-		b[fullBytes] &= mask
-	}
-}
-
-// applyHostBits ORs random host bits into base (which already has network bits fixed).
-func applyHostBits(base []byte, host [16]byte) {
-	for i := 0; i < 16; i++ {
-		base[i] |= host[i]
+		return addr
+	}
+	a := addr.As16()
+	hi := binary.BigEndian.Uint64(a[:8])
+	lo := binary.BigEndian.Uint64(a[8:])
+	// Shifting a uint64 by 64 gives 0, so the masks come out right for
+	// hostBits of 64 and 128 too.
+	if hostBits < 64 {
+		mask := uint64(1)<<hostBits - 1
+		lo = lo&^mask | r.Uint64()&mask
+	} else {
+		mask := uint64(1)<<(hostBits-64) - 1
+		lo = r.Uint64()
+		hi = hi&^mask | r.Uint64()&mask
 	}
+	binary.BigEndian.PutUint64(a[:8], hi)
+	binary.BigEndian.PutUint64(a[8:], lo)
+	return netip.AddrFrom16(a)
 }
 
 // childPrefixAddr computes the i-th child prefix address when splitting.
@@ -0,0 +1,61 @@
+package cidr
+
+import (
+	mrand "math/rand"
+	"net/netip"
+	"testing"
+)
+
+var randomAddrPrefixes = []string{
+	"0.0.0.0/0", "104.16.0.0/13", "1.0.0.0/24", "1.0.0.1/32",
+	"::/0", "2606:4700::/32", "2606:4700::/64", "2606:4700::/100", "2606:4700::1/128",
+}
+
+func TestRandomAddr(t *testing.T) {
+	r := mrand.New(mrand.NewSource(1))
+	for _, s := range randomAddrPrefixes {
+		p := netip.MustParsePrefix(s)
+		seen := make(map[netip.Addr]bool)
+		for range 1000 {
+			a := RandomAddr(p, r)
+			if !p.Contains(a) {
+				t.Fatalf("RandomAddr(%s) = %s, outside the prefix", p, a)
+			}
+			seen[a] = true
+		}
+		// Only a /32 or /128 has a single address; the others have room
+		// for many distinct draws.
+		if hostBits := p.Addr().BitLen() - p.Bits(); hostBits >= 16 && len(seen) < 990 {
+			t.Errorf("RandomAddr(%s): %d distinct addresses in 1000 draws", p, len(seen))
+		}
+	}
+}
+
+func TestRandomAddrAllocs(t *testing.T) {
+	r := mrand.New(mrand.NewSource(1))
+	for _, s := range randomAddrPrefixes {
+		p := netip.MustParsePrefix(s)
+		if n := testing.AllocsPerRun(100, func() { RandomAddr(p, r) }); n != 0 {
+			t.Errorf("RandomAddr(%s) allocates %v times", p, n)
+		}
+	}
+}
+
+func BenchmarkRandomAddr(b *testing.B) {
+	for _, bm := range []struct {
+		name   string
+		prefix string
+	}{
+		{"v4", "104.16.0.0/13"},
+		{"v6", "2606:4700::/32"},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			p := netip.MustParsePrefix(bm.prefix)
+			r := mrand.New(mrand.NewSource(1))
+			b.ReportAllocs()
+			for b.Loop() {
+				RandomAddr(p, r)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package cidr
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/bits"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// ParseError is a malformed entry of a range list. Line and Col are 1-based
+// and point at the offending part of the entry, e.g. the end of a range
+// that lies before its start; Col counts bytes.
+type ParseError struct {
+	File string // set by ReadRangesFile
+	Line int    // 0 for a single entry parsed by ParseRange
+	Col  int
+	Text string // the whole entry
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	pos := fmt.Sprintf("line %d, column %d", e.Line, e.Col)
+	if e.Line == 0 {
+		pos = fmt.Sprintf("column %d", e.Col)
+	}
+	if e.File != "" {
+		pos = e.File + ": " + pos
+	}
+	return fmt.Sprintf("%s: parse range %q: %v", pos, e.Text, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// ParseRange parses one entry of a range list into the prefixes it covers:
+//
+//	1.0.0.0/24               a CIDR; host bits are cleared
+//	::ffff:1.0.0.0/120       an IPv4-mapped CIDR, as the IPv4 CIDR it maps (1.0.0.0/24)
+//	1.0.0.1                  a single IP, as a /32 or /128
+//	1.0.0.1-1.0.0.255        an inclusive range, as the fewest CIDRs that cover it exactly
+//	2606:4700::-2606:4700::ff
+//
+// Both ends of a range must be of the same family. Errors are *ParseError.
+func ParseRange(s string) ([]netip.Prefix, error) {
+	out, off, err := appendRange(nil, s)
+	if err != nil {
+		return nil, &ParseError{Col: off + 1, Text: s, Err: err}
+	}
+	return out, nil
+}
+
+// ParseRanges parses a list of ranges in the syntax of ParseRange, separated
+// by commas, spaces or newlines, with # starting a comment that runs to the
+// end of the line. IPv4 and IPv6 ranges can be mixed. Errors are
+// *ParseError; their Line is 0 when s is a single line, such as a flag
+// value.
+func ParseRanges(s string) ([]netip.Prefix, error) {
+	out, err := ReadRanges(strings.NewReader(s))
+	if perr, ok := err.(*ParseError); ok && !strings.Contains(strings.TrimRight(s, "\r\n"), "\n") {
+		perr.Line = 0
+	}
+	return out, err
+}
+
+// ReadRanges is ParseRanges reading from r.
+func ReadRanges(r io.Reader) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := sc.Text()
+		if idx := strings.IndexByte(text, '#'); idx >= 0 {
+			text = text[:idx]
+		}
+		for start := 0; start < len(text); {
+			start += len(text[start:]) - len(strings.TrimLeft(text[start:], ", \t\r"))
+			end := start + strings.IndexAny(text[start:]+" ", ", \t\r")
+			if start == end {
+				break
+			}
+			entry := text[start:end]
+			var off int
+			var err error
+			if out, off, err = appendRange(out, entry); err != nil {
+				return nil, &ParseError{Line: line, Col: start + off + 1, Text: entry, Err: err}
+			}
+			start = end
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ReadRangesFile is ReadRanges reading the file at path.
+func ReadRangesFile(path string) ([]netip.Prefix, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	out, err := ReadRanges(f)
+	if perr, ok := err.(*ParseError); ok {
+		perr.File = path
+	}
+	return out, err
+}
+
+// appendRange appends the prefixes of entry s to out. On error it returns
+// the offset in s of the part at fault.
+func appendRange(out []netip.Prefix, s string) ([]netip.Prefix, int, error) {
+	if from, to, ok := strings.Cut(s, "-"); ok {
+		first, err := parseAddr(from)
+		if err != nil {
+			return out, 0, err
+		}
+		last, err := parseAddr(to)
+		if err != nil {
+			return out, len(from) + 1, err
+		}
+		if first.Is4() != last.Is4() {
+			return out, len(from) + 1, fmt.Errorf("%s and %s are of different families", first, last)
+		}
+		if last.Less(first) {
+			return out, len(from) + 1, fmt.Errorf("end %s is before start %s", last, first)
+		}
+		return appendSpan(out, first, last), 0, nil
+	}
+	if addr, bitsStr, ok := strings.Cut(s, "/"); ok {
+		if _, err := parseAddr(addr); err != nil {
+			return out, 0, err
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return out, len(addr) + 1, fmt.Errorf("invalid prefix length %q", bitsStr)
+		}
+		if p.Addr().Is4In6() {
+			// The prefix of the mapped IPv4 range is the last 32 bits.
+			if p.Bits() < 96 {
+				return out, len(addr) + 1, fmt.Errorf("prefix length %d of IPv4-mapped address %s is below 96", p.Bits(), p.Addr())
+			}
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		return append(out, p.Masked()), 0, nil
+	}
+	ip, err := parseAddr(s)
+	if err != nil {
+		return out, 0, err
+	}
+	return append(out, netip.PrefixFrom(ip, ip.BitLen())), 0, nil
+}
+
+// parseAddr parses an IPv4 or IPv6 address without a zone. IPv4-mapped
+// IPv6 addresses are taken as the IPv4 address they map.
+func parseAddr(s string) (netip.Addr, error) {
+	ip, err := netip.ParseAddr(s)
+	if err != nil || ip.Zone() != "" {
+		return netip.Addr{}, fmt.Errorf("invalid IP address %q", s)
+	}
+	return ip.Unmap(), nil
+}
+
+// appendSpan appends the fewest prefixes that cover first..last exactly:
+// from first on, each is the largest block aligned at its start that does
+// not reach past last.
+func appendSpan(out []netip.Prefix, first, last netip.Addr) []netip.Prefix {
+	width := first.BitLen()
+	cur, end := toUint128(first), toUint128(last)
+	for {
+		host := min(cur.trailingZeros(), width)
+		for host > 0 && end.less(cur.or(lowMask(host))) {
+			host--
+		}
+		out = append(out, netip.PrefixFrom(cur.addr(width), width-host))
+		top := cur.or(lowMask(host))
+		if top == end {
+			return out
+		}
+		cur = top.inc()
+	}
+}
+
+// uint128 is an address as a number; IPv4 addresses use the low 32 bits.
+type uint128 struct{ hi, lo uint64 }
+
+func toUint128(ip netip.Addr) uint128 {
+	if ip.Is4() {
+		b := ip.As4()
+		return uint128{lo: uint64(b[0])<<24 | uint64(b[1])<<16 | uint64(b[2])<<8 | uint64(b[3])}
+	}
+	b := ip.As16()
+	var u uint128
+	for i := range 8 {
+		u.hi = u.hi<<8 | uint64(b[i])
+		u.lo = u.lo<<8 | uint64(b[8+i])
+	}
+	return u
+}
+
+func (u uint128) addr(width int) netip.Addr {
+	if width == 32 {
+		return netip.AddrFrom4([4]byte{byte(u.lo >> 24), byte(u.lo >> 16), byte(u.lo >> 8), byte(u.lo)})
+	}
+	var b [16]byte
+	for i := range 8 {
+		b[7-i] = byte(u.hi >> (8 * i))
+		b[15-i] = byte(u.lo >> (8 * i))
+	}
+	return netip.AddrFrom16(b)
+}
+
+// lowMask has the low n bits set.
+func lowMask(n int) uint128 {
+	switch {
+	case n >= 128:
+		return uint128{^uint64(0), ^uint64(0)}
+	case n >= 64:
+		return uint128{1<<(n-64) - 1, ^uint64(0)}
+	default:
+		return uint128{0, 1<<n - 1}
+	}
+}
+
+func (u uint128) trailingZeros() int {
+	if u.lo != 0 {
+		return bits.TrailingZeros64(u.lo)
+	}
+	return 64 + bits.TrailingZeros64(u.hi)
+}
+
+func (u uint128) or(v uint128) uint128 { return uint128{u.hi | v.hi, u.lo | v.lo} }
+
+func (u uint128) less(v uint128) bool { return u.hi < v.hi || u.hi == v.hi && u.lo < v.lo }
+
+func (u uint128) inc() uint128 {
+	lo, carry := bits.Add64(u.lo, 1, 0)
+	return uint128{u.hi + carry, lo}
+}
@@ -0,0 +1,193 @@
+package cidr
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"1.0.0.0/24", []string{"1.0.0.0/24"}},
+		{"1.0.0.7/24", []string{"1.0.0.0/24"}},
+		{"1.0.0.1", []string{"1.0.0.1/32"}},
+		{"::ffff:1.0.0.1", []string{"1.0.0.1/32"}},
+		{"::ffff:1.0.0.7/120", []string{"1.0.0.0/24"}},
+		{"::ffff:0:0/96", []string{"0.0.0.0/0"}},
+		{"::ffff:1.0.0.1/128", []string{"1.0.0.1/32"}},
+		{"1.0.0.1-1.0.0.1", []string{"1.0.0.1/32"}},
+		{"1.0.0.1-1.0.0.6", []string{"1.0.0.1/32", "1.0.0.2/31", "1.0.0.4/31", "1.0.0.6/32"}},
+		{"0.0.0.0-255.255.255.255", []string{"0.0.0.0/0"}},
+		{"2606:4700::-2606:4700::ff", []string{"2606:4700::/120"}},
+		{"::-ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", []string{"::/0"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseRange(tt.in)
+		if err != nil {
+			t.Errorf("ParseRange(%q): %v", tt.in, err)
+			continue
+		}
+		var gs []string
+		for _, p := range got {
+			gs = append(gs, p.String())
+		}
+		if !slices.Equal(gs, tt.want) {
+			t.Errorf("ParseRange(%q) = %v, want %v", tt.in, gs, tt.want)
+		}
+	}
+}
+
+func TestParseRangeErrors(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"1.0.0", `column 1: parse range "1.0.0": invalid IP address "1.0.0"`},
+		{"1.0.0.0/33", `column 9: parse range "1.0.0.0/33": invalid prefix length "33"`},
+		{"1.0.0.9-1.0.0.1", `column 9: parse range "1.0.0.9-1.0.0.1": end 1.0.0.1 is before start 1.0.0.9`},
+		{"1.0.0.1-::1", `column 9: parse range "1.0.0.1-::1": 1.0.0.1 and ::1 are of different families`},
+		{"fe80::1%eth0", `column 1: parse range "fe80::1%eth0": invalid IP address "fe80::1%eth0"`},
+		{"::ffff:0:0/95", `column 12: parse range "::ffff:0:0/95": prefix length 95 of IPv4-mapped address ::ffff:0.0.0.0 is below 96`},
+	}
+	for _, tt := range tests {
+		_, err := ParseRange(tt.in)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("ParseRange(%q) error = %v, want %s", tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestParseRanges(t *testing.T) {
+	got, err := ParseRanges("1.0.0.0/24, 1.0.0.1 # a comment, 2.0.0.0/8\n\t2606:4700::/32,,\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []netip.Prefix{
+		netip.MustParsePrefix("1.0.0.0/24"),
+		netip.MustParsePrefix("1.0.0.1/32"),
+		netip.MustParsePrefix("2606:4700::/32"),
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("ParseRanges = %v, want %v", got, want)
+	}
+}
+
+func TestParseRangesErrorPosition(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		// A single value, e.g. of --cidr, has no line.
+		{"1.0.0.0/24,1.0.0.0/33", `column 20: parse range "1.0.0.0/33": invalid prefix length "33"`},
+		{"1.0.0.0/33\n", `column 9: parse range "1.0.0.0/33": invalid prefix length "33"`},
+		{"1.0.0.0/24\n 1.0.0.0/33", `line 2, column 10: parse range "1.0.0.0/33": invalid prefix length "33"`},
+	}
+	for _, tt := range tests {
+		_, err := ParseRanges(tt.in)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("ParseRanges(%q) error = %v, want %s", tt.in, err, tt.want)
+		}
+	}
+}
+
+// FuzzParseRange checks that a parsed entry covers one contiguous span of
+// addresses with masked prefixes of a single family, and that an error
+// points into the entry.
+func FuzzParseRange(f *testing.F) {
+	for _, s := range []string{
+		"1.0.0.0/24", "1.0.0.1", "1.0.0.1-1.0.0.255", "2606:4700::-2606:4700::ff",
+		"::ffff:1.2.3.4-1.2.3.9", "::ffff:1.0.0.0/120", "::ffff:0:0/95", "1.0.0.0/33", "1.0.0.9-1.0.0.1", "-", "/", "fe80::1%eth0",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		ps, err := ParseRange(s)
+		if err != nil {
+			var perr *ParseError
+			if !errors.As(err, &perr) {
+				t.Fatalf("error %v is not a *ParseError", err)
+			}
+			if perr.Line != 0 || perr.Col < 1 || perr.Col > len(s)+1 || perr.Text != s {
+				t.Fatalf("error %+v does not point into %q", perr, s)
+			}
+			return
+		}
+		if len(ps) == 0 {
+			t.Fatalf("ParseRange(%q) returned no prefix", s)
+		}
+		for i, p := range ps {
+			if !p.IsValid() || p != p.Masked() || p.Addr().Is4() != ps[0].Addr().Is4() || p.Addr().Is4In6() {
+				t.Fatalf("ParseRange(%q): bad prefix %s", s, p)
+			}
+			if i > 0 {
+				next := lastAddr(ps[i-1]).Next()
+				if next != p.Addr() {
+					t.Fatalf("ParseRange(%q): %s does not follow %s", s, p, ps[i-1])
+				}
+			}
+		}
+	})
+}
+
+// FuzzReadRanges checks that a list parses to the concatenation of its
+// entries, and that an error names the line and entry at fault.
+func FuzzReadRanges(f *testing.F) {
+	for _, s := range []string{
+		"1.0.0.0/24, 1.0.0.1\n2606:4700::/32",
+		"# comment\n1.0.0.1-1.0.0.9 # tail\r\n",
+		"1.0.0.0/24\n\n 1.0.0.0/33",
+		",,\t,",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		got, err := ReadRanges(strings.NewReader(s))
+		lines := strings.Split(s, "\n")
+		if err != nil {
+			var perr *ParseError
+			if !errors.As(err, &perr) {
+				return // e.g. a line longer than the scanner's buffer
+			}
+			if perr.Line < 1 || perr.Line > len(lines) {
+				t.Fatalf("error %v: line out of range", err)
+			}
+			line := lines[perr.Line-1]
+			if perr.Col < 1 || perr.Col > len(line)+1 || !strings.Contains(line, perr.Text) {
+				t.Fatalf("error %+v does not point into line %q", perr, line)
+			}
+			if _, err := ParseRange(perr.Text); err == nil {
+				t.Fatalf("error %v for an entry that ParseRange accepts", err)
+			}
+			return
+		}
+		var want []netip.Prefix
+		for _, line := range lines {
+			line, _, _ = strings.Cut(line, "#")
+			for _, entry := range strings.FieldsFunc(line, func(r rune) bool { return strings.ContainsRune(", \t\r", r) }) {
+				ps, err := ParseRange(entry)
+				if err != nil {
+					t.Fatalf("ReadRanges(%q) accepted %q: %v", s, entry, err)
+				}
+				want = append(want, ps...)
+			}
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("ReadRanges(%q) = %v, want %v", s, got, want)
+		}
+	})
+}
+
+// lastAddr returns the last address of p.
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	a, _ := netip.AddrFromSlice(b)
+	return a
+}
@@ -0,0 +1,119 @@
+// Package color highlights the terminal output of mcis with ANSI colors.
+// Colors are used only for a standard output or error that is a terminal,
+// never when NO_COLOR is set (https://no-color.org) or TERM is "dumb", and
+// never for files; Setup overrides the detection for --color.
+package color
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// Color is an ANSI SGR code.
+type Color string
+
+// Colors of the output.
+const (
+	Red    Color = "31"
+	Green  Color = "32"
+	Yellow Color = "33"
+	Cyan   Color = "36"
+	Bold   Color = "1"
+)
+
+// Modes of Setup, the values of --color.
+const (
+	Auto   = "auto"
+	Always = "always"
+	Never  = "never"
+)
+
+// Modes lists the values of --color.
+var Modes = []string{Auto, Always, Never}
+
+var stdout, stderr atomic.Bool
+
+func init() {
+	stdout.Store(detect(os.Stdout))
+	stderr.Store(detect(os.Stderr))
+}
+
+// detect reports whether f is a terminal that colors should be used for.
+func detect(f *os.File) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	fi, err := f.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	return enableVT(f)
+}
+
+// Setup sets whether stdout and stderr are colored: detected for Auto, or
+// always or never.
+func Setup(mode string) error {
+	switch mode {
+	case Auto, "":
+		stdout.Store(detect(os.Stdout))
+		stderr.Store(detect(os.Stderr))
+	case Always:
+		enableVT(os.Stdout)
+		enableVT(os.Stderr)
+		stdout.Store(true)
+		stderr.Store(true)
+	case Never:
+		stdout.Store(false)
+		stderr.Store(false)
+	default:
+		return fmt.Errorf("unknown color mode %q, want one of %s", mode, strings.Join(Modes, ", "))
+	}
+	return nil
+}
+
+// Enabled reports whether output to w is colored.
+func Enabled(w io.Writer) bool {
+	switch w {
+	case os.Stdout:
+		return stdout.Load()
+	case os.Stderr:
+		return stderr.Load()
+	}
+	return false
+}
+
+// Wrap returns s in color c if output to w is colored, or else s.
+func Wrap(w io.Writer, c Color, s string) string {
+	if !Enabled(w) || s == "" {
+		return s
+	}
+	return "\x1b[" + string(c) + "m" + s + "\x1b[0m"
+}
+
+// Message highlights the phase prefix of a status line, e.g. "dns:", up to
+// its first colon: red for errors, yellow for warnings and cyan otherwise.
+// msg is the untranslated line, which picks the color, and s the line as
+// printed.
+func Message(w io.Writer, msg, s string) string {
+	if !Enabled(w) {
+		return s
+	}
+	end := strings.IndexAny(s, ":：")
+	if end <= 0 || strings.ContainsAny(s[:end], " \t\n") {
+		return s
+	}
+	_, size := utf8.DecodeRuneInString(s[end:])
+	end += size
+	c := Cyan
+	switch {
+	case strings.HasPrefix(msg, "error:"):
+		c = Red
+	case strings.Contains(msg, "warning:"):
+		c = Yellow
+	}
+	return Wrap(w, c, s[:end]) + s[end:]
+}
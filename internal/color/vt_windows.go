@@ -0,0 +1,28 @@
+package color
+
+import (
+	"os"
+	"syscall"
+)
+
+// enableVirtualTerminalProcessing is the console mode that makes the Windows
+// console interpret ANSI sequences.
+const enableVirtualTerminalProcessing = 0x4
+
+var procSetConsoleMode = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+
+// enableVT turns on the ANSI sequences of the console f, and reports
+// whether it interprets them: Windows 10 consoles and Windows Terminal do,
+// older consoles do not.
+func enableVT(f *os.File) bool {
+	h := syscall.Handle(f.Fd())
+	var mode uint32
+	if err := syscall.GetConsoleMode(h, &mode); err != nil {
+		return false
+	}
+	if mode&enableVirtualTerminalProcessing != 0 {
+		return true
+	}
+	r, _, _ := procSetConsoleMode.Call(uintptr(h), uintptr(mode|enableVirtualTerminalProcessing))
+	return r != 0
+}
@@ -8,24 +8,41 @@ import (
 	"io"
 	"net/http"
 	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/transport"
 )
 
 const cloudflareAPIBase = "https://api.cloudflare.com/client/v4"
 
+// cfPageSize is the number of records asked for per page of a list, well
+// within the API's limit; the default page holds only 100.
+const cfPageSize = 5000
+
 // CloudflareProvider implements Provider for Cloudflare DNS.
 type CloudflareProvider struct {
-	token    string
-	zoneID   string
-	zoneName string // cached zone name (e.g., "example.com")
-	client   *http.Client
+	token   string
+	zoneID  string
+	client  *http.Client
+	apiBase string // cloudflareAPIBase, or Config.APIBase
+
+	// zoneMu guards zoneName, the cached zone name (e.g., "example.com"),
+	// and is held while it is fetched, so that concurrent calls fetch it
+	// once; a failed fetch is retried by the next call.
+	zoneMu   sync.Mutex
+	zoneName string
 }
 
-// NewCloudflareProvider creates a new Cloudflare DNS provider.
-func NewCloudflareProvider(token, zoneID string) *CloudflareProvider {
+// NewCloudflareProvider creates a new Cloudflare DNS provider. A nil client
+// uses the shared API transport.
+func NewCloudflareProvider(token, zoneID string, client *http.Client) *CloudflareProvider {
 	return &CloudflareProvider{
-		token:  token,
-		zoneID: zoneID,
-		client: &http.Client{},
+		token:   token,
+		zoneID:  zoneID,
+		client:  transport.ClientOr(client),
+		apiBase: cloudflareAPIBase,
 	}
 }
 
@@ -48,6 +65,11 @@ type cfListResponse struct {
 	Success bool          `json:"success"`
 	Errors  []cfError     `json:"errors"`
 	Result  []cfDNSRecord `json:"result"`
+	// ResultInfo tells the page of Result among TotalPages.
+	ResultInfo struct {
+		Page       int `json:"page"`
+		TotalPages int `json:"total_pages"`
+	} `json:"result_info"`
 }
 
 // cfCreateResponse represents the Cloudflare API create response.
@@ -79,11 +101,13 @@ type cfZoneResponse struct {
 
 // getZoneName fetches and caches the zone name (domain).
 func (p *CloudflareProvider) getZoneName(ctx context.Context) (string, error) {
+	p.zoneMu.Lock()
+	defer p.zoneMu.Unlock()
 	if p.zoneName != "" {
 		return p.zoneName, nil
 	}
 
-	url := fmt.Sprintf("%s/zones/%s", cloudflareAPIBase, p.zoneID)
+	url := fmt.Sprintf("%s/zones/%s", p.apiBase, p.zoneID)
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
@@ -105,14 +129,11 @@ func (p *CloudflareProvider) getZoneName(ctx context.Context) (string, error) {
 
 	var result cfZoneResponse
 	if err := json.Unmarshal(body, &result); err != nil {
-		return "", fmt.Errorf("parse response: %w", err)
+		return "", parseError("cloudflare", resp.StatusCode, err)
 	}
 
 	if !result.Success {
-		if len(result.Errors) > 0 {
-			return "", fmt.Errorf("cloudflare API error: %s", result.Errors[0].Message)
-		}
-		return "", fmt.Errorf("cloudflare API error: unknown")
+		return "", cfAPIError(resp.StatusCode, result.Errors)
 	}
 
 	p.zoneName = result.Result.Name
@@ -131,6 +152,11 @@ func (p *CloudflareProvider) buildFQDN(ctx context.Context, subdomain string) (s
 	return subdomain + "." + zoneName, nil
 }
 
+// FQDN returns the full domain name of subdomain in the zone.
+func (p *CloudflareProvider) FQDN(ctx context.Context, subdomain string) (string, error) {
+	return p.buildFQDN(ctx, subdomain)
+}
+
 // DeleteRecords deletes all A or AAAA records for the subdomain.
 func (p *CloudflareProvider) DeleteRecords(ctx context.Context, subdomain string, ipv6 bool) error {
 	recordType := "A"
@@ -150,13 +176,13 @@ func (p *CloudflareProvider) DeleteRecords(ctx context.Context, subdomain string
 		return err
 	}
 
-	// Delete each record
-	for _, rec := range records {
+	// Delete the records concurrently
+	return forEach(ctx, records, func(ctx context.Context, rec cfDNSRecord) error {
 		if err := p.deleteRecord(ctx, rec.ID); err != nil {
 			return fmt.Errorf("delete record %s: %w", rec.ID, err)
 		}
-	}
-	return nil
+		return nil
+	})
 }
 
 // CreateRecords creates A/AAAA records for the given IPs.
@@ -167,7 +193,7 @@ func (p *CloudflareProvider) CreateRecords(ctx context.Context, subdomain string
 		return err
 	}
 
-	for _, ip := range ips {
+	return forEach(ctx, ips, func(ctx context.Context, ip netip.Addr) error {
 		recordType := "A"
 		if ip.Is6() {
 			recordType = "AAAA"
@@ -175,14 +201,95 @@ func (p *CloudflareProvider) CreateRecords(ctx context.Context, subdomain string
 		if err := p.createRecord(ctx, fqdn, recordType, ip.String()); err != nil {
 			return fmt.Errorf("create record for %s: %w", ip.String(), err)
 		}
+		return nil
+	})
+}
+
+// ListRecords returns the A or AAAA records of the subdomain.
+func (p *CloudflareProvider) ListRecords(ctx context.Context, subdomain string, ipv6 bool) ([]Record, error) {
+	recordType := "A"
+	if ipv6 {
+		recordType = "AAAA"
+	}
+	fqdn, err := p.buildFQDN(ctx, subdomain)
+	if err != nil {
+		return nil, err
+	}
+	records, err := p.listRecords(ctx, fqdn, recordType)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Record, 0, len(records))
+	for _, rec := range records {
+		ip, err := netip.ParseAddr(rec.Content)
+		if err != nil {
+			return nil, fmt.Errorf("record %s: %w", rec.ID, err)
+		}
+		out = append(out, Record{ID: rec.ID, Type: rec.Type, Name: rec.Name, IP: ip, TTL: rec.TTL})
+	}
+	return out, nil
+}
+
+// UpdateRecord changes the IP of rec in place.
+func (p *CloudflareProvider) UpdateRecord(ctx context.Context, rec Record, ip netip.Addr) error {
+	url := fmt.Sprintf("%s/zones/%s/dns_records/%s", p.apiBase, p.zoneID, rec.ID)
+
+	data, err := json.Marshal(map[string]interface{}{"content": ip.String()})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var result cfCreateResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return parseError("cloudflare", resp.StatusCode, err)
+	}
+
+	if !result.Success {
+		return cfAPIError(resp.StatusCode, result.Errors)
 	}
+
 	return nil
 }
 
+// listRecords returns every record of type recordType named name, reading
+// all pages of the list.
 func (p *CloudflareProvider) listRecords(ctx context.Context, name, recordType string) ([]cfDNSRecord, error) {
-	url := fmt.Sprintf("%s/zones/%s/dns_records?type=%s&name=%s", cloudflareAPIBase, p.zoneID, recordType, name)
+	var records []cfDNSRecord
+	for page := 1; ; page++ {
+		result, err := p.listPage(ctx, name, recordType, page)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, result.Result...)
+		if page >= result.ResultInfo.TotalPages || len(result.Result) == 0 {
+			return records, nil
+		}
+	}
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+func (p *CloudflareProvider) listPage(ctx context.Context, name, recordType string, page int) (*cfListResponse, error) {
+	q := url.Values{"type": {recordType}, "name": {name}, "page": {strconv.Itoa(page)}, "per_page": {strconv.Itoa(cfPageSize)}}
+	reqURL := fmt.Sprintf("%s/zones/%s/dns_records?%s", p.apiBase, p.zoneID, q.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -202,21 +309,18 @@ func (p *CloudflareProvider) listRecords(ctx context.Context, name, recordType s
 
 	var result cfListResponse
 	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, fmt.Errorf("parse response: %w", err)
+		return nil, parseError("cloudflare", resp.StatusCode, err)
 	}
 
 	if !result.Success {
-		if len(result.Errors) > 0 {
-			return nil, fmt.Errorf("cloudflare API error: %s", result.Errors[0].Message)
-		}
-		return nil, fmt.Errorf("cloudflare API error: unknown")
+		return nil, cfAPIError(resp.StatusCode, result.Errors)
 	}
 
-	return result.Result, nil
+	return &result, nil
 }
 
 func (p *CloudflareProvider) deleteRecord(ctx context.Context, recordID string) error {
-	url := fmt.Sprintf("%s/zones/%s/dns_records/%s", cloudflareAPIBase, p.zoneID, recordID)
+	url := fmt.Sprintf("%s/zones/%s/dns_records/%s", p.apiBase, p.zoneID, recordID)
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, url, nil)
 	if err != nil {
@@ -238,21 +342,18 @@ func (p *CloudflareProvider) deleteRecord(ctx context.Context, recordID string)
 
 	var result cfDeleteResponse
 	if err := json.Unmarshal(body, &result); err != nil {
-		return fmt.Errorf("parse response: %w", err)
+		return parseError("cloudflare", resp.StatusCode, err)
 	}
 
 	if !result.Success {
-		if len(result.Errors) > 0 {
-			return fmt.Errorf("cloudflare API error: %s", result.Errors[0].Message)
-		}
-		return fmt.Errorf("cloudflare API error: unknown")
+		return cfAPIError(resp.StatusCode, result.Errors)
 	}
 
 	return nil
 }
 
 func (p *CloudflareProvider) createRecord(ctx context.Context, name, recordType, content string) error {
-	url := fmt.Sprintf("%s/zones/%s/dns_records", cloudflareAPIBase, p.zoneID)
+	url := fmt.Sprintf("%s/zones/%s/dns_records", p.apiBase, p.zoneID)
 
 	payload := map[string]interface{}{
 		"type":    recordType,
@@ -287,14 +388,11 @@ func (p *CloudflareProvider) createRecord(ctx context.Context, name, recordType,
 
 	var result cfCreateResponse
 	if err := json.Unmarshal(body, &result); err != nil {
-		return fmt.Errorf("parse response: %w", err)
+		return parseError("cloudflare", resp.StatusCode, err)
 	}
 
 	if !result.Success {
-		if len(result.Errors) > 0 {
-			return fmt.Errorf("cloudflare API error: %s", result.Errors[0].Message)
-		}
-		return fmt.Errorf("cloudflare API error: unknown")
+		return cfAPIError(resp.StatusCode, result.Errors)
 	}
 
 	return nil
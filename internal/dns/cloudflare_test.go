@@ -0,0 +1,211 @@
+package dns_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/dns"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/dns/dnstest"
+)
+
+func TestCloudflareFixtures(t *testing.T) {
+	if err := dnstest.VerifyProvider(os.DirFS("dnstest/testdata"), "cloudflare"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCloudflareZoneNameConcurrent lists records from several goroutines at
+// once; the zone name they share is fetched once.
+func TestCloudflareZoneNameConcurrent(t *testing.T) {
+	var zoneFetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/zones/zone-id" {
+			zoneFetches.Add(1)
+			_, _ = w.Write([]byte(`{"success": true, "result": {"name": "example.com"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success": true, "result": [], "result_info": {"page": 1, "total_pages": 1}}`))
+	}))
+	defer srv.Close()
+	p, err := dns.NewProvider(dns.Config{Provider: "cloudflare", Token: dnstest.Token, Zone: "zone-id", APIBase: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			if _, err := p.ListRecords(context.Background(), "cf", false); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+	if n := zoneFetches.Load(); n != 1 {
+		t.Errorf("fetched the zone %d times, want 1", n)
+	}
+}
+
+const (
+	cfZone   = "GET /zones/zone-id"
+	cfZoneOK = `{"success": true, "result": {"name": "example.com"}}`
+)
+
+// cfList is the request for a page of the A records of cf.example.com.
+func cfList(page int) string {
+	return fmt.Sprintf("GET /zones/zone-id/dns_records?name=cf.example.com&page=%d&per_page=5000&type=A", page)
+}
+
+// cfPage is a page of A records of cf.example.com.
+func cfPage(page, pages int, ips ...string) reply {
+	var recs []string
+	for _, ip := range ips {
+		recs = append(recs, fmt.Sprintf(`{"id": "id-%s", "type": "A", "name": "cf.example.com", "content": %q, "ttl": 1}`, ip, ip))
+	}
+	return reply{body: fmt.Sprintf(`{"success": true, "result": [%s], "result_info": {"page": %d, "total_pages": %d}}`, strings.Join(recs, ", "), page, pages)}
+}
+
+func TestCloudflareListRecords(t *testing.T) {
+	tests := []struct {
+		name    string
+		routes  map[string]reply
+		want    []string
+		wantErr *dns.APIError
+	}{
+		{
+			name:   "one page",
+			routes: map[string]reply{cfZone: {body: cfZoneOK}, cfList(1): cfPage(1, 1, "1.0.0.1", "1.0.0.2")},
+			want:   []string{"1.0.0.1", "1.0.0.2"},
+		},
+		{
+			name:   "empty",
+			routes: map[string]reply{cfZone: {body: cfZoneOK}, cfList(1): cfPage(1, 0)},
+		},
+		{
+			name: "pages",
+			routes: map[string]reply{
+				cfZone:    {body: cfZoneOK},
+				cfList(1): cfPage(1, 3, "1.0.0.1", "1.0.0.2"),
+				cfList(2): cfPage(2, 3, "1.0.0.3"),
+				cfList(3): cfPage(3, 3, "1.0.0.4"),
+			},
+			want: []string{"1.0.0.1", "1.0.0.2", "1.0.0.3", "1.0.0.4"},
+		},
+		{
+			name: "empty page ends the list",
+			routes: map[string]reply{
+				cfZone:    {body: cfZoneOK},
+				cfList(1): cfPage(1, 9, "1.0.0.1"),
+				cfList(2): cfPage(2, 9),
+			},
+			want: []string{"1.0.0.1"},
+		},
+		{
+			name: "error on a later page",
+			routes: map[string]reply{
+				cfZone:    {body: cfZoneOK},
+				cfList(1): cfPage(1, 2, "1.0.0.1"),
+				cfList(2): {status: http.StatusTooManyRequests, body: `{"success": false, "errors": [{"code": 10000, "message": "rate limited"}]}`},
+			},
+			wantErr: &dns.APIError{Provider: "cloudflare", Status: http.StatusTooManyRequests, Code: "10000", Message: "rate limited"},
+		},
+		{
+			name:    "bad token",
+			routes:  map[string]reply{cfZone: {status: http.StatusForbidden, body: `{"success": false, "errors": [{"code": 9109, "message": "Invalid access token"}]}`}},
+			wantErr: &dns.APIError{Provider: "cloudflare", Status: http.StatusForbidden, Code: "9109", Message: "Invalid access token"},
+		},
+		{
+			name:    "gateway error",
+			routes:  map[string]reply{cfZone: {body: cfZoneOK}, cfList(1): {status: http.StatusBadGateway, body: "<html>502 Bad Gateway</html>"}},
+			wantErr: &dns.APIError{Provider: "cloudflare", Status: http.StatusBadGateway},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, _ := apiServer(t, tt.routes)
+			p, err := dns.NewProvider(dns.Config{Provider: "cloudflare", Token: dnstest.Token, Zone: "zone-id", APIBase: base})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := p.ListRecords(context.Background(), "cf", false)
+			checkAPIError(t, err, tt.wantErr)
+			if ips := recordIPs(got); !slices.Equal(ips, tt.want) {
+				t.Errorf("ListRecords = %v, want %v", ips, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloudflareListRecordsBadResponse(t *testing.T) {
+	base, _ := apiServer(t, map[string]reply{cfZone: {body: cfZoneOK}, cfList(1): {body: `{"success": true, "result": [`}})
+	p, err := dns.NewProvider(dns.Config{Provider: "cloudflare", Token: dnstest.Token, Zone: "zone-id", APIBase: base})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.ListRecords(context.Background(), "cf", false); err == nil || !strings.Contains(err.Error(), "parse response") {
+		t.Errorf("ListRecords error = %v, want a parse error", err)
+	}
+}
+
+func TestCloudflareDeleteRecords(t *testing.T) {
+	tests := []struct {
+		name    string
+		routes  map[string]reply
+		want    []string // the requests sent, sorted
+		wantErr *dns.APIError
+	}{
+		{
+			name: "every page",
+			routes: map[string]reply{
+				cfZone:    {body: cfZoneOK},
+				cfList(1): cfPage(1, 2, "1.0.0.1"),
+				cfList(2): cfPage(2, 2, "1.0.0.2"),
+				"DELETE /zones/zone-id/dns_records/id-1.0.0.1": {body: `{"success": true}`},
+				"DELETE /zones/zone-id/dns_records/id-1.0.0.2": {body: `{"success": true}`},
+			},
+			want: []string{
+				"DELETE /zones/zone-id/dns_records/id-1.0.0.1",
+				"DELETE /zones/zone-id/dns_records/id-1.0.0.2",
+				cfZone,
+				cfList(1),
+				cfList(2),
+			},
+		},
+		{
+			name: "delete fails",
+			routes: map[string]reply{
+				cfZone:    {body: cfZoneOK},
+				cfList(1): cfPage(1, 1, "1.0.0.1"),
+				"DELETE /zones/zone-id/dns_records/id-1.0.0.1": {status: http.StatusNotFound, body: `{"success": false, "errors": [{"code": 81044, "message": "Record does not exist."}]}`},
+			},
+			want: []string{
+				"DELETE /zones/zone-id/dns_records/id-1.0.0.1",
+				cfZone,
+				cfList(1),
+			},
+			wantErr: &dns.APIError{Provider: "cloudflare", Status: http.StatusNotFound, Code: "81044", Message: "Record does not exist."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, requests := apiServer(t, tt.routes)
+			p, err := dns.NewProvider(dns.Config{Provider: "cloudflare", Token: dnstest.Token, Zone: "zone-id", APIBase: base})
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkAPIError(t, p.DeleteRecords(context.Background(), "cf", false), tt.wantErr)
+			if got := requests(); !slices.Equal(got, tt.want) {
+				t.Errorf("requests = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
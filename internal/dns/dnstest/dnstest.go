@@ -0,0 +1,170 @@
+// Package dnstest checks the DNS providers against recorded API exchanges:
+// every provider runs the same flows (list, upload, update, prune and an API
+// error) against a server that answers from a fixture, and fails when a
+// request does not match the recording, a recorded request is never sent,
+// or the outcome differs from the recorded one. A new provider lands with a
+// fixture per flow in testdata/<provider>/; Verify reports the missing ones.
+// The fixtures are test data only: the tests of this package and of the
+// providers read them from that directory.
+//
+// Fixtures are recorded with RecordFlow against the real API, or written by
+// hand, e.g. to cover pagination that a small zone never shows:
+//
+//	{
+//	  "provider": "cloudflare",
+//	  "flow": "list",
+//	  "zone": "zone-id",
+//	  "exchanges": [
+//	    {
+//	      "request": {"method": "GET", "path": "/zones/zone-id"},
+//	      "response": {"status": 200, "body": {"success": true, "result": {"name": "example.com"}}}
+//	    }
+//	  ],
+//	  "result": [...]
+//	}
+//
+// Paths are relative to the provider's API base and match with their query
+// parameters in any order; request bodies match as JSON. The requests of a
+// flow may arrive in any order, since the providers send them in parallel.
+package dnstest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// Token is the API token of the replayed providers, which every replayed
+// request must carry; RecordFlow leaves the real one out of the fixture.
+const Token = "test-token"
+
+// Fixture is the recording of one flow of one provider.
+type Fixture struct {
+	Provider  string     `json:"provider"`
+	Flow      string     `json:"flow"`
+	Zone      string     `json:"zone"`              // Config.Zone of the provider
+	TeamID    string     `json:"team_id,omitempty"` // Config.TeamID at Vercel
+	Exchanges []Exchange `json:"exchanges"`
+	// Result is the outcome of the flow, as JSON; see Flow.
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// Exchange is one API request and the response to it.
+type Exchange struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is what an API request must look like to get the Response.
+type Request struct {
+	Method string `json:"method"`
+	Path   string `json:"path"` // relative to the API base, with the query
+	// Header lists headers the request must carry, e.g. Content-Type;
+	// others are not compared. Authorization is always checked for Token.
+	Header map[string]string `json:"header,omitempty"`
+	Body   json.RawMessage   `json:"body,omitempty"`
+}
+
+// Response is a canned API response.
+type Response struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	// Text is a body that is not JSON, such as the HTML page of a gateway
+	// error; it is sent when Body is empty.
+	Text string `json:"text,omitempty"`
+}
+
+func (r Response) write(w http.ResponseWriter) {
+	if len(r.Body) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(r.Status)
+	if len(r.Body) > 0 {
+		_, _ = w.Write(r.Body)
+	} else {
+		_, _ = io.WriteString(w, r.Text)
+	}
+}
+
+// Load reads the fixture at path in fsys.
+func Load(fsys fs.FS, path string) (*Fixture, error) {
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	var fx Fixture
+	if err := json.Unmarshal(data, &fx); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &fx, nil
+}
+
+// Save writes fx as indented JSON to path.
+func (fx *Fixture) Save(path string) error {
+	data, err := json.MarshalIndent(fx, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// match reports how req falls short of r, or "" if it matches.
+func (r Request) match(method, target string, header func(string) string, body []byte) string {
+	if method != r.Method {
+		return fmt.Sprintf("method %s, want %s", method, r.Method)
+	}
+	gotPath, gotQuery, _ := strings.Cut(target, "?")
+	wantPath, wantQuery, _ := strings.Cut(r.Path, "?")
+	if gotPath != wantPath {
+		return fmt.Sprintf("path %s, want %s", gotPath, wantPath)
+	}
+	gq, _ := url.ParseQuery(gotQuery)
+	wq, _ := url.ParseQuery(wantQuery)
+	if len(gq) != 0 || len(wq) != 0 {
+		if !reflect.DeepEqual(gq, wq) {
+			return fmt.Sprintf("query %s, want %s", gq.Encode(), wq.Encode())
+		}
+	}
+	if got := header("Authorization"); got != "Bearer "+Token {
+		return fmt.Sprintf("Authorization %q, want %q", got, "Bearer "+Token)
+	}
+	for k, v := range r.Header {
+		if got := header(k); got != v {
+			return fmt.Sprintf("header %s %q, want %q", k, got, v)
+		}
+	}
+	if !jsonEqual(body, r.Body) {
+		return fmt.Sprintf("body %s, want %s", compact(body), compact(r.Body))
+	}
+	return ""
+}
+
+// jsonEqual reports whether a and b are the same JSON value; empty values
+// are equal only to each other.
+func jsonEqual(a, b []byte) bool {
+	a, b = bytes.TrimSpace(a), bytes.TrimSpace(b)
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+	var va, vb any
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return bytes.Equal(a, b)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// compact returns JSON data on one line, for messages.
+func compact(data []byte) []byte {
+	var buf bytes.Buffer
+	if json.Compact(&buf, data) != nil {
+		return bytes.TrimSpace(data)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,91 @@
+package dnstest
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/dns"
+)
+
+var fixtures = os.DirFS("testdata")
+
+func TestVerify(t *testing.T) {
+	if err := Verify(fixtures); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyMissingFixture(t *testing.T) {
+	err := Verify(fstest.MapFS{})
+	if err == nil {
+		t.Fatal("Verify of no fixtures succeeded")
+	}
+	for _, provider := range dns.ProviderNames {
+		for _, f := range Flows {
+			if want := provider + " " + f.Name + ":"; !strings.Contains(err.Error(), want) {
+				t.Errorf("error does not report %q:\n%v", want, err)
+			}
+		}
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(fx *Fixture)
+		want   string
+	}{
+		{"path", func(fx *Fixture) { fx.Exchanges[0].Request.Path += "/other" }, "never requested"},
+		{"missing exchange", func(fx *Fixture) { fx.Exchanges = fx.Exchanges[1:] }, "unexpected request"},
+		{"header", func(fx *Fixture) { fx.Exchanges[0].Request.Header = map[string]string{"Content-Type": "text/plain"} }, "header Content-Type"},
+		{"result", func(fx *Fixture) { fx.Result = []byte(`[]`) }, "result"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fx, err := Load(fixtures, "cloudflare/list.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.change(fx)
+			err = Replay(fx)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Replay = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordFlow(t *testing.T) {
+	for _, provider := range dns.ProviderNames {
+		t.Run(provider, func(t *testing.T) {
+			fx, err := Load(fixtures, provider+"/update.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Record against a server that replays the fixture, which
+			// must give the fixture back.
+			srv := httptest.NewServer(&server{fx: fx, used: make([]bool, len(fx.Exchanges))})
+			defer srv.Close()
+			got, err := RecordFlow(context.Background(), dns.Config{
+				Provider: provider,
+				Token:    Token,
+				Zone:     fx.Zone,
+				TeamID:   fx.TeamID,
+				APIBase:  srv.URL,
+			}, "update")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Exchanges) != len(fx.Exchanges) {
+				t.Fatalf("recorded %d exchanges, want %d", len(got.Exchanges), len(fx.Exchanges))
+			}
+			if err := Replay(got); err != nil {
+				t.Fatalf("replay of the recording: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package dnstest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/dns"
+)
+
+// Subdomain is the subdomain of every flow.
+const Subdomain = "cf"
+
+// UploadIPs are the IPs of the upload flow.
+var UploadIPs = []netip.Addr{
+	netip.MustParseAddr("192.0.2.1"),
+	netip.MustParseAddr("192.0.2.2"),
+	netip.MustParseAddr("2001:db8::1"),
+}
+
+// UpdateIP is the IP that the update flow points a record at.
+var UpdateIP = netip.MustParseAddr("192.0.2.20")
+
+// Flow is a sequence of provider calls. Run returns the outcome that the
+// fixture records, which must marshal to JSON, or an error if the provider
+// failed where the flow expects it to succeed.
+type Flow struct {
+	Name string
+	Run  func(ctx context.Context, p dns.Provider) (any, error)
+}
+
+// errorResult is the outcome of the error flow.
+type errorResult struct {
+	Status    int    `json:"status"`
+	Code      string `json:"code,omitempty"`
+	Message   string `json:"message,omitempty"`
+	Retryable bool   `json:"retryable"`
+}
+
+// Flows are the flows every provider has a fixture of.
+var Flows = []Flow{
+	{"list", func(ctx context.Context, p dns.Provider) (any, error) {
+		// The A records of Subdomain; the fixtures span several pages.
+		return p.ListRecords(ctx, Subdomain, false)
+	}},
+	{"upload", func(ctx context.Context, p dns.Provider) (any, error) {
+		// Replaces the A and AAAA records of Subdomain with UploadIPs.
+		return nil, dns.Upload(ctx, p, Subdomain, UploadIPs)
+	}},
+	{"update", func(ctx context.Context, p dns.Provider) (any, error) {
+		// Points the first A record of Subdomain at UpdateIP.
+		recs, err := p.ListRecords(ctx, Subdomain, false)
+		if err != nil {
+			return nil, err
+		}
+		if len(recs) == 0 {
+			return nil, errors.New("no A record to update")
+		}
+		return recs[0].ID, dns.UpdateRecord(ctx, p, recs[0], UpdateIP)
+	}},
+	{"prune", func(ctx context.Context, p dns.Provider) (any, error) {
+		// Deletes every A and AAAA record of Subdomain.
+		return nil, dns.Prune(ctx, p, Subdomain)
+	}},
+	{"error", func(ctx context.Context, p dns.Provider) (any, error) {
+		// The API rejects the request, e.g. for a revoked token.
+		_, err := p.ListRecords(ctx, Subdomain, false)
+		var apiErr *dns.APIError
+		if !errors.As(err, &apiErr) {
+			return nil, fmt.Errorf("want an API error, got %v", err)
+		}
+		return errorResult{apiErr.Status, apiErr.Code, apiErr.Message, apiErr.IsRetryable()}, nil
+	}},
+}
+
+// flow returns the flow named name.
+func flow(name string) (Flow, bool) {
+	for _, f := range Flows {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Flow{}, false
+}
@@ -0,0 +1,114 @@
+package dnstest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/dns"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/transport"
+)
+
+// recorder is a RoundTripper that records the exchanges it passes to next,
+// with the paths relative to base.
+type recorder struct {
+	next http.RoundTripper
+	base string // path of the API base
+
+	mu        sync.Mutex
+	exchanges []Exchange
+}
+
+func (rec *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		if reqBody, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+	resp, err := rec.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	ex := Exchange{
+		Request: Request{
+			Method: req.Method,
+			Path:   strings.TrimPrefix(req.URL.RequestURI(), rec.base),
+		},
+		Response: Response{Status: resp.StatusCode},
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		ex.Request.Header = map[string]string{"Content-Type": ct}
+	}
+	if len(reqBody) > 0 {
+		ex.Request.Body = json.RawMessage(reqBody)
+	}
+	if json.Valid(respBody) {
+		ex.Response.Body = json.RawMessage(respBody)
+	} else {
+		ex.Response.Text = string(respBody)
+	}
+	rec.mu.Lock()
+	rec.exchanges = append(rec.exchanges, ex)
+	rec.mu.Unlock()
+	return resp, nil
+}
+
+// RecordFlow runs the flow named name against the provider of cfg, usually
+// the real API with real credentials, and returns the fixture of what it
+// sent and received. The token is not recorded, and the subdomain is
+// Subdomain, so record in a zone where its records may change.
+func RecordFlow(ctx context.Context, cfg dns.Config, name string) (*Fixture, error) {
+	f, ok := flow(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown flow %q", name)
+	}
+	if cfg.Zone == "" {
+		return nil, errors.New("record: Config.Zone is required, since the fixture replays it")
+	}
+	base := cfg.APIBase
+	if base == "" {
+		base = dns.APIBases[cfg.Provider]
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	next := http.RoundTripper(transport.API())
+	if cfg.HTTPClient != nil && cfg.HTTPClient.Transport != nil {
+		next = cfg.HTTPClient.Transport
+	}
+	rec := &recorder{next: next, base: strings.TrimSuffix(u.Path, "/")}
+	cfg.HTTPClient = &http.Client{Transport: rec}
+	p, err := dns.NewProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	result, err := f.Run(ctx, p)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	fx := &Fixture{Provider: cfg.Provider, Flow: name, Zone: cfg.Zone, TeamID: cfg.TeamID, Exchanges: rec.exchanges}
+	if result != nil {
+		if fx.Result, err = json.Marshal(result); err != nil {
+			return nil, err
+		}
+	}
+	return fx, nil
+}
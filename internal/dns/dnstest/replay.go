@@ -0,0 +1,143 @@
+package dnstest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/dns"
+)
+
+// replayTimeout bounds the replay of one flow.
+const replayTimeout = 10 * time.Second
+
+// server answers the requests of a flow from the exchanges of a fixture,
+// using each exchange once.
+type server struct {
+	mu   sync.Mutex
+	fx   *Fixture
+	used []bool
+	errs []error
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var misses []string
+	for i, ex := range s.fx.Exchanges {
+		if s.used[i] {
+			continue
+		}
+		miss := ex.Request.match(r.Method, r.URL.RequestURI(), r.Header.Get, body)
+		if miss != "" {
+			// Only the near misses, of the same method and path, explain.
+			if p, _, _ := strings.Cut(ex.Request.Path, "?"); ex.Request.Method == r.Method && p == r.URL.Path {
+				misses = append(misses, fmt.Sprintf("exchange %d: %s", i+1, miss))
+			}
+			continue
+		}
+		s.used[i] = true
+		ex.Response.write(w)
+		return
+	}
+	err := fmt.Errorf("unexpected request %s %s", r.Method, r.URL.RequestURI())
+	if len(misses) > 0 {
+		err = fmt.Errorf("%w:\n\t%s", err, strings.Join(misses, "\n\t"))
+	}
+	s.errs = append(s.errs, err)
+	http.Error(w, "no recorded exchange matches", http.StatusNotImplemented)
+}
+
+// Replay runs the flow of fx against a provider whose API answers from fx,
+// and returns every difference from the recording.
+func Replay(fx *Fixture) error {
+	f, ok := flow(fx.Flow)
+	if !ok {
+		return fmt.Errorf("unknown flow %q", fx.Flow)
+	}
+	s := &server{fx: fx, used: make([]bool, len(fx.Exchanges))}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	p, err := dns.NewProvider(dns.Config{
+		Provider: fx.Provider,
+		Token:    Token,
+		Zone:     fx.Zone,
+		TeamID:   fx.TeamID,
+		APIBase:  srv.URL,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), replayTimeout)
+	defer cancel()
+	result, err := f.Run(ctx, p)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	errs := s.errs
+	if err != nil {
+		errs = append(errs, fmt.Errorf("flow failed: %w", err))
+	}
+	for i, used := range s.used {
+		if !used {
+			ex := fx.Exchanges[i].Request
+			errs = append(errs, fmt.Errorf("exchange %d never requested: %s %s", i+1, ex.Method, ex.Path))
+		}
+	}
+	if err == nil {
+		got, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		if result == nil {
+			got = nil
+		}
+		if !jsonEqual(got, fx.Result) {
+			errs = append(errs, fmt.Errorf("result %s, want %s", got, fx.Result))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Verify replays the fixture of every flow of every provider in
+// dns.ProviderNames from fsys, at <provider>/<flow>.json, e.g. from
+// os.DirFS("testdata"). The errors name the provider and flow.
+func Verify(fsys fs.FS) error {
+	var errs []error
+	for _, provider := range dns.ProviderNames {
+		errs = append(errs, VerifyProvider(fsys, provider))
+	}
+	return errors.Join(errs...)
+}
+
+// VerifyProvider is Verify for the one provider.
+func VerifyProvider(fsys fs.FS, provider string) error {
+	var errs []error
+	for _, f := range Flows {
+		name := path.Join(provider, f.Name+".json")
+		fx, err := Load(fsys, name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %w", provider, f.Name, err))
+			continue
+		}
+		if fx.Provider != provider || fx.Flow != f.Name {
+			errs = append(errs, fmt.Errorf("%s: records %s %s", name, fx.Provider, fx.Flow))
+			continue
+		}
+		if err := Replay(fx); err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %w", provider, f.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
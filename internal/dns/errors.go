@@ -0,0 +1,83 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// APIError is an error returned by a provider's API.
+type APIError struct {
+	Provider string // "cloudflare" or "vercel"
+	Status   int    // HTTP status of the response
+	Code     string // provider error code, if any
+	Message  string
+}
+
+func (e *APIError) Error() string {
+	msg := e.Message
+	switch {
+	case msg == "" && e.Status != 0:
+		msg = fmt.Sprintf("status %d", e.Status)
+	case msg == "":
+		msg = "unknown"
+	}
+	if e.Code != "" {
+		msg += " (code " + e.Code + ")"
+	}
+	return e.Provider + " API error: " + msg
+}
+
+// IsRetryable reports whether the same request may succeed later: the API
+// was rate limiting, timed out or failed on its side. Errors in the request
+// itself, such as a bad token or a conflicting record, are not.
+func (e *APIError) IsRetryable() bool {
+	return e.Status == http.StatusRequestTimeout || e.Status == http.StatusTooManyRequests || e.Status >= 500
+}
+
+// IsRetryable reports whether err, as returned by a Provider, is worth
+// retrying: an APIError that says so, or a network failure before the API
+// answered. Cancellation is not.
+func IsRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.IsRetryable()
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// cfAPIError is the error of a Cloudflare response with success false.
+func cfAPIError(status int, errs []cfError) error {
+	e := &APIError{Provider: "cloudflare", Status: status}
+	if len(errs) > 0 {
+		e.Code, e.Message = strconv.Itoa(errs[0].Code), errs[0].Message
+	}
+	return e
+}
+
+// vercelAPIError is the error of a Vercel response with an error status.
+func vercelAPIError(status int, body []byte) error {
+	e := &APIError{Provider: "vercel", Status: status}
+	var errResp vercelErrorResponse
+	if json.Unmarshal(body, &errResp) == nil {
+		e.Code, e.Message = errResp.Error.Code, errResp.Error.Message
+	}
+	return e
+}
+
+// parseError is the error of a response that is not the API's JSON, such as
+// the HTML page of a gateway error.
+func parseError(provider string, status int, err error) error {
+	if status >= 400 {
+		return &APIError{Provider: provider, Status: status}
+	}
+	return fmt.Errorf("parse response: %w", err)
+}
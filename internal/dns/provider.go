@@ -2,9 +2,21 @@ package dns
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"net/netip"
+	"net/url"
 	"os"
+	"strings"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/logging"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/pipeline"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/ratelimit"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/transport"
 )
 
 // Config holds DNS upload configuration.
@@ -15,6 +27,55 @@ type Config struct {
 	Subdomain   string // Subdomain prefix (e.g., "cf" for cf.example.com)
 	UploadCount int    // Number of IPs to upload
 	TeamID      string // Vercel Team ID (optional)
+
+	// Limiter, when set, paces every API request of the provider. Share one
+	// limiter between all providers using the same account to stay within
+	// its rate limit.
+	Limiter *ratelimit.Limiter
+
+	// HTTPClient, if set, sends the API requests instead of a client on the
+	// shared API transport; Limiter still paces them.
+	HTTPClient *http.Client
+
+	// APIBase, if set, replaces the base URL of the provider's public API,
+	// e.g. a reverse proxy or an allow-listed egress gateway in front of it:
+	// https://gateway.example.com/cloudflare/client/v4.
+	APIBase string
+	// CAFile, if set, is a PEM file of the CAs that the API server's
+	// certificate is verified against instead of the system roots. It is
+	// ignored with HTTPClient, whose transport carries its own TLS settings.
+	CAFile string
+}
+
+// apiClient returns the client of the API requests of cfg.
+func (cfg Config) apiClient() (*http.Client, error) {
+	if cfg.CAFile == "" || cfg.HTTPClient != nil {
+		return ratelimit.Wrap(cfg.HTTPClient, cfg.Limiter), nil
+	}
+	pem, err := os.ReadFile(cfg.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: CA file: %w", cfg.Provider, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: CA file: no certificates in %s", cfg.Provider, cfg.CAFile)
+	}
+	t := transport.API().Clone()
+	t.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return ratelimit.Wrap(&http.Client{Transport: t}, cfg.Limiter), nil
+}
+
+// apiBase returns cfg.APIBase without a trailing slash, or def if it is
+// empty.
+func (cfg Config) apiBase(def string) (string, error) {
+	if cfg.APIBase == "" {
+		return def, nil
+	}
+	u, err := url.Parse(cfg.APIBase)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", fmt.Errorf("%s: API base URL %q: want http(s)://host[/path]", cfg.Provider, cfg.APIBase)
+	}
+	return strings.TrimSuffix(cfg.APIBase, "/"), nil
 }
 
 // Provider defines the interface for DNS record management.
@@ -25,6 +86,59 @@ type Provider interface {
 	DeleteRecords(ctx context.Context, subdomain string, ipv6 bool) error
 	// CreateRecords creates A/AAAA records for the given IPs.
 	CreateRecords(ctx context.Context, subdomain string, ips []netip.Addr) error
+	// ListRecords returns the A or AAAA records of the subdomain.
+	ListRecords(ctx context.Context, subdomain string, ipv6 bool) ([]Record, error)
+}
+
+// Record is an A or AAAA record.
+type Record struct {
+	ID   string     // provider's record ID
+	Type string     // "A" or "AAAA"
+	Name string     // full domain name
+	IP   netip.Addr // record content
+	TTL  int        // seconds; 1 is automatic at Cloudflare
+}
+
+// Updater is implemented by providers that can change the IP of a record in
+// place. Use CanUpdate to find out, or UpdateRecord, which reports
+// errors.ErrUnsupported for the others.
+type Updater interface {
+	UpdateRecord(ctx context.Context, rec Record, ip netip.Addr) error
+}
+
+// CanUpdate reports whether provider can update records in place.
+func CanUpdate(provider Provider) bool {
+	_, ok := provider.(Updater)
+	return ok
+}
+
+// UpdateRecord points rec, as returned by ListRecords, at ip, which must be
+// of the same family.
+func UpdateRecord(ctx context.Context, provider Provider, rec Record, ip netip.Addr) error {
+	u, ok := provider.(Updater)
+	if !ok {
+		return fmt.Errorf("%s: update record: %w", provider.Name(), errors.ErrUnsupported)
+	}
+	if ip.Is6() != (rec.Type == "AAAA") {
+		return fmt.Errorf("update %s record %s to %s: wrong address family", rec.Type, rec.ID, ip)
+	}
+	return u.UpdateRecord(ctx, rec, ip)
+}
+
+// Namer is implemented by providers that can tell the full domain name of a
+// subdomain, e.g. to resolve the published records.
+type Namer interface {
+	FQDN(ctx context.Context, subdomain string) (string, error)
+}
+
+// ProviderNames lists the providers accepted by NewProvider.
+var ProviderNames = []string{"cloudflare", "vercel"}
+
+// APIBases are the base URLs of the providers' public APIs, which
+// Config.APIBase replaces.
+var APIBases = map[string]string{
+	"cloudflare": cloudflareAPIBase,
+	"vercel":     vercelAPIBase,
 }
 
 // NewProvider creates a Provider based on the config.
@@ -45,7 +159,17 @@ func NewProvider(cfg Config) (Provider, error) {
 		if zone == "" {
 			return nil, fmt.Errorf("cloudflare: zone ID required (--dns-zone or CF_ZONE_ID)")
 		}
-		return NewCloudflareProvider(token, zone), nil
+		base, err := cfg.apiBase(cloudflareAPIBase)
+		if err != nil {
+			return nil, err
+		}
+		client, err := cfg.apiClient()
+		if err != nil {
+			return nil, err
+		}
+		p := NewCloudflareProvider(token, zone, client)
+		p.apiBase = base
+		return p, nil
 
 	case "vercel":
 		token := cfg.Token
@@ -57,25 +181,69 @@ func NewProvider(cfg Config) (Provider, error) {
 			teamID = os.Getenv("VERCEL_TEAM_ID")
 		}
 		domain := cfg.Zone
+		if domain == "" {
+			domain = os.Getenv("VERCEL_DOMAIN")
+		}
 		if token == "" {
 			return nil, fmt.Errorf("vercel: API token required (--dns-token or VERCEL_TOKEN)")
 		}
 		if domain == "" {
-			return nil, fmt.Errorf("vercel: domain required (--dns-zone)")
+			return nil, fmt.Errorf("vercel: domain required (--dns-zone or VERCEL_DOMAIN)")
+		}
+		base, err := cfg.apiBase(vercelAPIBase)
+		if err != nil {
+			return nil, err
 		}
-		return NewVercelProvider(token, domain, teamID), nil
+		client, err := cfg.apiClient()
+		if err != nil {
+			return nil, err
+		}
+		p := NewVercelProvider(token, domain, teamID, client)
+		p.apiBase = base
+		return p, nil
 
 	default:
-		return nil, fmt.Errorf("unknown DNS provider: %s (supported: cloudflare, vercel)", cfg.Provider)
+		return nil, fmt.Errorf("unknown DNS provider: %s (supported: %s)", cfg.Provider, strings.Join(ProviderNames, ", "))
 	}
 }
 
+// maxInFlight bounds the API calls a provider has in flight at once. The
+// API transport multiplexes them over one HTTP/2 connection, so deleting or
+// creating hundreds of records takes a few round trips instead of one per
+// record, while Config.Limiter still paces them.
+const maxInFlight = 8
+
+// forEach calls fn for every item with up to maxInFlight calls at a time and
+// returns the first error; once a call failed, no further calls are started.
+// A call that panics fails with a *pipeline.PanicError.
+func forEach[T any](ctx context.Context, items []T, fn func(context.Context, T) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	protected := func(ctx context.Context, it T) error {
+		return pipeline.Protect(func() error { return fn(ctx, it) })
+	}
+	var first error
+	for err := range pipeline.Map(ctx, pipeline.Source(ctx, items), maxInFlight, protected) {
+		if err != nil && first == nil {
+			first = err
+			cancel()
+		}
+	}
+	if first == nil {
+		// Canceled by the caller, possibly before every item was started.
+		first = ctx.Err()
+	}
+	return first
+}
+
 // Upload uploads the given IPs to the DNS provider.
 // It first deletes existing records for the subdomain, then creates new ones.
-func Upload(ctx context.Context, provider Provider, subdomain string, ips []netip.Addr, verbose bool) error {
+// Each step is logged to slog.Default() at debug level.
+func Upload(ctx context.Context, provider Provider, subdomain string, ips []netip.Addr) error {
 	if len(ips) == 0 {
 		return nil
 	}
+	log := logger(provider, subdomain)
 
 	// Separate IPv4 and IPv6 addresses
 	var v4, v6 []netip.Addr
@@ -89,15 +257,11 @@ func Upload(ctx context.Context, provider Provider, subdomain string, ips []neti
 
 	// Delete existing A records and create new ones
 	if len(v4) > 0 {
-		if verbose {
-			fmt.Fprintf(os.Stderr, "dns: deleting existing A records for %s...\n", subdomain)
-		}
+		log.DebugContext(ctx, "deleting existing records", "type", "A")
 		if err := provider.DeleteRecords(ctx, subdomain, false); err != nil {
 			return fmt.Errorf("delete A records: %w", err)
 		}
-		if verbose {
-			fmt.Fprintf(os.Stderr, "dns: creating %d A records for %s...\n", len(v4), subdomain)
-		}
+		log.DebugContext(ctx, "creating records", "type", "A", "count", len(v4))
 		if err := provider.CreateRecords(ctx, subdomain, v4); err != nil {
 			return fmt.Errorf("create A records: %w", err)
 		}
@@ -105,22 +269,36 @@ func Upload(ctx context.Context, provider Provider, subdomain string, ips []neti
 
 	// Delete existing AAAA records and create new ones
 	if len(v6) > 0 {
-		if verbose {
-			fmt.Fprintf(os.Stderr, "dns: deleting existing AAAA records for %s...\n", subdomain)
-		}
+		log.DebugContext(ctx, "deleting existing records", "type", "AAAA")
 		if err := provider.DeleteRecords(ctx, subdomain, true); err != nil {
 			return fmt.Errorf("delete AAAA records: %w", err)
 		}
-		if verbose {
-			fmt.Fprintf(os.Stderr, "dns: creating %d AAAA records for %s...\n", len(v6), subdomain)
-		}
+		log.DebugContext(ctx, "creating records", "type", "AAAA", "count", len(v6))
 		if err := provider.CreateRecords(ctx, subdomain, v6); err != nil {
 			return fmt.Errorf("create AAAA records: %w", err)
 		}
 	}
 
-	if verbose {
-		fmt.Fprintf(os.Stderr, "dns: upload complete (%d A, %d AAAA records)\n", len(v4), len(v6))
+	log.DebugContext(ctx, "upload complete", "a", len(v4), "aaaa", len(v6))
+	return nil
+}
+
+// Prune deletes all A and AAAA records of the subdomain, i.e. every record
+// Upload manages there, logging each step like Upload.
+func Prune(ctx context.Context, provider Provider, subdomain string) error {
+	log := logger(provider, subdomain)
+	log.DebugContext(ctx, "deleting records", "type", "A")
+	if err := provider.DeleteRecords(ctx, subdomain, false); err != nil {
+		return fmt.Errorf("delete A records: %w", err)
+	}
+	log.DebugContext(ctx, "deleting records", "type", "AAAA")
+	if err := provider.DeleteRecords(ctx, subdomain, true); err != nil {
+		return fmt.Errorf("delete AAAA records: %w", err)
 	}
 	return nil
 }
+
+// logger is the logger of the changes to subdomain at provider.
+func logger(provider Provider, subdomain string) *slog.Logger {
+	return slog.Default().With(logging.Phase("dns"), logging.Provider(provider.Name()), "subdomain", subdomain)
+}
@@ -0,0 +1,82 @@
+package dns_test
+
+import (
+	"cmp"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/dns"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/dns/dnstest"
+)
+
+// reply is a canned API response.
+type reply struct {
+	status int
+	body   string
+}
+
+// apiServer answers the requests in routes, keyed by method and request
+// URI, e.g. "GET /zones/zone-id"; any other request fails the test. It
+// returns the requests it received, sorted, once the test is done with
+// the provider.
+func apiServer(t *testing.T, routes map[string]reply) (base string, requests func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Method + " " + r.RequestURI
+		mu.Lock()
+		got = append(got, key)
+		mu.Unlock()
+		if auth := r.Header.Get("Authorization"); auth != "Bearer "+dnstest.Token {
+			t.Errorf("%s: Authorization %q", key, auth)
+		}
+		rep, ok := routes[key]
+		if !ok {
+			t.Errorf("unexpected request %s", key)
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(cmp.Or(rep.status, http.StatusOK))
+		_, _ = w.Write([]byte(rep.body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Sorted(slices.Values(got))
+	}
+}
+
+// recordIPs returns the IPs of records, in order.
+func recordIPs(records []dns.Record) []string {
+	var out []string
+	for _, r := range records {
+		out = append(out, r.IP.String())
+	}
+	return out
+}
+
+// checkAPIError checks that err wraps an *dns.APIError equal to want, or
+// that there is no error if want is nil.
+func checkAPIError(t *testing.T, err error, want *dns.APIError) {
+	t.Helper()
+	if want == nil {
+		if err != nil {
+			t.Fatalf("error = %v", err)
+		}
+		return
+	}
+	var apiErr *dns.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("error = %v (%T), want *dns.APIError", err, err)
+	}
+	if *apiErr != *want {
+		t.Fatalf("error = %+v, want %+v", *apiErr, *want)
+	}
+}
@@ -9,26 +9,35 @@ import (
 	"net/http"
 	"net/netip"
 	"net/url"
+	"strconv"
 	"strings"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/transport"
 )
 
 const vercelAPIBase = "https://api.vercel.com"
 
+// vercelPageSize is the number of records asked for per page of a list.
+const vercelPageSize = 100
+
 // VercelProvider implements Provider for Vercel DNS.
 type VercelProvider struct {
-	token  string
-	domain string
-	teamID string
-	client *http.Client
+	token   string
+	domain  string
+	teamID  string
+	client  *http.Client
+	apiBase string // vercelAPIBase, or Config.APIBase
 }
 
-// NewVercelProvider creates a new Vercel DNS provider.
-func NewVercelProvider(token, domain, teamID string) *VercelProvider {
+// NewVercelProvider creates a new Vercel DNS provider. A nil client uses the
+// shared API transport.
+func NewVercelProvider(token, domain, teamID string, client *http.Client) *VercelProvider {
 	return &VercelProvider{
-		token:  token,
-		domain: domain,
-		teamID: teamID,
-		client: &http.Client{},
+		token:   token,
+		domain:  domain,
+		teamID:  teamID,
+		client:  transport.ClientOr(client),
+		apiBase: vercelAPIBase,
 	}
 }
 
@@ -47,11 +56,13 @@ type vercelDNSRecord struct {
 
 // vercelListResponse represents the Vercel API list response.
 type vercelListResponse struct {
-	Records    []vercelDNSRecord `json:"records"`
+	Records []vercelDNSRecord `json:"records"`
+	// Pagination.Next is the timestamp to pass as until for the next page,
+	// null on the last one.
 	Pagination struct {
 		Count int    `json:"count"`
-		Next  string `json:"next"`
-		Prev  string `json:"prev"`
+		Next  *int64 `json:"next"`
+		Prev  *int64 `json:"prev"`
 	} `json:"pagination"`
 }
 
@@ -63,6 +74,14 @@ type vercelErrorResponse struct {
 	} `json:"error"`
 }
 
+// FQDN returns the full domain name of subdomain in the domain.
+func (p *VercelProvider) FQDN(ctx context.Context, subdomain string) (string, error) {
+	if subdomain == "" || subdomain == "@" {
+		return p.domain, nil
+	}
+	return subdomain + "." + p.domain, nil
+}
+
 // DeleteRecords deletes all A or AAAA records for the subdomain.
 func (p *VercelProvider) DeleteRecords(ctx context.Context, subdomain string, ipv6 bool) error {
 	recordType := "A"
@@ -76,20 +95,24 @@ func (p *VercelProvider) DeleteRecords(ctx context.Context, subdomain string, ip
 		return err
 	}
 
-	// Filter and delete matching records
+	// Filter and delete matching records, concurrently
+	var matching []vercelDNSRecord
 	for _, rec := range records {
 		if rec.Type == recordType && rec.Name == subdomain {
-			if err := p.deleteRecord(ctx, rec.ID); err != nil {
-				return fmt.Errorf("delete record %s: %w", rec.ID, err)
-			}
+			matching = append(matching, rec)
 		}
 	}
-	return nil
+	return forEach(ctx, matching, func(ctx context.Context, rec vercelDNSRecord) error {
+		if err := p.deleteRecord(ctx, rec.ID); err != nil {
+			return fmt.Errorf("delete record %s: %w", rec.ID, err)
+		}
+		return nil
+	})
 }
 
 // CreateRecords creates A/AAAA records for the given IPs.
 func (p *VercelProvider) CreateRecords(ctx context.Context, subdomain string, ips []netip.Addr) error {
-	for _, ip := range ips {
+	return forEach(ctx, ips, func(ctx context.Context, ip netip.Addr) error {
 		recordType := "A"
 		if ip.Is6() {
 			recordType = "AAAA"
@@ -97,12 +120,68 @@ func (p *VercelProvider) CreateRecords(ctx context.Context, subdomain string, ip
 		if err := p.createRecord(ctx, subdomain, recordType, ip.String()); err != nil {
 			return fmt.Errorf("create record for %s: %w", ip.String(), err)
 		}
+		return nil
+	})
+}
+
+// ListRecords returns the A or AAAA records of the subdomain.
+func (p *VercelProvider) ListRecords(ctx context.Context, subdomain string, ipv6 bool) ([]Record, error) {
+	recordType := "A"
+	if ipv6 {
+		recordType = "AAAA"
+	}
+	records, err := p.listRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fqdn, _ := p.FQDN(ctx, subdomain)
+	var out []Record
+	for _, rec := range records {
+		if rec.Type != recordType || rec.Name != subdomain {
+			continue
+		}
+		ip, err := netip.ParseAddr(rec.Value)
+		if err != nil {
+			return nil, fmt.Errorf("record %s: %w", rec.ID, err)
+		}
+		out = append(out, Record{ID: rec.ID, Type: rec.Type, Name: fqdn, IP: ip, TTL: rec.TTL})
+	}
+	return out, nil
+}
+
+// UpdateRecord changes the IP of rec in place.
+func (p *VercelProvider) UpdateRecord(ctx context.Context, rec Record, ip netip.Addr) error {
+	path := fmt.Sprintf("/v1/domains/records/%s", url.PathEscape(rec.ID))
+	reqURL := p.buildURL(path)
+
+	data, err := json.Marshal(map[string]interface{}{"value": ip.String()})
+	if err != nil {
+		return err
 	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, reqURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(resp.Body)
+		return vercelAPIError(resp.StatusCode, body)
+	}
+
 	return nil
 }
 
 func (p *VercelProvider) buildURL(path string) string {
-	u := vercelAPIBase + path
+	u := p.apiBase + path
 	if p.teamID != "" {
 		if strings.Contains(u, "?") {
 			u += "&teamId=" + url.QueryEscape(p.teamID)
@@ -113,8 +192,30 @@ func (p *VercelProvider) buildURL(path string) string {
 	return u
 }
 
+// listRecords returns every record of the domain, reading all pages of the
+// list.
 func (p *VercelProvider) listRecords(ctx context.Context) ([]vercelDNSRecord, error) {
-	path := fmt.Sprintf("/v4/domains/%s/records", url.PathEscape(p.domain))
+	var records []vercelDNSRecord
+	var until *int64
+	for {
+		result, err := p.listPage(ctx, until)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, result.Records...)
+		next := result.Pagination.Next
+		if next == nil || len(result.Records) == 0 || until != nil && *next == *until {
+			return records, nil
+		}
+		until = next
+	}
+}
+
+func (p *VercelProvider) listPage(ctx context.Context, until *int64) (*vercelListResponse, error) {
+	path := fmt.Sprintf("/v4/domains/%s/records?limit=%d", url.PathEscape(p.domain), vercelPageSize)
+	if until != nil {
+		path += "&until=" + strconv.FormatInt(*until, 10)
+	}
 	reqURL := p.buildURL(path)
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
@@ -136,19 +237,15 @@ func (p *VercelProvider) listRecords(ctx context.Context) ([]vercelDNSRecord, er
 	}
 
 	if resp.StatusCode >= 400 {
-		var errResp vercelErrorResponse
-		if json.Unmarshal(body, &errResp) == nil && errResp.Error.Message != "" {
-			return nil, fmt.Errorf("vercel API error: %s", errResp.Error.Message)
-		}
-		return nil, fmt.Errorf("vercel API error: status %d", resp.StatusCode)
+		return nil, vercelAPIError(resp.StatusCode, body)
 	}
 
 	var result vercelListResponse
 	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, fmt.Errorf("parse response: %w", err)
+		return nil, parseError("vercel", resp.StatusCode, err)
 	}
 
-	return result.Records, nil
+	return &result, nil
 }
 
 func (p *VercelProvider) deleteRecord(ctx context.Context, recordID string) error {
@@ -170,11 +267,7 @@ func (p *VercelProvider) deleteRecord(ctx context.Context, recordID string) erro
 
 	if resp.StatusCode >= 400 {
 		body, _ := io.ReadAll(resp.Body)
-		var errResp vercelErrorResponse
-		if json.Unmarshal(body, &errResp) == nil && errResp.Error.Message != "" {
-			return fmt.Errorf("vercel API error: %s", errResp.Error.Message)
-		}
-		return fmt.Errorf("vercel API error: status %d", resp.StatusCode)
+		return vercelAPIError(resp.StatusCode, body)
 	}
 
 	return nil
@@ -211,11 +304,7 @@ func (p *VercelProvider) createRecord(ctx context.Context, name, recordType, val
 
 	if resp.StatusCode >= 400 {
 		body, _ := io.ReadAll(resp.Body)
-		var errResp vercelErrorResponse
-		if json.Unmarshal(body, &errResp) == nil && errResp.Error.Message != "" {
-			return fmt.Errorf("vercel API error: %s", errResp.Error.Message)
-		}
-		return fmt.Errorf("vercel API error: status %d", resp.StatusCode)
+		return vercelAPIError(resp.StatusCode, body)
 	}
 
 	return nil
@@ -0,0 +1,167 @@
+package dns_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/dns"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/dns/dnstest"
+)
+
+func TestVercelFixtures(t *testing.T) {
+	if err := dnstest.VerifyProvider(os.DirFS("dnstest/testdata"), "vercel"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const vercelList = "GET /v4/domains/example.com/records?limit=100"
+
+// vercelPage is a page of records of example.com, named name/type/value,
+// with next as its pagination.next (0 = null).
+func vercelPage(next int64, records ...string) reply {
+	var recs []string
+	for _, r := range records {
+		f := strings.Split(r, "/")
+		recs = append(recs, fmt.Sprintf(`{"id": "id-%s", "name": %q, "type": %q, "value": %q, "ttl": 60}`, f[2], f[0], f[1], f[2]))
+	}
+	nextJSON := "null"
+	if next != 0 {
+		nextJSON = strconv.FormatInt(next, 10)
+	}
+	return reply{body: fmt.Sprintf(`{"records": [%s], "pagination": {"count": %d, "next": %s, "prev": null}}`, strings.Join(recs, ", "), len(recs), nextJSON)}
+}
+
+func TestVercelListRecords(t *testing.T) {
+	tests := []struct {
+		name    string
+		teamID  string
+		routes  map[string]reply
+		want    []string
+		wantErr *dns.APIError
+	}{
+		{
+			name:   "one page",
+			routes: map[string]reply{vercelList: vercelPage(0, "cf/A/1.0.0.1", "cf/AAAA/2606::1", "www/A/1.0.0.9", "cf/A/1.0.0.2")},
+			want:   []string{"1.0.0.1", "1.0.0.2"},
+		},
+		{
+			name: "pages",
+			routes: map[string]reply{
+				vercelList:                vercelPage(200, "cf/A/1.0.0.1"),
+				vercelList + "&until=200": vercelPage(100, "www/A/1.0.0.9", "cf/A/1.0.0.2"),
+				vercelList + "&until=100": vercelPage(0, "cf/A/1.0.0.3"),
+			},
+			want: []string{"1.0.0.1", "1.0.0.2", "1.0.0.3"},
+		},
+		{
+			name: "repeated cursor ends the list",
+			routes: map[string]reply{
+				vercelList:                vercelPage(200, "cf/A/1.0.0.1"),
+				vercelList + "&until=200": vercelPage(200, "cf/A/1.0.0.2"),
+			},
+			want: []string{"1.0.0.1", "1.0.0.2"},
+		},
+		{
+			name:   "team",
+			teamID: "team-id",
+			routes: map[string]reply{
+				vercelList + "&teamId=team-id":           vercelPage(200, "cf/A/1.0.0.1"),
+				vercelList + "&until=200&teamId=team-id": vercelPage(0, "cf/A/1.0.0.2"),
+			},
+			want: []string{"1.0.0.1", "1.0.0.2"},
+		},
+		{
+			name:    "forbidden",
+			routes:  map[string]reply{vercelList: {status: http.StatusForbidden, body: `{"error": {"code": "forbidden", "message": "Not authorized"}}`}},
+			wantErr: &dns.APIError{Provider: "vercel", Status: http.StatusForbidden, Code: "forbidden", Message: "Not authorized"},
+		},
+		{
+			name: "error on a later page",
+			routes: map[string]reply{
+				vercelList:                vercelPage(200, "cf/A/1.0.0.1"),
+				vercelList + "&until=200": {status: http.StatusTooManyRequests, body: `{"error": {"code": "rate_limited", "message": "Rate limit exceeded"}}`},
+			},
+			wantErr: &dns.APIError{Provider: "vercel", Status: http.StatusTooManyRequests, Code: "rate_limited", Message: "Rate limit exceeded"},
+		},
+		{
+			name:    "gateway error",
+			routes:  map[string]reply{vercelList: {status: http.StatusBadGateway, body: "<html>502 Bad Gateway</html>"}},
+			wantErr: &dns.APIError{Provider: "vercel", Status: http.StatusBadGateway},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, _ := apiServer(t, tt.routes)
+			p, err := dns.NewProvider(dns.Config{Provider: "vercel", Token: dnstest.Token, Zone: "example.com", TeamID: tt.teamID, APIBase: base})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := p.ListRecords(context.Background(), "cf", false)
+			checkAPIError(t, err, tt.wantErr)
+			if ips := recordIPs(got); !slices.Equal(ips, tt.want) {
+				t.Errorf("ListRecords = %v, want %v", ips, tt.want)
+			}
+			for _, r := range got {
+				if r.Name != "cf.example.com" {
+					t.Errorf("record %s named %q, want cf.example.com", r.ID, r.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestVercelDeleteRecords(t *testing.T) {
+	tests := []struct {
+		name    string
+		routes  map[string]reply
+		want    []string // the requests sent, sorted
+		wantErr *dns.APIError
+	}{
+		{
+			name: "matching records of every page",
+			routes: map[string]reply{
+				vercelList:                vercelPage(200, "cf/A/1.0.0.1", "cf/AAAA/2606::1"),
+				vercelList + "&until=200": vercelPage(0, "www/A/1.0.0.9", "cf/A/1.0.0.2"),
+				"DELETE /v2/domains/example.com/records/id-1.0.0.1": {body: `{}`},
+				"DELETE /v2/domains/example.com/records/id-1.0.0.2": {body: `{}`},
+			},
+			want: []string{
+				"DELETE /v2/domains/example.com/records/id-1.0.0.1",
+				"DELETE /v2/domains/example.com/records/id-1.0.0.2",
+				vercelList,
+				vercelList + "&until=200",
+			},
+		},
+		{
+			name: "delete fails",
+			routes: map[string]reply{
+				vercelList: vercelPage(0, "cf/A/1.0.0.1"),
+				"DELETE /v2/domains/example.com/records/id-1.0.0.1": {status: http.StatusNotFound, body: `{"error": {"code": "not_found", "message": "Record not found"}}`},
+			},
+			want: []string{
+				"DELETE /v2/domains/example.com/records/id-1.0.0.1",
+				vercelList,
+			},
+			wantErr: &dns.APIError{Provider: "vercel", Status: http.StatusNotFound, Code: "not_found", Message: "Record not found"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, requests := apiServer(t, tt.routes)
+			p, err := dns.NewProvider(dns.Config{Provider: "vercel", Token: dnstest.Token, Zone: "example.com", APIBase: base})
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkAPIError(t, p.DeleteRecords(context.Background(), "cf", false), tt.wantErr)
+			if got := requests(); !slices.Equal(got, tt.want) {
+				t.Errorf("requests = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/clock"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/logging"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/probe"
+)
+
+// Backoff tuning. The rates are the fraction of probes that timed out or
+// were reset; the range itself may be dead, so a spike is measured against
+// the rate the search has been seeing so far.
+const (
+	backoffWindow  = 50   // results per global evaluation
+	backoffSpike   = 0.25 // rise over the baseline that halves the limit
+	backoffRecover = 0.10 // rise over the baseline below which it grows again
+	backoffAlpha   = 0.1  // weight of a window in the baseline
+
+	subnetWindow      = 16 // results per subnet evaluation
+	subnetSpike       = 0.5
+	subnetRecover     = 0.2
+	subnetMinInterval = 250 * time.Millisecond
+	subnetMaxInterval = 2 * time.Second
+	maxSubnets        = 4096
+	maxRedraws        = 3 // draws avoiding a backed-off subnet before waiting for it
+)
+
+// backoff slows the search down while timeouts and connection resets spike,
+// as they do when an ISP rate-limits the scan or a NAT runs out of
+// conntrack entries. Globally it lowers the number of probes in flight
+// (halving it on a spike, growing it back step by step), per /24 (IPv4) or
+// /48 (IPv6) it spaces out the probes of a subnet that keeps failing.
+type backoff struct {
+	log   *slog.Logger
+	clock clock.Clock
+	wake  chan struct{}
+
+	mu       sync.Mutex
+	max      int
+	limit    int
+	inflight int
+	seen     int
+	bad      int
+	baseline float64
+	warm     bool
+	subnets  map[netip.Prefix]*subnetBackoff
+}
+
+// subnetBackoff tracks a subnet that has seen timeouts or resets.
+type subnetBackoff struct {
+	seen     int
+	bad      int
+	interval time.Duration
+	next     time.Time
+}
+
+func newBackoff(concurrency int, log *slog.Logger, c clock.Clock) *backoff {
+	return &backoff{
+		log:     log,
+		clock:   c,
+		wake:    make(chan struct{}, 1),
+		max:     concurrency,
+		limit:   concurrency,
+		subnets: make(map[netip.Prefix]*subnetBackoff),
+	}
+}
+
+// acquire waits until another probe may be started; it returns false when
+// ctx is canceled first. A nil b never waits.
+func (b *backoff) acquire(ctx context.Context) bool {
+	if b == nil {
+		return true
+	}
+	for {
+		b.mu.Lock()
+		if b.inflight < b.limit {
+			b.inflight++
+			b.mu.Unlock()
+			return true
+		}
+		b.mu.Unlock()
+		select {
+		case <-b.wake:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// wait returns how long ip's subnet must wait for its next probe. When it
+// is zero, the slot is taken and the probe may be started.
+func (b *backoff) wait(ip netip.Addr) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.subnets[subnetOf(ip)]
+	if s == nil || s.interval == 0 {
+		return 0
+	}
+	now := b.clock.Now()
+	if d := s.next.Sub(now); d > 0 {
+		return d
+	}
+	s.next = now.Add(s.interval)
+	return 0
+}
+
+// done records the result of a probe started by acquire.
+func (b *backoff) done(ip netip.Addr, r probe.Result) {
+	if b == nil {
+		return
+	}
+	bad := !r.OK && congested(r.Error)
+
+	b.mu.Lock()
+	b.inflight--
+	b.seen++
+	if bad {
+		b.bad++
+	}
+	if b.seen >= backoffWindow {
+		b.adjust()
+	}
+	b.observeSubnet(ip, bad)
+	b.mu.Unlock()
+
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// adjust evaluates a full window of results. b.mu is held.
+func (b *backoff) adjust() {
+	rate := float64(b.bad) / float64(b.seen)
+	b.seen, b.bad = 0, 0
+	if !b.warm {
+		// The first window sets the baseline unless it already looks like
+		// a spike, which is then compared against a clean start.
+		b.warm = true
+		if rate < backoffSpike {
+			b.baseline = rate
+			return
+		}
+	}
+	defer func() { b.baseline += backoffAlpha * (rate - b.baseline) }()
+
+	switch {
+	case rate >= b.baseline+backoffSpike && b.limit > 1:
+		b.limit = max(1, b.limit/2)
+		b.log.Debug("backing off: probes time out or are reset", logging.Phase("search"), slog.Float64("bad_rate", rate), slog.Int("concurrency", b.limit))
+	case rate <= b.baseline+backoffRecover && b.limit < b.max:
+		b.limit = min(b.max, b.limit+max(1, b.max/8))
+		if b.limit == b.max {
+			b.log.Debug("backoff recovered", logging.Phase("search"), slog.Int("concurrency", b.limit))
+		}
+	}
+}
+
+// observeSubnet updates the backoff of ip's subnet. b.mu is held.
+func (b *backoff) observeSubnet(ip netip.Addr, bad bool) {
+	key := subnetOf(ip)
+	s := b.subnets[key]
+	if s == nil {
+		if !bad || len(b.subnets) >= maxSubnets {
+			return
+		}
+		s = &subnetBackoff{}
+		b.subnets[key] = s
+	}
+	s.seen++
+	if bad {
+		s.bad++
+	}
+	if s.seen < subnetWindow {
+		return
+	}
+
+	rate := float64(s.bad) / float64(s.seen)
+	s.seen, s.bad = 0, 0
+	switch {
+	case rate >= subnetSpike:
+		if s.interval == 0 {
+			s.interval = subnetMinInterval
+			b.log.Debug("backing off subnet: its probes time out or are reset", logging.Phase("search"), logging.Subnet(key), slog.Float64("bad_rate", rate), slog.Duration("interval", s.interval))
+		} else {
+			s.interval = min(subnetMaxInterval, 2*s.interval)
+		}
+	case rate <= subnetRecover:
+		s.interval /= 2
+		if s.interval < subnetMinInterval {
+			delete(b.subnets, key)
+		}
+	}
+}
+
+// subnetOf returns the /24 (IPv4) or /48 (IPv6) of ip.
+func subnetOf(ip netip.Addr) netip.Prefix {
+	bits := 24
+	if ip.Is6() {
+		bits = 48
+	}
+	p, _ := ip.Prefix(bits)
+	return p
+}
+
+// congested reports whether a probe error is a timeout or a connection
+// reset, the failures that rate limiting and conntrack exhaustion cause.
+func congested(err string) bool {
+	err = strings.ToLower(err)
+	for _, s := range []string{
+		"timeout", // also "i/o timeout", "TLS handshake timeout", ...
+		"connection reset",
+		"forcibly closed", // Windows WSAECONNRESET
+	} {
+		if strings.Contains(err, s) {
+			return true
+		}
+	}
+	return false
+}
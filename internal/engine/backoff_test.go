@@ -0,0 +1,148 @@
+package engine
+
+import (
+	"context"
+	"log/slog"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/clock"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/probe"
+)
+
+var epoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// feed reports n probe results of ip to b, the first bad of them timeouts.
+func feed(t *testing.T, b *backoff, ip netip.Addr, n, bad int) {
+	t.Helper()
+	for i := range n {
+		if !b.acquire(context.Background()) {
+			t.Fatal("acquire failed")
+		}
+		r := probe.Result{IP: ip, OK: true}
+		if i < bad {
+			r = probe.Result{IP: ip, Error: "i/o timeout"}
+		}
+		b.done(ip, r)
+	}
+}
+
+func TestBackoffLimit(t *testing.T) {
+	// Each window is backoffWindow results, of which bad time out.
+	tests := []struct {
+		name    string
+		windows []int
+		want    []int // limit after each window
+	}{
+		{"clean", []int{0, 0, 0}, []int{8, 8, 8}},
+		{"spike and recovery", []int{0, 25, 25, 0, 0, 0, 0, 0, 0}, []int{8, 4, 2, 3, 4, 5, 6, 7, 8}},
+		{"spike from the start", []int{25}, []int{4}},
+		{"floor", []int{0, 50, 50, 50, 50}, []int{8, 4, 2, 1, 1}},
+		{"dead range sets the baseline", []int{10, 20, 35}, []int{8, 8, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBackoff(8, slog.New(slog.DiscardHandler), clock.NewFake(epoch))
+			for i, bad := range tt.windows {
+				// Spread the results over subnets so that none backs off.
+				feed(t, b, netip.AddrFrom4([4]byte{10, byte(i), 0, 1}), backoffWindow, bad)
+				if b.limit != tt.want[i] {
+					t.Fatalf("limit after window %d = %d, want %d", i, b.limit, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBackoffAcquireAtLimit(t *testing.T) {
+	b := newBackoff(2, slog.New(slog.DiscardHandler), clock.NewFake(epoch))
+	ctx, cancel := context.WithCancel(context.Background())
+	b.acquire(ctx)
+	b.acquire(ctx)
+	cancel()
+	if b.acquire(ctx) {
+		t.Fatal("acquire beyond the limit succeeded")
+	}
+}
+
+func TestBackoffSubnet(t *testing.T) {
+	// Each window is subnetWindow results, all bad or all good.
+	tests := []struct {
+		name    string
+		ip      string
+		windows []bool
+		want    []time.Duration // interval after each window
+	}{
+		{"widen to the cap", "1.0.0.1", []bool{true, true, true, true, true}, []time.Duration{250 * time.Millisecond, 500 * time.Millisecond, time.Second, 2 * time.Second, 2 * time.Second}},
+		{"recover", "1.0.0.1", []bool{true, true, false, false}, []time.Duration{250 * time.Millisecond, 500 * time.Millisecond, 250 * time.Millisecond, 0}},
+		{"clean", "1.0.0.1", []bool{false}, []time.Duration{0}},
+		{"ipv6", "2606:4700::1", []bool{true}, []time.Duration{250 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBackoff(8, slog.New(slog.DiscardHandler), clock.NewFake(epoch))
+			ip := netip.MustParseAddr(tt.ip)
+			for i, bad := range tt.windows {
+				n := 0
+				if bad {
+					n = subnetWindow
+				}
+				feed(t, b, ip, subnetWindow, n)
+				var got time.Duration
+				if s := b.subnets[subnetOf(ip)]; s != nil {
+					got = s.interval
+				}
+				if got != tt.want[i] {
+					t.Fatalf("interval after window %d = %s, want %s", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBackoffSubnetWait(t *testing.T) {
+	c := clock.NewFake(epoch)
+	b := newBackoff(8, slog.New(slog.DiscardHandler), c)
+	ip := netip.MustParseAddr("1.0.0.1")
+	feed(t, b, ip, subnetWindow, subnetWindow)
+
+	steps := []struct {
+		ip      string
+		advance time.Duration
+		want    time.Duration
+	}{
+		{"1.0.0.1", 0, 0}, // takes the slot
+		{"1.0.0.2", 0, 250 * time.Millisecond},
+		{"1.0.0.1", 100 * time.Millisecond, 150 * time.Millisecond},
+		{"1.0.1.1", 0, 0}, // another /24
+		{"1.0.0.1", 150 * time.Millisecond, 0},
+		{"1.0.0.1", 0, 250 * time.Millisecond},
+	}
+	for i, s := range steps {
+		c.Advance(s.advance)
+		if got := b.wait(netip.MustParseAddr(s.ip)); got != s.want {
+			t.Fatalf("step %d: wait(%s) = %s, want %s", i, s.ip, got, s.want)
+		}
+	}
+}
+
+func TestCongested(t *testing.T) {
+	tests := []struct {
+		err  string
+		want bool
+	}{
+		{"dial tcp 1.0.0.1:443: i/o timeout", true},
+		{"net/http: TLS handshake timeout", true},
+		{"read tcp: connection reset by peer", true},
+		{"wsarecv: An existing connection was forcibly closed by the remote host.", true},
+		{"dial tcp 1.0.0.1:443: connect: connection refused", false},
+		{"unexpected status 403", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := congested(tt.err); got != tt.want {
+			t.Errorf("congested(%q) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package engine
+
+import (
+	"maps"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/bandit"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/cidr"
+)
+
+// State is the resumable state of a search: the statistics of every prefix
+// in the tree, the top results and the number of probes done. In a run over
+// both IPv4 and IPv6, Completed counts the IPv4 probes and CompletedV6 the
+// IPv6 ones.
+type State struct {
+	Completed   int64             `json:"completed"`
+	CompletedV6 int64             `json:"completed_v6,omitempty"`
+	Arms        []bandit.ArmState `json:"arms"`
+	Top         []TopResult       `json:"top"`
+}
+
+// family returns the part of s that belongs to one family's search of a run
+// over both families.
+func (s *State) family(v6 bool) *State {
+	f := &State{Completed: s.Completed}
+	if v6 {
+		f.Completed = s.CompletedV6
+	}
+	for _, a := range s.Arms {
+		if a.Prefix.Addr().Is6() == v6 {
+			f.Arms = append(f.Arms, a)
+		}
+	}
+	for _, r := range s.Top {
+		if r.IP.Is6() == v6 {
+			f.Top = append(f.Top, r)
+		}
+	}
+	return f
+}
+
+// checkpointer hands the state of a run to Config.Checkpoint without
+// holding up the search. The scorer only copies the arms that changed since
+// its last checkpoint and the top results; a background goroutine merges
+// them into its own copy of the tree and saves that, however long it takes.
+type checkpointer struct {
+	save func(*State)
+	wake chan struct{}
+	done chan struct{}
+
+	mu        sync.Mutex
+	pending   map[netip.Prefix]bandit.ArmState
+	top       [2][]TopResult // per search; [1] is IPv6 in a run over both
+	completed [2]int64
+
+	arms map[netip.Prefix]bandit.ArmState // owned by the goroutine
+}
+
+func newCheckpointer(save func(*State)) *checkpointer {
+	c := &checkpointer{
+		save:    save,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		pending: make(map[netip.Prefix]bandit.ArmState),
+		arms:    make(map[netip.Prefix]bandit.ArmState),
+	}
+	go c.run()
+	return c
+}
+
+// publish queues the changes of search slot for the next save.
+func (c *checkpointer) publish(slot int, arms []bandit.ArmState, top []TopResult, completed int64) {
+	c.mu.Lock()
+	for _, a := range arms {
+		c.pending[a.Prefix] = a
+	}
+	c.top[slot], c.completed[slot] = top, completed
+	c.mu.Unlock()
+	select {
+	case c.wake <- struct{}{}:
+	default: // a save is already due
+	}
+}
+
+// stop saves the last published state and returns once it is written.
+func (c *checkpointer) stop() {
+	close(c.wake)
+	<-c.done
+}
+
+func (c *checkpointer) run() {
+	defer close(c.done)
+	for range c.wake {
+		c.mu.Lock()
+		pending := c.pending
+		c.pending = make(map[netip.Prefix]bandit.ArmState)
+		st := &State{
+			Completed:   c.completed[0],
+			CompletedV6: c.completed[1],
+			Top:         slices.Concat(c.top[0], c.top[1]),
+		}
+		c.mu.Unlock()
+
+		maps.Copy(c.arms, pending)
+		st.Arms = slices.SortedFunc(maps.Values(c.arms), func(a, b bandit.ArmState) int {
+			return cidr.Compare(a.Prefix, b.Prefix)
+		})
+		c.save(st)
+	}
+}
+
+// markDirty records that the arm of prefix changed since the last
+// checkpoint. Called from the scorer only.
+func (e *Engine) markDirty(prefix netip.Prefix) {
+	if e.ckpt != nil {
+		e.dirty[prefix] = struct{}{}
+	}
+}
+
+// checkpoint publishes the arms changed since the last call, the top
+// results and the probe count. Called from the scorer only.
+func (e *Engine) checkpoint() {
+	if e.ckpt == nil {
+		return
+	}
+	arms := make([]bandit.ArmState, 0, len(e.dirty))
+	for prefix := range e.dirty {
+		if node := e.tree.GetNode(prefix); node != nil {
+			arms = append(arms, node.State())
+		}
+	}
+	clear(e.dirty)
+	e.ckpt.publish(e.ckptSlot, arms, e.topN.Snapshot(), atomic.LoadInt64(&e.completed))
+	e.lastCkpt = e.cfg.Clock.Now()
+}
+
+// resume restores the tree, the top results and the probe count of st.
+// The top IPs count as sampled, so the search does not probe them again.
+func (e *Engine) resume(st *State) error {
+	if err := e.tree.Restore(st.Arms); err != nil {
+		return err
+	}
+	for _, r := range st.Top {
+		e.topN.Consider(r)
+		e.seen.add(r.IP)
+	}
+	e.completed, e.submitted = st.Completed, st.Completed
+	return nil
+}
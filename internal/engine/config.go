@@ -3,11 +3,18 @@
 package engine
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/netip"
 	"time"
 
 	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/bandit"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/clock"
 	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/probe"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/probecache"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/ratelimit"
 )
 
 // Config holds all configuration for the search engine.
@@ -15,6 +22,21 @@ type Config struct {
 	// Budget is the total number of probes to perform.
 	Budget int
 
+	// BudgetV6 and ConcurrencyV6 are the parts of Budget and Concurrency
+	// given to the IPv6 search when a run covers both families; each family
+	// then runs its own search in parallel with the rest for IPv4, and
+	// each keeps half of the TopN results (more if the other family has
+	// too few), ranked together. They must be less than Budget and
+	// Concurrency; 0 = half of them.
+	BudgetV6      int
+	ConcurrencyV6 int
+
+	// Deadline, if set, stops drawing new probes at that time (on Clock),
+	// even with budget left; the search then ends as if the budget were
+	// spent, so a Coarse search still refines its best IPs. Unlike a
+	// canceled context it keeps the results whole.
+	Deadline time.Time
+
 	// TopN is the number of top results to keep.
 	TopN int
 
@@ -45,8 +67,18 @@ type Config struct {
 	// Seed is the random seed (0 = time-based).
 	Seed int64
 
-	// Verbose enables progress output to stderr.
-	Verbose bool
+	// NewSource, if set, creates the random source of each search head from
+	// its seed instead of math/rand.NewSource.
+	NewSource func(seed int64) rand.Source
+
+	// Clock is the time source of the time-based seed, the backoff, the
+	// checkpoint interval and the progress lines (default clock.System).
+	// Tests drive a clock.Fake to make them deterministic.
+	Clock clock.Clock
+
+	// Logger receives the progress of the search at debug level and a line
+	// per probe at logging.LevelTrace (default slog.Default()).
+	Logger *slog.Logger
 
 	// SplitInterval is how often to check for split opportunities (by samples).
 	SplitInterval int
@@ -59,14 +91,74 @@ type Config struct {
 
 	// ColoBlock is a blacklist of CDN colo codes; results with colo in this list do not enter TopN. Empty = no filter.
 	ColoBlock []string
+
+	// DedupeFPRate is the false-positive rate of the bloom filter that
+	// remembers the sampled IPs of searches with a budget of millions, where
+	// an exact set would take gigabytes (default 0.001). A false positive
+	// skips an IP that was never probed.
+	DedupeFPRate float64
+
+	// DisableBackoff turns off the automatic slow-down while timeouts and
+	// connection resets spike.
+	DisableBackoff bool
+
+	// Limiter, if set, caps the probe rate: every probe, on every worker and
+	// in both families' searches, waits for one of its tokens. Cache hits
+	// are not limited.
+	Limiter *ratelimit.Limiter
+
+	// Coarse, if set, measures the IPs during the search in place of the
+	// HTTP trace probe, e.g. with raw SYN probes that scale to far larger
+	// budgets. The best 4×TopN IPs are then probed with the HTTP trace,
+	// which ranks them and fills in status and colo.
+	Coarse func(ctx context.Context, ip netip.Addr) probe.Result
+
+	// TraceProbe, if set, measures the IPs in place of the HTTP trace probe,
+	// in the search and in the refinement of a Coarse search alike, e.g.
+	// with the synthetic results of a simulation. Cache does not apply.
+	TraceProbe func(ctx context.Context, ip netip.Addr) probe.Result
+
+	// Cache, if set, answers the HTTP trace probes of IPs measured recently
+	// with the same probe settings, and stores the new results.
+	Cache *probecache.Cache
+
+	// Checkpoint, if set, receives the state of the search every
+	// CheckpointInterval (default 10s) and when it ends, e.g. to save it to
+	// disk for Resume. It is called from a background goroutine with a copy
+	// of the state, so a slow save never holds up the probe workers.
+	Checkpoint         func(*State)
+	CheckpointInterval time.Duration
+
+	// Resume, if set, continues the search saved in this state, with its
+	// tree, top results and probe count, over the same CIDRs.
+	Resume *State
+
+	// Explain, if set, attaches an Explain of its score to every top
+	// result.
+	Explain bool
+
+	// OnResult, if set, is called with every probe result, before the colo
+	// filter, e.g. to stream all results to disk. Calls come from a single
+	// goroutine.
+	OnResult func(TopResult)
+
+	// OnTop, if set, is called while the search runs with every result
+	// that enters the top results, or improves on its IP's result there,
+	// along with its rank among them (0 = best), e.g. to start testing the
+	// candidates before the search ends. A coarse search ranks its
+	// candidates before refine, and a search over both families ranks
+	// each family on its own. Calls come from a single goroutine and must
+	// not block.
+	OnTop func(r TopResult, rank int)
 }
 
 // Request holds the input for a search run.
 type Request struct {
-	// CIDRs is a list of CIDR strings to search.
-	CIDRs []string
+	// CIDRs are the prefixes to search.
+	CIDRs []netip.Prefix
 
-	// CIDRFile is a path to a file containing CIDRs.
+	// CIDRFile is a path to a file containing CIDRs, IPs or ranges, read
+	// at every run.
 	CIDRFile string
 
 	// Probe is the probe configuration.
@@ -87,7 +179,6 @@ func DefaultConfig() Config {
 		MaxBitsV4:       24,
 		MaxBitsV6:       56,
 		Seed:            0,
-		Verbose:         false,
 		SplitInterval:   20, // Check more frequently
 		DiversityWeight: 0.3,
 	}
@@ -104,6 +195,15 @@ func (c *Config) Validate() error {
 	if c.Concurrency <= 0 {
 		return fmt.Errorf("concurrency must be > 0, got %d", c.Concurrency)
 	}
+	if c.BudgetV6 < 0 {
+		return fmt.Errorf("budgetV6 must be >= 0, got %d", c.BudgetV6)
+	}
+	if c.ConcurrencyV6 < 0 {
+		return fmt.Errorf("concurrencyV6 must be >= 0, got %d", c.ConcurrencyV6)
+	}
+	if c.DedupeFPRate >= 1 {
+		return fmt.Errorf("dedupeFPRate must be in (0,1), got %g", c.DedupeFPRate)
+	}
 	if c.Heads <= 0 {
 		return fmt.Errorf("heads must be > 0, got %d", c.Heads)
 	}
@@ -174,6 +274,16 @@ func (c *Config) ApplyDefaults() {
 	if c.DiversityWeight <= 0 {
 		c.DiversityWeight = defaults.DiversityWeight
 	}
+	if c.DedupeFPRate <= 0 {
+		c.DedupeFPRate = 0.001
+	}
+	if c.CheckpointInterval <= 0 {
+		c.CheckpointInterval = 10 * time.Second
+	}
+	c.Clock = clock.Or(c.Clock)
+	if c.Logger == nil {
+		c.Logger = slog.Default()
+	}
 }
 
 // ToTreeConfig converts to bandit.TreeConfig.
@@ -196,6 +306,7 @@ func (c *Config) ToHeadManagerConfig(timeoutMS float64) bandit.HeadManagerConfig
 		HistorySize:     c.Beam,
 		DiversityWeight: c.DiversityWeight,
 		RepulsionDecay:  0.5,
+		NewSource:       c.NewSource,
 	}
 }
 
@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"hash/maphash"
+	"math"
+	"net/netip"
+)
+
+// bloomBudget is the budget above which the sampled IPs are remembered in a
+// bloom filter instead of an exact set. A map costs some 50 bytes per IP,
+// gigabytes at tens of millions of samples; the filter costs
+// -ln(p)/ln(2)² bits per IP, about 15 at p = 0.001, and a false positive
+// only means drawing another IP.
+const bloomBudget = 4 << 20
+
+// seenSet remembers the IPs drawn so far. Not safe for concurrent use.
+type seenSet interface {
+	// add adds ip and reports whether it was new.
+	add(ip netip.Addr) bool
+}
+
+// newSeenSet returns the set for a search of budget IPs: exact up to
+// bloomBudget, a bloom filter with false-positive rate fpRate above.
+func newSeenSet(budget int, fpRate float64) seenSet {
+	if budget <= bloomBudget {
+		return exactSet(make(map[netip.Addr]struct{}, min(budget, 1<<20)))
+	}
+	return newBloom(budget, fpRate)
+}
+
+// exactSet is a plain map: sync.Map would box every key into an interface,
+// allocating on each draw.
+type exactSet map[netip.Addr]struct{}
+
+func (s exactSet) add(ip netip.Addr) bool {
+	if _, seen := s[ip]; seen {
+		return false
+	}
+	s[ip] = struct{}{}
+	return true
+}
+
+// bloom is a bloom filter sized for n IPs; its memory does not grow with
+// the IPs added.
+type bloom struct {
+	bits   []uint64
+	k      uint64
+	h1, h2 maphash.Seed
+}
+
+func newBloom(n int, fpRate float64) *bloom {
+	m := math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	k := max(1, math.Round(-math.Log2(fpRate)))
+	return &bloom{
+		bits: make([]uint64, (uint64(m)+63)/64),
+		k:    uint64(k),
+		h1:   maphash.MakeSeed(),
+		h2:   maphash.MakeSeed(),
+	}
+}
+
+func (b *bloom) add(ip netip.Addr) bool {
+	// Double hashing: the k positions are h1 + i*h2.
+	h1, h2 := maphash.Comparable(b.h1, ip), maphash.Comparable(b.h2, ip)|1
+	m := uint64(len(b.bits)) * 64
+	added := false
+	for i := range b.k {
+		pos := (h1 + i*h2) % m
+		word, bit := pos/64, uint64(1)<<(pos%64)
+		if b.bits[word]&bit == 0 {
+			b.bits[word] |= bit
+			added = true
+		}
+	}
+	return added
+}
@@ -4,17 +4,26 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"maps"
 	"net/netip"
-	"os"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/bandit"
 	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/cidr"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/clock"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/logging"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/pipeline"
 	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/probe"
 )
 
+// coarseFactor is how many more candidates than TopN a coarse search keeps
+// for refine.
+const coarseFactor = 4
+
 // Engine is the core search engine using hierarchical Thompson Sampling.
 type Engine struct {
 	cfg      Config
@@ -24,16 +33,39 @@ type Engine struct {
 	headManager *bandit.HeadManager
 	topN        *TopNCollector
 
-	// Worker coordination
-	tasks chan probeTask
-	done  chan probeDone
-
 	// Statistics
 	submitted int64
 	completed int64
 
-	// Deduplication using atomic map
-	seenIPs sync.Map
+	// Deduplication of sampled IPs.
+	seenMu sync.Mutex
+	seen   seenSet
+
+	// stats aggregates every probe result.
+	stats aggregator
+
+	// backoff slows probing while timeouts and resets spike; nil when
+	// disabled.
+	backoff *backoff
+
+	// expired is set when the sampler stopped at Config.Deadline.
+	expired atomic.Bool
+
+	// started is set once tree and topN are initialized, so Progress can
+	// read them from other goroutines.
+	started atomic.Bool
+
+	// families holds the per-family searches of a run over both IPv4 and
+	// IPv6, which Progress then sums up.
+	families atomic.Pointer[familySearch]
+
+	// ckpt saves the state of the run, under ckptSlot; nil without
+	// Config.Checkpoint. dirty holds the prefixes whose arms changed since
+	// the scorer last published them, at lastCkpt.
+	ckpt     *checkpointer
+	ckptSlot int
+	dirty    map[netip.Prefix]struct{}
+	lastCkpt time.Time
 }
 
 type probeTask struct {
@@ -70,118 +102,313 @@ func (e *Engine) Run(ctx context.Context, req Request) (Response, error) {
 	if len(prefixes) == 0 {
 		return Response{}, errors.New("no CIDR provided (use --cidr or --cidr-file)")
 	}
+	if e.cfg.Checkpoint != nil {
+		e.ckpt = newCheckpointer(e.cfg.Checkpoint)
+		defer e.ckpt.stop()
+	}
+	if v4, v6 := splitFamilies(prefixes); len(v4) > 0 && len(v6) > 0 {
+		return e.runFamilies(ctx, req, v4, v6)
+	}
+	return e.run(ctx, req, prefixes, e.cfg.Resume)
+}
 
+// run searches prefixes, continuing from resume if it is not nil.
+func (e *Engine) run(ctx context.Context, req Request, prefixes []netip.Prefix, resume *State) (Response, error) {
 	// Initialize seed
 	seed := e.cfg.Seed
 	if seed == 0 {
-		seed = time.Now().UnixNano()
+		seed = e.cfg.Clock.Now().UnixNano()
 	}
+	hmCfg := e.cfg.ToHeadManagerConfig(req.TimeoutMS())
+	hmCfg.BaseSeed = seed
 
 	// Initialize components
 	timeoutMS := req.TimeoutMS()
 	e.tree = bandit.NewArmTree(prefixes, e.cfg.ToTreeConfig())
-	e.headManager = bandit.NewHeadManager(e.cfg.ToHeadManagerConfig(timeoutMS))
-	e.topN = NewTopNCollector(e.cfg.TopN)
+	e.headManager = bandit.NewHeadManager(hmCfg)
+	topN := e.cfg.TopN
+	if e.cfg.Coarse != nil {
+		topN *= coarseFactor
+	}
+	e.topN = NewTopNCollector(topN)
+	e.seen = newSeenSet(e.cfg.Budget, e.cfg.DedupeFPRate)
+	if !e.cfg.DisableBackoff {
+		e.backoff = newBackoff(e.cfg.Concurrency, e.cfg.Logger, e.cfg.Clock)
+	}
+	if resume != nil {
+		if err := e.resume(resume); err != nil {
+			return Response{}, fmt.Errorf("resume: %w", err)
+		}
+	}
+	e.dirty = make(map[netip.Prefix]struct{})
+	e.lastCkpt = e.cfg.Clock.Now()
+	e.started.Store(true)
 
-	// Initialize channels
-	e.tasks = make(chan probeTask, e.cfg.Concurrency*2)
-	e.done = make(chan probeDone, e.cfg.Concurrency*2)
+	// The search is a pipeline: the sampler draws IPs as fast as the probe
+	// workers take them, and the scorer feeds the results back into the tree
+	// the sampler draws from. Because the stages hand over one task at a
+	// time, a draw never runs far ahead of the statistics it is based on.
+	tasks := e.sample(ctx)
+	done := pipeline.Map(ctx, tasks, e.cfg.Concurrency, e.guarded("search", e.prober(req.Probe)))
+	err := e.score(ctx, done, timeoutMS)
 
-	// Start workers
-	var wg sync.WaitGroup
-	for i := 0; i < e.cfg.Concurrency; i++ {
-		wg.Add(1)
-		go e.worker(ctx, &wg, req.Probe)
+	if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		return Response{}, err
 	}
 
-	// Run main event-driven scheduling loop
-	err = e.schedule(ctx, timeoutMS)
+	top := e.topN.Snapshot()
+	if e.cfg.Coarse != nil {
+		if err == nil {
+			top = e.refine(ctx, top, req.Probe, timeoutMS)
+		} else {
+			// An interrupted search keeps its coarse ranking.
+			top = top[:min(len(top), e.cfg.TopN)]
+		}
+	}
+	if e.cfg.Explain {
+		e.explain(top, timeoutMS)
+	}
+	return Response{Top: top, Stats: e.stats.snapshot(), Expired: e.expired.Load()}, nil
+}
 
-	// Cleanup
-	close(e.tasks)
-	wg.Wait()
-	close(e.done)
+// Progress is a point-in-time view of a running search.
+type Progress struct {
+	Submitted int64     `json:"submitted"`
+	Completed int64     `json:"completed"`
+	Budget    int       `json:"budget"`
+	Nodes     int       `json:"nodes"`
+	Best      TopResult `json:"best"`
+}
 
-	// Drain any remaining results
-	for d := range e.done {
-		e.processOneResult(d, timeoutMS)
+// Progress returns the current search progress. It is safe to call
+// concurrently with Run.
+func (e *Engine) Progress() Progress {
+	if fam := e.families.Load(); fam != nil {
+		return fam.progress()
+	}
+	p := Progress{
+		Submitted: atomic.LoadInt64(&e.submitted),
+		Completed: atomic.LoadInt64(&e.completed),
+		Budget:    e.cfg.Budget,
+	}
+	if e.started.Load() {
+		p.Nodes = e.tree.Size()
+		p.Best = e.topN.Best()
 	}
+	return p
+}
 
-	if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
-		return Response{}, err
+// Top returns the current top results, best first. It is safe to call
+// concurrently with Run.
+func (e *Engine) Top() []TopResult {
+	if fam := e.families.Load(); fam != nil {
+		return fam.top()
+	}
+	if !e.started.Load() {
+		return nil
 	}
+	return e.topN.Snapshot()
+}
 
-	return Response{Top: e.topN.Snapshot()}, nil
+// sample is the sampler stage: it draws Budget tasks, spreading them over
+// the heads, and stops early at the Deadline or when ctx is canceled.
+func (e *Engine) sample(ctx context.Context) <-chan probeTask {
+	out := make(chan probeTask)
+	go func() {
+		defer close(out)
+		for n := int(atomic.LoadInt64(&e.completed)); n < e.cfg.Budget; n++ {
+			if !e.cfg.Deadline.IsZero() && !e.cfg.Clock.Now().Before(e.cfg.Deadline) {
+				e.cfg.Logger.Debug("deadline reached; ending the search", logging.Phase("search"), slog.Int("drawn", n), slog.Int("budget", e.cfg.Budget))
+				e.expired.Store(true)
+				return
+			}
+			task, ok := e.drawTask(ctx, n%e.cfg.Heads)
+			if ctx.Err() != nil {
+				return
+			}
+			if !ok {
+				continue
+			}
+			if !e.backoff.acquire(ctx) {
+				return
+			}
+			select {
+			case out <- task:
+				atomic.AddInt64(&e.submitted, 1)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
 }
 
-// schedule is the main event-driven scheduling loop.
-func (e *Engine) schedule(ctx context.Context, timeoutMS float64) error {
-	start := time.Now()
-	lastLog := time.Now()
-	lastSplit := int64(0)
+// drawTask draws the next task like nextTask, steering clear of the subnets
+// that the backoff spaces out: it draws again a few times and otherwise
+// waits for the subnet's next slot.
+func (e *Engine) drawTask(ctx context.Context, headID int) (probeTask, bool) {
+	task, ok := e.nextTask(headID)
+	for try := 0; ok && try < maxRedraws; try++ {
+		if e.backoff.wait(task.ip) == 0 {
+			return task, true
+		}
+		task, ok = e.nextTask(headID)
+	}
+	for ok {
+		d := e.backoff.wait(task.ip)
+		if d == 0 {
+			return task, true
+		}
+		if !sleep(ctx, e.cfg.Clock, d) {
+			return task, false
+		}
+	}
+	return task, false
+}
 
-	// Initial fill - submit initial batch of tasks
-	initialBatch := e.cfg.Concurrency * 2
-	if initialBatch > e.cfg.Budget {
-		initialBatch = e.cfg.Budget
+// sleep waits for d on c; it returns false if ctx is canceled first.
+func sleep(ctx context.Context, c clock.Clock, d time.Duration) bool {
+	t := c.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return true
+	case <-ctx.Done():
+		return false
 	}
+}
 
-	for i := 0; i < initialBatch; i++ {
-		headID := i % e.cfg.Heads
-		if err := e.submitOneTask(ctx, headID); err != nil {
-			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
-				return err
-			}
+// prober returns the probe stage, which measures the latency of a task's IP
+// with Config.Coarse if set, or else the HTTP trace.
+func (e *Engine) prober(probeCfg probe.Config) func(context.Context, probeTask) probeDone {
+	if coarse := e.cfg.Coarse; coarse != nil {
+		coarse = e.limited(coarse)
+		return func(ctx context.Context, task probeTask) probeDone {
+			return probeDone{task: task, result: coarse(ctx, task.ip)}
 		}
 	}
+	return e.httpProber(probeCfg)
+}
 
-	// Main event loop - process results and submit new tasks
-	for atomic.LoadInt64(&e.completed) < int64(e.cfg.Budget) {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
+// httpProber returns a probe stage that measures with the HTTP trace, or
+// takes the result from Config.Cache, or measures with Config.TraceProbe.
+func (e *Engine) httpProber(probeCfg probe.Config) func(context.Context, probeTask) probeDone {
+	if trace := e.cfg.TraceProbe; trace != nil {
+		trace = e.limited(trace)
+		return func(ctx context.Context, task probeTask) probeDone {
+			return probeDone{task: task, result: trace(ctx, task.ip)}
+		}
+	}
+	prober := probe.NewProber(probeCfg)
 
-		case d := <-e.done:
-			// Process the completed probe
-			e.processOneResult(d, timeoutMS)
-			completed := atomic.AddInt64(&e.completed, 1)
+	// Calculate timeout for multiple rounds
+	rounds := probeCfg.Rounds
+	if rounds <= 0 {
+		rounds = 6
+	}
+	multiTimeout := probeCfg.Timeout * time.Duration(rounds)
 
-			// Check if we need to split - more aggressive splitting
-			if completed-lastSplit >= int64(e.cfg.SplitInterval) {
-				e.trySplit()
-				lastSplit = completed
-			}
+	measure := e.limited(func(ctx context.Context, ip netip.Addr) probe.Result {
+		pctx, cancel := context.WithTimeout(ctx, multiTimeout)
+		defer cancel()
+		return prober.ProbeHTTPTraceMulti(pctx, ip)
+	})
+	if e.cfg.Cache != nil {
+		measure = e.cfg.Cache.Wrap(probeCfg, measure)
+	}
+	return func(ctx context.Context, task probeTask) probeDone {
+		return probeDone{task: task, result: measure(ctx, task.ip)}
+	}
+}
 
-			// Submit replacement task if we haven't reached budget
-			submitted := atomic.LoadInt64(&e.submitted)
-			if submitted < int64(e.cfg.Budget) {
-				headID := int(submitted) % e.cfg.Heads
-				if err := e.submitOneTask(ctx, headID); err != nil {
-					// Non-fatal, continue
-				}
-			}
+// guarded makes a panic in the probe stage fn a failed result, counted in
+// Stats.Panics, instead of a crash of the whole run.
+func (e *Engine) guarded(phase string, fn func(context.Context, probeTask) probeDone) func(context.Context, probeTask) probeDone {
+	return func(ctx context.Context, task probeTask) probeDone {
+		var d probeDone
+		err := pipeline.Protect(func() error {
+			d = fn(ctx, task)
+			return nil
+		})
+		var pe *pipeline.PanicError
+		if !errors.As(err, &pe) {
+			return d
+		}
+		e.cfg.Logger.Debug("probe panicked", logging.Phase(phase), logging.IP(task.ip), "panic", pe.Value, "stack", string(pe.Stack))
+		e.stats.addPanic(fmt.Sprintf("%s %s: %v", phase, task.ip, pe))
+		return probeDone{task: task, result: probe.Result{IP: task.ip, When: e.cfg.Clock.Now(), Error: pe.Error()}}
+	}
+}
 
-			// Verbose logging
-			if e.cfg.Verbose && time.Since(lastLog) > time.Second {
-				best := e.topN.Best()
-				elapsed := time.Since(start).Truncate(100 * time.Millisecond)
-				fmt.Fprintf(os.Stderr, "progress: %d/%d done, best=%.1fms ip=%s prefix=%s elapsed=%s nodes=%d\n",
-					completed, e.cfg.Budget, best.ScoreMS, best.IP.String(), best.Prefix.String(), elapsed, e.tree.Size())
-				lastLog = time.Now()
-			}
+// limited makes fn wait for a token of Config.Limiter before each probe.
+func (e *Engine) limited(fn func(context.Context, netip.Addr) probe.Result) func(context.Context, netip.Addr) probe.Result {
+	l := e.cfg.Limiter
+	if l == nil {
+		return fn
+	}
+	return func(ctx context.Context, ip netip.Addr) probe.Result {
+		if err := l.Wait(ctx); err != nil {
+			return probe.Result{IP: ip, When: e.cfg.Clock.Now(), Error: err.Error()}
 		}
+		return fn(ctx, ip)
 	}
+}
+
+// score is the scorer stage: it updates the tree and the top results with
+// each probe result, splits promising prefixes, and logs the progress. It
+// returns once the probe stage is drained, with ctx's error if it was canceled.
+func (e *Engine) score(ctx context.Context, done <-chan probeDone, timeoutMS float64) error {
+	clk := e.cfg.Clock
+	start := clk.Now()
+	lastLog := start
+	lastSplit := int64(0)
+
+	log := e.cfg.Logger
+
+	for d := range done {
+		e.traceProbe(ctx, "search", d)
+		e.backoff.done(d.task.ip, d.result)
+
+		// Failures after cancellation were most likely cut short by it and
+		// carry no signal about the prefix.
+		if ctx.Err() != nil && !d.result.OK {
+			continue
+		}
+		e.stats.add(d.result)
+		e.processOneResult(d, timeoutMS)
+		e.markDirty(d.task.prefix)
+		completed := atomic.AddInt64(&e.completed, 1)
 
-	return nil
+		// Check if we need to split - more aggressive splitting
+		if completed-lastSplit >= int64(e.cfg.SplitInterval) {
+			e.trySplit()
+			lastSplit = completed
+		}
+
+		if clk.Now().Sub(lastLog) > time.Second && log.Enabled(ctx, slog.LevelDebug) {
+			best := e.topN.Best()
+			log.LogAttrs(ctx, slog.LevelDebug, "progress", logging.Phase("search"),
+				slog.Int64("done", completed), slog.Int("budget", e.cfg.Budget),
+				slog.Float64("best_ms", best.ScoreMS), logging.IP(best.IP), logging.Subnet(best.Prefix),
+				slog.Duration("elapsed", clk.Now().Sub(start).Truncate(100*time.Millisecond)), slog.Int("nodes", e.tree.Size()))
+			lastLog = clk.Now()
+		}
+
+		if e.ckpt != nil && clk.Now().Sub(e.lastCkpt) >= e.cfg.CheckpointInterval {
+			e.checkpoint()
+		}
+	}
+	e.checkpoint()
+	return ctx.Err()
 }
 
-// submitOneTask submits a single probe task for a head.
-func (e *Engine) submitOneTask(ctx context.Context, headID int) error {
+// nextTask draws the next probe task for a head; ok is false when there is
+// no prefix to sample from.
+func (e *Engine) nextTask(headID int) (task probeTask, ok bool) {
 	head := e.headManager.GetHead(headID % e.cfg.Heads)
 	if head == nil {
-		return nil
+		return task, false
 	}
-
 	var prefix netip.Prefix
 
 	// Exploitation mode: directly sample from known-good prefixes
@@ -224,18 +451,11 @@ func (e *Engine) submitOneTask(ctx context.Context, headID int) error {
 	}
 
 	if !prefix.IsValid() {
-		return nil
+		return task, false
 	}
 
 	ip := e.sampleIPWithDedup(prefix, head)
-
-	select {
-	case e.tasks <- probeTask{headID: headID, prefix: prefix, ip: ip}:
-		atomic.AddInt64(&e.submitted, 1)
-		return nil
-	case <-ctx.Done():
-		return ctx.Err()
-	}
+	return probeTask{headID: headID, prefix: prefix, ip: ip}, true
 }
 
 // passColoFilter returns true if the result with the given colo should enter TopN.
@@ -272,64 +492,111 @@ func (e *Engine) processOneResult(d probeDone, timeoutMS float64) {
 		stats = node.Stats()
 	}
 
-	// Colo filter: only consider for TopN if colo passes
-	colo := ""
-	if d.result.Trace != nil {
-		colo = d.result.Trace["colo"]
+	r := topResult(d, timeoutMS)
+	r.PrefixSamples = stats.Samples
+	r.PrefixOK = stats.Successes
+	r.PrefixFail = stats.Failures
+	if e.cfg.OnResult != nil {
+		e.cfg.OnResult(r)
 	}
-	if !e.passColoFilter(colo) {
+
+	// Colo filter: only consider for TopN if colo passes. Coarse probes
+	// carry no colo; refine filters their candidates.
+	if e.cfg.Coarse == nil && !e.passColoFilter(r.Trace["colo"]) {
 		return
 	}
 
+	// Add to top N
+	if rank := e.topN.Consider(r); rank >= 0 && e.cfg.OnTop != nil {
+		e.cfg.OnTop(r, rank)
+	}
+}
+
+// topResult converts a probe result, without the prefix statistics.
+func topResult(d probeDone, timeoutMS float64) TopResult {
 	// Calculate score - use actual latency for success, penalty for failure
 	score := float64(d.result.TotalMS)
 	if !d.result.OK {
 		score = timeoutMS * 2
 	}
 
-	// Add to top N
-	e.topN.Consider(TopResult{
-		IP:            d.task.ip,
-		Prefix:        d.task.prefix,
-		OK:            d.result.OK,
-		Status:        d.result.Status,
-		Error:         d.result.Error,
-		ConnectMS:     d.result.ConnectMS,
-		TLSMS:         d.result.TLSMS,
-		TTFBMS:        d.result.TTFBMS,
-		TotalMS:       d.result.TotalMS,
-		ScoreMS:       score,
-		Trace:         d.result.Trace,
-		PrefixSamples: stats.Samples,
-		PrefixOK:      stats.Successes,
-		PrefixFail:    stats.Failures,
-	})
+	return TopResult{
+		IP:        d.task.ip,
+		Prefix:    d.task.prefix,
+		OK:        d.result.OK,
+		Status:    d.result.Status,
+		Error:     d.result.Error,
+		ConnectMS: d.result.ConnectMS,
+		TLSMS:     d.result.TLSMS,
+		TTFBMS:    d.result.TTFBMS,
+		TotalMS:   d.result.TotalMS,
+		JitterMS:  d.result.JitterMS,
+		ScoreMS:   score,
+		Trace:     d.result.Trace,
+	}
 }
 
-// worker runs probe tasks.
-func (e *Engine) worker(ctx context.Context, wg *sync.WaitGroup, probeCfg probe.Config) {
-	defer wg.Done()
-
-	prober := probe.NewProber(probeCfg)
-
-	// Calculate timeout for multiple rounds
-	rounds := probeCfg.Rounds
-	if rounds <= 0 {
-		rounds = 6
+// explain attaches the breakdown of their scores to the results, with the
+// statistics of their subnets at the end of the search.
+func (e *Engine) explain(top []TopResult, timeoutMS float64) {
+	for i := range top {
+		r := &top[i]
+		x := &Explain{JitterMS: r.JitterMS}
+		if r.OK {
+			x.LatencyMS = float64(r.TotalMS)
+		} else {
+			x.FailurePenaltyMS = r.ScoreMS
+		}
+		if node := e.tree.GetNode(r.Prefix); node != nil {
+			s := node.Stats()
+			x.SubnetLoss = 1 - s.SuccessRate
+			x.SubnetMeanMS = s.MeanLatency
+			x.SubnetPriorMS = s.Score(timeoutMS)
+		}
+		r.Explain = x
 	}
-	multiTimeout := probeCfg.Timeout * time.Duration(rounds)
-
-	for task := range e.tasks {
-		pctx, cancel := context.WithTimeout(ctx, multiTimeout)
-		result := prober.ProbeHTTPTraceMulti(pctx, task.ip)
-		cancel()
+}
 
-		select {
-		case e.done <- probeDone{task: task, result: result}:
-		case <-ctx.Done():
-			return
+// refine probes the candidates of a coarse search with the HTTP trace and
+// returns the best TopN of them, ranked on that.
+func (e *Engine) refine(ctx context.Context, candidates []TopResult, probeCfg probe.Config, timeoutMS float64) []TopResult {
+	e.cfg.Logger.Debug("probing the best IPs with the HTTP trace", logging.Phase("refine"), slog.Int("candidates", len(candidates)))
+	tasks := make([]probeTask, len(candidates))
+	byIP := make(map[netip.Addr]TopResult, len(candidates))
+	for i, c := range candidates {
+		tasks[i] = probeTask{prefix: c.Prefix, ip: c.IP}
+		byIP[c.IP] = c
+	}
+
+	top := NewTopNCollector(e.cfg.TopN)
+	done := pipeline.Map(ctx, pipeline.Source(ctx, tasks), e.cfg.Concurrency, e.guarded("refine", e.httpProber(probeCfg)))
+	for d := range done {
+		e.traceProbe(ctx, "refine", d)
+		c := byIP[d.task.ip]
+		r := topResult(d, timeoutMS)
+		r.PrefixSamples, r.PrefixOK, r.PrefixFail = c.PrefixSamples, c.PrefixOK, c.PrefixFail
+		if e.passColoFilter(r.Trace["colo"]) {
+			top.Consider(r)
 		}
 	}
+	return top.Snapshot()
+}
+
+// traceProbe logs a probe result at logging.LevelTrace.
+func (e *Engine) traceProbe(ctx context.Context, phase string, d probeDone) {
+	log := e.cfg.Logger
+	if !log.Enabled(ctx, logging.LevelTrace) {
+		return
+	}
+	r := d.result
+	attrs := []slog.Attr{
+		logging.Phase(phase), logging.IP(d.task.ip), logging.Subnet(d.task.prefix),
+		slog.Bool("ok", r.OK), slog.Int("status", r.Status), slog.Int64("total_ms", r.TotalMS),
+	}
+	if r.Error != "" {
+		attrs = append(attrs, slog.String("error", r.Error))
+	}
+	log.LogAttrs(ctx, logging.LevelTrace, "probe", attrs...)
 }
 
 // trySplit attempts to split promising prefixes.
@@ -345,8 +612,12 @@ func (e *Engine) trySplit() {
 		if splitCount >= maxSplits {
 			break
 		}
-		if e.tree.SplitNode(node) != nil {
+		if children := e.tree.SplitNode(node); children != nil {
 			splitCount++
+			e.markDirty(node.Prefix)
+			for _, c := range children {
+				e.markDirty(c.Prefix)
+			}
 		}
 	}
 
@@ -381,8 +652,8 @@ func (e *Engine) getExploitationPrefixes() []netip.Prefix {
 
 	// Build weighted list: tier1 prefixes appear 3x, tier2 appear 1x
 	var exploitPrefixes []netip.Prefix
-	for prefix, score := range prefixBestScore {
-		if score <= tier1Threshold {
+	for _, prefix := range slices.SortedFunc(maps.Keys(prefixBestScore), cidr.Compare) {
+		if score := prefixBestScore[prefix]; score <= tier1Threshold {
 			// Best prefixes get 3x weight
 			exploitPrefixes = append(exploitPrefixes, prefix, prefix, prefix)
 		} else {
@@ -411,13 +682,13 @@ func (e *Engine) sampleIPWithDedup(prefix netip.Prefix, head *bandit.SearchHead)
 	const maxTries = 32
 	var last netip.Addr
 
+	e.seenMu.Lock()
+	defer e.seenMu.Unlock()
 	for i := 0; i < maxTries; i++ {
 		ip := head.Sampler.SampleIP(prefix)
 		last = ip
 
-		// Use uint128 representation for efficient dedup
-		key := ipToKey(ip)
-		if _, loaded := e.seenIPs.LoadOrStore(key, struct{}{}); !loaded {
+		if e.seen.add(ip) {
 			return ip
 		}
 	}
@@ -426,26 +697,19 @@ func (e *Engine) sampleIPWithDedup(prefix netip.Prefix, head *bandit.SearchHead)
 	return last
 }
 
-// ipToKey converts an IP to a comparable key.
-// Using the IP directly as netip.Addr is comparable and efficient.
-func ipToKey(ip netip.Addr) netip.Addr {
-	return ip
-}
-
 // loadPrefixes loads and deduplicates CIDR prefixes from the request.
 func loadPrefixes(req Request) ([]netip.Prefix, error) {
 	var pfxs []netip.Prefix
 
-	if len(req.CIDRs) > 0 {
-		ps, err := cidr.ParseCIDRs(req.CIDRs)
-		if err != nil {
-			return nil, err
+	for _, p := range req.CIDRs {
+		if !p.IsValid() {
+			return nil, errors.New("invalid prefix in the CIDRs")
 		}
-		pfxs = append(pfxs, ps...)
+		pfxs = append(pfxs, p)
 	}
 
 	if req.CIDRFile != "" {
-		ps, err := cidr.ReadCIDRsFromFile(req.CIDRFile)
+		ps, err := cidr.ReadRangesFile(req.CIDRFile)
 		if err != nil {
 			return nil, err
 		}
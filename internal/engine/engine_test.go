@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"net/netip"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/bandit"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/clock"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/probe"
+)
+
+// draws runs the sampler and the scorer of a search over prefixes in
+// lockstep, without the pipeline between them, and returns the IPs drawn.
+// Each IP's latency is a function of its address, so the draws depend on
+// the seed alone.
+func draws(t *testing.T, seed int64, n int, prefixes ...string) []netip.Addr {
+	t.Helper()
+	e := New(Config{Budget: n, TopN: 10, Concurrency: 1, Seed: seed, Clock: clock.NewFake(epoch)}, probe.Config{})
+	if err := e.cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	var ps []netip.Prefix
+	for _, s := range prefixes {
+		ps = append(ps, netip.MustParsePrefix(s))
+	}
+	const timeoutMS = 3000
+	hmCfg := e.cfg.ToHeadManagerConfig(timeoutMS)
+	hmCfg.BaseSeed = seed
+	e.tree = bandit.NewArmTree(ps, e.cfg.ToTreeConfig())
+	e.headManager = bandit.NewHeadManager(hmCfg)
+	e.topN = NewTopNCollector(e.cfg.TopN)
+	e.seen = newSeenSet(e.cfg.Budget, e.cfg.DedupeFPRate)
+
+	var ips []netip.Addr
+	for i := range n {
+		task, ok := e.nextTask(i % e.cfg.Heads)
+		if !ok {
+			t.Fatalf("draw %d: no prefix to sample from", i)
+		}
+		ips = append(ips, task.ip)
+		b := task.ip.As16()
+		r := probe.Result{IP: task.ip, OK: true, TotalMS: 20 + int64(b[13]%64)*5 + int64(b[15]%8)}
+		e.processOneResult(probeDone{task: task, result: r}, timeoutMS)
+		if completed := atomic.AddInt64(&e.completed, 1); completed%int64(e.cfg.SplitInterval) == 0 {
+			e.trySplit()
+		}
+	}
+	return ips
+}
+
+func TestSampleReproducible(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefixes []string
+	}{
+		{"ipv4", []string{"104.16.0.0/13"}},
+		{"ipv6", []string{"2606:4700::/32"}},
+		{"several", []string{"104.16.0.0/16", "172.64.0.0/16", "162.159.0.0/16"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const n = 500
+			a := draws(t, 42, n, tt.prefixes...)
+			if b := draws(t, 42, n, tt.prefixes...); !slices.Equal(a, b) {
+				i := 0
+				for a[i] == b[i] {
+					i++
+				}
+				t.Fatalf("same seed drew %s and %s at draw %d", a[i], b[i], i)
+			}
+			if c := draws(t, 43, n, tt.prefixes...); slices.Equal(a, c) {
+				t.Fatal("seeds 42 and 43 drew the same IPs")
+			}
+		})
+	}
+}
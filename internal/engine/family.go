@@ -0,0 +1,143 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"sync"
+)
+
+// familySearch is a run over both IPv4 and IPv6 prefixes: one search per
+// family, each with its own tree, share of the budget and probe workers, so
+// a slow IPv6 path does not hold back the IPv4 probes or the other way
+// round.
+type familySearch struct {
+	v4, v6 *Engine
+}
+
+// splitFamilies separates the IPv4 and IPv6 prefixes.
+func splitFamilies(prefixes []netip.Prefix) (v4, v6 []netip.Prefix) {
+	for _, p := range prefixes {
+		if p.Addr().Is4() {
+			v4 = append(v4, p)
+		} else {
+			v6 = append(v6, p)
+		}
+	}
+	return v4, v6
+}
+
+// runFamilies searches v4 and v6 in parallel and merges their results: the
+// top results of both families ranked together, as mergeTop shares them
+// out, and the combined stats.
+func (e *Engine) runFamilies(ctx context.Context, req Request, v4, v6 []netip.Prefix) (Response, error) {
+	budget6, err := familyShare("budget", e.cfg.Budget, e.cfg.BudgetV6)
+	if err != nil {
+		return Response{}, err
+	}
+	concurrency6, err := familyShare("concurrency", e.cfg.Concurrency, e.cfg.ConcurrencyV6)
+	if err != nil {
+		return Response{}, err
+	}
+	cfg4, cfg6 := e.cfg, e.cfg
+	cfg4.Budget, cfg6.Budget = e.cfg.Budget-budget6, budget6
+	cfg4.Concurrency, cfg6.Concurrency = e.cfg.Concurrency-concurrency6, concurrency6
+	if onResult := e.cfg.OnResult; onResult != nil {
+		// OnResult promises calls from one goroutine at a time.
+		var mu sync.Mutex
+		cfg4.OnResult = func(r TopResult) {
+			mu.Lock()
+			defer mu.Unlock()
+			onResult(r)
+		}
+		cfg6.OnResult = cfg4.OnResult
+	}
+	if onTop := e.cfg.OnTop; onTop != nil {
+		var mu sync.Mutex
+		cfg4.OnTop = func(r TopResult, rank int) {
+			mu.Lock()
+			defer mu.Unlock()
+			onTop(r, rank)
+		}
+		cfg6.OnTop = cfg4.OnTop
+	}
+	cfg4.Logger, cfg6.Logger = e.cfg.Logger.With("family", "v4"), e.cfg.Logger.With("family", "v6")
+	fam := &familySearch{v4: New(cfg4, e.probeCfg), v6: New(cfg6, e.probeCfg)}
+	fam.v4.ckpt, fam.v6.ckpt = e.ckpt, e.ckpt
+	fam.v6.ckptSlot = 1
+	var resume4, resume6 *State
+	if st := e.cfg.Resume; st != nil {
+		resume4, resume6 = st.family(false), st.family(true)
+	}
+	e.families.Store(fam)
+
+	var res4, res6 Response
+	var err4, err6 error
+	var wg sync.WaitGroup
+	wg.Go(func() { res4, err4 = fam.v4.run(ctx, req, v4, resume4) })
+	wg.Go(func() { res6, err6 = fam.v6.run(ctx, req, v6, resume6) })
+	wg.Wait()
+	if err := errors.Join(err4, err6); err != nil {
+		return Response{}, err
+	}
+
+	top := mergeTop(res4.Top, res6.Top, e.cfg.TopN)
+	stats := fam.v4.stats.merged(&fam.v6.stats)
+	return Response{Top: top, Stats: stats, Expired: res4.Expired || res6.Expired}, nil
+}
+
+// familyShare returns the IPv6 part of total, v6 or else half of it, and
+// checks that both families get some of it.
+func familyShare(name string, total, v6 int) (int, error) {
+	if total < 2 {
+		return 0, fmt.Errorf("%s must be at least 2 to search IPv4 and IPv6, got %d", name, total)
+	}
+	if v6 == 0 {
+		return total / 2, nil
+	}
+	if v6 >= total {
+		return 0, fmt.Errorf("%sV6 (%d) must be less than %s (%d), which it is part of", name, v6, name, total)
+	}
+	return v6, nil
+}
+
+// mergeTop ranks together the best n of the top results of both families,
+// each ordered best first. Each family gets half of n, the one with the
+// better best result the larger half when n is odd, so that an IPv6 path
+// a few milliseconds slower is not crowded out entirely; what one family
+// cannot fill goes to the other.
+func mergeTop(top4, top6 []TopResult, n int) []TopResult {
+	a, b := top4, top6
+	if len(b) > 0 && (len(a) == 0 || b[0].ScoreMS < a[0].ScoreMS) {
+		a, b = b, a
+	}
+	na := min(len(a), (n+1)/2)
+	nb := min(len(b), n-na)
+	na = min(len(a), n-nb)
+	top := append(a[:na:na], b[:nb]...)
+	sort.SliceStable(top, func(i, j int) bool { return top[i].ScoreMS < top[j].ScoreMS })
+	return top
+}
+
+// top ranks the current top results of both searches together.
+func (f *familySearch) top() []TopResult {
+	return mergeTop(f.v4.Top(), f.v6.Top(), f.v4.cfg.TopN)
+}
+
+// progress sums up the progress of both searches.
+func (f *familySearch) progress() Progress {
+	p4, p6 := f.v4.Progress(), f.v6.Progress()
+	p := Progress{
+		Submitted: p4.Submitted + p6.Submitted,
+		Completed: p4.Completed + p6.Completed,
+		Budget:    p4.Budget + p6.Budget,
+		Nodes:     p4.Nodes + p6.Nodes,
+		Best:      p4.Best,
+	}
+	if p6.Best.IP.IsValid() && (!p.Best.IP.IsValid() || p6.Best.ScoreMS < p.Best.ScoreMS) {
+		p.Best = p6.Best
+	}
+	return p
+}
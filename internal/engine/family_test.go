@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+// tops returns top results with the given scores, IPv4 or IPv6.
+func tops(v6 bool, scores ...float64) []TopResult {
+	var out []TopResult
+	for i, s := range scores {
+		ip := netip.AddrFrom4([4]byte{1, 0, 0, byte(i + 1)})
+		if v6 {
+			ip = netip.AddrFrom16([16]byte{0x26, 0x06, 15: byte(i + 1)})
+		}
+		out = append(out, TopResult{IP: ip, ScoreMS: s})
+	}
+	return out
+}
+
+func TestMergeTop(t *testing.T) {
+	tests := []struct {
+		name       string
+		top4, top6 []TopResult
+		n          int
+		want       []string
+	}{
+		{
+			name: "half each",
+			top4: tops(false, 10, 11, 12, 13), top6: tops(true, 20, 21, 22, 23), n: 4,
+			want: []string{"1.0.0.1", "1.0.0.2", "2606::1", "2606::2"},
+		},
+		{
+			name: "odd n favors the better family",
+			top4: tops(false, 30, 31, 32), top6: tops(true, 20, 21, 22), n: 3,
+			want: []string{"2606::1", "2606::2", "1.0.0.1"},
+		},
+		{
+			name: "short family filled by the other",
+			top4: tops(false, 10, 11, 12, 13), top6: tops(true, 20), n: 4,
+			want: []string{"1.0.0.1", "1.0.0.2", "1.0.0.3", "2606::1"},
+		},
+		{
+			name: "one family",
+			top4: nil, top6: tops(true, 20, 21, 22), n: 2,
+			want: []string{"2606::1", "2606::2"},
+		},
+		{
+			name: "fewer than n",
+			top4: tops(false, 10), top6: tops(true, 5), n: 5,
+			want: []string{"2606::1", "1.0.0.1"},
+		},
+		{
+			name: "none",
+			n:    3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, r := range mergeTop(tt.top4, tt.top6, tt.n) {
+				got = append(got, r.IP.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("mergeTop = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeTopKeepsInputs(t *testing.T) {
+	top4, top6 := tops(false, 10, 11, 12), tops(true, 20, 21, 22)
+	want := fmt.Sprint(top4)
+	mergeTop(top4[:1], top6, 4)
+	if got := fmt.Sprint(top4); got != want {
+		t.Errorf("mergeTop changed its input: %s, want %s", got, want)
+	}
+}
@@ -40,6 +40,7 @@ type TopResult struct {
 	TLSMS     int64             `json:"tls_ms"`
 	TTFBMS    int64             `json:"ttfb_ms"`
 	TotalMS   int64             `json:"total_ms"`
+	JitterMS  float64           `json:"jitter_ms,omitempty"`
 	ScoreMS   float64           `json:"score_ms"`
 	Trace     map[string]string `json:"trace,omitempty"`
 
@@ -52,99 +53,147 @@ type TopResult struct {
 	PrefixSamples int `json:"prefix_samples"`
 	PrefixOK      int `json:"prefix_ok"`
 	PrefixFail    int `json:"prefix_fail"`
+
+	// VantageMS holds the latency seen by each vantage point when the
+	// candidates were re-probed by agents; ScoreMS is then their merge.
+	VantageMS map[string]float64 `json:"vantage_ms,omitempty"`
+
+	// Explain breaks the score down; set with Config.Explain.
+	Explain *Explain `json:"explain,omitempty"`
+}
+
+// Explain says why a result ranks where it does. ScoreMS, which ranks the
+// results (lower is better), is the sum of LatencyMS, FailurePenaltyMS and
+// AgentsMS; the other fields are measured alongside but do not enter it.
+type Explain struct {
+	LatencyMS        float64 `json:"latency_ms"`         // HTTP trace latency of the IP; 0 when the probe failed
+	FailurePenaltyMS float64 `json:"failure_penalty_ms"` // a failed probe scores twice the probe timeout
+	AgentsMS         float64 `json:"agents_ms"`          // added by merging in the latencies of the agents
+
+	JitterMS float64 `json:"jitter_ms"` // mean deviation of the probe rounds
+
+	// The estimates of the subnet steered the sampling to the IP:
+	// SubnetLoss is its share of failed probes and SubnetMeanMS its
+	// latency, in which a failure counts as twice the probe timeout at half
+	// weight. SubnetPriorMS is its score, SubnetMeanMS plus SubnetLoss
+	// times the probe timeout.
+	SubnetLoss    float64 `json:"subnet_loss"`
+	SubnetMeanMS  float64 `json:"subnet_mean_ms"`
+	SubnetPriorMS float64 `json:"subnet_prior_ms"`
+
+	// SpeedRank is the place of the IP by download speed among the
+	// download-tested ones, which orders the DNS upload; 0 = not tested.
+	SpeedRank int `json:"speed_rank,omitempty"`
 }
 
 // Response holds the complete search response.
 type Response struct {
 	Top []TopResult `json:"top"`
+	// Stats aggregates all probes, including those that did not make the top.
+	Stats Stats `json:"stats"`
+	// Expired is set when Config.Deadline ended the search before its
+	// budget was spent.
+	Expired bool `json:"expired,omitempty"`
 }
 
 // topNHeap is a max-heap of TopResult ordered by ScoreMS.
 // We use a max-heap so we can efficiently remove the worst result when full.
+// index maps each IP to its position and is kept up to date by Swap, Push
+// and Pop.
 type topNHeap struct {
 	items []TopResult
+	index map[netip.Addr]int
 }
 
 func (h topNHeap) Len() int           { return len(h.items) }
 func (h topNHeap) Less(i, j int) bool { return h.items[i].ScoreMS > h.items[j].ScoreMS } // max-heap
-func (h topNHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h topNHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.index[h.items[i].IP] = i
+	h.index[h.items[j].IP] = j
+}
 
 func (h *topNHeap) Push(x interface{}) {
-	h.items = append(h.items, x.(TopResult))
+	r := x.(TopResult)
+	h.index[r.IP] = len(h.items)
+	h.items = append(h.items, r)
 }
 
 func (h *topNHeap) Pop() interface{} {
 	old := h.items
 	n := len(old)
 	x := old[n-1]
+	old[n-1] = TopResult{} // drop the references of the popped result
 	h.items = old[0 : n-1]
+	delete(h.index, x.IP)
 	return x
 }
 
-// TopNCollector collects and maintains the top N results efficiently using a heap.
+// TopNCollector collects and maintains the top N results efficiently using a
+// heap. Its memory is bounded by N however many results it considers.
 type TopNCollector struct {
-	n      int
-	heap   *topNHeap
-	ipSeen map[netip.Addr]int // IP -> index in heap for dedup
-	mu     sync.Mutex
+	n    int
+	heap *topNHeap
+	mu   sync.Mutex
 }
 
 // NewTopNCollector creates a new TopN collector with heap-based storage.
 func NewTopNCollector(n int) *TopNCollector {
-	h := &topNHeap{items: make([]TopResult, 0, n+1)}
+	h := &topNHeap{items: make([]TopResult, 0, n+1), index: make(map[netip.Addr]int, n+1)}
 	heap.Init(h)
 	return &TopNCollector{
-		n:      n,
-		heap:   h,
-		ipSeen: make(map[netip.Addr]int, n),
+		n:    n,
+		heap: h,
 	}
 }
 
-// Consider adds a result to the collector if it qualifies.
-func (c *TopNCollector) Consider(r TopResult) {
+// Consider adds a result to the collector if it qualifies. It returns the
+// rank of r among the results (0 = best) when r entered the collector or
+// improved the score of its IP there, and -1 otherwise.
+func (c *TopNCollector) Consider(r TopResult) int {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	if c.n <= 0 {
-		return
+		return -1
 	}
 
 	// Check for duplicate IP
-	if idx, exists := c.ipSeen[r.IP]; exists {
+	if idx, exists := c.heap.index[r.IP]; exists {
 		// Only update if new score is better
 		if r.ScoreMS < c.heap.items[idx].ScoreMS {
 			c.heap.items[idx] = r
 			heap.Fix(c.heap, idx)
-			c.rebuildIPMap()
+			return c.rank(r)
 		}
-		return
+		return -1
 	}
 
 	// If heap is not full, just add
 	if c.heap.Len() < c.n {
 		heap.Push(c.heap, r)
-		c.rebuildIPMap()
-		return
+		return c.rank(r)
 	}
 
 	// Heap is full, check if new result is better than worst
 	if r.ScoreMS < c.heap.items[0].ScoreMS {
-		// Remove the worst
-		worst := heap.Pop(c.heap).(TopResult)
-		delete(c.ipSeen, worst.IP)
-
-		// Add the new one
+		// Replace the worst
+		heap.Pop(c.heap)
 		heap.Push(c.heap, r)
-		c.rebuildIPMap()
+		return c.rank(r)
 	}
+	return -1
 }
 
-// rebuildIPMap rebuilds the IP -> index map after heap modifications.
-func (c *TopNCollector) rebuildIPMap() {
-	c.ipSeen = make(map[netip.Addr]int, len(c.heap.items))
-	for i, item := range c.heap.items {
-		c.ipSeen[item.IP] = i
+// rank returns the number of results that score better than r.
+func (c *TopNCollector) rank(r TopResult) int {
+	n := 0
+	for _, item := range c.heap.items {
+		if item.ScoreMS < r.ScoreMS {
+			n++
+		}
 	}
+	return n
 }
 
 // Best returns the best result so far.
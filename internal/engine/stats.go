@@ -0,0 +1,157 @@
+package engine
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/probe"
+)
+
+// histMS is the upper end of the latency histogram; slower probes count in
+// its last bucket.
+const histMS = 10_000
+
+// Stats aggregates every probe of a search. It is built in constant memory,
+// so a run of millions of samples keeps only its top results in full.
+type Stats struct {
+	Probes int64 `json:"probes"`
+	OK     int64 `json:"ok"`
+	Failed int64 `json:"failed"`
+
+	// Latency of the successful probes. The percentiles have a resolution
+	// of 1ms up to 10s.
+	MinMS  int64   `json:"min_ms"`
+	MaxMS  int64   `json:"max_ms"`
+	MeanMS float64 `json:"mean_ms"`
+	P50MS  int64   `json:"p50_ms"`
+	P90MS  int64   `json:"p90_ms"`
+	P99MS  int64   `json:"p99_ms"`
+
+	// Colos counts the successful probes per colo.
+	Colos map[string]int64 `json:"colos,omitempty"`
+
+	// Panics counts the probes, of the search and of the refinement, that
+	// panicked and were recorded as failed; PanicErrors describes the
+	// first maxPanicErrors of them.
+	Panics      int64    `json:"panics,omitempty"`
+	PanicErrors []string `json:"panic_errors,omitempty"`
+}
+
+// maxPanicErrors bounds Stats.PanicErrors; a bug that panics on every probe
+// must not grow it with the budget.
+const maxPanicErrors = 10
+
+// aggregator builds Stats from a stream of probe results.
+type aggregator struct {
+	mu    sync.Mutex
+	stats Stats
+	sumMS float64
+	hist  [histMS + 1]int64
+}
+
+func (a *aggregator) add(r probe.Result) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s := &a.stats
+	s.Probes++
+	if !r.OK {
+		s.Failed++
+		return
+	}
+	s.OK++
+	ms := r.TotalMS
+	if s.OK == 1 || ms < s.MinMS {
+		s.MinMS = ms
+	}
+	s.MaxMS = max(s.MaxMS, ms)
+	a.sumMS += float64(ms)
+	a.hist[min(max(ms, 0), histMS)]++
+	if colo := r.Trace["colo"]; colo != "" {
+		if s.Colos == nil {
+			s.Colos = make(map[string]int64)
+		}
+		s.Colos[colo]++
+	}
+}
+
+// addPanic counts a probe that panicked, as described by desc.
+func (a *aggregator) addPanic(desc string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stats.Panics++
+	if len(a.stats.PanicErrors) < maxPanicErrors {
+		a.stats.PanicErrors = append(a.stats.PanicErrors, desc)
+	}
+}
+
+// snapshot returns the current Stats.
+func (a *aggregator) snapshot() Stats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s := a.stats
+	s.PanicErrors = slices.Clone(a.stats.PanicErrors)
+	if s.Colos != nil {
+		s.Colos = make(map[string]int64, len(a.stats.Colos))
+		for k, v := range a.stats.Colos {
+			s.Colos[k] = v
+		}
+	}
+	if s.OK == 0 {
+		return s
+	}
+	s.MeanMS = a.sumMS / float64(s.OK)
+	s.P50MS, s.P90MS, s.P99MS = a.quantile(0.5), a.quantile(0.9), a.quantile(0.99)
+	return s
+}
+
+// merged returns the Stats of the probes of both a and b.
+func (a *aggregator) merged(b *aggregator) Stats {
+	var m aggregator
+	for _, x := range []*aggregator{a, b} {
+		x.mu.Lock()
+		s := &m.stats
+		if x.stats.OK > 0 {
+			if s.OK == 0 || x.stats.MinMS < s.MinMS {
+				s.MinMS = x.stats.MinMS
+			}
+			s.MaxMS = max(s.MaxMS, x.stats.MaxMS)
+		}
+		s.Probes += x.stats.Probes
+		s.OK += x.stats.OK
+		s.Failed += x.stats.Failed
+		s.Panics += x.stats.Panics
+		for _, desc := range x.stats.PanicErrors {
+			if len(s.PanicErrors) < maxPanicErrors {
+				s.PanicErrors = append(s.PanicErrors, desc)
+			}
+		}
+		m.sumMS += x.sumMS
+		for ms, n := range x.hist {
+			m.hist[ms] += n
+		}
+		for colo, n := range x.stats.Colos {
+			if s.Colos == nil {
+				s.Colos = make(map[string]int64)
+			}
+			s.Colos[colo] += n
+		}
+		x.mu.Unlock()
+	}
+	return m.snapshot()
+}
+
+// quantile returns the latency below which a fraction q of the successful
+// probes fall.
+func (a *aggregator) quantile(q float64) int64 {
+	rank := int64(q * float64(a.stats.OK))
+	var seen int64
+	for ms, n := range a.hist {
+		seen += n
+		if seen > rank {
+			return int64(ms)
+		}
+	}
+	return histMS
+}
@@ -0,0 +1,160 @@
+// Package health records the outcome of the last search run and answers
+// container health probes from it.
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Status is the outcome of one search run.
+type Status struct {
+	// Finished is when the run ended.
+	Finished time.Time `json:"finished"`
+	// DurationMS is how long the run took.
+	DurationMS int64 `json:"duration_ms"`
+
+	// ScanOK is true when the search itself completed.
+	ScanOK bool `json:"scan_ok"`
+	// UploadEnabled is true when a DNS provider is configured.
+	UploadEnabled bool `json:"upload_enabled"`
+	// UploadOK is true when the DNS upload succeeded.
+	UploadOK bool `json:"upload_ok"`
+	// Error is the run error, if any.
+	Error string `json:"error,omitempty"`
+
+	// Results is the number of results kept.
+	Results int `json:"results"`
+	// BestIP and BestMS describe the best result.
+	BestIP netip.Addr `json:"best_ip,omitzero"`
+	BestMS float64    `json:"best_ms"`
+	// Uploaded lists the IPs published to DNS.
+	Uploaded []netip.Addr `json:"uploaded,omitempty"`
+}
+
+// Check reports why st is unhealthy at now, or nil. A run is healthy when the
+// scan (and the upload, if enabled) succeeded within maxAge.
+func Check(st Status, maxAge time.Duration, now time.Time) error {
+	if !st.ScanOK {
+		if st.Error != "" {
+			return fmt.Errorf("last scan failed: %s", st.Error)
+		}
+		return errors.New("last scan failed")
+	}
+	if st.UploadEnabled && !st.UploadOK {
+		if st.Error != "" {
+			return fmt.Errorf("last upload failed: %s", st.Error)
+		}
+		return errors.New("last upload failed")
+	}
+	if age := now.Sub(st.Finished); maxAge > 0 && age > maxAge {
+		return fmt.Errorf("last successful run is %s old (max %s)", age.Truncate(time.Second), maxAge)
+	}
+	return nil
+}
+
+// WriteFile atomically writes st as JSON to path.
+func WriteFile(path string, st Status) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ReadFile reads a status written by WriteFile.
+func ReadFile(path string) (Status, error) {
+	var st Status
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return st, err
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return st, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return st, nil
+}
+
+// Tracker keeps the latest status in memory and serves it as /healthz.
+type Tracker struct {
+	started time.Time
+	maxAge  time.Duration
+
+	mu   sync.Mutex
+	last *Status
+}
+
+// NewTracker creates a tracker whose runs must succeed within maxAge.
+func NewTracker(maxAge time.Duration) *Tracker {
+	return &Tracker{started: time.Now(), maxAge: maxAge}
+}
+
+// Record stores the status of a finished run.
+func (t *Tracker) Record(st Status) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.last = &st
+}
+
+// Last returns the latest status, if any run has finished.
+func (t *Tracker) Last() (Status, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.last == nil {
+		return Status{}, false
+	}
+	return *t.last, true
+}
+
+// healthzResponse is the JSON body of /healthz.
+type healthzResponse struct {
+	Healthy bool    `json:"healthy"`
+	Reason  string  `json:"reason,omitempty"`
+	Last    *Status `json:"last,omitempty"`
+}
+
+// ServeHTTP answers 200 when healthy and 503 otherwise. Before the first run
+// finishes the process counts as healthy for up to maxAge, so a long first
+// scan is not killed by a liveness probe.
+func (t *Tracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	resp := healthzResponse{Healthy: true}
+	now := time.Now()
+	if st, ok := t.Last(); ok {
+		resp.Last = &st
+		if err := Check(st, t.maxAge, now); err != nil {
+			resp.Healthy = false
+			resp.Reason = err.Error()
+		}
+	} else if t.maxAge > 0 && now.Sub(t.started) > t.maxAge {
+		resp.Healthy = false
+		resp.Reason = "no run finished yet"
+	} else {
+		resp.Reason = "starting"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,112 @@
+// Package i18n translates the messages mcis shows to people: the progress
+// and error lines of a search run, the run summaries sent to notifiers and
+// the HTML report. Messages are looked up by their English text, which is
+// also the fallback; results, logs meant for machines and flag help stay in
+// English.
+package i18n
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/color"
+)
+
+// Languages lists the supported languages, besides "auto".
+var Languages = []string{"en", "zh-CN"}
+
+// catalogs maps a language to its translations, keyed by the English text.
+var catalogs = map[string]map[string]string{
+	"zh-CN": zhCN,
+}
+
+var current atomic.Pointer[string]
+
+// Set selects the language of the messages: one of Languages, or "auto" or
+// "" for the language of the environment (see Detect).
+func Set(lang string) error {
+	if lang == "" || lang == "auto" {
+		lang = Detect()
+	}
+	for _, l := range Languages {
+		if strings.EqualFold(lang, l) {
+			current.Store(&l)
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported language %q (want auto, %s)", lang, strings.Join(Languages, ", "))
+}
+
+// Lang returns the selected language, "en" until Set is called.
+func Lang() string {
+	if l := current.Load(); l != nil {
+		return *l
+	}
+	return "en"
+}
+
+// Detect returns the supported language of the environment: the first of
+// $LC_ALL, $LC_MESSAGES and $LANG that is set, else the user's locale on
+// Windows. Simplified Chinese locales (zh, zh_CN, zh_SG, zh-Hans) select
+// zh-CN; anything else selects en.
+func Detect() string {
+	locale := ""
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if locale = os.Getenv(name); locale != "" {
+			break
+		}
+	}
+	if locale == "" {
+		locale = systemLocale()
+	}
+	return fromLocale(locale)
+}
+
+// fromLocale maps a POSIX locale such as zh_CN.UTF-8 or a BCP 47 tag such
+// as zh-Hans-CN to a supported language.
+func fromLocale(locale string) string {
+	locale, _, _ = strings.Cut(locale, ".")
+	locale, _, _ = strings.Cut(locale, "@")
+	parts := strings.FieldsFunc(strings.ToLower(locale), func(r rune) bool { return r == '_' || r == '-' })
+	if len(parts) == 0 || parts[0] != "zh" {
+		return "en"
+	}
+	for _, p := range parts[1:] {
+		switch p {
+		case "hans", "cn", "sg":
+			return "zh-CN"
+		case "hant", "tw", "hk", "mo":
+			return "en"
+		}
+	}
+	return "zh-CN"
+}
+
+// T returns the translation of msg in the selected language, or msg itself.
+func T(msg string) string {
+	if t, ok := catalogs[Lang()][msg]; ok {
+		return t
+	}
+	return msg
+}
+
+// Sprintf is fmt.Sprintf with the translation of format.
+func Sprintf(format string, a ...any) string {
+	return fmt.Sprintf(T(format), a...)
+}
+
+// Fprintf is fmt.Fprintf with the translation of format, whose phase prefix
+// is highlighted on a color terminal (see color.Message).
+func Fprintf(w io.Writer, format string, a ...any) {
+	fmt.Fprintf(w, color.Message(w, format, T(format)), a...)
+}
+
+// Fprintln writes the translation of msg followed by a, space-separated, and
+// a newline, e.g. Fprintln(os.Stderr, "error:", err), highlighted like
+// Fprintf.
+func Fprintln(w io.Writer, msg string, a ...any) {
+	fmt.Fprintln(w, append([]any{color.Message(w, msg, T(msg))}, a...)...)
+}
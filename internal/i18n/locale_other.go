@@ -0,0 +1,6 @@
+//go:build !windows
+
+package i18n
+
+// systemLocale is empty: elsewhere the locale is in the environment.
+func systemLocale() string { return "" }
@@ -0,0 +1,71 @@
+package i18n
+
+// zhCN is the Simplified Chinese catalog. The component prefixes of the CLI
+// lines (dns:, leader: ...) stay in English, so logs can be searched the
+// same way in every language.
+var zhCN = map[string]string{
+	// CLI
+	"error:":                                   "错误:",
+	"error: debug server:":                     "错误: 调试服务器:",
+	"error: health server:":                    "错误: 健康检查服务器:",
+	"error: leader election:":                  "错误: 领导者选举:",
+	"error: leader: lost lease %s, aborting\n": "错误: leader: 失去租约 %s，正在中止\n",
+	"error: leader: release lease:":            "错误: leader: 释放租约:",
+	"error: notify %s: %v\n":                   "错误: 通知 %s: %v\n",
+	"error: release lock:":                     "错误: 释放锁:",
+	"error: reload:":                           "错误: 重新加载:",
+	"error: remove search state:":              "错误: 删除搜索状态:",
+	"error: save probe cache:":                 "错误: 保存探测缓存:",
+	"error: save search state:":                "错误: 保存搜索状态:",
+	"interrupt: stopping search and flushing best-so-far results (press Ctrl-C again to abort)": "interrupt: 正在停止搜索并输出目前最优的结果（再按一次 Ctrl-C 中止）",
+	"interrupt: aborting":                            "interrupt: 正在中止",
+	"syn: warning: %v; searching with HTTP probes\n": "syn: 警告: %v；改用 HTTP 探测搜索\n",
+	"warning: %d tasks panicked and were recorded as failures (-v logs the stacks):\n": "警告: %d 个任务发生 panic，已记为失败（-v 可查看调用栈）:\n",
+	"  ... and %d more\n":                             "  ……另有 %d 个\n",
+	"icmp: warning: %v; searching with HTTP probes\n": "icmp: 警告: %v；改用 HTTP 探测搜索\n",
+	"syn: %s ICMP sockets are permitted; --icmp searches faster than HTTP probes\n":                                                        "syn: 当前可用 %s ICMP 套接字；--icmp 的搜索比 HTTP 探测更快\n",
+	"simulate: probing a latency model; DNS records are kept in memory, and agents, publishers, the archive and notifications are skipped": "simulate: 探测的是延迟模型；DNS 记录只保存在内存中，并跳过 agent、发布、归档与通知",
+	"max-runtime: stopped sampling after %d probes to finish within %s\n":                                                                  "max-runtime: 已在 %d 次探测后停止采样，以便在 %s 内完成\n",
+	"state: resuming the search from %s after %d probes\n":                                                                                 "state: 从 %s 恢复搜索，已完成 %d 次探测\n",
+	"dns: only %d qualifying IPs (need %d), skipping upload\n":                                                                             "dns: 仅 %d 个 IP 达标（需要 %d 个），跳过上传\n",
+	"dns: updated %s\n": "dns: 已更新 %s\n",
+	"leader: lease %s is held by %s, skipping this run\n":                                 "leader: 租约 %s 由 %s 持有，跳过本次运行\n",
+	"leader: %s acquired lease %s/%s\n":                                                   "leader: %s 已获得租约 %s/%s\n",
+	"reload: changes to %s take effect after a restart\n":                                 "reload: 对 %s 的修改需重启后生效\n",
+	"debug: serving pprof and runtime stats on %s/debug/\n":                               "debug: 在 %s/debug/ 提供 pprof 与运行时统计\n",
+	"reload: loaded %s\n":                                                                 "reload: 已加载 %s\n",
+	"agent: %s: %v (left out of the merge)\n":                                             "agent: %s: %v（未参与合并）\n",
+	"agent: merged %d vantage points (%s), dropped %d candidates that failed somewhere\n": "agent: 已合并 %d 个探测点（%s），丢弃了 %d 个在某处失败的候选\n",
+
+	// Run summaries
+	"mcis run failed on %s":                      "mcis 在 %s 上运行失败",
+	"mcis run finished on %s":                    "mcis 在 %s 上运行完成",
+	"mcis run finished on %s, DNS upload failed": "mcis 在 %s 上运行完成，DNS 上传失败",
+	"mcis run interrupted on %s":                 "mcis 在 %s 上的运行被中断",
+	"new":                                        "新",
+	"DNS: published %d IPs to %s":                "DNS: 已发布 %d 个 IP 到 %s",
+	"DNS: upload skipped, only %d of the %d required IPs qualified": "DNS: 跳过上传，仅 %d 个 IP 达标（需要 %d 个）",
+	"DNS: upload to %s failed: %s":                                  "DNS: 上传到 %s 失败: %s",
+	"DNS: nothing uploaded to %s":                                   "DNS: 未向 %s 上传任何记录",
+	"Error:":                                                        "错误:",
+	"Error: %s":                                                     "错误: %s",
+	"Took %s":                                                       "耗时 %s",
+	"took %s":                                                       "耗时 %s",
+	"Rotated to %s":                                                 "已轮换为 %s",
+	"Selected IPs":                                                  "选中的 IP",
+	"Latency · Speed":                                               "延迟 · 速度",
+	"failed":                                                        "失败",
+	"published %d IPs":                                              "已发布 %d 个 IP",
+	"nothing uploaded":                                              "未上传",
+
+	// HTML report
+	"mcis report":               "mcis 报告",
+	"Generated %s, %d results.": "生成于 %s，共 %d 条结果。",
+	"Prefix":                    "前缀",
+	"Colo":                      "机房",
+	"Score (ms)":                "得分 (ms)",
+	"Connect":                   "连接",
+	"Total":                     "总计",
+	"Prefix ok/samples":         "前缀 成功/采样",
+	"Download (Mbps)":           "下载 (Mbps)",
+}
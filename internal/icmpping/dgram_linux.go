@@ -0,0 +1,38 @@
+//go:build linux
+
+package icmpping
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// Hints how to permit the ICMP sockets that failed to open.
+const (
+	dgramHint = "add a group of the user to sysctl net.ipv4.ping_group_range"
+	rawHint   = "raw sockets need root or CAP_NET_RAW, e.g. sudo setcap cap_net_raw+ep mcis"
+	autoHint  = "add a group of the user to sysctl net.ipv4.ping_group_range, or run as root or with CAP_NET_RAW"
+)
+
+// listenDgram opens an unprivileged datagram ICMP socket. Linux allows it to
+// the groups in net.ipv4.ping_group_range, which also covers ICMPv6.
+func listenDgram(v6 bool) (net.PacketConn, error) {
+	family, proto := syscall.AF_INET, syscall.IPPROTO_ICMP
+	var sa syscall.Sockaddr = &syscall.SockaddrInet4{}
+	if v6 {
+		family, proto = syscall.AF_INET6, syscall.IPPROTO_ICMPV6
+		sa = &syscall.SockaddrInet6{}
+	}
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	f := os.NewFile(uintptr(fd), "icmp")
+	defer f.Close()
+	return net.FilePacketConn(f)
+}
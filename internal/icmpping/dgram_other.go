@@ -0,0 +1,14 @@
+//go:build !linux
+
+package icmpping
+
+import (
+	"errors"
+	"net"
+)
+
+// listenDgram is only available on Linux; elsewhere the pool uses raw
+// sockets.
+func listenDgram(v6 bool) (net.PacketConn, error) {
+	return nil, errors.New("datagram ICMP sockets are not supported on this platform")
+}
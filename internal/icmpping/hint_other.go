@@ -0,0 +1,10 @@
+//go:build !linux && !windows
+
+package icmpping
+
+// Hints how to permit the ICMP sockets that failed to open.
+const (
+	dgramHint = "use raw sockets on this platform"
+	rawHint   = "raw sockets need root"
+	autoHint  = rawHint
+)
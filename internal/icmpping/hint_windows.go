@@ -0,0 +1,8 @@
+package icmpping
+
+// Hints how to permit the ICMP sockets that failed to open.
+const (
+	dgramHint = "use raw sockets on Windows"
+	rawHint   = "raw sockets need an elevated prompt: run mcis as administrator"
+	autoHint  = rawHint
+)
@@ -0,0 +1,376 @@
+// Package icmpping measures latency with ICMP echo requests. A Pool holds a
+// few sockets per address family and demultiplexes the replies arriving on
+// them to the waiting probes, so thousands of pings can be in flight without
+// a socket (and a file descriptor) per probe.
+//
+// Sockets are unprivileged datagram ICMP sockets where the OS offers them
+// (Linux, with net.ipv4.ping_group_range covering the user), and raw ICMP
+// sockets otherwise, which need root or CAP_NET_RAW, or an elevated prompt
+// on Windows. Detect tells which of them the process may open.
+package icmpping
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/probe"
+)
+
+// ICMP message types.
+const (
+	echoRequest4 = 8
+	echoReply4   = 0
+	echoRequest6 = 128
+	echoReply6   = 129
+)
+
+var (
+	errTimeout = errors.New("timeout")
+	errNoIPv6  = errors.New("no ICMPv6 socket")
+)
+
+// Config configures a Pool.
+type Config struct {
+	// Timeout is how long to wait for the reply to one echo request
+	// (default 1s).
+	Timeout time.Duration
+
+	// Rounds is the number of pings per IP; the latency is their average
+	// (default 3).
+	Rounds int
+
+	// Sockets is the number of sockets per address family (default 4).
+	Sockets int
+
+	// Socket is the kind of the sockets, one of SocketKinds (default
+	// SocketAuto).
+	Socket string
+}
+
+// Socket kinds of Config.Socket.
+const (
+	SocketAuto  = "auto"  // datagram where permitted, raw otherwise
+	SocketDgram = "dgram" // unprivileged datagram sockets only
+	SocketRaw   = "raw"   // raw sockets only
+)
+
+// SocketKinds lists the values of Config.Socket.
+var SocketKinds = []string{SocketAuto, SocketDgram, SocketRaw}
+
+func (c *Config) applyDefaults() {
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second
+	}
+	if c.Rounds <= 0 {
+		c.Rounds = 3
+	}
+	if c.Sockets <= 0 {
+		c.Sockets = 4
+	}
+	if c.Socket == "" {
+		c.Socket = SocketAuto
+	}
+}
+
+// Pool sends echo requests over a fixed set of sockets. It is safe for
+// concurrent use.
+type Pool struct {
+	cfg    Config
+	socks4 []*socket
+	socks6 []*socket // empty without IPv6
+	next   atomic.Uint32
+}
+
+// socket is one ICMP socket with the pings waiting for a reply on it.
+type socket struct {
+	conn  net.PacketConn
+	dgram bool   // datagram socket: the kernel sets and filters the ID
+	id    uint16 // echo ID of a raw socket
+	v6    bool
+
+	mu      sync.Mutex
+	seq     uint16
+	pending map[pingKey]chan time.Time
+}
+
+type pingKey struct {
+	ip  netip.Addr
+	seq uint16
+}
+
+// Open opens the sockets. IPv4 is required; IPv6 is used when available.
+func Open(cfg Config) (*Pool, error) {
+	cfg.applyDefaults()
+	if !slices.Contains(SocketKinds, cfg.Socket) {
+		return nil, fmt.Errorf("unknown ICMP socket kind %q", cfg.Socket)
+	}
+	p := &Pool{cfg: cfg}
+	for range cfg.Sockets {
+		s, err := openSocket(false, cfg.Socket)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.socks4 = append(p.socks4, s)
+	}
+	for range cfg.Sockets {
+		s, err := openSocket(true, cfg.Socket)
+		if err != nil {
+			break
+		}
+		p.socks6 = append(p.socks6, s)
+	}
+	for _, s := range append(p.socks4, p.socks6...) {
+		go s.read()
+	}
+	return p, nil
+}
+
+// openSocket opens a socket of kind: for SocketAuto a datagram ICMP socket,
+// or a raw one if that fails.
+func openSocket(v6 bool, kind string) (*socket, error) {
+	s := &socket{v6: v6, pending: make(map[pingKey]chan time.Time)}
+	if kind != SocketRaw {
+		conn, err := listenDgram(v6)
+		if err == nil {
+			s.conn, s.dgram = conn, true
+			return s, nil
+		}
+		if kind == SocketDgram {
+			return nil, fmt.Errorf("datagram ICMP socket: %w (%s)", err, dgramHint)
+		}
+	}
+	conn, err := listenRaw(v6)
+	if err != nil {
+		if kind == SocketRaw {
+			return nil, fmt.Errorf("raw ICMP socket: %w (%s)", err, rawHint)
+		}
+		return nil, fmt.Errorf("ICMP sockets: %w (%s)", err, autoHint)
+	}
+	s.conn, s.id = conn, uint16(rand.Uint32())
+	return s, nil
+}
+
+// listenRaw opens a raw ICMP socket.
+func listenRaw(v6 bool) (net.PacketConn, error) {
+	network, addr := "ip4:icmp", "0.0.0.0"
+	if v6 {
+		network, addr = "ip6:ipv6-icmp", "::"
+	}
+	return net.ListenPacket(network, addr)
+}
+
+// Capability tells which kinds of ICMP socket the process may open.
+type Capability struct {
+	Dgram bool // unprivileged datagram sockets
+	Raw   bool // raw sockets
+	// Err explains why neither kind is permitted, with a hint how to
+	// permit one; nil when either is.
+	Err error
+}
+
+// Detect opens and closes an IPv4 socket of each kind to find out which are
+// permitted.
+func Detect() Capability {
+	var c Capability
+	if conn, err := listenDgram(false); err == nil {
+		c.Dgram = true
+		_ = conn.Close()
+	}
+	conn, err := listenRaw(false)
+	if err == nil {
+		c.Raw = true
+		_ = conn.Close()
+	} else if !c.Dgram {
+		c.Err = fmt.Errorf("ICMP sockets: %w (%s)", err, autoHint)
+	}
+	return c
+}
+
+// Socket returns the kind of socket that SocketAuto opens, or "" if none is
+// permitted.
+func (c Capability) Socket() string {
+	switch {
+	case c.Dgram:
+		return SocketDgram
+	case c.Raw:
+		return SocketRaw
+	}
+	return ""
+}
+
+// Socket returns the kind of the pool's sockets, SocketDgram or SocketRaw.
+func (p *Pool) Socket() string {
+	if p.socks4[0].dgram {
+		return SocketDgram
+	}
+	return SocketRaw
+}
+
+// Close closes the sockets; pending pings time out.
+func (p *Pool) Close() error {
+	var errs []error
+	for _, s := range append(p.socks4, p.socks6...) {
+		errs = append(errs, s.conn.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Probe pings ip Config.Rounds times, one after the other, and returns the
+// average round trip as TotalMS. It fails on the first lost ping, with
+// Error "timeout".
+func (p *Pool) Probe(ctx context.Context, ip netip.Addr) probe.Result {
+	res := probe.Result{IP: ip, When: time.Now()}
+	ip = ip.Unmap()
+	socks := p.socks4
+	if ip.Is6() {
+		socks = p.socks6
+	}
+	if len(socks) == 0 {
+		res.Error = errNoIPv6.Error()
+		return res
+	}
+	s := socks[p.next.Add(1)%uint32(len(socks))]
+
+	var total time.Duration
+	for range p.cfg.Rounds {
+		rtt, err := s.ping(ctx, ip, p.cfg.Timeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				err = errTimeout
+			}
+			res.Error = err.Error()
+			res.TotalMS = time.Since(res.When).Milliseconds()
+			return res
+		}
+		total += rtt
+	}
+	res.OK = true
+	res.TotalMS = (total / time.Duration(p.cfg.Rounds)).Milliseconds()
+	return res
+}
+
+// ping sends one echo request and waits for its reply.
+func (s *socket) ping(ctx context.Context, ip netip.Addr, timeout time.Duration) (time.Duration, error) {
+	reply := make(chan time.Time, 1)
+	s.mu.Lock()
+	var key pingKey
+	for range 1 << 16 {
+		s.seq++
+		key = pingKey{ip: ip, seq: s.seq}
+		if _, busy := s.pending[key]; !busy {
+			break
+		}
+	}
+	s.pending[key] = reply
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, key)
+		s.mu.Unlock()
+	}()
+
+	var dst net.Addr = &net.IPAddr{IP: ip.AsSlice()}
+	if s.dgram {
+		dst = &net.UDPAddr{IP: ip.AsSlice()}
+	}
+	sent := time.Now()
+	if _, err := s.conn.WriteTo(s.echo(key.seq), dst); err != nil {
+		return 0, err
+	}
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case at := <-reply:
+		return at.Sub(sent), nil
+	case <-t.C:
+		return 0, errTimeout
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// echo builds an echo request. The kernel computes the ICMPv6 checksum and,
+// on datagram sockets, replaces the ID.
+func (s *socket) echo(seq uint16) []byte {
+	b := make([]byte, 16)
+	b[0] = echoRequest4
+	if s.v6 {
+		b[0] = echoRequest6
+	}
+	binary.BigEndian.PutUint16(b[4:], s.id)
+	binary.BigEndian.PutUint16(b[6:], seq)
+	copy(b[8:], "mcis-icp")
+	if !s.v6 {
+		binary.BigEndian.PutUint16(b[2:], checksum(b))
+	}
+	return b
+}
+
+// read hands the echo replies arriving on s to the waiting pings. It
+// returns when s is closed.
+func (s *socket) read() {
+	buf := make([]byte, 1500)
+	want := byte(echoReply4)
+	if s.v6 {
+		want = echoReply6
+	}
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		at := time.Now()
+		// Raw IPv4 sockets get the IP header stripped by the net package.
+		if err != nil || n < 8 || buf[0] != want {
+			continue
+		}
+		if !s.dgram && binary.BigEndian.Uint16(buf[4:]) != s.id {
+			continue // a reply to another process's ping
+		}
+		var ip net.IP
+		switch a := addr.(type) {
+		case *net.IPAddr:
+			ip = a.IP
+		case *net.UDPAddr:
+			ip = a.IP
+		}
+		from, ok := netip.AddrFromSlice(ip)
+		if !ok {
+			continue
+		}
+		key := pingKey{ip: from.Unmap(), seq: binary.BigEndian.Uint16(buf[6:])}
+
+		s.mu.Lock()
+		reply := s.pending[key]
+		s.mu.Unlock()
+		if reply != nil {
+			select {
+			case reply <- at:
+			default:
+			}
+		}
+	}
+}
+
+// checksum is the Internet checksum of b.
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
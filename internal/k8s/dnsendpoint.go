@@ -0,0 +1,62 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// DNSEndpointAPIVersion is the API version of the external-dns DNSEndpoint
+// custom resource.
+const DNSEndpointAPIVersion = "externaldns.k8s.io/v1alpha1"
+
+// DNSEndpoint is the external-dns custom resource that asks external-dns to
+// manage the records listed in its spec.
+type DNSEndpoint struct {
+	APIVersion string          `json:"apiVersion"`
+	Kind       string          `json:"kind"`
+	Metadata   ObjectMeta      `json:"metadata"`
+	Spec       DNSEndpointSpec `json:"spec"`
+}
+
+// ObjectMeta is the part of metav1.ObjectMeta this package uses.
+type ObjectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+}
+
+// DNSEndpointSpec lists the records of a DNSEndpoint.
+type DNSEndpointSpec struct {
+	Endpoints []Endpoint `json:"endpoints"`
+}
+
+// Endpoint is one record set.
+type Endpoint struct {
+	DNSName    string   `json:"dnsName"`
+	RecordType string   `json:"recordType"`
+	RecordTTL  int      `json:"recordTTL,omitempty"`
+	Targets    []string `json:"targets"`
+}
+
+// ApplyDNSEndpoint creates ep, or replaces the spec of the existing resource
+// of the same name. An empty namespace means the namespace of the pod.
+func (c *Client) ApplyDNSEndpoint(ctx context.Context, ep DNSEndpoint) error {
+	if ep.Metadata.Namespace == "" {
+		ep.Metadata.Namespace = c.namespace
+	}
+	path := fmt.Sprintf("/apis/externaldns.k8s.io/v1alpha1/namespaces/%s/dnsendpoints", url.PathEscape(ep.Metadata.Namespace))
+
+	var cur DNSEndpoint
+	err := c.do(ctx, http.MethodGet, path+"/"+url.PathEscape(ep.Metadata.Name), nil, &cur)
+	if IsStatus(err, http.StatusNotFound) {
+		return c.do(ctx, http.MethodPost, path, ep, nil)
+	}
+	if err != nil {
+		return err
+	}
+	ep.Metadata.ResourceVersion = cur.Metadata.ResourceVersion
+	return c.do(ctx, http.MethodPut, path+"/"+url.PathEscape(ep.Metadata.Name), ep, nil)
+}
@@ -0,0 +1,212 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// microTime is the wire format of metav1.MicroTime.
+const microTime = "2006-01-02T15:04:05.000000Z07:00"
+
+type lease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   leaseMetadata `json:"metadata"`
+	Spec       leaseSpec     `json:"spec"`
+}
+
+type leaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions,omitempty"`
+}
+
+// LeaderElector holds a Lease so that only one replica is active at a time.
+type LeaderElector struct {
+	c         *Client
+	namespace string
+	name      string
+	identity  string
+	duration  time.Duration
+	deadline  time.Duration // renew deadline, shorter than duration
+
+	current *lease // last lease seen, for optimistic concurrency
+}
+
+// NewLeaderElector creates an elector for the Lease namespace/name; identity
+// must be unique per replica (the pod name). renewDeadline is how long Hold
+// keeps trying to renew before giving up leadership; it must be shorter than
+// duration so that work stops before another replica can take over. Values
+// outside (0, duration) default to two thirds of duration.
+func (c *Client) NewLeaderElector(namespace, name, identity string, duration, renewDeadline time.Duration) *LeaderElector {
+	if namespace == "" {
+		namespace = c.namespace
+	}
+	if renewDeadline <= 0 || renewDeadline >= duration {
+		renewDeadline = duration * 2 / 3
+	}
+	return &LeaderElector{c: c, namespace: namespace, name: name, identity: identity, duration: duration, deadline: renewDeadline}
+}
+
+// Holder returns the identity of the last known leader.
+func (l *LeaderElector) Holder() string {
+	if l.current == nil {
+		return ""
+	}
+	return l.current.Spec.HolderIdentity
+}
+
+func (l *LeaderElector) path() string {
+	return fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases", url.PathEscape(l.namespace))
+}
+
+// TryAcquire takes or renews the lease. It reports false when another replica
+// holds an unexpired lease.
+func (l *LeaderElector) TryAcquire(ctx context.Context) (bool, error) {
+	now := time.Now()
+	var cur lease
+	err := l.c.do(ctx, http.MethodGet, l.path()+"/"+url.PathEscape(l.name), nil, &cur)
+	if IsStatus(err, http.StatusNotFound) {
+		next := l.newLease(now, 0)
+		if err := l.c.do(ctx, http.MethodPost, l.path(), next, &cur); err != nil {
+			if IsStatus(err, http.StatusConflict) {
+				return false, nil // another replica created it first
+			}
+			return false, err
+		}
+		l.current = &cur
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	l.current = &cur
+
+	holder := cur.Spec.HolderIdentity
+	if holder != "" && holder != l.identity && !expired(cur.Spec, now) {
+		return false, nil
+	}
+
+	next := l.newLease(now, cur.Spec.LeaseTransitions)
+	next.Metadata.ResourceVersion = cur.Metadata.ResourceVersion
+	if holder == l.identity {
+		next.Spec.AcquireTime = cur.Spec.AcquireTime
+	} else {
+		next.Spec.LeaseTransitions++
+	}
+	if err := l.c.do(ctx, http.MethodPut, l.path()+"/"+url.PathEscape(l.name), next, &cur); err != nil {
+		if IsStatus(err, http.StatusConflict) {
+			return false, nil // updated concurrently; look again next time
+		}
+		return false, err
+	}
+	l.current = &cur
+	return true, nil
+}
+
+func (l *LeaderElector) newLease(now time.Time, transitions int) *lease {
+	return &lease{
+		APIVersion: "coordination.k8s.io/v1",
+		Kind:       "Lease",
+		Metadata:   leaseMetadata{Name: l.name, Namespace: l.namespace},
+		Spec: leaseSpec{
+			HolderIdentity:       l.identity,
+			LeaseDurationSeconds: max(1, int(l.duration.Seconds())),
+			AcquireTime:          now.UTC().Format(microTime),
+			RenewTime:            now.UTC().Format(microTime),
+			LeaseTransitions:     transitions,
+		},
+	}
+}
+
+func expired(spec leaseSpec, now time.Time) bool {
+	renew, err := time.Parse(microTime, spec.RenewTime)
+	if err != nil {
+		renew, err = time.Parse(time.RFC3339, spec.RenewTime)
+		if err != nil {
+			return true
+		}
+	}
+	return now.After(renew.Add(time.Duration(spec.LeaseDurationSeconds) * time.Second))
+}
+
+// Wait blocks until the lease is acquired or ctx is canceled, retrying every
+// retry interval.
+func (l *LeaderElector) Wait(ctx context.Context, retry time.Duration, onRetry func(err error)) error {
+	for {
+		ok, err := l.TryAcquire(ctx)
+		if ok {
+			return nil
+		}
+		if onRetry != nil {
+			onRetry(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retry):
+		}
+	}
+}
+
+// Hold renews the lease until ctx is canceled, every third of the renew
+// deadline so that a failed renewal is retried before it passes. When no
+// renewal has succeeded within the renew deadline, or another replica holds
+// the lease, lost is called and Hold returns; the lease is then still valid
+// for the rest of its duration, which leaves time to stop work before
+// another replica can take over.
+func (l *LeaderElector) Hold(ctx context.Context, lost func()) {
+	ticker := time.NewTicker(l.deadline / 3)
+	defer ticker.Stop()
+	deadline := time.NewTimer(l.deadline)
+	defer deadline.Stop()
+	renewed := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline.C:
+			lost()
+			return
+		case <-ticker.C:
+		}
+		rctx, cancel := context.WithDeadline(ctx, renewed.Add(l.deadline))
+		ok, err := l.TryAcquire(rctx)
+		cancel()
+		if ok {
+			renewed = time.Now()
+			deadline.Reset(l.deadline)
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil || !time.Now().Before(renewed.Add(l.deadline)) {
+			lost()
+			return
+		}
+	}
+}
+
+// Release gives up the lease so another replica can take over immediately.
+// It must not run concurrently with Hold or TryAcquire.
+func (l *LeaderElector) Release(ctx context.Context) error {
+	if l.current == nil || l.current.Spec.HolderIdentity != l.identity {
+		return nil
+	}
+	next := *l.current
+	next.Spec.HolderIdentity = ""
+	next.Spec.LeaseDurationSeconds = 1
+	return l.c.do(ctx, http.MethodPut, l.path()+"/"+url.PathEscape(l.name), &next, nil)
+}
@@ -0,0 +1,192 @@
+// Package lock implements an advisory PID lock file, so that overlapping
+// invocations (e.g. a slow cron run and the next one) do not scan and upload
+// at the same time.
+package lock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// createGrace is how long a lock file whose content cannot be parsed is
+// still considered held: on file systems without hard links it is created
+// empty and written afterwards.
+const createGrace = 10 * time.Second
+
+// owner is the content of a lock file.
+type owner struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Started time.Time `json:"started"`
+	Token   string    `json:"token"`
+}
+
+// HeldError reports that the lock is held by a live process.
+type HeldError struct {
+	Path    string
+	PID     int
+	Host    string
+	Started time.Time
+}
+
+func (e *HeldError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("lock %s is being created by another process since %s", e.Path, e.Started.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("lock %s is held by pid %d on %s since %s", e.Path, e.PID, e.Host, e.Started.Format(time.RFC3339))
+}
+
+// Lock is an acquired lock file.
+type Lock struct {
+	path  string
+	owner owner
+}
+
+// Acquire creates the lock file at path. An existing lock is taken over when
+// it is stale: its process no longer exists on this host, its content has
+// been unreadable for longer than createGrace, or it is older than maxAge
+// (0 = no age limit). Otherwise a *HeldError is returned.
+func Acquire(path string, maxAge time.Duration) (*Lock, error) {
+	host, _ := os.Hostname()
+	var token [16]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return nil, err
+	}
+	me := owner{PID: os.Getpid(), Host: host, Started: time.Now(), Token: hex.EncodeToString(token[:])}
+	data, err := json.Marshal(me)
+	if err != nil {
+		return nil, err
+	}
+
+	// Retry a few times: the lock may be released or replaced while we look at it.
+	for range 3 {
+		err := create(path, data)
+		if err == nil {
+			return &Lock{path: path, owner: me}, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+
+		prev, raw, err := read(path)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue // released in the meantime
+			}
+			return nil, err
+		}
+		if prev == nil {
+			// Possibly still being written by its creator.
+			if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) < createGrace {
+				return nil, &HeldError{Path: path, Started: fi.ModTime()}
+			}
+		} else if !stale(*prev, host, maxAge) {
+			return nil, &HeldError{Path: path, PID: prev.PID, Host: prev.Host, Started: prev.Started}
+		}
+		// Only remove the lock we inspected, not one a concurrent process
+		// has just created in its place.
+		if _, cur, err := read(path); err == nil && string(cur) != string(raw) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("remove stale lock: %w", err)
+		}
+	}
+	return nil, fmt.Errorf("lock %s: contended, giving up", path)
+}
+
+// Release removes the lock file, unless another process has taken it over
+// in the meantime (e.g. after maxAge passed), in which case it is left alone
+// and an error is returned.
+func (l *Lock) Release() error {
+	cur, _, err := read(l.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if cur == nil || cur.PID != l.owner.PID || cur.Token != l.owner.Token {
+		return fmt.Errorf("lock %s was taken over by another process, leaving it in place", l.path)
+	}
+	err = os.Remove(l.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// create writes data to a temporary file and links it into place, so that
+// the lock file never exists without its content. File systems without hard
+// links fall back to creating the file exclusively and writing it.
+func create(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return createExcl(path, data)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Link(tmp.Name(), path)
+	if err == nil || errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	return createExcl(path, data)
+}
+
+func createExcl(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// read returns the owner of the lock at path, or nil when the content cannot
+// be parsed (still being written, or a crash mid-write), along with the raw
+// content.
+func read(path string) (*owner, []byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var o owner
+	if err := json.Unmarshal(raw, &o); err != nil || o.PID <= 0 {
+		return nil, raw, nil
+	}
+	return &o, raw, nil
+}
+
+func stale(o owner, host string, maxAge time.Duration) bool {
+	if maxAge > 0 && time.Since(o.Started) > maxAge {
+		return true
+	}
+	// A process on another host (shared storage) cannot be checked.
+	if o.Host != host {
+		return false
+	}
+	// Our own PID is a previous run whose PID we were given again, as PID 1
+	// is in every restart of a container: we hold no lock we do not know of.
+	return o.PID == os.Getpid() || !processAlive(o.PID)
+}
@@ -0,0 +1,66 @@
+// Package logging holds the leveled diagnostics of mcis, written with
+// log/slog: the level below debug that per-probe lines use, the handlers of
+// the --log-format flag and the attributes that lines about the same thing
+// share, so they can be filtered alike whichever part of mcis wrote them.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"strings"
+)
+
+// LevelTrace is the level of the lines written for every probe, below
+// slog.LevelDebug.
+const LevelTrace = slog.LevelDebug - 4
+
+// Formats lists the formats NewHandler accepts.
+var Formats = []string{"text", "json"}
+
+// Attribute keys shared by the log lines.
+const (
+	KeyIP       = "ip"
+	KeySubnet   = "subnet"
+	KeyPhase    = "phase"
+	KeyProvider = "provider"
+)
+
+// IP is the attribute of the IP a line is about.
+func IP(ip netip.Addr) slog.Attr { return slog.String(KeyIP, ip.String()) }
+
+// Subnet is the attribute of the prefix a line is about.
+func Subnet(p netip.Prefix) slog.Attr { return slog.String(KeySubnet, p.String()) }
+
+// Phase is the attribute of the step of a run a line belongs to, e.g.
+// search, refine, download or dns.
+func Phase(name string) slog.Attr { return slog.String(KeyPhase, name) }
+
+// Provider is the attribute of the DNS or publishing provider a line is
+// about.
+func Provider(name string) slog.Attr { return slog.String(KeyProvider, name) }
+
+// NewHandler returns a handler that writes the records at or above level to
+// w as logfmt-style text or as JSON, one record per line. Trace records are
+// labeled TRACE.
+func NewHandler(w io.Writer, format string, level slog.Leveler) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: replaceLevel}
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q (want %s)", format, strings.Join(Formats, ", "))
+}
+
+// replaceLevel names LevelTrace, which slog would print as DEBUG-4.
+func replaceLevel(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 && a.Key == slog.LevelKey {
+		if l, ok := a.Value.Any().(slog.Level); ok && l <= LevelTrace {
+			a.Value = slog.StringValue("TRACE")
+		}
+	}
+	return a
+}
@@ -0,0 +1,269 @@
+// Package mqtt is a minimal MQTT 3.1.1 client that publishes messages: it
+// connects (optionally over TLS, with a username and password), publishes
+// with QoS 0 or 1 and disconnects. It does not subscribe.
+package mqtt
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// Packet types.
+const (
+	typeConnect    = 1
+	typeConnack    = 2
+	typePublish    = 3
+	typePuback     = 4
+	typeDisconnect = 14
+)
+
+// Options configure a connection.
+type Options struct {
+	// Broker is the broker URL: mqtt://host[:1883] or mqtts://host[:8883]
+	// (also tcp:// and ssl://). User info in the URL is used as the login.
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	// TLS is used for mqtts brokers; nil means the system roots.
+	TLS *tls.Config
+	// KeepAlive is sent to the broker; the connection is short-lived, so
+	// this only needs to cover one batch of publishes.
+	KeepAlive time.Duration
+}
+
+// Client is a connection to a broker.
+type Client struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	nextID uint16
+	stop   func() bool // unregisters the abort on ctx cancellation
+}
+
+// ConnectError is a connection refused by the broker.
+type ConnectError struct {
+	Code byte
+}
+
+func (e *ConnectError) Error() string {
+	reasons := map[byte]string{
+		1: "unacceptable protocol version",
+		2: "client identifier rejected",
+		3: "server unavailable",
+		4: "bad username or password",
+		5: "not authorized",
+	}
+	if r, ok := reasons[e.Code]; ok {
+		return "mqtt: connection refused: " + r
+	}
+	return fmt.Sprintf("mqtt: connection refused: code %d", e.Code)
+}
+
+// Dial connects to the broker. ctx bounds the connection and every later
+// operation of the client: its deadline applies to them and canceling it
+// aborts them.
+func Dial(ctx context.Context, o Options) (*Client, error) {
+	u, err := url.Parse(o.Broker)
+	if err != nil {
+		return nil, fmt.Errorf("mqtt: broker URL: %w", err)
+	}
+	secure := false
+	port := "1883"
+	switch u.Scheme {
+	case "mqtt", "tcp":
+	case "mqtts", "ssl", "tls":
+		secure, port = true, "8883"
+	default:
+		return nil, fmt.Errorf("mqtt: unsupported broker scheme %q (use mqtt:// or mqtts://)", u.Scheme)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	if u.User != nil && o.Username == "" {
+		o.Username = u.User.Username()
+		o.Password, _ = u.User.Password()
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	// A deadline in the past unblocks any read or write in progress.
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Unix(1, 0)) })
+	if secure {
+		cfg := &tls.Config{}
+		if o.TLS != nil {
+			cfg = o.TLS.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		conn = tls.Client(conn, cfg)
+	}
+
+	c := &Client{conn: conn, r: bufio.NewReader(conn), stop: stop}
+	if err := c.connect(o); err != nil {
+		stop()
+		conn.Close()
+		return nil, cmp.Or(ctx.Err(), err)
+	}
+	return c, nil
+}
+
+func (c *Client) connect(o Options) error {
+	keepAlive := o.KeepAlive
+	if keepAlive <= 0 {
+		keepAlive = time.Minute
+	}
+	var flags byte = 0x02 // clean session
+	if o.Username != "" {
+		flags |= 0x80
+		if o.Password != "" {
+			flags |= 0x40
+		}
+	}
+	var b []byte
+	b = appendString(b, "MQTT")
+	b = append(b, 4, flags) // protocol level 4 = 3.1.1
+	b = binary.BigEndian.AppendUint16(b, uint16(min(keepAlive/time.Second, 0xffff)))
+	b = appendString(b, o.ClientID)
+	if o.Username != "" {
+		b = appendString(b, o.Username)
+		if o.Password != "" {
+			b = appendString(b, o.Password)
+		}
+	}
+	if err := c.write(typeConnect<<4, b); err != nil {
+		return err
+	}
+
+	typ, body, err := c.read()
+	if err != nil {
+		return err
+	}
+	if typ != typeConnack || len(body) != 2 {
+		return errors.New("mqtt: unexpected reply to connect")
+	}
+	if body[1] != 0 {
+		return &ConnectError{Code: body[1]}
+	}
+	return nil
+}
+
+// Publish sends payload to topic. With qos 1 it waits for the broker's
+// acknowledgement; retain asks the broker to keep the message for future
+// subscribers.
+func (c *Client) Publish(topic string, payload []byte, qos byte, retain bool) error {
+	if qos > 1 {
+		return errors.New("mqtt: only QoS 0 and 1 are supported")
+	}
+	header := byte(typePublish<<4) | qos<<1
+	if retain {
+		header |= 0x01
+	}
+	b := appendString(nil, topic)
+	var id uint16
+	if qos > 0 {
+		c.nextID++
+		if c.nextID == 0 {
+			c.nextID = 1
+		}
+		id = c.nextID
+		b = binary.BigEndian.AppendUint16(b, id)
+	}
+	b = append(b, payload...)
+	if err := c.write(header, b); err != nil {
+		return err
+	}
+	if qos == 0 {
+		return nil
+	}
+
+	typ, body, err := c.read()
+	if err != nil {
+		return err
+	}
+	if typ != typePuback || len(body) != 2 || binary.BigEndian.Uint16(body) != id {
+		return errors.New("mqtt: unexpected reply to publish")
+	}
+	return nil
+}
+
+// Close disconnects from the broker.
+func (c *Client) Close() error {
+	c.stop()
+	err := c.write(typeDisconnect<<4, nil)
+	if cerr := c.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (c *Client) write(header byte, body []byte) error {
+	if len(body) > 268_435_455 {
+		return errors.New("mqtt: packet too large")
+	}
+	pkt := []byte{header}
+	// Remaining length: 7 bits per byte, high bit set on all but the last.
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		pkt = append(pkt, b)
+		if n == 0 {
+			break
+		}
+	}
+	pkt = append(pkt, body...)
+	_, err := c.conn.Write(pkt)
+	return err
+}
+
+func (c *Client) read() (typ byte, body []byte, err error) {
+	header, err := c.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, mult := 0, 1
+	for i := 0; ; i++ {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n += int(b&0x7f) * mult
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("mqtt: malformed packet length")
+		}
+		mult *= 128
+	}
+	body = make([]byte, n)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return 0, nil, err
+	}
+	return header >> 4, body, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
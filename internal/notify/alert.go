@@ -0,0 +1,285 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/transport"
+)
+
+// Alert conditions, used in the deduplication keys of the pages.
+const (
+	AlertNoQualifyingIPs = "no-qualifying-ips"
+	AlertUploadFailing   = "upload-failing"
+)
+
+// Alert is a page about one condition.
+type Alert struct {
+	Key      string // stable per host and condition, so repeats deduplicate
+	Summary  string
+	Host     string
+	Details  map[string]any
+	Severity string // "critical" or "error"
+}
+
+// Pager opens and closes incidents at an alerting service.
+type Pager interface {
+	Name() string
+	Trigger(ctx context.Context, a Alert) error
+	Resolve(ctx context.Context, a Alert) error
+}
+
+// AlertState is what Alerting remembers between runs: the consecutive upload
+// failures and the conditions currently paged. Keep one for the whole process
+// so that it survives config reloads.
+type AlertState struct {
+	mu             sync.Mutex
+	uploadFailures int
+	firing         map[string]bool
+}
+
+// Alerting is a Notifier that pages when a run finds no qualifying IPs, or
+// when the DNS upload failed on failAfter consecutive runs, and resolves the
+// page once the condition clears.
+type Alerting struct {
+	pagers    []Pager
+	failAfter int
+	state     *AlertState
+}
+
+// NewAlerting creates the notifier. failAfter below 1 is treated as 1.
+func NewAlerting(pagers []Pager, failAfter int, state *AlertState) *Alerting {
+	return &Alerting{pagers: pagers, failAfter: max(failAfter, 1), state: state}
+}
+
+func (a *Alerting) Name() string {
+	names := make([]string, len(a.pagers))
+	for i, p := range a.pagers {
+		names[i] = p.Name()
+	}
+	return "alert (" + strings.Join(names, ", ") + ")"
+}
+
+func (a *Alerting) Notify(ctx context.Context, s Summary) error {
+	if s.Interrupted {
+		return nil // a partial run says nothing about the health of the setup
+	}
+
+	a.state.mu.Lock()
+	switch {
+	case s.UploadError != "":
+		a.state.uploadFailures++
+	case s.UploadOK:
+		a.state.uploadFailures = 0
+	}
+	failures := a.state.uploadFailures
+	a.state.mu.Unlock()
+
+	noIPs := len(s.Top) == 0 || s.ThresholdMissed()
+	summary := "mcis on " + s.Host + " found no IPs meeting the thresholds"
+	if s.ThresholdMissed() {
+		summary = fmt.Sprintf("mcis on %s found only %d of the %d required IPs", s.Host, s.Qualifying, s.Required)
+	} else if s.Error != "" {
+		summary += ": " + s.Error
+	}
+	errs := []error{
+		a.set(ctx, noIPs, Alert{
+			Key:      "mcis/" + s.Host + "/" + AlertNoQualifyingIPs,
+			Summary:  summary,
+			Severity: "critical",
+		}, s),
+	}
+	if s.UploadEnabled {
+		errs = append(errs, a.set(ctx, failures >= a.failAfter, Alert{
+			Key:      "mcis/" + s.Host + "/" + AlertUploadFailing,
+			Summary:  fmt.Sprintf("mcis on %s failed to update %s %d times in a row: %s", s.Host, strings.Join(s.Targets, ", "), failures, s.UploadError),
+			Severity: "error",
+		}, s))
+	}
+	return errors.Join(errs...)
+}
+
+// set triggers the alert while the condition holds (again on every run, which
+// the services deduplicate) and resolves it once when it clears.
+func (a *Alerting) set(ctx context.Context, firing bool, al Alert, s Summary) error {
+	a.state.mu.Lock()
+	if a.state.firing == nil {
+		a.state.firing = map[string]bool{}
+	}
+	wasFiring := a.state.firing[al.Key]
+	a.state.firing[al.Key] = firing
+	a.state.mu.Unlock()
+	if !firing && !wasFiring {
+		return nil
+	}
+
+	al.Host = s.Host
+	al.Details = map[string]any{
+		"error":        s.Error,
+		"upload_error": s.UploadError,
+		"targets":      s.Targets,
+		"results":      len(s.Top),
+		"finished":     s.Finished.Format(time.RFC3339),
+	}
+	var errs []error
+	for _, p := range a.pagers {
+		var err error
+		if firing {
+			err = p.Trigger(ctx, al)
+		} else {
+			err = p.Resolve(ctx, al)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+const (
+	pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+	opsgenieAPIBase    = "https://api.opsgenie.com"
+)
+
+// PagerDuty sends alerts to the PagerDuty Events API v2.
+type PagerDuty struct {
+	routingKey string
+	client     *http.Client
+}
+
+// NewPagerDuty creates a pager for the integration (routing) key of a service.
+func NewPagerDuty(routingKey string) *PagerDuty {
+	return &PagerDuty{routingKey: routingKey, client: transport.Client()}
+}
+
+func (p *PagerDuty) Name() string {
+	return "pagerduty"
+}
+
+func (p *PagerDuty) Trigger(ctx context.Context, a Alert) error {
+	return postJSON(ctx, p.client, pagerDutyEventsURL, map[string]any{
+		"routing_key":  p.routingKey,
+		"event_action": "trigger",
+		"dedup_key":    a.Key,
+		"payload": map[string]any{
+			"summary":        a.Summary,
+			"source":         a.Host,
+			"severity":       a.Severity,
+			"component":      "mcis",
+			"custom_details": a.Details,
+		},
+	}, nil)
+}
+
+func (p *PagerDuty) Resolve(ctx context.Context, a Alert) error {
+	return postJSON(ctx, p.client, pagerDutyEventsURL, map[string]any{
+		"routing_key":  p.routingKey,
+		"event_action": "resolve",
+		"dedup_key":    a.Key,
+	}, nil)
+}
+
+// Opsgenie sends alerts to the Opsgenie Alert API.
+type Opsgenie struct {
+	apiKey  string
+	baseURL string
+	client  *http.Client
+}
+
+// NewOpsgenie creates a pager for an API integration key. baseURL selects the
+// region, e.g. "https://api.eu.opsgenie.com"; empty means the default one.
+func NewOpsgenie(apiKey, baseURL string) *Opsgenie {
+	if baseURL == "" {
+		baseURL = opsgenieAPIBase
+	}
+	return &Opsgenie{apiKey: apiKey, baseURL: strings.TrimSuffix(baseURL, "/"), client: transport.Client()}
+}
+
+func (o *Opsgenie) Name() string {
+	return "opsgenie"
+}
+
+func (o *Opsgenie) header() http.Header {
+	return http.Header{"Authorization": {"GenieKey " + o.apiKey}}
+}
+
+func (o *Opsgenie) Trigger(ctx context.Context, a Alert) error {
+	priority := "P2"
+	if a.Severity == "critical" {
+		priority = "P1"
+	}
+	details := make(map[string]string, len(a.Details))
+	for k, v := range a.Details {
+		details[k] = fmt.Sprint(v)
+	}
+	return postJSON(ctx, o.client, o.baseURL+"/v2/alerts", map[string]any{
+		"message":  truncate(a.Summary, 130),
+		"alias":    a.Key,
+		"source":   a.Host,
+		"priority": priority,
+		"tags":     []string{"mcis"},
+		"details":  details,
+	}, o.header())
+}
+
+func (o *Opsgenie) Resolve(ctx context.Context, a Alert) error {
+	return postJSON(ctx, o.client, o.baseURL+"/v2/alerts/"+url.PathEscape(a.Key)+"/close?identifierType=alias",
+		map[string]any{"source": a.Host}, o.header())
+}
+
+// Alertmanager posts alerts to a Prometheus Alertmanager, or anything else
+// that accepts its /api/v2/alerts format.
+type Alertmanager struct {
+	url    string
+	client *http.Client
+}
+
+// NewAlertmanager creates a pager for an Alertmanager base URL such as
+// "http://alertmanager:9093".
+func NewAlertmanager(baseURL string) *Alertmanager {
+	return &Alertmanager{url: strings.TrimSuffix(baseURL, "/") + "/api/v2/alerts", client: transport.Client()}
+}
+
+func (m *Alertmanager) Name() string {
+	return "alertmanager"
+}
+
+func (m *Alertmanager) Trigger(ctx context.Context, a Alert) error {
+	return m.post(ctx, a, time.Time{})
+}
+
+// Resolve sends the alert with an end time in the past.
+func (m *Alertmanager) Resolve(ctx context.Context, a Alert) error {
+	return m.post(ctx, a, time.Now())
+}
+
+func (m *Alertmanager) post(ctx context.Context, a Alert, endsAt time.Time) error {
+	condition := a.Key[strings.LastIndex(a.Key, "/")+1:]
+	alert := map[string]any{
+		"labels": map[string]string{
+			"alertname": "mcis-" + condition,
+			"instance":  a.Host,
+			"severity":  a.Severity,
+		},
+		"annotations": map[string]string{"summary": a.Summary},
+	}
+	if !endsAt.IsZero() {
+		alert["endsAt"] = endsAt.UTC().Format(time.RFC3339)
+	}
+	return postJSON(ctx, m.client, m.url, []any{alert}, nil)
+}
+
+// truncate shortens s to at most n runes.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
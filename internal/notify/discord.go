@@ -0,0 +1,97 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/i18n"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/transport"
+)
+
+// Embed colors of a Discord message.
+const (
+	discordGreen  = 0x2ecc71
+	discordOrange = 0xe67e22
+	discordRed    = 0xe74c3c
+)
+
+// Discord posts run summaries to a Discord webhook as an embed.
+type Discord struct {
+	webhookURL string
+	client     *http.Client
+}
+
+// NewDiscord creates a notifier for the webhook URL.
+func NewDiscord(webhookURL string) *Discord {
+	return &Discord{webhookURL: webhookURL, client: transport.Client()}
+}
+
+func (d *Discord) Name() string {
+	return "discord"
+}
+
+func (d *Discord) Notify(ctx context.Context, s Summary) error {
+	type field struct {
+		Name   string `json:"name"`
+		Value  string `json:"value"`
+		Inline bool   `json:"inline,omitempty"`
+	}
+	type embed struct {
+		Title       string  `json:"title"`
+		Description string  `json:"description,omitempty"`
+		Color       int     `json:"color"`
+		Fields      []field `json:"fields,omitempty"`
+		Footer      struct {
+			Text string `json:"text"`
+		} `json:"footer"`
+		Timestamp string `json:"timestamp"`
+	}
+
+	e := embed{Title: Title(s), Color: discordGreen, Timestamp: s.Finished.Format(time.RFC3339)}
+	switch {
+	case !s.OK:
+		e.Color = discordRed
+	case s.UploadEnabled && !s.UploadOK, s.Interrupted:
+		e.Color = discordOrange
+	}
+	if s.Error != "" {
+		e.Description = "**" + i18n.T("Error:") + "** " + s.Error
+	}
+	if len(s.Top) > 0 {
+		var ips, metrics strings.Builder
+		for i, t := range s.Top {
+			fmt.Fprintf(&ips, "%d. `%s` %s\n", i+1, t.IP, t.Colo)
+			fmt.Fprintf(&metrics, "%.0fms (%s)", t.ScoreMS, t.Delta())
+			if t.DownloadMbps > 0 {
+				fmt.Fprintf(&metrics, " · %.1f Mbps", t.DownloadMbps)
+			}
+			metrics.WriteString("\n")
+		}
+		e.Fields = append(e.Fields,
+			field{Name: i18n.T("Selected IPs"), Value: ips.String(), Inline: true},
+			field{Name: i18n.T("Latency · Speed"), Value: metrics.String(), Inline: true})
+	}
+	if s.UploadEnabled {
+		state := i18n.T("failed")
+		switch {
+		case s.UploadOK:
+			state = i18n.Sprintf("published %d IPs", len(s.Uploaded))
+		case s.UploadError == "":
+			state = i18n.T("nothing uploaded")
+		}
+		if s.UploadError != "" {
+			state += ": " + s.UploadError
+		}
+		e.Fields = append(e.Fields, field{Name: "DNS " + strings.Join(s.Targets, ", "), Value: state})
+	}
+	e.Footer.Text = s.Host + " · " + i18n.Sprintf("took %s", s.Duration.Round(time.Second))
+
+	msg := map[string]any{"username": "mcis", "embeds": []embed{e}}
+	if err := postJSON(ctx, d.client, d.webhookURL, msg, nil); err != nil {
+		return fmt.Errorf("post webhook: %w", redactURL(err, d.webhookURL))
+	}
+	return nil
+}
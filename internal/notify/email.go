@@ -0,0 +1,210 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxAttachment is the largest results file attached to an email.
+const maxAttachment = 10 << 20
+
+// TLS modes of an SMTP connection.
+const (
+	SMTPStartTLS = "starttls" // plain connection upgraded with STARTTLS
+	SMTPTLS      = "tls"      // implicit TLS, usually port 465
+	SMTPPlain    = "none"     // no TLS; only for relays on localhost
+)
+
+// EmailConfig configures the email notifier.
+type EmailConfig struct {
+	Addr     string // SMTP server host:port
+	TLS      string // one of the SMTP* modes; empty picks tls for port 465 and starttls otherwise
+	Username string // empty disables authentication
+	Password string
+	From     string
+	To       []string
+}
+
+// Email sends run summaries by SMTP, with the results file attached.
+type Email struct {
+	cfg  EmailConfig
+	host string
+}
+
+// NewEmail validates cfg and creates the notifier.
+func NewEmail(cfg EmailConfig) (*Email, error) {
+	host, port, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("smtp address %q: %w", cfg.Addr, err)
+	}
+	if cfg.TLS == "" {
+		cfg.TLS = SMTPStartTLS
+		if port == "465" {
+			cfg.TLS = SMTPTLS
+		}
+	}
+	switch cfg.TLS {
+	case SMTPStartTLS, SMTPTLS, SMTPPlain:
+	default:
+		return nil, fmt.Errorf("unknown smtp tls mode %q (supported: %s, %s, %s)", cfg.TLS, SMTPStartTLS, SMTPTLS, SMTPPlain)
+	}
+	if _, err := mail.ParseAddress(cfg.From); err != nil {
+		return nil, fmt.Errorf("smtp sender %q: %w", cfg.From, err)
+	}
+	if len(cfg.To) == 0 {
+		return nil, errors.New("smtp: no recipients")
+	}
+	for _, to := range cfg.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return nil, fmt.Errorf("smtp recipient %q: %w", to, err)
+		}
+	}
+	return &Email{cfg: cfg, host: host}, nil
+}
+
+func (e *Email) Name() string {
+	return "email"
+}
+
+func (e *Email) Notify(ctx context.Context, s Summary) error {
+	msg, err := e.message(s)
+	if err != nil {
+		return err
+	}
+
+	d := net.Dialer{}
+	conn, err := d.DialContext(ctx, "tcp", e.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	// Canceling ctx aborts the SMTP exchange, not only its connect.
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+	tlsCfg := &tls.Config{ServerName: e.host}
+	if e.cfg.TLS == SMTPTLS {
+		conn = tls.Client(conn, tlsCfg)
+	}
+	c, err := smtp.NewClient(conn, e.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if e.cfg.TLS == SMTPStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("smtp: server does not support STARTTLS")
+		}
+		if err := c.StartTLS(tlsCfg); err != nil {
+			return fmt.Errorf("smtp starttls: %w", err)
+		}
+	}
+	if e.cfg.Username != "" {
+		// PlainAuth refuses to send the password without TLS, except to localhost.
+		if err := c.Auth(smtp.PlainAuth("", e.cfg.Username, e.cfg.Password, e.host)); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+	from, _ := mail.ParseAddress(e.cfg.From)
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, to := range e.cfg.To {
+		addr, _ := mail.ParseAddress(to)
+		if err := c.Rcpt(addr.Address); err != nil {
+			return fmt.Errorf("smtp recipient %s: %w", addr.Address, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// message builds the MIME message: the text summary and, when the run wrote
+// one, the results file as an attachment.
+func (e *Email) message(s Summary) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	h := textproto.MIMEHeader{}
+	h.Set("From", e.cfg.From)
+	h.Set("To", strings.Join(e.cfg.To, ", "))
+	h.Set("Subject", mime.QEncoding.Encode("utf-8", Title(s)))
+	h.Set("Date", s.Finished.Format(time.RFC1123Z))
+	h.Set("MIME-Version", "1.0")
+	h.Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	for _, k := range []string{"From", "To", "Subject", "Date", "MIME-Version", "Content-Type"} {
+		fmt.Fprintf(&buf, "%s: %s\r\n", k, h.Get(k))
+	}
+	buf.WriteString("\r\n")
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	writeBase64(part, []byte(Text(s)))
+
+	if s.ResultsFile != "" {
+		data, err := os.ReadFile(s.ResultsFile)
+		switch {
+		case err != nil:
+			return nil, fmt.Errorf("attach results: %w", err)
+		case len(data) > maxAttachment:
+			// Too large for most mail servers; the summary is still useful.
+		default:
+			name := filepath.Base(s.ResultsFile)
+			part, err := mw.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {mime.FormatMediaType("application/octet-stream", map[string]string{"name": name})},
+				"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": name})},
+				"Content-Transfer-Encoding": {"base64"},
+			})
+			if err != nil {
+				return nil, err
+			}
+			writeBase64(part, data)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeBase64 writes data base64-encoded in lines of 76 characters.
+func writeBase64(w io.Writer, data []byte) {
+	enc := base64.StdEncoding.EncodeToString(data)
+	for len(enc) > 76 {
+		_, _ = w.Write([]byte(enc[:76] + "\r\n"))
+		enc = enc[76:]
+	}
+	_, _ = w.Write([]byte(enc + "\r\n"))
+}
@@ -0,0 +1,110 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/i18n"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/transport"
+)
+
+// TagRotation tags Grafana annotations of runs that changed the published
+// IPs.
+const TagRotation = "rotation"
+
+// Grafana posts an annotation for each run to the Grafana HTTP API, spanning
+// the run on the time axis, so dashboards show when scans and IP rotations
+// happened.
+type Grafana struct {
+	url          string
+	token        string
+	dashboardUID string
+	tags         []string
+	onlyRotation bool
+	client       *http.Client
+}
+
+// NewGrafana creates a notifier for the Grafana at baseURL. token is a
+// service account token with the annotations:write permission. The
+// annotations go to the dashboard dashboardUID, or are organization-wide
+// when it is empty. With onlyRotation set, only runs that changed the
+// published IPs are annotated.
+func NewGrafana(baseURL, token, dashboardUID string, tags []string, onlyRotation bool) *Grafana {
+	return &Grafana{
+		url:          strings.TrimSuffix(baseURL, "/") + "/api/annotations",
+		token:        token,
+		dashboardUID: dashboardUID,
+		tags:         tags,
+		onlyRotation: onlyRotation,
+		client:       transport.Client(),
+	}
+}
+
+func (g *Grafana) Name() string {
+	return "grafana"
+}
+
+func (g *Grafana) Notify(ctx context.Context, s Summary) error {
+	if g.onlyRotation && !s.Rotated {
+		return nil
+	}
+	tags := append([]string{"mcis", s.Host}, s.Events()...)
+	if s.Rotated {
+		tags = append(tags, TagRotation)
+	}
+	tags = append(tags, g.tags...)
+
+	msg := map[string]any{
+		"time":    s.Finished.Add(-s.Duration).UnixMilli(),
+		"timeEnd": s.Finished.UnixMilli(),
+		"tags":    tags,
+		"text":    grafanaText(s),
+	}
+	if g.dashboardUID != "" {
+		msg["dashboardUID"] = g.dashboardUID
+	}
+	header := http.Header{"Authorization": {"Bearer " + g.token}}
+	if err := postJSON(ctx, g.client, g.url, msg, header); err != nil {
+		return fmt.Errorf("create annotation: %w", err)
+	}
+	return nil
+}
+
+// grafanaText is the annotation text: the summary, led by the new IPs of a
+// rotation. Grafana renders it as HTML.
+func grafanaText(s Summary) string {
+	lines := strings.Split(Text(s), "\n")
+	if s.Rotated {
+		ips := make([]string, len(s.Published))
+		for i, ip := range s.Published {
+			ips[i] = ip.String()
+		}
+		lines = append(lines[:1], append([]string{i18n.Sprintf("Rotated to %s", strings.Join(ips, ", "))}, lines[1:]...)...)
+	}
+	for i, l := range lines {
+		lines[i] = html.EscapeString(l)
+	}
+	return strings.Join(lines, "<br>")
+}
+
+// SameIPs reports whether a and b hold the same IPs, in any order.
+func SameIPs(a, b []netip.Addr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[netip.Addr]int, len(a))
+	for _, ip := range a {
+		seen[ip]++
+	}
+	for _, ip := range b {
+		if seen[ip] == 0 {
+			return false
+		}
+		seen[ip]--
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package notify
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// haEntity is a Home Assistant MQTT discovery config.
+type haEntity struct {
+	component string // "sensor" or "binary_sensor"
+	objectID  string
+	config    map[string]any
+}
+
+// homeAssistantMessages returns the retained discovery configs announcing the
+// run sensors to Home Assistant under prefix (usually "homeassistant").
+func (m *MQTT) homeAssistantMessages(s Summary) ([]mqttMessage, error) {
+	node := haID(s.Host)
+	device := map[string]any{
+		"identifiers":  []string{"mcis_" + node},
+		"name":         "mcis " + s.Host,
+		"manufacturer": "mcis",
+		"model":        "Monte Carlo IP Searcher",
+	}
+	summary, upload := m.topic+"/summary", m.topic+"/upload"
+	entities := []haEntity{
+		{"sensor", "best_latency", map[string]any{
+			"name":                "Best latency",
+			"state_topic":         summary,
+			"value_template":      "{{ value_json.top[0].score_ms | round(0) if value_json.top else None }}",
+			"unit_of_measurement": "ms",
+			"device_class":        "duration",
+			"state_class":         "measurement",
+			"icon":                "mdi:timer-outline",
+		}},
+		{"sensor", "best_ip", map[string]any{
+			"name":           "Best IP",
+			"state_topic":    summary,
+			"value_template": "{{ value_json.top[0].ip if value_json.top else None }}",
+			"icon":           "mdi:ip-network",
+		}},
+		{"sensor", "results", map[string]any{
+			"name":           "Qualifying IPs",
+			"state_topic":    summary,
+			"value_template": "{{ value_json.top | count }}",
+			"state_class":    "measurement",
+		}},
+		{"sensor", "last_run", map[string]any{
+			"name":           "Last run",
+			"state_topic":    summary,
+			"value_template": "{{ value_json.finished }}",
+			"device_class":   "timestamp",
+		}},
+		{"sensor", "last_upload", map[string]any{
+			"name":           "Last upload",
+			"state_topic":    upload,
+			"value_template": "{{ value_json.finished }}",
+			"device_class":   "timestamp",
+			"icon":           "mdi:dns",
+		}},
+		{"binary_sensor", "problem", map[string]any{
+			"name":           "Problem",
+			"state_topic":    summary,
+			"value_template": "{{ 'OFF' if value_json.ok and (not value_json.upload_enabled or value_json.upload_ok) else 'ON' }}",
+			"device_class":   "problem",
+		}},
+	}
+
+	msgs := make([]mqttMessage, 0, len(entities))
+	for _, e := range entities {
+		e.config["unique_id"] = "mcis_" + node + "_" + e.objectID
+		e.config["object_id"] = "mcis_" + node + "_" + e.objectID
+		e.config["device"] = device
+		data, err := json.Marshal(e.config)
+		if err != nil {
+			return nil, err
+		}
+		topic := strings.Join([]string{m.haPrefix, e.component, "mcis_" + node, e.objectID, "config"}, "/")
+		msgs = append(msgs, mqttMessage{topic, data})
+	}
+	return msgs, nil
+}
+
+// haID turns a host name into the characters allowed in discovery IDs.
+func haID(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
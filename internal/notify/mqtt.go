@@ -0,0 +1,97 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/mqtt"
+)
+
+// MQTT publishes run summaries to a broker, as retained QoS 1 messages:
+//
+//	<topic>/summary  the Summary as JSON
+//	<topic>/ips      the selected IPs as a JSON array, best first
+//	<topic>/upload   the time and IPs of the last successful DNS upload
+//
+// The IP list and the upload are only updated by runs that have them. With a
+// Home Assistant discovery prefix, the run sensors are announced as well.
+type MQTT struct {
+	opts     mqtt.Options
+	topic    string
+	haPrefix string
+}
+
+// NewMQTT creates a notifier publishing under topic. haPrefix, when set, is
+// the Home Assistant discovery prefix (usually "homeassistant").
+func NewMQTT(opts mqtt.Options, topic, haPrefix string) *MQTT {
+	return &MQTT{opts: opts, topic: topic, haPrefix: haPrefix}
+}
+
+func (m *MQTT) Name() string {
+	return "mqtt"
+}
+
+func (m *MQTT) Notify(ctx context.Context, s Summary) error {
+	summary, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	var msgs []mqttMessage
+	if m.haPrefix != "" {
+		// Discovery configs go first, so the sensors exist when the state arrives.
+		if msgs, err = m.homeAssistantMessages(s); err != nil {
+			return err
+		}
+	}
+	msgs = append(msgs, mqttMessage{m.topic + "/summary", summary})
+	if ips := SelectedIPs(s); len(ips) > 0 {
+		data, err := json.Marshal(ips)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, mqttMessage{m.topic + "/ips", data})
+	}
+	if s.UploadOK {
+		data, err := json.Marshal(map[string]any{"finished": s.Finished, "ips": s.Uploaded, "targets": s.Targets})
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, mqttMessage{m.topic + "/upload", data})
+	}
+	return publishMQTT(ctx, m.opts, msgs)
+}
+
+// SelectedIPs returns the IPs a run settled on: the uploaded ones when it
+// published to DNS, its best results otherwise.
+func SelectedIPs(s Summary) []netip.Addr {
+	if len(s.Uploaded) > 0 {
+		return s.Uploaded
+	}
+	ips := make([]netip.Addr, len(s.Top))
+	for i, e := range s.Top {
+		ips[i] = e.IP
+	}
+	return ips
+}
+
+type mqttMessage struct {
+	topic   string
+	payload []byte
+}
+
+// publishMQTT publishes retained messages over one connection.
+func publishMQTT(ctx context.Context, opts mqtt.Options, msgs []mqttMessage) error {
+	c, err := mqtt.Dial(ctx, opts)
+	if err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		if err := c.Publish(msg.topic, msg.payload, 1, true); err != nil {
+			c.Close()
+			return fmt.Errorf("publish %s: %w", msg.topic, err)
+		}
+	}
+	return c.Close()
+}
@@ -0,0 +1,212 @@
+// Package notify sends a summary of each search run to chat services and
+// other endpoints.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/i18n"
+)
+
+// Summary describes a finished run.
+type Summary struct {
+	Host     string        `json:"host"` // machine the run happened on
+	Finished time.Time     `json:"finished"`
+	Duration time.Duration `json:"duration_ns"`
+
+	// OK is false when the search failed; Error then tells why.
+	OK          bool   `json:"ok"`
+	Error       string `json:"error,omitempty"`
+	Interrupted bool   `json:"interrupted"`
+
+	// Top holds the best results, best first.
+	Top []Entry `json:"top"`
+
+	UploadEnabled bool         `json:"upload_enabled"`
+	UploadOK      bool         `json:"upload_ok"`
+	UploadError   string       `json:"upload_error,omitempty"`
+	Targets       []string     `json:"targets,omitempty"` // DNS targets, e.g. "cloudflare:cf"
+	Uploaded      []netip.Addr `json:"uploaded,omitempty"`
+	// Qualifying and Required are set when the upload was skipped because
+	// fewer IPs than Required passed the download test.
+	Qualifying int `json:"qualifying,omitempty"`
+	Required   int `json:"required,omitempty"`
+
+	// Published holds the IPs that reached DNS or a publisher; Rotated is
+	// true when they differ from the IPs published before.
+	Published []netip.Addr `json:"published,omitempty"`
+	Rotated   bool         `json:"rotated"`
+
+	// ResultsFile is the --out-file the results were written to, if any.
+	ResultsFile string `json:"results_file,omitempty"`
+}
+
+// Entry is one of the best IPs of a run.
+type Entry struct {
+	IP      netip.Addr `json:"ip"`
+	Colo    string     `json:"colo,omitempty"`
+	ScoreMS float64    `json:"score_ms"`
+	// PrevMS is the score of the IP in the previous run, 0 when it is new.
+	PrevMS       float64 `json:"prev_ms,omitempty"`
+	DownloadMbps float64 `json:"download_mbps,omitempty"` // 0 when not download-tested
+}
+
+// Events of a run.
+const (
+	EventRunComplete     = "run-complete"
+	EventRunFailed       = "run-failed"
+	EventUploadFailed    = "upload-failed"
+	EventThresholdMissed = "threshold-missed"
+)
+
+// EventNames lists every event.
+var EventNames = []string{EventRunComplete, EventRunFailed, EventUploadFailed, EventThresholdMissed}
+
+// Events returns the events of the run: run-complete or run-failed, plus
+// upload-failed and threshold-missed when they apply.
+func (s Summary) Events() []string {
+	events := []string{EventRunComplete}
+	if !s.OK {
+		events[0] = EventRunFailed
+	}
+	if s.UploadEnabled && s.UploadError != "" {
+		events = append(events, EventUploadFailed)
+	}
+	if s.ThresholdMissed() {
+		events = append(events, EventThresholdMissed)
+	}
+	return events
+}
+
+// ThresholdMissed reports whether the upload was skipped for lack of
+// qualifying IPs.
+func (s Summary) ThresholdMissed() bool {
+	return s.Required > 0
+}
+
+// Notifier delivers run summaries.
+type Notifier interface {
+	// Name identifies the notifier in error messages.
+	Name() string
+	// Notify sends the summary of one run.
+	Notify(ctx context.Context, s Summary) error
+}
+
+// Title is a one-line headline of the run.
+func Title(s Summary) string {
+	switch {
+	case !s.OK:
+		return i18n.Sprintf("mcis run failed on %s", s.Host)
+	case s.UploadEnabled && !s.UploadOK:
+		return i18n.Sprintf("mcis run finished on %s, DNS upload failed", s.Host)
+	case s.Interrupted:
+		return i18n.Sprintf("mcis run interrupted on %s", s.Host)
+	default:
+		return i18n.Sprintf("mcis run finished on %s", s.Host)
+	}
+}
+
+// Delta formats the change of an entry's score since the previous run.
+func (e Entry) Delta() string {
+	if e.PrevMS == 0 {
+		return i18n.T("new")
+	}
+	return fmt.Sprintf("%+.0fms", e.ScoreMS-e.PrevMS)
+}
+
+// Line formats an entry on one line, e.g. "1.2.3.4 HKG 153ms (-12ms) 85.3Mbps".
+func (e Entry) Line() string {
+	var b strings.Builder
+	b.WriteString(e.IP.String())
+	if e.Colo != "" {
+		b.WriteString(" " + e.Colo)
+	}
+	fmt.Fprintf(&b, " %.0fms (%s)", e.ScoreMS, e.Delta())
+	if e.DownloadMbps > 0 {
+		fmt.Fprintf(&b, " %.1fMbps", e.DownloadMbps)
+	}
+	return b.String()
+}
+
+// UploadLine describes the DNS upload, or is empty when it is disabled.
+func UploadLine(s Summary) string {
+	switch {
+	case !s.UploadEnabled:
+		return ""
+	case s.UploadOK:
+		return i18n.Sprintf("DNS: published %d IPs to %s", len(s.Uploaded), strings.Join(s.Targets, ", "))
+	case s.ThresholdMissed():
+		return i18n.Sprintf("DNS: upload skipped, only %d of the %d required IPs qualified", s.Qualifying, s.Required)
+	case s.UploadError != "":
+		return i18n.Sprintf("DNS: upload to %s failed: %s", strings.Join(s.Targets, ", "), s.UploadError)
+	default:
+		return i18n.Sprintf("DNS: nothing uploaded to %s", strings.Join(s.Targets, ", "))
+	}
+}
+
+// Text renders the summary as plain text for chat messages.
+func Text(s Summary) string {
+	var b strings.Builder
+	b.WriteString(Title(s))
+	b.WriteString("\n")
+	if s.Error != "" {
+		b.WriteString(i18n.Sprintf("Error: %s", s.Error) + "\n")
+	}
+	for i, e := range s.Top {
+		fmt.Fprintf(&b, "%d. %s\n", i+1, e.Line())
+	}
+	if l := UploadLine(s); l != "" {
+		b.WriteString(l + "\n")
+	}
+	b.WriteString(i18n.Sprintf("Took %s", s.Duration.Round(time.Second)))
+	return b.String()
+}
+
+// postJSON POSTs v as JSON and fails on a non-2xx status.
+func postJSON(ctx context.Context, client *http.Client, url string, v any, header http.Header) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return post(ctx, client, url, data, header, "application/json")
+}
+
+// post POSTs a body of the given content type and fails on a non-2xx status.
+func post(ctx context.Context, client *http.Client, url string, data []byte, header http.Header, contentType string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// redactURL removes a secret URL (a webhook, say) from an error of the HTTP
+// client, which quotes the URL of the failed request.
+func redactURL(err error, secret string) error {
+	if err == nil || secret == "" || !strings.Contains(err.Error(), secret) {
+		return err
+	}
+	return errors.New(strings.ReplaceAll(err.Error(), secret, "***"))
+}
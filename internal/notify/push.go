@@ -0,0 +1,102 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/transport"
+)
+
+const (
+	ntfyDefaultServer = "https://ntfy.sh"
+	pushoverAPIURL    = "https://api.pushover.net/1/messages.json"
+)
+
+// Ntfy publishes run summaries to an ntfy topic.
+type Ntfy struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewNtfy creates a notifier for topic, either a topic name on ntfy.sh or the
+// full URL of a topic on another server. token (optional) is an access token
+// for protected topics.
+func NewNtfy(topic, token string) *Ntfy {
+	u := topic
+	if !strings.Contains(topic, "://") {
+		u = ntfyDefaultServer + "/" + topic
+	}
+	return &Ntfy{url: u, token: token, client: transport.Client()}
+}
+
+func (n *Ntfy) Name() string {
+	return "ntfy"
+}
+
+func (n *Ntfy) Notify(ctx context.Context, s Summary) error {
+	// ntfy decodes RFC 2047 words, which carry a title that is not ASCII.
+	header := http.Header{"Title": {mime.BEncoding.Encode("utf-8", Title(s))}, "Tags": {"white_check_mark"}}
+	switch {
+	case !s.OK:
+		header.Set("Priority", "high")
+		header.Set("Tags", "rotating_light")
+	case s.UploadEnabled && !s.UploadOK:
+		header.Set("Priority", "high")
+		header.Set("Tags", "warning")
+	}
+	if n.token != "" {
+		header.Set("Authorization", "Bearer "+n.token)
+	}
+	if err := post(ctx, n.client, n.url, []byte(body(s)), header, "text/plain; charset=utf-8"); err != nil {
+		return fmt.Errorf("publish: %w", err)
+	}
+	return nil
+}
+
+// Pushover sends run summaries through the Pushover API.
+type Pushover struct {
+	token  string
+	user   string
+	client *http.Client
+}
+
+// NewPushover creates a notifier for an application token and a user (or
+// group) key.
+func NewPushover(token, user string) *Pushover {
+	return &Pushover{token: token, user: user, client: transport.Client()}
+}
+
+func (p *Pushover) Name() string {
+	return "pushover"
+}
+
+func (p *Pushover) Notify(ctx context.Context, s Summary) error {
+	priority := "0"
+	if !s.OK || (s.UploadEnabled && !s.UploadOK) {
+		priority = "1"
+	}
+	form := url.Values{
+		"token":    {p.token},
+		"user":     {p.user},
+		"title":    {Title(s)},
+		"message":  {body(s)},
+		"priority": {priority},
+	}
+	err := post(ctx, p.client, pushoverAPIURL, []byte(form.Encode()), nil, "application/x-www-form-urlencoded")
+	if err != nil {
+		return fmt.Errorf("send message: %w", err)
+	}
+	return nil
+}
+
+// body is the plain-text summary without its title, for services that show
+// the title separately.
+func body(s Summary) string {
+	_, rest, _ := strings.Cut(Text(s), "\n")
+	return rest
+}
@@ -0,0 +1,76 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/i18n"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/transport"
+)
+
+// Slack posts run summaries to a Slack incoming webhook.
+type Slack struct {
+	webhookURL string
+	client     *http.Client
+}
+
+// NewSlack creates a notifier for the incoming webhook URL.
+func NewSlack(webhookURL string) *Slack {
+	return &Slack{webhookURL: webhookURL, client: transport.Client()}
+}
+
+func (s *Slack) Name() string {
+	return "slack"
+}
+
+func (s *Slack) Notify(ctx context.Context, sum Summary) error {
+	type text struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	type block struct {
+		Type     string `json:"type"`
+		Text     *text  `json:"text,omitempty"`
+		Elements []text `json:"elements,omitempty"`
+	}
+
+	blocks := []block{{Type: "header", Text: &text{Type: "plain_text", Text: Title(sum)}}}
+	if sum.Error != "" {
+		blocks = append(blocks, block{Type: "section", Text: &text{Type: "mrkdwn", Text: "*" + i18n.T("Error:") + "* " + slackEscape(sum.Error)}})
+	}
+	if len(sum.Top) > 0 {
+		var b strings.Builder
+		for i, e := range sum.Top {
+			fmt.Fprintf(&b, "%d. `%s`", i+1, e.IP)
+			if e.Colo != "" {
+				b.WriteString(" " + e.Colo)
+			}
+			fmt.Fprintf(&b, " *%.0fms* (%s)", e.ScoreMS, e.Delta())
+			if e.DownloadMbps > 0 {
+				fmt.Fprintf(&b, " %.1f Mbps", e.DownloadMbps)
+			}
+			b.WriteString("\n")
+		}
+		blocks = append(blocks, block{Type: "section", Text: &text{Type: "mrkdwn", Text: b.String()}})
+	}
+	footer := []text{{Type: "mrkdwn", Text: i18n.Sprintf("Took %s", sum.Duration.Round(time.Second))}}
+	if l := UploadLine(sum); l != "" {
+		footer = append([]text{{Type: "mrkdwn", Text: slackEscape(l)}}, footer...)
+	}
+	blocks = append(blocks, block{Type: "context", Elements: footer})
+
+	// text is the fallback shown in notifications.
+	msg := map[string]any{"text": Title(sum), "blocks": blocks}
+	if err := postJSON(ctx, s.client, s.webhookURL, msg, nil); err != nil {
+		return fmt.Errorf("post webhook: %w", redactURL(err, s.webhookURL))
+	}
+	return nil
+}
+
+// slackEscape escapes the characters Slack's mrkdwn treats as markup.
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
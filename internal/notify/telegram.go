@@ -0,0 +1,42 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/transport"
+)
+
+const telegramAPIBase = "https://api.telegram.org"
+
+// Telegram sends run summaries through a Telegram bot.
+type Telegram struct {
+	token  string
+	chatID string
+	client *http.Client
+}
+
+// NewTelegram creates a notifier for the bot token and chat ID (a numeric ID
+// or "@channelname").
+func NewTelegram(token, chatID string) *Telegram {
+	return &Telegram{token: token, chatID: chatID, client: transport.Client()}
+}
+
+func (t *Telegram) Name() string {
+	return "telegram"
+}
+
+func (t *Telegram) Notify(ctx context.Context, s Summary) error {
+	msg := map[string]any{
+		"chat_id":                  t.chatID,
+		"text":                     Text(s),
+		"disable_web_page_preview": true,
+	}
+	url := fmt.Sprintf("%s/bot%s/sendMessage", telegramAPIBase, t.token)
+	if err := postJSON(ctx, t.client, url, msg, nil); err != nil {
+		// Errors of the client contain the URL, and with it the token.
+		return fmt.Errorf("send message: %w", redactURL(err, t.token))
+	}
+	return nil
+}
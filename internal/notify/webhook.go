@@ -0,0 +1,86 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/transport"
+)
+
+// Webhook POSTs a JSON payload to an arbitrary URL for selected events. The
+// payload is rendered from a text/template whose data is a WebhookData; the
+// default template sends the whole summary.
+type Webhook struct {
+	url    string
+	tmpl   *template.Template
+	events []string
+	header http.Header
+	client *http.Client
+}
+
+// WebhookData is the data of a webhook template.
+type WebhookData struct {
+	Event string // one of the Event constants
+	Summary
+}
+
+// DefaultWebhookTemplate sends the event and the summary as JSON.
+const DefaultWebhookTemplate = `{"event": {{json .Event}}, "summary": {{json .Summary}}}`
+
+// ParseWebhookTemplate parses a payload template. Besides the text/template
+// builtins it provides json (encode a value, e.g. {{json .Error}} for a
+// quoted, escaped string), text (the plain-text message) and title.
+func ParseWebhookTemplate(text string) (*template.Template, error) {
+	return template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+		"text":  Text,
+		"title": Title,
+	}).Parse(text)
+}
+
+// NewWebhook creates a notifier for url that fires on the given events (all
+// of them when empty). header is added to every request, e.g. for auth.
+func NewWebhook(url string, tmpl *template.Template, events []string, header http.Header) (*Webhook, error) {
+	for _, e := range events {
+		if !slices.Contains(EventNames, e) {
+			return nil, fmt.Errorf("unknown webhook event %q (supported: %s)", e, strings.Join(EventNames, ", "))
+		}
+	}
+	if len(events) == 0 {
+		events = EventNames
+	}
+	return &Webhook{url: url, tmpl: tmpl, events: events, header: header, client: transport.Client()}, nil
+}
+
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+// Notify sends one request per event of the run that the webhook fires on.
+func (w *Webhook) Notify(ctx context.Context, s Summary) error {
+	for _, event := range s.Events() {
+		if !slices.Contains(w.events, event) {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := w.tmpl.Execute(&buf, WebhookData{Event: event, Summary: s}); err != nil {
+			return fmt.Errorf("render %s payload: %w", event, err)
+		}
+		if !json.Valid(buf.Bytes()) {
+			return fmt.Errorf("render %s payload: template output is not valid JSON", event)
+		}
+		if err := post(ctx, w.client, w.url, buf.Bytes(), w.header, "application/json"); err != nil {
+			return fmt.Errorf("post %s: %w", event, redactURL(err, w.url))
+		}
+	}
+	return nil
+}
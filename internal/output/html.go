@@ -0,0 +1,50 @@
+package output
+
+import (
+	"html/template"
+	"io"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/engine"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/i18n"
+)
+
+var reportTmpl = template.Must(template.New("report").Funcs(template.FuncMap{
+	"inc":  func(i int) int { return i + 1 },
+	"t":    i18n.T,
+	"tf":   i18n.Sprintf,
+	"lang": i18n.Lang,
+}).Parse(`<!DOCTYPE html>
+<html lang="{{lang}}">
+<head>
+<meta charset="utf-8">
+<title>{{t "mcis report"}} {{.Generated.Format "2006-01-02 15:04"}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; }
+th, td { padding: 4px 10px; border-bottom: 1px solid #ddd; text-align: right; }
+th { background: #f4f4f4; }
+td.l, th.l { text-align: left; }
+tr.fail { color: #999; }
+</style>
+</head>
+<body>
+<h1>{{t "mcis report"}}</h1>
+<p>{{tf "Generated %s, %d results." (.Generated.Format "2006-01-02 15:04:05 MST") (len .Rows)}}</p>
+<table>
+<tr><th>#</th><th class="l">IP</th><th class="l">{{t "Prefix"}}</th><th class="l">{{t "Colo"}}</th><th>{{t "Score (ms)"}}</th><th>{{t "Connect"}}</th><th>TLS</th><th>TTFB</th><th>{{t "Total"}}</th><th>{{t "Prefix ok/samples"}}</th><th>{{t "Download (Mbps)"}}</th></tr>
+{{- range $i, $r := .Rows}}
+<tr{{if not $r.OK}} class="fail"{{end}}><td>{{inc $i}}</td><td class="l">{{$r.IP}}</td><td class="l">{{$r.Prefix}}</td><td class="l">{{index $r.Trace "colo"}}</td><td>{{printf "%.1f" $r.ScoreMS}}</td><td>{{$r.ConnectMS}}</td><td>{{$r.TLSMS}}</td><td>{{$r.TTFBMS}}</td><td>{{$r.TotalMS}}</td><td>{{$r.PrefixOK}}/{{$r.PrefixSamples}}</td><td>{{if $r.DownloadOK}}{{printf "%.2f" $r.DownloadMbps}}{{else if $r.DownloadError}}<span title="{{$r.DownloadError}}">{{t "failed"}}</span>{{end}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// WriteHTML writes results as a self-contained HTML report.
+func WriteHTML(w io.Writer, rows []engine.TopResult) error {
+	return reportTmpl.Execute(w, struct {
+		Generated time.Time
+		Rows      []engine.TopResult
+	}{time.Now(), rows})
+}
@@ -8,6 +8,7 @@ import (
 	"sort"
 	"strconv"
 
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/color"
 	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/engine"
 )
 
@@ -73,8 +74,10 @@ func WriteCSV(w io.Writer, rows []engine.TopResult) error {
 	return cw.Error()
 }
 
-// WriteText writes results as human-readable text format.
-func WriteText(w io.Writer, rows []engine.TopResult) error {
+// WriteText writes results as human-readable text format. On a color
+// terminal the IPs of results with a score at or under goodMS are green,
+// those of slower ones yellow and those of failures red.
+func WriteText(w io.Writer, rows []engine.TopResult, goodMS float64) error {
 	// Ensure stable output
 	sort.SliceStable(rows, func(i, j int) bool { return rows[i].ScoreMS < rows[j].ScoreMS })
 	for i, r := range rows {
@@ -89,11 +92,25 @@ func WriteText(w io.Writer, rows []engine.TopResult) error {
 				dl += "\tdl_err=" + r.DownloadError
 			}
 		}
+		c := color.Green
+		switch {
+		case !r.OK:
+			c = color.Red
+		case r.ScoreMS > goodMS:
+			c = color.Yellow
+		}
 		_, err := fmt.Fprintf(w, "%d\t%s\t%.1fms\tok=%v\tstatus=%d\tprefix=%s\tcolo=%s%s\n",
-			i+1, r.IP.String(), r.ScoreMS, r.OK, r.Status, r.Prefix.String(), colo, dl)
+			i+1, color.Wrap(w, c, r.IP.String()), r.ScoreMS, r.OK, r.Status, r.Prefix.String(), colo, dl)
 		if err != nil {
 			return err
 		}
+		if x := r.Explain; x != nil {
+			_, err = fmt.Fprintf(w, "\tscore = latency %.1fms + failure %.1fms + agents %+.1fms\tjitter=%.1fms\tsubnet_loss=%.1f%%\tsubnet_mean=%.1fms\tsubnet_prior=%.1fms\tspeed_rank=%d\n",
+				x.LatencyMS, x.FailurePenaltyMS, x.AgentsMS, x.JitterMS, 100*x.SubnetLoss, x.SubnetMeanMS, x.SubnetPriorMS, x.SpeedRank)
+			if err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
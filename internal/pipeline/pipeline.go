@@ -0,0 +1,75 @@
+// Package pipeline connects the stages of a run with channels. Each stage
+// runs in its own goroutines and hands items on through an unbuffered
+// channel, so stages overlap, a slow stage holds back the ones before it
+// (backpressure) and only the items in flight are held in memory.
+//
+// Every stage stops when its input is closed or ctx is canceled, and closes
+// its output when it stops; ranging over the last stage's output therefore
+// always terminates.
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+// Source emits items in order.
+func Source[T any](ctx context.Context, items []T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for _, it := range items {
+			select {
+			case out <- it:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Filter passes on the items for which keep returns true, in order.
+func Filter[T any](ctx context.Context, in <-chan T, keep func(T) bool) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for it := range in {
+			if !keep(it) {
+				continue
+			}
+			select {
+			case out <- it:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Map applies fn to the items with up to workers calls at a time. With more
+// than one worker the output order follows completion, not input.
+func Map[In, Out any](ctx context.Context, in <-chan In, workers int, fn func(context.Context, In) Out) <-chan Out {
+	out := make(chan Out)
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Go(func() {
+			for it := range in {
+				if ctx.Err() != nil {
+					return
+				}
+				select {
+				case out <- fn(ctx, it):
+				case <-ctx.Done():
+					return
+				}
+			}
+		})
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
@@ -0,0 +1,29 @@
+package pipeline
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is a panic recovered from a task, with the stack of the
+// goroutine where it happened.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Protect calls fn and returns its error, or a *PanicError if it panics, so
+// that a crash in one task, e.g. on a malformed response, fails only that
+// task instead of the whole run.
+func Protect(fn func() error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+	return fn()
+}
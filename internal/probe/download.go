@@ -2,7 +2,6 @@ package probe
 
 import (
 	"context"
-	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -10,7 +9,10 @@ import (
 	"net/http"
 	"net/netip"
 	"strconv"
+	"sync"
 	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/transport"
 )
 
 type DownloadConfig struct {
@@ -22,6 +24,9 @@ type DownloadConfig struct {
 	// CustomURL indicates the user supplied a custom download URL.
 	// When true, the Path is used as-is (no "?bytes=N" appended).
 	CustomURL bool
+
+	// Dialer, if set, makes the connections, as in Config.
+	Dialer *net.Dialer
 }
 
 type DownloadResult struct {
@@ -58,28 +63,19 @@ func NewDownloadProber(cfg DownloadConfig) *DownloadProber {
 		cfg.Path = "/__down"
 	}
 
-	transport := &http.Transport{
-		Proxy: nil, // critical: ignore HTTP(S)_PROXY and NO_PROXY env vars
-		DialContext: (&net.Dialer{
-			Timeout:   cfg.Timeout,
-			KeepAlive: 30 * time.Second,
-		}).DialContext,
-		ForceAttemptHTTP2:     true,
-		MaxIdleConns:          64,
-		MaxIdleConnsPerHost:   8,
-		IdleConnTimeout:       30 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ResponseHeaderTimeout: 20 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-		TLSClientConfig: &tls.Config{
-			ServerName: cfg.SNI,
-		},
-	}
+	tr := transport.Direct(transport.DirectConfig{
+		SNI:            cfg.SNI,
+		DialTimeout:    cfg.Timeout,
+		TLSTimeout:     10 * time.Second,
+		HeaderTimeout:  20 * time.Second,
+		MaxIdlePerHost: 8,
+		Dialer:         cfg.Dialer,
+	})
 
 	return &DownloadProber{
 		cfg: cfg,
 		client: &http.Client{
-			Transport: transport,
+			Transport: tr,
 			Timeout:   cfg.Timeout,
 		},
 	}
@@ -135,14 +131,8 @@ func (p *DownloadProber) Download(ctx context.Context, ip netip.Addr) DownloadRe
 		return out
 	}
 
-	var n int64
-	if p.cfg.Bytes == 0 {
-		// No limit: read until EOF (custom URL only).
-		n, err = io.Copy(io.Discard, resp.Body)
-	} else {
-		// Read at most cfg.Bytes.
-		n, err = io.CopyN(io.Discard, resp.Body, p.cfg.Bytes)
-	}
+	// Read at most cfg.Bytes; 0 reads until EOF (custom URL only).
+	n, err := drain(resp.Body, p.cfg.Bytes)
 	elapsed := time.Since(start)
 	out.TotalMS = elapsed.Milliseconds()
 	out.Bytes = n
@@ -165,3 +155,40 @@ func (p *DownloadProber) Download(ctx context.Context, ip netip.Addr) DownloadRe
 	out.OK = true
 	return out
 }
+
+// downloadBufSize is the read size of the speed tests. The body arrives in
+// TLS records of up to 16KB; reading several at a time keeps the per-read
+// overhead out of fast measurements.
+const downloadBufSize = 256 << 10
+
+// downloadBufs holds the read buffers of finished speed tests for the next
+// ones, so that a round of tests allocates no garbage for the collector to
+// pause on while a download is being timed.
+var downloadBufs = sync.Pool{
+	New: func() any {
+		b := make([]byte, downloadBufSize)
+		return &b
+	},
+}
+
+// drain reads r into a pooled buffer and discards it, up to limit bytes if
+// limit > 0 or else until EOF. It returns the bytes read and the read error
+// that stopped it, io.EOF included.
+func drain(r io.Reader, limit int64) (int64, error) {
+	bp := downloadBufs.Get().(*[]byte)
+	defer downloadBufs.Put(bp)
+
+	var n int64
+	for limit <= 0 || n < limit {
+		buf := *bp
+		if limit > 0 && limit-n < int64(len(buf)) {
+			buf = buf[:limit-n]
+		}
+		m, err := r.Read(buf)
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
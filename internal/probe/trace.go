@@ -6,12 +6,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"net/http"
 	"net/http/httptrace"
 	"net/netip"
 	"strings"
 	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/transport"
 )
 
 type Config struct {
@@ -21,6 +24,11 @@ type Config struct {
 	Path       string
 	Rounds     int // 总测试次数，默认6
 	SkipFirst  int // 跳过前N次，默认1（跳过第1次握手）
+
+	// Dialer, if set, makes the connections, e.g. from a given source
+	// address; its Timeout replaces the connect part of Timeout. It is local
+	// to this process and never sent to an agent.
+	Dialer *net.Dialer `json:"-"`
 }
 
 type Result struct {
@@ -32,6 +40,7 @@ type Result struct {
 	TLSMS     int64             `json:"tls_ms"`
 	TTFBMS    int64             `json:"ttfb_ms"`
 	TotalMS   int64             `json:"total_ms"`
+	JitterMS  float64           `json:"jitter_ms,omitempty"` // mean deviation of the rounds from TotalMS
 	Trace     map[string]string `json:"trace,omitempty"`
 	When      time.Time         `json:"when"`
 }
@@ -53,25 +62,16 @@ func NewProber(cfg Config) *Prober {
 		cfg.Timeout = 3 * time.Second
 	}
 
-	transport := &http.Transport{
-		Proxy: nil, // critical: ignore HTTP(S)_PROXY and NO_PROXY env vars
-		DialContext: (&net.Dialer{
-			Timeout:   cfg.Timeout,
-			KeepAlive: 30 * time.Second,
-		}).DialContext,
-		ForceAttemptHTTP2:     true,
-		MaxIdleConns:          1024,
-		MaxIdleConnsPerHost:   256,
-		IdleConnTimeout:       30 * time.Second,
-		TLSHandshakeTimeout:   cfg.Timeout,
-		ResponseHeaderTimeout: cfg.Timeout,
-		ExpectContinueTimeout: 1 * time.Second,
-		TLSClientConfig: &tls.Config{
-			ServerName: cfg.SNI,
-		},
-	}
+	tr := transport.Direct(transport.DirectConfig{
+		SNI:            cfg.SNI,
+		DialTimeout:    cfg.Timeout,
+		TLSTimeout:     cfg.Timeout,
+		HeaderTimeout:  cfg.Timeout,
+		MaxIdlePerHost: 256,
+		Dialer:         cfg.Dialer,
+	})
 	client := &http.Client{
-		Transport: transport,
+		Transport: tr,
 		Timeout:   cfg.Timeout,
 	}
 
@@ -247,6 +247,10 @@ func calculateAverage(results []Result, ip netip.Addr) Result {
 
 	count := int64(len(results))
 	avg.TotalMS = totalTotalMS / count
+	mean := float64(totalTotalMS) / float64(count)
+	for _, r := range results {
+		avg.JitterMS += math.Abs(float64(r.TotalMS)-mean) / float64(count)
+	}
 
 	if validConnect > 0 {
 		avg.ConnectMS = totalConnectMS / int64(validConnect)
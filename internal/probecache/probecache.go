@@ -0,0 +1,183 @@
+// Package probecache keeps probe results on disk, so that back-to-back runs
+// and verify skip the IPs they measured a short while ago. Results are keyed
+// by IP and probe settings: changing --host, --path, --rounds, ... probes
+// again.
+package probecache
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/clock"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/probe"
+)
+
+// Cache holds the results younger than its TTL. It is safe for concurrent
+// use; changes reach the file on Save.
+type Cache struct {
+	path  string
+	ttl   time.Duration
+	clock clock.Clock
+
+	mu      sync.Mutex
+	entries map[key]probe.Result
+	hits    int
+	dirty   bool
+}
+
+type key struct {
+	ip       netip.Addr
+	settings string
+}
+
+// entry is a line of the cache file.
+type entry struct {
+	Settings string       `json:"settings"`
+	Result   probe.Result `json:"result"`
+}
+
+// Open loads the cache file at path; a missing file is an empty cache.
+func Open(path string, ttl time.Duration) (*Cache, error) {
+	return OpenWithClock(path, ttl, clock.System)
+}
+
+// OpenWithClock is Open with the age of the results taken from clk, e.g. a
+// clock.Fake.
+func OpenWithClock(path string, ttl time.Duration, clk clock.Clock) (*Cache, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("probe cache TTL must be > 0, got %s", ttl)
+	}
+	c := &Cache{path: path, ttl: ttl, clock: clk, entries: make(map[key]probe.Result)}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for sc.Scan() {
+		var e entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if c.fresh(e.Result) {
+			c.entries[key{e.Result.IP, e.Settings}] = e.Result
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+func (c *Cache) fresh(r probe.Result) bool {
+	return c.clock.Now().Sub(r.When) < c.ttl
+}
+
+// Settings identifies the probe settings that a result depends on,
+// including the source address of cfg.Dialer, since another source may
+// take another route.
+func Settings(cfg probe.Config) string {
+	b := fmt.Appendf(nil, "%s|%s|%s|%s|%d|%d", cfg.SNI, cfg.HostHeader, cfg.Path, cfg.Timeout, cfg.Rounds, cfg.SkipFirst)
+	if cfg.Dialer != nil && cfg.Dialer.LocalAddr != nil {
+		b = fmt.Appendf(b, "|%s", cfg.Dialer.LocalAddr)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Get returns the cached result of ip under settings, if it is fresh.
+func (c *Cache) Get(settings string, ip netip.Addr) (probe.Result, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.entries[key{ip, settings}]
+	if !ok || !c.fresh(r) {
+		return probe.Result{}, false
+	}
+	c.hits++
+	return r, true
+}
+
+// Put stores the result r.IP measured under settings.
+func (c *Cache) Put(settings string, r probe.Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key{r.IP, settings}] = r
+	c.dirty = true
+}
+
+// Hits returns how many probes the cache has answered.
+func (c *Cache) Hits() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits
+}
+
+// Wrap returns fn with the cache in front of it: fresh results are
+// returned without probing, and new successful results are stored unless
+// ctx was canceled while probing, which cut them short. Failures are not
+// stored: a timeout is often passing, and caching it would keep the IP out
+// of the next runs for the whole TTL.
+func (c *Cache) Wrap(cfg probe.Config, fn func(context.Context, netip.Addr) probe.Result) func(context.Context, netip.Addr) probe.Result {
+	settings := Settings(cfg)
+	return func(ctx context.Context, ip netip.Addr) (r probe.Result) {
+		if r, ok := c.Get(settings, ip); ok {
+			return r
+		}
+		r = fn(ctx, ip)
+		if r.OK && ctx.Err() == nil {
+			c.Put(settings, r)
+		}
+		return r
+	}
+}
+
+// Save writes the fresh results back to the file, replacing it atomically.
+// It does nothing if nothing was stored since Open.
+func (c *Cache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(c.path), "."+filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for k, r := range c.entries {
+		if !c.fresh(r) {
+			continue
+		}
+		if err := enc.Encode(entry{Settings: k.settings, Result: r}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := errors.Join(w.Flush(), f.Close()); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), c.path); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
@@ -0,0 +1,172 @@
+package probecache
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/clock"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/probe"
+)
+
+var epoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestCacheTTL(t *testing.T) {
+	ip := netip.MustParseAddr("1.0.0.1")
+	tests := []struct {
+		name string
+		age  time.Duration // clock advance after Put
+		want bool
+	}{
+		{"fresh", 0, true},
+		{"almost expired", 10*time.Minute - time.Nanosecond, true},
+		{"expired", 10 * time.Minute, false},
+		{"long expired", 24 * time.Hour, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := clock.NewFake(epoch)
+			cache, err := OpenWithClock(filepath.Join(t.TempDir(), "cache.jsonl"), 10*time.Minute, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cache.Put("s", probe.Result{IP: ip, OK: true, TotalMS: 42, When: c.Now()})
+			c.Advance(tt.age)
+			r, ok := cache.Get("s", ip)
+			if ok != tt.want {
+				t.Fatalf("Get after %s: ok = %v, want %v", tt.age, ok, tt.want)
+			}
+			if ok && r.TotalMS != 42 {
+				t.Errorf("Get = %+v, want TotalMS 42", r)
+			}
+			if _, ok := cache.Get("other", ip); ok {
+				t.Error("Get under other settings hit")
+			}
+		})
+	}
+}
+
+func TestCacheSaveOpen(t *testing.T) {
+	c := clock.NewFake(epoch)
+	path := filepath.Join(t.TempDir(), "cache.jsonl")
+	cache, err := OpenWithClock(path, time.Hour, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, young := netip.MustParseAddr("1.0.0.1"), netip.MustParseAddr("1.0.0.2")
+	cache.Put("s", probe.Result{IP: old, OK: true, When: c.Now()})
+	c.Advance(30 * time.Minute)
+	cache.Put("s", probe.Result{IP: young, OK: true, When: c.Now()})
+	if err := cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopened 45 minutes later, only the younger result is left.
+	c.Advance(45 * time.Minute)
+	cache, err = OpenWithClock(path, time.Hour, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get("s", old); ok {
+		t.Errorf("%s survived its TTL", old)
+	}
+	if _, ok := cache.Get("s", young); !ok {
+		t.Errorf("%s was not reloaded", young)
+	}
+	if n := cache.Hits(); n != 1 {
+		t.Errorf("Hits = %d, want 1", n)
+	}
+}
+
+func TestCacheWrap(t *testing.T) {
+	c := clock.NewFake(epoch)
+	cache, err := OpenWithClock(filepath.Join(t.TempDir(), "cache.jsonl"), time.Minute, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	probes := 0
+	fn := cache.Wrap(probe.Config{SNI: "example.com"}, func(ctx context.Context, ip netip.Addr) probe.Result {
+		probes++
+		return probe.Result{IP: ip, OK: true, When: c.Now()}
+	})
+	ip := netip.MustParseAddr("1.0.0.1")
+	steps := []struct {
+		advance time.Duration
+		probes  int
+	}{
+		{0, 1},
+		{30 * time.Second, 1},
+		{30 * time.Second, 2}, // expired: probed again
+		{0, 2},
+	}
+	for i, s := range steps {
+		c.Advance(s.advance)
+		fn(context.Background(), ip)
+		if probes != s.probes {
+			t.Fatalf("step %d: %d probes, want %d", i, probes, s.probes)
+		}
+	}
+
+	failed := netip.MustParseAddr("1.0.0.3")
+	fail := cache.Wrap(probe.Config{SNI: "example.com"}, func(ctx context.Context, ip netip.Addr) probe.Result {
+		probes++
+		return probe.Result{IP: ip, Error: "i/o timeout", When: c.Now()}
+	})
+	fail(context.Background(), failed)
+	fail(context.Background(), failed)
+	if probes != 4 {
+		t.Errorf("failures probed %d times, want 2: a failure was cached", probes-2)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	other := netip.MustParseAddr("1.0.0.2")
+	fn(ctx, other)
+	if _, ok := cache.Get(Settings(probe.Config{SNI: "example.com"}), other); ok {
+		t.Error("result of a canceled probe was stored")
+	}
+}
+
+func TestSettings(t *testing.T) {
+	base := probe.Config{SNI: "example.com", Path: "/cdn-cgi/trace", Timeout: time.Second, Rounds: 6, SkipFirst: 1}
+	from := func(addr string) *net.Dialer {
+		return &net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(addr)}}
+	}
+	tests := []struct {
+		name   string
+		change func(*probe.Config)
+		same   bool // the settings of base
+	}{
+		{"unchanged", func(*probe.Config) {}, true},
+		{"dialer without source", func(c *probe.Config) { c.Dialer = &net.Dialer{} }, true},
+		{"rounds", func(c *probe.Config) { c.Rounds = 3 }, false},
+		{"host", func(c *probe.Config) { c.HostHeader = "other.example.com" }, false},
+		{"source", func(c *probe.Config) { c.Dialer = from("192.0.2.1") }, false},
+		{"other source", func(c *probe.Config) { c.Dialer = from("192.0.2.2") }, false},
+	}
+	seen := map[string]string{Settings(base): "base"}
+	for _, tt := range tests {
+		cfg := base
+		tt.change(&cfg)
+		s := Settings(cfg)
+		if tt.same {
+			if s != Settings(base) {
+				t.Errorf("%s: Settings = %s, want the settings of base", tt.name, s)
+			}
+			continue
+		}
+		if other, ok := seen[s]; ok {
+			t.Errorf("%s: Settings = %s, the same as %s", tt.name, s, other)
+		}
+		seen[s] = tt.name
+	}
+}
+
+func TestOpenTTL(t *testing.T) {
+	if _, err := Open(filepath.Join(t.TempDir(), "cache.jsonl"), 0); err == nil {
+		t.Error("Open with TTL 0 succeeded")
+	}
+}
@@ -0,0 +1,105 @@
+package publish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/transport"
+)
+
+// ConsulDefaultAddr is the address of the local Consul agent.
+const ConsulDefaultAddr = "http://127.0.0.1:8500"
+
+// ConsulConfig configures the Consul publisher.
+type ConsulConfig struct {
+	Addr    string // HTTP address of a Consul agent
+	Token   string // ACL token with service:write on Service
+	Service string
+	Port    int
+	Tags    []string
+
+	// HTTPClient, if set, sends the requests instead of a client on the
+	// shared API transport.
+	HTTPClient *http.Client
+}
+
+// Consul registers each selected IP as an instance of a service with a
+// Consul agent, so service meshes and internal resolvers (e.g.
+// SERVICE.service.consul) can use them without public DNS. Instances of
+// earlier runs that are no longer selected are deregistered.
+type Consul struct {
+	cfg    ConsulConfig
+	base   string
+	client *http.Client
+}
+
+// NewConsul validates cfg and creates the publisher.
+func NewConsul(cfg ConsulConfig) (*Consul, error) {
+	if cfg.Service == "" {
+		return nil, errors.New("consul: service name required")
+	}
+	if cfg.Addr == "" {
+		cfg.Addr = ConsulDefaultAddr
+	}
+	if !strings.Contains(cfg.Addr, "://") {
+		cfg.Addr = "http://" + cfg.Addr // CONSUL_HTTP_ADDR is often just host:port
+	}
+	return &Consul{cfg: cfg, base: strings.TrimSuffix(cfg.Addr, "/") + "/v1/agent", client: transport.ClientOr(cfg.HTTPClient)}, nil
+}
+
+func (c *Consul) Name() string {
+	return "consul:" + c.cfg.Service
+}
+
+// consulService is the agent's service registration.
+type consulService struct {
+	ID      string
+	Service string `json:",omitempty"` // set in listings only
+	Name    string `json:",omitempty"`
+	Address string
+	Port    int
+	Tags    []string          `json:",omitempty"`
+	Meta    map[string]string `json:",omitempty"`
+}
+
+func (c *Consul) Publish(ctx context.Context, r Result) error {
+	header := http.Header{}
+	if c.cfg.Token != "" {
+		header.Set("X-Consul-Token", c.cfg.Token)
+	}
+
+	keep := make(map[string]bool, len(r.IPs))
+	for i, ip := range r.IPs {
+		svc := consulService{
+			ID:      c.cfg.Service + "-" + strings.ReplaceAll(ip.String(), ":", "-"),
+			Name:    c.cfg.Service,
+			Address: ip.String(),
+			Port:    c.cfg.Port,
+			Tags:    c.cfg.Tags,
+			Meta:    map[string]string{"managed-by": "mcis", "rank": fmt.Sprint(i + 1)},
+		}
+		keep[svc.ID] = true
+		if err := doJSON(ctx, c.client, http.MethodPut, c.base+"/service/register", header, svc, nil); err != nil {
+			return fmt.Errorf("register %s: %w", ip, err)
+		}
+	}
+
+	var registered map[string]consulService
+	filter := url.Values{"filter": {fmt.Sprintf("Service == %q", c.cfg.Service)}}
+	if err := doJSON(ctx, c.client, http.MethodGet, c.base+"/services?"+filter.Encode(), header, nil, &registered); err != nil {
+		return fmt.Errorf("list services: %w", err)
+	}
+	for id, svc := range registered {
+		if keep[id] || svc.Meta["managed-by"] != "mcis" {
+			continue // leave instances registered by others alone
+		}
+		if err := doJSON(ctx, c.client, http.MethodPut, c.base+"/service/deregister/"+url.PathEscape(id), header, nil, nil); err != nil {
+			return fmt.Errorf("deregister %s: %w", id, err)
+		}
+	}
+	return nil
+}
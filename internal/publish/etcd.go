@@ -0,0 +1,78 @@
+package publish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/transport"
+)
+
+// EtcdConfig configures the etcd publisher.
+type EtcdConfig struct {
+	Endpoint string // client URL, e.g. http://127.0.0.1:2379
+	Key      string
+	Format   string // FormatJSON or FormatText
+	// Username and Password authenticate when etcd has auth enabled.
+	Username string
+	Password string
+
+	// HTTPClient, if set, sends the requests instead of a client on the
+	// shared API transport.
+	HTTPClient *http.Client
+}
+
+// Etcd writes the selected IPs to a key in etcd through the JSON gateway of
+// its v3 API.
+type Etcd struct {
+	cfg    EtcdConfig
+	base   string
+	client *http.Client
+}
+
+// NewEtcd validates cfg and creates the publisher.
+func NewEtcd(cfg EtcdConfig) (*Etcd, error) {
+	switch {
+	case cfg.Endpoint == "":
+		return nil, errors.New("etcd: endpoint required")
+	case cfg.Key == "":
+		return nil, errors.New("etcd: key required")
+	case (cfg.Username == "") != (cfg.Password == ""):
+		return nil, errors.New("etcd: username and password go together")
+	}
+	if _, err := FormatIPs(nil, cfg.Format); err != nil {
+		return nil, fmt.Errorf("etcd: %w", err)
+	}
+	return &Etcd{cfg: cfg, base: strings.TrimSuffix(cfg.Endpoint, "/") + "/v3", client: transport.ClientOr(cfg.HTTPClient)}, nil
+}
+
+func (e *Etcd) Name() string {
+	return "etcd:" + e.cfg.Key
+}
+
+func (e *Etcd) Publish(ctx context.Context, r Result) error {
+	value, err := FormatIPs(r.IPs, e.cfg.Format)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	if e.cfg.Username != "" {
+		var auth struct {
+			Token string `json:"token"`
+		}
+		in := map[string]string{"name": e.cfg.Username, "password": e.cfg.Password}
+		if err := doJSON(ctx, e.client, http.MethodPost, e.base+"/auth/authenticate", nil, in, &auth); err != nil {
+			return fmt.Errorf("authenticate: %w", err)
+		}
+		header.Set("Authorization", auth.Token)
+	}
+	// The gateway takes bytes fields base64-encoded, which encoding/json
+	// does for []byte.
+	put := map[string][]byte{"key": []byte(e.cfg.Key), "value": value}
+	if err := doJSON(ctx, e.client, http.MethodPost, e.base+"/kv/put", header, put, nil); err != nil {
+		return fmt.Errorf("put: %w", err)
+	}
+	return nil
+}
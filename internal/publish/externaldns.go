@@ -0,0 +1,162 @@
+package publish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/k8s"
+)
+
+// ExternalDNSConfig configures the external-dns publisher.
+type ExternalDNSConfig struct {
+	DNSName   string // record name, e.g. cf.example.com
+	Name      string // name of the DNSEndpoint resource
+	Namespace string
+	TTL       int // 0 leaves the TTL to external-dns
+	// File receives the DNSEndpoint manifest as YAML, for kubectl or a GitOps
+	// pipeline to apply.
+	File string
+	// Client, when set, applies the DNSEndpoint to the cluster.
+	Client *k8s.Client
+}
+
+// ExternalDNS hands the selected IPs to a Kubernetes cluster running
+// external-dns in the form of a DNSEndpoint resource, so that external-dns
+// owns the records and this tool only supplies the targets.
+type ExternalDNS struct {
+	cfg ExternalDNSConfig
+}
+
+// NewExternalDNS validates cfg and creates the publisher.
+func NewExternalDNS(cfg ExternalDNSConfig) (*ExternalDNS, error) {
+	switch {
+	case cfg.DNSName == "":
+		return nil, errors.New("external-dns publisher: no DNS name")
+	case cfg.File == "" && cfg.Client == nil:
+		return nil, errors.New("external-dns publisher: needs a manifest file or a cluster to apply to")
+	}
+	if cfg.Name == "" {
+		cfg.Name = "mcis"
+	}
+	return &ExternalDNS{cfg: cfg}, nil
+}
+
+func (e *ExternalDNS) Name() string {
+	var dest []string
+	if e.cfg.File != "" {
+		dest = append(dest, e.cfg.File)
+	}
+	if e.cfg.Client != nil {
+		dest = append(dest, "dnsendpoint/"+e.cfg.Name)
+	}
+	return "external-dns:" + e.cfg.DNSName + " (" + strings.Join(dest, ", ") + ")"
+}
+
+// Publish writes the manifest and applies it, as configured.
+func (e *ExternalDNS) Publish(ctx context.Context, r Result) error {
+	ep := e.endpoint(r)
+	if e.cfg.File != "" {
+		if err := writeFileAtomic(e.cfg.File, DNSEndpointYAML(ep)); err != nil {
+			return err
+		}
+	}
+	if e.cfg.Client != nil {
+		if err := e.cfg.Client.ApplyDNSEndpoint(ctx, ep); err != nil {
+			return fmt.Errorf("apply: %w", err)
+		}
+	}
+	return nil
+}
+
+// endpoint builds the DNSEndpoint with an A and an AAAA record set, each
+// present only when there are IPs of that family.
+func (e *ExternalDNS) endpoint(r Result) k8s.DNSEndpoint {
+	var v4, v6 []string
+	for _, ip := range r.IPs {
+		if ip.Is4() {
+			v4 = append(v4, ip.String())
+		} else {
+			v6 = append(v6, ip.String())
+		}
+	}
+	ep := k8s.DNSEndpoint{
+		APIVersion: k8s.DNSEndpointAPIVersion,
+		Kind:       "DNSEndpoint",
+		Metadata: k8s.ObjectMeta{
+			Name:      e.cfg.Name,
+			Namespace: e.cfg.Namespace,
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": "mcis"},
+		},
+	}
+	for _, set := range []struct {
+		typ     string
+		targets []string
+	}{{"A", v4}, {"AAAA", v6}} {
+		if len(set.targets) > 0 {
+			ep.Spec.Endpoints = append(ep.Spec.Endpoints, k8s.Endpoint{
+				DNSName:    e.cfg.DNSName,
+				RecordType: set.typ,
+				RecordTTL:  e.cfg.TTL,
+				Targets:    set.targets,
+			})
+		}
+	}
+	return ep
+}
+
+// DNSEndpointYAML renders ep as a YAML manifest. Every string is quoted, so
+// no value needs escaping beyond what strconv.Quote does.
+func DNSEndpointYAML(ep k8s.DNSEndpoint) []byte {
+	var b strings.Builder
+	q := strconv.Quote
+	fmt.Fprintf(&b, "apiVersion: %s\nkind: %s\nmetadata:\n  name: %s\n", ep.APIVersion, ep.Kind, q(ep.Metadata.Name))
+	if ep.Metadata.Namespace != "" {
+		fmt.Fprintf(&b, "  namespace: %s\n", q(ep.Metadata.Namespace))
+	}
+	if len(ep.Metadata.Labels) > 0 {
+		b.WriteString("  labels:\n")
+		for k, v := range ep.Metadata.Labels {
+			fmt.Fprintf(&b, "    %s: %s\n", q(k), q(v))
+		}
+	}
+	b.WriteString("spec:\n  endpoints:")
+	if len(ep.Spec.Endpoints) == 0 {
+		b.WriteString(" []")
+	}
+	b.WriteString("\n")
+	for _, e := range ep.Spec.Endpoints {
+		fmt.Fprintf(&b, "    - dnsName: %s\n      recordType: %s\n", q(e.DNSName), e.RecordType)
+		if e.RecordTTL > 0 {
+			fmt.Fprintf(&b, "      recordTTL: %d\n", e.RecordTTL)
+		}
+		b.WriteString("      targets:\n")
+		for _, t := range e.Targets {
+			fmt.Fprintf(&b, "        - %s\n", q(t))
+		}
+	}
+	return []byte(b.String())
+}
+
+// writeFileAtomic replaces path with data, so readers never see a partial
+// file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
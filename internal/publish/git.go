@@ -0,0 +1,175 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// DefaultGitMessage is the default commit message template.
+const DefaultGitMessage = "Update selected IPs ({{len .IPs}}, best {{index .IPs 0}})"
+
+// GitConfig configures the Git publisher.
+type GitConfig struct {
+	Repo   string // clone URL, e.g. git@github.com:org/infra.git
+	Branch string
+	Path   string // file in the repository
+	Format string // FormatJSON or FormatText
+	// DeployKey is an SSH private key file used for clone and push.
+	DeployKey string
+	// WorkDir holds the clone between runs; empty means a directory in the
+	// user cache directory derived from Repo and Branch.
+	WorkDir string
+	Author  string // "Name <email>"
+	// Message is a text/template for the commit message whose data is the
+	// Result.
+	Message string
+}
+
+// Git commits the selected IPs to a file in a Git repository and pushes
+// them, so that GitOps pipelines can pick them up with review history. It
+// runs the git command, which must be installed.
+type Git struct {
+	cfg     GitConfig
+	name    string
+	email   string
+	message *template.Template
+}
+
+// NewGit validates cfg and creates the publisher.
+func NewGit(cfg GitConfig) (*Git, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, errors.New("git publisher: the git command is not installed")
+	}
+	if cfg.Path == "" || filepath.IsAbs(cfg.Path) || strings.HasPrefix(filepath.Clean(cfg.Path), "..") {
+		return nil, fmt.Errorf("git publisher: --git-path %q must be a path inside the repository", cfg.Path)
+	}
+	if _, err := FormatIPs(nil, cfg.Format); err != nil {
+		return nil, fmt.Errorf("git publisher: %w", err)
+	}
+	if cfg.Branch == "" {
+		cfg.Branch = "main"
+	}
+	if cfg.WorkDir == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("git publisher: %w; set --git-workdir", err)
+		}
+		sum := sha256.Sum256([]byte(cfg.Repo + "#" + cfg.Branch))
+		cfg.WorkDir = filepath.Join(dir, "mcis", "git-"+hex.EncodeToString(sum[:6]))
+	}
+	g := &Git{cfg: cfg, name: "mcis", email: "mcis@localhost"}
+	if cfg.Author != "" {
+		name, email, ok := strings.Cut(cfg.Author, "<")
+		if !ok || !strings.HasSuffix(email, ">") {
+			return nil, fmt.Errorf("git publisher: author %q: want 'Name <email>'", cfg.Author)
+		}
+		g.name, g.email = strings.TrimSpace(name), strings.TrimSuffix(email, ">")
+	}
+	msg := cfg.Message
+	if msg == "" {
+		msg = DefaultGitMessage
+	}
+	var err error
+	if g.message, err = template.New("message").Parse(msg); err != nil {
+		return nil, fmt.Errorf("git publisher: message template: %w", err)
+	}
+	return g, nil
+}
+
+func (g *Git) Name() string {
+	return "git:" + g.cfg.Repo + "#" + g.cfg.Branch + ":" + g.cfg.Path
+}
+
+// Publish updates the file and pushes a commit when its content changed. A
+// push that loses a race with another one is retried once on the new head.
+func (g *Git) Publish(ctx context.Context, r Result) error {
+	content, err := FormatIPs(r.IPs, g.cfg.Format)
+	if err != nil {
+		return err
+	}
+	var msg bytes.Buffer
+	if err := g.message.Execute(&msg, r); err != nil {
+		return fmt.Errorf("message template: %w", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := g.sync(ctx); err != nil {
+			return err
+		}
+		changed, err := g.commit(ctx, content, msg.String())
+		if err != nil || !changed {
+			return err
+		}
+		err = g.git(ctx, "push", "origin", "HEAD:refs/heads/"+g.cfg.Branch)
+		if err == nil || attempt == 1 {
+			return err
+		}
+	}
+}
+
+// sync clones the repository, or resets the existing clone to the remote
+// branch.
+func (g *Git) sync(ctx context.Context) error {
+	if _, err := os.Stat(filepath.Join(g.cfg.WorkDir, ".git")); err != nil {
+		if err := os.MkdirAll(filepath.Dir(g.cfg.WorkDir), 0o755); err != nil {
+			return err
+		}
+		_ = os.RemoveAll(g.cfg.WorkDir) // a clone interrupted earlier
+		return g.run(ctx, "", "clone", "--depth", "1", "--branch", g.cfg.Branch, g.cfg.Repo, g.cfg.WorkDir)
+	}
+	if err := g.git(ctx, "fetch", "--depth", "1", "origin", g.cfg.Branch); err != nil {
+		return err
+	}
+	return g.git(ctx, "reset", "--hard", "FETCH_HEAD")
+}
+
+// commit writes the file and commits it, reporting false when it is
+// unchanged.
+func (g *Git) commit(ctx context.Context, content []byte, msg string) (bool, error) {
+	file := filepath.Join(g.cfg.WorkDir, g.cfg.Path)
+	if old, err := os.ReadFile(file); err == nil && bytes.Equal(old, content) {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(file, content, 0o644); err != nil {
+		return false, err
+	}
+	if err := g.git(ctx, "add", "--", g.cfg.Path); err != nil {
+		return false, err
+	}
+	return true, g.git(ctx, "-c", "user.name="+g.name, "-c", "user.email="+g.email, "commit", "--quiet", "-m", msg)
+}
+
+func (g *Git) git(ctx context.Context, args ...string) error {
+	return g.run(ctx, g.cfg.WorkDir, args...)
+}
+
+func (g *Git) run(ctx context.Context, dir string, args ...string) error {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if g.cfg.DeployKey != "" {
+		cmd.Env = append(cmd.Env, "GIT_SSH_COMMAND=ssh -i "+shellQuote(g.cfg.DeployKey)+" -o IdentitiesOnly=yes -o StrictHostKeyChecking=accept-new")
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git %s: %w: %s", args[0], err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// shellQuote quotes s for the shell that git runs GIT_SSH_COMMAND with.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
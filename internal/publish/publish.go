@@ -0,0 +1,100 @@
+// Package publish writes the selected IPs of a run to destinations other
+// than DNS records, such as key-value stores, object storage or Git.
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/engine"
+)
+
+// Result is what a run hands to the publishers.
+type Result struct {
+	// IPs are the selected IPs, fastest first: the same ones DNS upload uses.
+	IPs []netip.Addr
+	// Top holds all results of the run, best first.
+	Top      []engine.TopResult
+	Finished time.Time
+	// ResultsFile is the --out-file of the run, empty when it wrote to stdout.
+	ResultsFile string
+}
+
+// Publisher writes a run's result somewhere.
+type Publisher interface {
+	// Name identifies the publisher in messages.
+	Name() string
+	Publish(ctx context.Context, r Result) error
+}
+
+// Formats of an IP list.
+const (
+	FormatJSON = "json" // a JSON array of strings
+	FormatText = "text" // one IP per line
+)
+
+// FormatIPs encodes ips in format.
+func FormatIPs(ips []netip.Addr, format string) ([]byte, error) {
+	switch format {
+	case FormatJSON, "":
+		return json.Marshal(ips)
+	case FormatText:
+		var b strings.Builder
+		for _, ip := range ips {
+			b.WriteString(ip.String())
+			b.WriteByte('\n')
+		}
+		return []byte(b.String()), nil
+	default:
+		return nil, fmt.Errorf("unknown IP list format %q (supported: %s, %s)", format, FormatJSON, FormatText)
+	}
+}
+
+// doJSON sends in (if not nil) as JSON and decodes the answer into out (if
+// not nil), failing on a non-2xx status.
+func doJSON(ctx context.Context, client *http.Client, method, url string, header http.Header, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(data))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("parse response: %w", err)
+	}
+	return nil
+}
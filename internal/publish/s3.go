@@ -0,0 +1,256 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/transport"
+)
+
+// DefaultArchiveKey is the default object key template of archived files.
+const DefaultArchiveKey = "{{.Date}}/{{.Time}}-{{.Name}}"
+
+// ArchiveConfig configures the object storage publisher.
+type ArchiveConfig struct {
+	// URL is s3://bucket[/prefix] or gs://bucket[/prefix].
+	URL string
+	// Endpoint overrides the storage endpoint, for S3-compatible services
+	// such as MinIO or R2 (e.g. "https://minio.internal:9000"). Buckets are
+	// then addressed path-style.
+	Endpoint string
+	Region   string // default us-east-1 (S3) or auto (GCS)
+	// Key is a text/template for the key below the prefix, with the fields
+	// Date (2006-01-02), Time (150405), Finished, Host and Name (file name).
+	Key          string
+	AccessKey    string // for GCS, an HMAC key of a service account
+	SecretKey    string
+	SessionToken string
+
+	// HTTPClient, if set, sends the requests instead of a client on the
+	// shared API transport.
+	HTTPClient *http.Client
+}
+
+// Archive uploads the results file and the HTML report of each run to an
+// S3-compatible bucket, including Google Cloud Storage through its XML API.
+type Archive struct {
+	cfg     ArchiveConfig
+	bucket  string
+	prefix  string
+	baseURL *url.URL // bucket URL; object keys are appended to its path
+	key     *template.Template
+	files   []string
+	client  *http.Client
+}
+
+// NewArchive validates cfg and creates the publisher for the given local
+// files (empty entries, e.g. no --report-file, are skipped).
+func NewArchive(cfg ArchiveConfig, files ...string) (*Archive, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("archive URL: %w", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("archive URL %q: missing bucket", cfg.URL)
+	}
+	a := &Archive{cfg: cfg, bucket: u.Host, prefix: strings.Trim(u.Path, "/"), client: transport.ClientOr(cfg.HTTPClient)}
+
+	endpoint := cfg.Endpoint
+	switch u.Scheme {
+	case "s3":
+		if a.cfg.Region == "" {
+			a.cfg.Region = "us-east-1"
+		}
+		if endpoint == "" {
+			// Virtual-hosted style, as AWS recommends.
+			a.baseURL = &url.URL{Scheme: "https", Host: a.bucket + ".s3." + a.cfg.Region + ".amazonaws.com", Path: "/"}
+		}
+	case "gs":
+		if a.cfg.Region == "" {
+			a.cfg.Region = "auto"
+		}
+		if endpoint == "" {
+			endpoint = "https://storage.googleapis.com"
+		}
+	default:
+		return nil, fmt.Errorf("archive URL %q: unsupported scheme (use s3:// or gs://)", cfg.URL)
+	}
+	if a.baseURL == nil {
+		e, err := url.Parse(endpoint)
+		if err != nil || e.Host == "" {
+			return nil, fmt.Errorf("archive endpoint %q: want a URL such as https://host:port", endpoint)
+		}
+		e.Path = strings.TrimSuffix(e.Path, "/") + "/" + a.bucket + "/"
+		a.baseURL = e
+	}
+
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("archive: access key and secret key required (--archive-access-key/--archive-secret-key or AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY)")
+	}
+	keyText := cfg.Key
+	if keyText == "" {
+		keyText = DefaultArchiveKey
+	}
+	if a.key, err = template.New("key").Option("missingkey=error").Parse(keyText); err != nil {
+		return nil, fmt.Errorf("archive key template: %w", err)
+	}
+	for _, f := range files {
+		if f != "" {
+			a.files = append(a.files, f)
+		}
+	}
+	if len(a.files) == 0 {
+		return nil, fmt.Errorf("archive: nothing to upload; set --out-file and/or --report-file")
+	}
+	return a, nil
+}
+
+func (a *Archive) Name() string {
+	return a.cfg.URL
+}
+
+// Publish uploads every file.
+func (a *Archive) Publish(ctx context.Context, r Result) error {
+	host, _ := os.Hostname()
+	for _, file := range a.files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var key strings.Builder
+		err = a.key.Execute(&key, map[string]any{
+			"Date":     r.Finished.UTC().Format("2006-01-02"),
+			"Time":     r.Finished.UTC().Format("150405"),
+			"Finished": r.Finished,
+			"Host":     host,
+			"Name":     filepath.Base(file),
+		})
+		if err != nil {
+			return fmt.Errorf("archive key template: %w", err)
+		}
+		name := strings.TrimPrefix(path.Join(a.prefix, key.String()), "/")
+		if err := a.put(ctx, name, data, contentType(file)); err != nil {
+			return fmt.Errorf("upload %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// put uploads one object, signed with AWS Signature Version 4.
+func (a *Archive) put(ctx context.Context, key string, data []byte, ctype string) error {
+	u := *a.baseURL
+	u.Path += key
+	u.RawPath = u.Path[:len(u.Path)-len(key)] + uriEncode(key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ctype)
+	a.sign(req, data, time.Now().UTC())
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// sign adds the SigV4 authorization headers to req.
+func (a *Archive) sign(req *http.Request, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	sum := sha256.Sum256(payload)
+	payloadHash := hex.EncodeToString(sum[:])
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if a.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.cfg.SessionToken)
+	}
+
+	names := []string{"content-type", "host", "x-amz-content-sha256", "x-amz-date"}
+	if a.cfg.SessionToken != "" {
+		names = append(names, "x-amz-security-token")
+	}
+	var canonHeaders strings.Builder
+	for _, n := range names {
+		v := req.Header.Get(n)
+		if n == "host" {
+			v = req.URL.Host
+		}
+		canonHeaders.WriteString(n + ":" + strings.TrimSpace(v) + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical := strings.Join([]string{
+		req.Method, req.URL.EscapedPath(), req.URL.RawQuery,
+		canonHeaders.String(), signed, payloadHash,
+	}, "\n")
+
+	scope := day + "/" + a.cfg.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	k := hmacSHA256([]byte("AWS4"+a.cfg.SecretKey), day)
+	k = hmacSHA256(k, a.cfg.Region)
+	k = hmacSHA256(k, "s3")
+	k = hmacSHA256(k, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(k, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		a.cfg.AccessKey, scope, signed, sig))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// uriEncode percent-encodes an object key as SigV4 expects: everything but
+// the unreserved characters and "/".
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// contentType guesses the MIME type of an archived file.
+func contentType(file string) string {
+	switch ext := filepath.Ext(file); ext {
+	case ".jsonl":
+		return "application/x-ndjson"
+	case ".csv":
+		return "text/csv"
+	default:
+		if t := mime.TypeByExtension(ext); t != "" {
+			return t
+		}
+		return "application/octet-stream"
+	}
+}
@@ -0,0 +1,84 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/ratelimit"
+)
+
+const cloudflareAPIBase = "https://api.cloudflare.com/client/v4"
+
+// WorkersKVConfig configures the Workers KV publisher.
+type WorkersKVConfig struct {
+	Token     string // API token with Workers KV Storage:Edit
+	AccountID string
+	Namespace string // namespace ID
+	Key       string
+	Format    string // FormatJSON or FormatText
+	// Limiter paces the API requests; share the one of the Cloudflare DNS
+	// provider, since both count against the same account limit.
+	Limiter *ratelimit.Limiter
+
+	// HTTPClient, if set, sends the requests instead of a client on the
+	// shared API transport; Limiter still paces them.
+	HTTPClient *http.Client
+}
+
+// WorkersKV writes the selected IPs to a Cloudflare Workers KV key.
+type WorkersKV struct {
+	cfg    WorkersKVConfig
+	client *http.Client
+}
+
+// NewWorkersKV validates cfg and creates the publisher.
+func NewWorkersKV(cfg WorkersKVConfig) (*WorkersKV, error) {
+	switch {
+	case cfg.Token == "":
+		return nil, fmt.Errorf("workers kv: API token required (--kv-token or CF_API_TOKEN)")
+	case cfg.AccountID == "":
+		return nil, fmt.Errorf("workers kv: account ID required (--kv-account or CF_ACCOUNT_ID)")
+	case cfg.Namespace == "":
+		return nil, fmt.Errorf("workers kv: namespace ID required (--kv-namespace)")
+	case cfg.Key == "":
+		return nil, fmt.Errorf("workers kv: key required (--kv-key)")
+	}
+	if _, err := FormatIPs(nil, cfg.Format); err != nil {
+		return nil, fmt.Errorf("workers kv: %w", err)
+	}
+	return &WorkersKV{cfg: cfg, client: ratelimit.Wrap(cfg.HTTPClient, cfg.Limiter)}, nil
+}
+
+func (k *WorkersKV) Name() string {
+	return "workers-kv:" + k.cfg.Key
+}
+
+func (k *WorkersKV) Publish(ctx context.Context, r Result) error {
+	value, err := FormatIPs(r.IPs, k.cfg.Format)
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf("%s/accounts/%s/storage/kv/namespaces/%s/values/%s",
+		cloudflareAPIBase, url.PathEscape(k.cfg.AccountID), url.PathEscape(k.cfg.Namespace), url.PathEscape(k.cfg.Key))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, bytes.NewReader(value))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+k.cfg.Token)
+	req.Header.Set("Content-Type", "text/plain")
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("workers kv error: status %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return nil
+}
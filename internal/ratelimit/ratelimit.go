@@ -0,0 +1,121 @@
+// Package ratelimit implements a token bucket shared by everything that talks
+// to the same API, so that several uploads in a row stay within its limits.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/clock"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/transport"
+)
+
+// Limiter allows rate events per second with bursts of up to burst events.
+// A nil *Limiter allows everything.
+type Limiter struct {
+	clock  clock.Clock
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// New creates a limiter that starts with a full bucket. A rate <= 0 returns
+// nil, i.e. no limit; burst is raised to at least 1.
+func New(rate float64, burst int) *Limiter {
+	return NewWithClock(rate, burst, clock.System)
+}
+
+// NewWithClock is New with the time taken from c, e.g. a clock.Fake.
+func NewWithClock(rate float64, burst int, c clock.Clock) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	b := float64(max(burst, 1))
+	return &Limiter{clock: c, rate: rate, burst: b, tokens: b, last: c.Now()}
+}
+
+// Rate returns the events per second allowed, or 0 for a nil limiter.
+func (l *Limiter) Rate() float64 {
+	if l == nil {
+		return 0
+	}
+	return l.rate
+}
+
+// Wait blocks until one event is allowed or ctx is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	now := l.clock.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	// Take the token now, possibly going negative: later callers queue behind.
+	l.tokens--
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return ctx.Err()
+	}
+	t := l.clock.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return nil
+	case <-ctx.Done():
+		// Give the token back so an abandoned wait does not delay others.
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Client returns an HTTP client on the shared API transport whose requests
+// wait for l; a nil l gives a plain client.
+func Client(l *Limiter) *http.Client {
+	if l == nil {
+		return transport.Client()
+	}
+	return &http.Client{Transport: &limited{limiter: l, next: transport.API()}}
+}
+
+// Wrap returns a copy of c whose requests wait for l. A nil c is Client(l);
+// a nil l returns c itself.
+func Wrap(c *http.Client, l *Limiter) *http.Client {
+	if c == nil {
+		return Client(l)
+	}
+	if l == nil {
+		return c
+	}
+	next := c.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	wrapped := *c
+	wrapped.Transport = &limited{limiter: l, next: next}
+	return &wrapped
+}
+
+type limited struct {
+	limiter *Limiter
+	next    http.RoundTripper
+}
+
+func (t *limited) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/clock"
+)
+
+var epoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// wait calls l.Wait and advances c in steps until it returns, reporting how
+// far the clock had to move.
+func wait(t *testing.T, c *clock.Fake, l *Limiter, step time.Duration) time.Duration {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- l.Wait(context.Background()) }()
+	var waited time.Duration
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("Wait: %v", err)
+			}
+			return waited
+		default:
+		}
+		if c.Pending() == 0 {
+			runtime.Gosched()
+			continue
+		}
+		c.Advance(step)
+		waited += step
+	}
+}
+
+func TestLimiterWait(t *testing.T) {
+	// At 4 events per second a token takes 250ms to refill.
+	type step struct {
+		idle time.Duration // clock advance before the call
+		want time.Duration // how long the call waits
+	}
+	tests := []struct {
+		name  string
+		burst int
+		steps []step
+	}{
+		{"full bucket", 3, []step{{0, 0}, {0, 0}, {0, 0}, {0, 250 * time.Millisecond}, {0, 250 * time.Millisecond}}},
+		{"burst raised to 1", 0, []step{{0, 0}, {0, 250 * time.Millisecond}}},
+		{"refill", 2, []step{{0, 0}, {0, 0}, {0, 250 * time.Millisecond}, {500 * time.Millisecond, 0}, {0, 0}, {0, 250 * time.Millisecond}}},
+		{"partial refill", 1, []step{{0, 0}, {100 * time.Millisecond, 150 * time.Millisecond}}},
+		{"refill capped at burst", 1, []step{{0, 0}, {10 * time.Second, 0}, {0, 250 * time.Millisecond}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := clock.NewFake(epoch)
+			l := NewWithClock(4, tt.burst, c)
+			for i, s := range tt.steps {
+				c.Advance(s.idle)
+				if got := wait(t, c, l, 50*time.Millisecond); got != s.want {
+					t.Fatalf("call %d waited %s, want %s", i, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLimiterWaitCanceled(t *testing.T) {
+	c := clock.NewFake(epoch)
+	l := NewWithClock(4, 1, c)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- l.Wait(ctx) }()
+	for c.Pending() == 0 {
+		runtime.Gosched()
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait = %v, want context.Canceled", err)
+	}
+
+	// The abandoned wait gave its token back: one refill is enough again.
+	c.Advance(250 * time.Millisecond)
+	if got := wait(t, c, l, 50*time.Millisecond); got != 0 {
+		t.Fatalf("Wait after cancel waited %s, want 0", got)
+	}
+}
+
+func TestLimiterNil(t *testing.T) {
+	l := New(0, 10)
+	if l != nil {
+		t.Fatalf("New(0, 10) = %v, want nil", l)
+	}
+	if err := l.Wait(context.Background()); err != nil {
+		t.Errorf("nil Wait = %v", err)
+	}
+	if r := l.Rate(); r != 0 {
+		t.Errorf("nil Rate = %v, want 0", r)
+	}
+}
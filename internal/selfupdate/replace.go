@@ -0,0 +1,68 @@
+package selfupdate
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Replace writes bin over the executable of the running process and returns
+// its path. The new binary is written next to the old one and renamed over
+// it, so an interrupted update never leaves a half-written binary. Windows
+// cannot overwrite a running executable, so there the old one is first
+// moved aside to <exe>.old, which the next update removes.
+func Replace(bin []byte) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return "", err
+	}
+	info, err := os.Stat(exe)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Dir(exe)
+	tmp, err := os.CreateTemp(dir, ".mcis-update-*")
+	if err != nil {
+		if errors.Is(err, fs.ErrPermission) {
+			return "", fmt.Errorf("%s is not writable: %w (run the update as the owner of %s, e.g. with sudo)", dir, err, exe)
+		}
+		return "", err
+	}
+	_, err = tmp.Write(bin)
+	if err == nil {
+		err = tmp.Chmod(info.Mode().Perm())
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+
+	if runtime.GOOS != "windows" {
+		if err := os.Rename(tmp.Name(), exe); err != nil {
+			_ = os.Remove(tmp.Name())
+			return "", err
+		}
+		return exe, nil
+	}
+	old := exe + ".old"
+	_ = os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		_ = os.Rename(old, exe)
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	return exe, nil
+}
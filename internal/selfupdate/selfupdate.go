@@ -0,0 +1,322 @@
+// Package selfupdate replaces the running binary with that of a GitHub
+// release: it finds the archive of the platform among the release assets,
+// checks it against the SHA-256 checksum published next to it and, when a
+// public key is configured, against its Ed25519 signature, and swaps the
+// extracted binary into place.
+//
+// The assets are those of the release workflow: mcis-<tag>-<os>-<arch>.tar.gz
+// (.zip on Windows), with <archive>.sha256 holding the hex checksum and,
+// for signed releases, <archive>.sig holding the base64 signature of the
+// archive.
+package selfupdate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/transport"
+)
+
+// DefaultAPI and DefaultRepo locate the releases of mcis.
+const (
+	DefaultAPI  = "https://api.github.com"
+	DefaultRepo = "Leo-Mu/montecarlo-ip-searcher"
+)
+
+// maxArchive bounds the download of a release archive.
+const maxArchive = 256 << 20
+
+// Config says where the releases are and how they are verified.
+type Config struct {
+	API  string // GitHub API base (default DefaultAPI)
+	Repo string // owner/name (default DefaultRepo)
+	// PublicKey, if set, is the Ed25519 key the archives must be signed
+	// with; without it only the checksum is verified.
+	PublicKey ed25519.PublicKey
+	// HTTPClient sends the requests (default the shared API client).
+	HTTPClient *http.Client
+}
+
+// Release is a published release.
+type Release struct {
+	Tag    string  `json:"tag_name"`
+	URL    string  `json:"html_url"`
+	Assets []Asset `json:"assets"`
+}
+
+// Asset is a file of a release.
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+	Size int64  `json:"size"`
+}
+
+// asset returns the asset named name.
+func (r *Release) asset(name string) (Asset, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return Asset{}, false
+}
+
+// Archive returns the archive of the platform: that of goos and goarch.
+func (r *Release) Archive(goos, goarch string) (Asset, error) {
+	ext := ".tar.gz"
+	if goos == "windows" {
+		ext = ".zip"
+	}
+	suffix := "-" + goos + "-" + goarch + ext
+	for _, a := range r.Assets {
+		if strings.HasPrefix(a.Name, "mcis-") && strings.HasSuffix(a.Name, suffix) {
+			return a, nil
+		}
+	}
+	return Asset{}, fmt.Errorf("release %s has no build for %s/%s", r.Tag, goos, goarch)
+}
+
+// Updater fetches and verifies releases.
+type Updater struct {
+	cfg    Config
+	client *http.Client
+}
+
+// New creates an updater for cfg.
+func New(cfg Config) *Updater {
+	if cfg.API == "" {
+		cfg.API = DefaultAPI
+	}
+	if cfg.Repo == "" {
+		cfg.Repo = DefaultRepo
+	}
+	cfg.API = strings.TrimSuffix(cfg.API, "/")
+	return &Updater{cfg: cfg, client: transport.ClientOr(cfg.HTTPClient)}
+}
+
+// Release returns the release tagged tag, or the latest one if tag is "".
+func (u *Updater) Release(ctx context.Context, tag string) (*Release, error) {
+	url := u.cfg.API + "/repos/" + u.cfg.Repo + "/releases/latest"
+	if tag != "" {
+		url = u.cfg.API + "/repos/" + u.cfg.Repo + "/releases/tags/" + tag
+	}
+	body, err := u.get(ctx, url, "application/vnd.github+json", 1<<20)
+	if err != nil {
+		return nil, err
+	}
+	var r Release
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, fmt.Errorf("parse release: %w", err)
+	}
+	if r.Tag == "" {
+		return nil, errors.New("parse release: no tag")
+	}
+	return &r, nil
+}
+
+// Fetch downloads the archive of the platform from r, verifies it and
+// returns the binary in it.
+func (u *Updater) Fetch(ctx context.Context, r *Release) ([]byte, error) {
+	a, err := r.Archive(runtime.GOOS, runtime.GOARCH)
+	if err != nil {
+		return nil, err
+	}
+	sum, ok := r.asset(a.Name + ".sha256")
+	if !ok {
+		return nil, fmt.Errorf("release %s publishes no checksum of %s", r.Tag, a.Name)
+	}
+	var sig Asset
+	if u.cfg.PublicKey != nil {
+		if sig, ok = r.asset(a.Name + ".sig"); !ok {
+			return nil, fmt.Errorf("release %s publishes no signature of %s", r.Tag, a.Name)
+		}
+	}
+
+	archive, err := u.get(ctx, a.URL, "application/octet-stream", maxArchive)
+	if err != nil {
+		return nil, err
+	}
+	sumText, err := u.get(ctx, sum.URL, "application/octet-stream", 4<<10)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyChecksum(archive, sumText); err != nil {
+		return nil, fmt.Errorf("%s: %w", a.Name, err)
+	}
+	if u.cfg.PublicKey != nil {
+		sigText, err := u.get(ctx, sig.URL, "application/octet-stream", 4<<10)
+		if err != nil {
+			return nil, err
+		}
+		if err := verifySignature(u.cfg.PublicKey, archive, sigText); err != nil {
+			return nil, fmt.Errorf("%s: %w", a.Name, err)
+		}
+	}
+	return extract(a.Name, archive)
+}
+
+// get fetches url, reading at most limit bytes.
+func (u *Updater) get(ctx context.Context, url, accept string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("GET %s: %w", url, err)
+	}
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("GET %s: larger than %d bytes", url, limit)
+	}
+	return body, nil
+}
+
+// verifyChecksum checks data against the hex SHA-256 at the start of
+// sumText, as sha256sum writes it.
+func verifyChecksum(data, sumText []byte) error {
+	fields := strings.Fields(string(sumText))
+	if len(fields) == 0 {
+		return errors.New("empty checksum file")
+	}
+	want, err := hex.DecodeString(fields[0])
+	if err != nil || len(want) != sha256.Size {
+		return fmt.Errorf("malformed checksum %q", fields[0])
+	}
+	got := sha256.Sum256(data)
+	if !bytes.Equal(got[:], want) {
+		return fmt.Errorf("checksum mismatch: got %x, want %x", got, want)
+	}
+	return nil
+}
+
+// verifySignature checks the base64 Ed25519 signature sigText of data.
+func verifySignature(key ed25519.PublicKey, data, sigText []byte) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigText)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return errors.New("malformed signature")
+	}
+	if !ed25519.Verify(key, data, sig) {
+		return errors.New("bad signature")
+	}
+	return nil
+}
+
+// ParsePublicKey parses a base64 Ed25519 public key.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("want a base64 Ed25519 public key of 32 bytes")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// binaryName is the name of the binary in the archives.
+func binaryName(goos string) string {
+	if goos == "windows" {
+		return "mcis.exe"
+	}
+	return "mcis"
+}
+
+// extract returns the binary from the archive named name.
+func extract(name string, archive []byte) ([]byte, error) {
+	bin := binaryName(runtime.GOOS)
+	if strings.HasSuffix(name, ".zip") {
+		zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		for _, f := range zr.File {
+			if path.Base(f.Name) != bin || f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			defer rc.Close()
+			return io.ReadAll(io.LimitReader(rc, maxArchive))
+		}
+		return nil, fmt.Errorf("%s holds no %s", name, bin)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s holds no %s", name, bin)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if h.Typeflag == tar.TypeReg && path.Base(h.Name) == bin {
+			return io.ReadAll(io.LimitReader(tr, maxArchive))
+		}
+	}
+}
+
+// Newer reports whether release version tag is newer than current; both are
+// of the form v1.2.3, with an optional -suffix that is ignored. A current
+// version that does not parse, such as that of a development build, is
+// never older.
+func Newer(tag, current string) bool {
+	t, ok1 := parseVersion(tag)
+	c, ok2 := parseVersion(current)
+	if !ok1 || !ok2 {
+		return false
+	}
+	for i := range t {
+		if t[i] != c[i] {
+			return t[i] > c[i]
+		}
+	}
+	return false
+}
+
+// parseVersion parses v1.2.3 into its numbers; missing ones are 0.
+func parseVersion(s string) ([3]int, bool) {
+	var v [3]int
+	s, _, _ = strings.Cut(strings.TrimPrefix(s, "v"), "-")
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 || s == "" {
+		return v, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
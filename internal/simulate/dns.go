@@ -0,0 +1,109 @@
+package simulate
+
+import (
+	"context"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/dns"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/logging"
+)
+
+// Provider is a dns.Provider that keeps the records in memory and logs every
+// change at debug level, for the DNS uploads of a simulation.
+type Provider struct {
+	name string
+
+	mu      sync.Mutex
+	records []dns.Record
+	nextID  int
+}
+
+// NewProvider creates an empty provider that reports name, e.g. that of the
+// provider it stands in for.
+func NewProvider(name string) *Provider {
+	return &Provider{name: name}
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+func (p *Provider) log(subdomain string) *slog.Logger {
+	return slog.With(logging.Provider(p.name), "subdomain", subdomain, "simulated", true)
+}
+
+func recordType(ipv6 bool) string {
+	if ipv6 {
+		return "AAAA"
+	}
+	return "A"
+}
+
+func (p *Provider) DeleteRecords(ctx context.Context, subdomain string, ipv6 bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	typ := recordType(ipv6)
+	p.mu.Lock()
+	n := len(p.records)
+	p.records = slices.DeleteFunc(p.records, func(r dns.Record) bool { return r.Name == subdomain && r.Type == typ })
+	n -= len(p.records)
+	p.mu.Unlock()
+	p.log(subdomain).DebugContext(ctx, "deleted records", "type", typ, "count", n)
+	return nil
+}
+
+func (p *Provider) CreateRecords(ctx context.Context, subdomain string, ips []netip.Addr) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	for _, ip := range ips {
+		p.nextID++
+		p.records = append(p.records, dns.Record{
+			ID:   strconv.Itoa(p.nextID),
+			Type: recordType(ip.Is6()),
+			Name: subdomain,
+			IP:   ip,
+			TTL:  1,
+		})
+	}
+	p.mu.Unlock()
+	p.log(subdomain).DebugContext(ctx, "created records", "ips", ips)
+	return nil
+}
+
+func (p *Provider) ListRecords(ctx context.Context, subdomain string, ipv6 bool) ([]dns.Record, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	typ := recordType(ipv6)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var out []dns.Record
+	for _, r := range p.records {
+		if r.Name == subdomain && r.Type == typ {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+func (p *Provider) UpdateRecord(ctx context.Context, rec dns.Record, ip netip.Addr) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	for i := range p.records {
+		if p.records[i].ID == rec.ID {
+			p.records[i].IP = ip
+		}
+	}
+	p.mu.Unlock()
+	p.log(rec.Name).DebugContext(ctx, "updated record", "id", rec.ID, logging.IP(ip))
+	return nil
+}
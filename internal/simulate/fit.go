@@ -0,0 +1,85 @@
+package simulate
+
+import (
+	"math"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// Sample is one recorded probe of an IP, such as a line of --all-results.
+type Sample struct {
+	IP   netip.Addr
+	OK   bool
+	MS   float64 // latency of a successful probe
+	Colo string
+	Mbps float64 // download speed, 0 if not tested
+}
+
+// Fit returns the rules that replay samples: one per /24 (IPv4) or /48
+// (IPv6) with samples, whose latency and jitter are the mean and standard
+// deviation of the successful probes, loss the share of failed ones, colo
+// the most frequent and speed the mean of the download tests (100 Mbps
+// without any). A subnet without a successful probe never answers.
+func Fit(samples []Sample) []Rule {
+	type acc struct {
+		n, ok     int
+		sum, sq   float64
+		mbps      float64
+		downloads int
+		colos     map[string]int
+	}
+	subnets := make(map[netip.Prefix]*acc)
+	for _, s := range samples {
+		bits := 24
+		if s.IP.Is6() {
+			bits = 48
+		}
+		p, err := s.IP.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		a := subnets[p]
+		if a == nil {
+			a = &acc{colos: make(map[string]int)}
+			subnets[p] = a
+		}
+		a.n++
+		if !s.OK {
+			continue
+		}
+		a.ok++
+		a.sum += s.MS
+		a.sq += s.MS * s.MS
+		if s.Colo != "" {
+			a.colos[s.Colo]++
+		}
+		if s.Mbps > 0 {
+			a.mbps += s.Mbps
+			a.downloads++
+		}
+	}
+
+	rules := make([]Rule, 0, len(subnets))
+	for p, a := range subnets {
+		r := Rule{Prefix: p, Subnet: Subnet{Loss: 1, Latency: time.Second, Colo: "SIM", Mbps: 100}}
+		if a.ok > 0 {
+			mean := a.sum / float64(a.ok)
+			sd := math.Sqrt(max(a.sq/float64(a.ok)-mean*mean, 0))
+			r.Latency = time.Duration(max(mean, 1) * float64(time.Millisecond))
+			r.Jitter = time.Duration(sd * float64(time.Millisecond))
+			r.Loss = 1 - float64(a.ok)/float64(a.n)
+			for colo, n := range a.colos {
+				if n > a.colos[r.Colo] || n == a.colos[r.Colo] && colo < r.Colo {
+					r.Colo = colo
+				}
+			}
+			if a.downloads > 0 {
+				r.Mbps = a.mbps / float64(a.downloads)
+			}
+		}
+		rules = append(rules, r)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Prefix.Addr().Less(rules[j].Prefix.Addr()) })
+	return rules
+}
@@ -0,0 +1,277 @@
+// Package simulate stands in for the network in mcis --simulate: probes and
+// download tests draw their results from a latency model per subnet, and DNS
+// uploads go to providers that keep the records in memory, so that the
+// search strategy, the selection and the upload logic can be exercised end
+// to end without sending a packet.
+package simulate
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/probe"
+)
+
+// Subnet is the behavior of the IPs of a subnet.
+type Subnet struct {
+	Latency time.Duration // mean round trip of a probe
+	Jitter  time.Duration // standard deviation around Latency
+	Loss    float64       // probability that a probe times out, 0 to 1
+	Colo    string        // CDN colo reported in the trace
+	Mbps    float64       // mean download speed
+}
+
+// Rule is the Subnet of the IPs of a Prefix.
+type Rule struct {
+	Prefix netip.Prefix
+	Subnet
+}
+
+// Model gives the Subnet of every IP: that of the longest Rule covering it,
+// or else a synthetic one derived from the IP's /24 (IPv4) or /48 (IPv6), so
+// that a search has fast and slow subnets to tell apart.
+type Model struct {
+	rules   []Rule // longest prefix first
+	unknown *Subnet
+	seed    uint64
+	timeout time.Duration
+}
+
+// Config configures a Model.
+type Config struct {
+	Rules []Rule
+	// Unknown, if set, is the Subnet of the IPs that no rule covers, in
+	// place of the synthetic ones; a model fitted to recorded probes knows
+	// nothing of the subnets that were never probed.
+	Unknown *Subnet
+	// Seed makes the draws reproducible: an IP gets the same results in
+	// every run with the same seed (0 = random).
+	Seed int64
+	// Timeout is the probe timeout; slower draws fail as timeouts
+	// (default 3s).
+	Timeout time.Duration
+}
+
+// colos are the colos of the synthetic subnets.
+var colos = []string{"HKG", "NRT", "SIN", "LAX", "SJC", "FRA"}
+
+// New creates the model of cfg.
+func New(cfg Config) *Model {
+	rules := slices.Clone(cfg.Rules)
+	slices.SortStableFunc(rules, func(a, b Rule) int { return b.Prefix.Bits() - a.Prefix.Bits() })
+	seed := uint64(cfg.Seed)
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 3 * time.Second
+	}
+	return &Model{rules: rules, unknown: cfg.Unknown, seed: seed, timeout: timeout}
+}
+
+// Lookup returns the Subnet of ip.
+func (m *Model) Lookup(ip netip.Addr) Subnet {
+	for _, r := range m.rules {
+		if r.Prefix.Contains(ip) {
+			return r.Subnet
+		}
+	}
+	if m.unknown != nil {
+		return *m.unknown
+	}
+	return m.synthetic(ip)
+}
+
+// synthetic derives the Subnet of ip from a hash of its /24 or /48: most
+// subnets are slow, a few are fast, and one in ten does not answer.
+func (m *Model) synthetic(ip netip.Addr) Subnet {
+	bits := 24
+	if ip.Is6() {
+		bits = 48
+	}
+	p, _ := ip.Prefix(bits)
+	h := m.hash(p.String())
+	u := float64(h>>11) / (1 << 53)
+	latency := 40 + 360*math.Sqrt(u)
+	s := Subnet{
+		Latency: time.Duration(latency * float64(time.Millisecond)),
+		Jitter:  time.Duration(latency / 10 * float64(time.Millisecond)),
+		Loss:    0.02,
+		Colo:    colos[h%uint64(len(colos))],
+		Mbps:    20000 / latency,
+	}
+	if h%10 == 0 {
+		s.Loss = 1
+	}
+	return s
+}
+
+func (m *Model) hash(s string) uint64 {
+	f := fnv.New64a()
+	fmt.Fprintf(f, "%d/%s", m.seed, s)
+	return f.Sum64()
+}
+
+// rng returns the random source of the draws for ip in phase, the same in
+// every run with the same seed.
+func (m *Model) rng(ip netip.Addr, phase string) *rand.Rand {
+	return rand.New(rand.NewPCG(m.seed, m.hash(phase+" "+ip.String())))
+}
+
+// Trace stands in for the HTTP trace probe of ip.
+func (m *Model) Trace(ctx context.Context, ip netip.Addr) probe.Result {
+	now := time.Now()
+	if err := ctx.Err(); err != nil {
+		return probe.Result{IP: ip, Error: err.Error(), When: now}
+	}
+	s := m.Lookup(ip)
+	r := m.rng(ip, "trace")
+	latency := s.Latency + time.Duration(r.NormFloat64()*float64(s.Jitter))
+	latency = max(latency, time.Millisecond)
+	if r.Float64() < s.Loss || latency > m.timeout {
+		return probe.Result{IP: ip, Error: "simulated: i/o timeout", When: now}
+	}
+	ms := latency.Milliseconds()
+	return probe.Result{
+		IP:        ip,
+		OK:        true,
+		Status:    200,
+		ConnectMS: ms / 3,
+		TLSMS:     ms / 3,
+		TTFBMS:    ms - 2*(ms/3),
+		TotalMS:   ms,
+		JitterMS:  float64(s.Jitter) / float64(time.Millisecond),
+		Trace:     map[string]string{"ip": ip.String(), "colo": s.Colo},
+		When:      now,
+	}
+}
+
+// Download stands in for the download test of ip, reporting bytes as if
+// they were fetched at the subnet's speed.
+func (m *Model) Download(ctx context.Context, ip netip.Addr, bytes int64) probe.DownloadResult {
+	now := time.Now()
+	if err := ctx.Err(); err != nil {
+		return probe.DownloadResult{IP: ip, Error: err.Error(), When: now}
+	}
+	s := m.Lookup(ip)
+	r := m.rng(ip, "download")
+	if r.Float64() < s.Loss {
+		return probe.DownloadResult{IP: ip, Error: "simulated: i/o timeout", When: now}
+	}
+	mbps := max(s.Mbps*(1+0.2*r.NormFloat64()), 0.1)
+	return probe.DownloadResult{
+		IP:      ip,
+		OK:      true,
+		Status:  200,
+		Bytes:   bytes,
+		TotalMS: int64(float64(bytes*8) / (mbps * 1e6) * 1000),
+		Mbps:    mbps,
+		When:    now,
+	}
+}
+
+// ParseRules parses a model file: one rule per line, a prefix followed by
+// key=value settings, with # starting a comment:
+//
+//	104.16.0.0/13   latency=180ms jitter=40ms loss=0.05 colo=LAX mbps=80
+//	104.16.8.0/24   latency=60ms colo=HKG
+//
+// Unset keys default to a jitter of a tenth of the latency, no loss, colo
+// SIM and 100 Mbps; latency is required.
+func ParseRules(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		rule, err := parseRule(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// ReadRulesFile is ParseRules reading the file at path.
+func ReadRulesFile(path string) ([]Rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	rules, err := ParseRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, nil
+}
+
+func parseRule(fields []string) (Rule, error) {
+	p, err := netip.ParsePrefix(fields[0])
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid prefix %q", fields[0])
+	}
+	rule := Rule{Prefix: p.Masked(), Subnet: Subnet{Jitter: -1, Colo: "SIM", Mbps: 100}}
+	for _, f := range fields[1:] {
+		key, value, ok := strings.Cut(f, "=")
+		if !ok {
+			return Rule{}, fmt.Errorf("%q: want key=value", f)
+		}
+		switch key {
+		case "latency":
+			rule.Latency, err = time.ParseDuration(value)
+			if err == nil && rule.Latency <= 0 {
+				err = errors.New("must be > 0")
+			}
+		case "jitter":
+			rule.Jitter, err = time.ParseDuration(value)
+			if err == nil && rule.Jitter < 0 {
+				err = errors.New("must be >= 0")
+			}
+		case "loss":
+			rule.Loss, err = strconv.ParseFloat(value, 64)
+			if err == nil && (rule.Loss < 0 || rule.Loss > 1) {
+				err = errors.New("must be between 0 and 1")
+			}
+		case "colo":
+			rule.Colo = strings.ToUpper(value)
+		case "mbps":
+			rule.Mbps, err = strconv.ParseFloat(value, 64)
+			if err == nil && rule.Mbps <= 0 {
+				err = errors.New("must be > 0")
+			}
+		default:
+			return Rule{}, fmt.Errorf("unknown key %q (want latency, jitter, loss, colo or mbps)", key)
+		}
+		if err != nil {
+			return Rule{}, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	if rule.Latency == 0 {
+		return Rule{}, fmt.Errorf("%s: latency is required", fields[0])
+	}
+	if rule.Jitter < 0 {
+		rule.Jitter = rule.Latency / 10
+	}
+	return rule, nil
+}
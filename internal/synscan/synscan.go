@@ -0,0 +1,46 @@
+// Package synscan measures latency with raw TCP SYN probes: it sends a SYN
+// to port 443 and times the SYN-ACK, without a socket, a handshake or a
+// goroutine per connection, so a single process can probe 100k+ IPs per
+// second. The kernel answers the SYN-ACK with a RST, so no connection is
+// left half-open on the target.
+//
+// Raw sockets need root or CAP_NET_RAW and are only available on Linux;
+// Open fails otherwise, and callers fall back to the standard dialer.
+package synscan
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotSupported is returned on platforms without raw TCP sockets.
+var ErrNotSupported = errors.New("raw SYN probes are only supported on Linux")
+
+// Port is the destination port of the probes.
+const Port = 443
+
+// Source ports of the probes. They lie above Linux's default ephemeral
+// range (32768-60999), so replies do not collide with local connections.
+const (
+	minSourcePort = 61000
+	maxSourcePort = 65535
+)
+
+// Config configures a Scanner.
+type Config struct {
+	// Timeout is how long to wait for the reply to one SYN (default 1s).
+	Timeout time.Duration
+
+	// Rounds is the number of SYNs per IP; the latency is their average
+	// (default 3).
+	Rounds int
+}
+
+func (c *Config) applyDefaults() {
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second
+	}
+	if c.Rounds <= 0 {
+		c.Rounds = 3
+	}
+}
@@ -0,0 +1,301 @@
+//go:build linux
+
+package synscan
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/probe"
+)
+
+// TCP header flags.
+const (
+	flagSYN = 0x02
+	flagRST = 0x04
+	flagACK = 0x10
+)
+
+// synLen is the length of a SYN: the TCP header plus an MSS option, which
+// some middleboxes insist on.
+const synLen = 24
+
+var (
+	errTimeout = errors.New("timeout")
+	errRefused = errors.New("connection refused")
+	errNoIPv6  = errors.New("no raw IPv6 socket")
+)
+
+// Scanner sends SYN probes and matches the replies. It is safe for
+// concurrent use; every Probe call waits only for its own replies.
+type Scanner struct {
+	cfg   Config
+	conn4 *net.IPConn
+	conn6 *net.IPConn // nil without IPv6
+
+	port atomic.Uint32
+
+	mu      sync.Mutex
+	pending map[pendingKey]*pending
+
+	srcMu sync.Mutex
+	src   map[netip.Prefix]netip.Addr
+}
+
+// pendingKey identifies the replies to one SYN.
+type pendingKey struct {
+	ip   netip.Addr
+	port uint16 // source port of the SYN
+}
+
+type pending struct {
+	seq   uint32
+	sent  time.Time
+	reply chan reply
+}
+
+// reply is the outcome of a SYN: a SYN-ACK (nil err) or a RST.
+type reply struct {
+	rtt time.Duration
+	err error
+}
+
+// Open opens the raw sockets. It fails without root or CAP_NET_RAW.
+func Open(cfg Config) (*Scanner, error) {
+	cfg.applyDefaults()
+	conn4, err := net.ListenIP("ip4:tcp", &net.IPAddr{IP: net.IPv4zero})
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			return nil, fmt.Errorf("raw sockets need root or CAP_NET_RAW: %w", err)
+		}
+		return nil, err
+	}
+	s := &Scanner{
+		cfg:     cfg,
+		conn4:   conn4,
+		pending: make(map[pendingKey]*pending),
+		src:     make(map[netip.Prefix]netip.Addr),
+	}
+	if conn6, err := net.ListenIP("ip6:tcp", &net.IPAddr{IP: net.IPv6unspecified}); err == nil {
+		s.conn6 = conn6
+	}
+	for _, c := range []*net.IPConn{s.conn4, s.conn6} {
+		if c == nil {
+			continue
+		}
+		// Replies arrive in bursts at high probe rates.
+		_ = c.SetReadBuffer(4 << 20)
+		go s.read(c)
+	}
+	return s, nil
+}
+
+// Close closes the raw sockets; pending probes time out.
+func (s *Scanner) Close() error {
+	err := s.conn4.Close()
+	if s.conn6 != nil {
+		err = errors.Join(err, s.conn6.Close())
+	}
+	return err
+}
+
+// Probe sends Config.Rounds SYNs to ip, one after the other, and returns
+// their average round trip as ConnectMS and TotalMS. Like the HTTP probe
+// it fails on the first failed round, with Error "timeout" or "connection
+// refused" (a RST).
+func (s *Scanner) Probe(ctx context.Context, ip netip.Addr) probe.Result {
+	res := probe.Result{IP: ip, When: time.Now()}
+	var total time.Duration
+	for range s.cfg.Rounds {
+		rtt, err := s.syn(ctx, ip.Unmap())
+		if err != nil {
+			if ctx.Err() != nil {
+				err = errTimeout
+			}
+			res.Error = err.Error()
+			res.TotalMS = time.Since(res.When).Milliseconds()
+			return res
+		}
+		total += rtt
+	}
+	ms := (total / time.Duration(s.cfg.Rounds)).Milliseconds()
+	res.OK = true
+	res.ConnectMS = ms
+	res.TotalMS = ms
+	return res
+}
+
+// syn sends one SYN and waits for its reply.
+func (s *Scanner) syn(ctx context.Context, ip netip.Addr) (time.Duration, error) {
+	conn := s.conn4
+	if ip.Is6() {
+		if conn = s.conn6; conn == nil {
+			return 0, errNoIPv6
+		}
+	}
+	src, err := s.source(ip)
+	if err != nil {
+		return 0, err
+	}
+
+	p := &pending{seq: rand.Uint32(), reply: make(chan reply, 1), sent: time.Now()}
+	key, err := s.register(ip, p)
+	if err != nil {
+		return 0, err
+	}
+	defer s.unregister(key)
+
+	if _, err := conn.WriteToIP(synPacket(src, ip, key.port, p.seq), &net.IPAddr{IP: ip.AsSlice()}); err != nil {
+		return 0, err
+	}
+
+	t := time.NewTimer(s.cfg.Timeout)
+	defer t.Stop()
+	select {
+	case r := <-p.reply:
+		return r.rtt, r.err
+	case <-t.C:
+		return 0, errTimeout
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// register picks a source port that no pending SYN to ip uses.
+func (s *Scanner) register(ip netip.Addr, p *pending) (pendingKey, error) {
+	const ports = maxSourcePort - minSourcePort + 1
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for range ports {
+		key := pendingKey{ip: ip, port: uint16(minSourcePort + s.port.Add(1)%ports)}
+		if _, busy := s.pending[key]; !busy {
+			s.pending[key] = p
+			return key, nil
+		}
+	}
+	return pendingKey{}, errors.New("no free source port")
+}
+
+func (s *Scanner) unregister(key pendingKey) {
+	s.mu.Lock()
+	delete(s.pending, key)
+	s.mu.Unlock()
+}
+
+// read matches the TCP segments arriving on conn to the pending SYNs. It
+// returns when conn is closed.
+func (s *Scanner) read(conn *net.IPConn) {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFromIP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		// The kernel strips the IPv4 header; IPv6 raw sockets never see it.
+		if err != nil || n < 20 || binary.BigEndian.Uint16(buf[0:2]) != Port {
+			continue
+		}
+		ip, ok := netip.AddrFromSlice(addr.IP)
+		if !ok {
+			continue
+		}
+		key := pendingKey{ip: ip.Unmap(), port: binary.BigEndian.Uint16(buf[2:4])}
+		ack := binary.BigEndian.Uint32(buf[8:12])
+		flags := buf[13]
+
+		s.mu.Lock()
+		p := s.pending[key]
+		s.mu.Unlock()
+		if p == nil || ack != p.seq+1 {
+			continue
+		}
+		r := reply{rtt: time.Since(p.sent)}
+		switch {
+		case flags&(flagSYN|flagACK) == flagSYN|flagACK:
+		case flags&flagRST != 0:
+			r.err = errRefused
+		default:
+			continue
+		}
+		select {
+		case p.reply <- r:
+		default:
+		}
+	}
+}
+
+// source returns the local address the kernel routes ip from, which the TCP
+// checksum covers. It is looked up once per /24 (IPv4) or /48 (IPv6).
+func (s *Scanner) source(ip netip.Addr) (netip.Addr, error) {
+	bits := 24
+	if ip.Is6() {
+		bits = 48
+	}
+	key, _ := ip.Prefix(bits)
+
+	s.srcMu.Lock()
+	src, ok := s.src[key]
+	s.srcMu.Unlock()
+	if ok {
+		return src, nil
+	}
+
+	// Connecting a UDP socket only looks up the route; nothing is sent.
+	c, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip, Port)))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	src = c.LocalAddr().(*net.UDPAddr).AddrPort().Addr().Unmap()
+	_ = c.Close()
+
+	s.srcMu.Lock()
+	s.src[key] = src
+	s.srcMu.Unlock()
+	return src, nil
+}
+
+// synPacket builds the TCP header of a SYN from src:sport to dst:Port;
+// the kernel adds the IP header.
+func synPacket(src, dst netip.Addr, sport uint16, seq uint32) []byte {
+	b := make([]byte, synLen)
+	binary.BigEndian.PutUint16(b[0:], sport)
+	binary.BigEndian.PutUint16(b[2:], Port)
+	binary.BigEndian.PutUint32(b[4:], seq)
+	b[12] = synLen / 4 << 4 // data offset in 32-bit words
+	b[13] = flagSYN
+	binary.BigEndian.PutUint16(b[14:], 64240) // window
+	b[20], b[21] = 2, 4                       // MSS option
+	binary.BigEndian.PutUint16(b[22:], 1460)
+	binary.BigEndian.PutUint16(b[16:], checksum(src, dst, b))
+	return b
+}
+
+// checksum computes the TCP checksum of seg, including the pseudo header
+// of src and dst.
+func checksum(src, dst netip.Addr, seg []byte) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i:]))
+		}
+	}
+	s, d := src.AsSlice(), dst.AsSlice()
+	add(s)
+	add(d)
+	sum += 6 // protocol TCP
+	sum += uint32(len(seg))
+	add(seg)
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+
+package synscan
+
+import (
+	"context"
+	"net/netip"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/probe"
+)
+
+// Scanner is only available on Linux.
+type Scanner struct{}
+
+// Open is only available on Linux.
+func Open(cfg Config) (*Scanner, error) { return nil, ErrNotSupported }
+
+// Probe is only available on Linux.
+func (s *Scanner) Probe(ctx context.Context, ip netip.Addr) probe.Result {
+	return probe.Result{IP: ip, Error: ErrNotSupported.Error()}
+}
+
+// Close is only available on Linux.
+func (s *Scanner) Close() error { return ErrNotSupported }
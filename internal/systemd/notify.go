@@ -0,0 +1,81 @@
+// Package systemd implements the sd_notify protocol used by Type=notify
+// services and the systemd watchdog, without linking libsystemd.
+package systemd
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Notification states understood by systemd.
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Status returns a STATUS= notification shown by "systemctl status".
+func Status(msg string) string {
+	return "STATUS=" + msg
+}
+
+// Notify sends state to the socket named by $NOTIFY_SOCKET. It reports false
+// (and no error) when the process is not running under systemd.
+func Notify(state string) (bool, error) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return false, nil
+	}
+
+	// A leading '@' selects the abstract namespace; net handles the translation.
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the watchdog timeout configured via WatchdogSec=,
+// or false when the watchdog is not enabled for this process.
+func WatchdogInterval() (time.Duration, bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false
+	}
+	return time.Duration(usec) * time.Microsecond, true
+}
+
+// RunWatchdog pings the watchdog at half the configured interval until ctx is
+// canceled. A ping is only sent while healthy returns true, so systemd
+// restarts the service once it stops making progress. It returns immediately
+// when the watchdog is not enabled.
+func RunWatchdog(ctx context.Context, healthy func() bool) {
+	interval, ok := WatchdogInterval()
+	if !ok {
+		return
+	}
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if healthy() {
+				_, _ = Notify(Watchdog)
+			}
+		}
+	}
+}
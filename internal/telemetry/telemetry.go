@@ -0,0 +1,65 @@
+// Package telemetry sends the anonymous usage report of a run to the
+// project's endpoint. Nothing is sent unless the user opts in with
+// --telemetry. A report says how the search was run, so effort goes to the
+// strategies and providers in use. It never carries an IP, prefix, domain,
+// zone, token or URL of the user, nor an ID that ties two reports together.
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/transport"
+)
+
+// Report is the whole content of a telemetry request.
+type Report struct {
+	Version string `json:"version"` // release of mcis, "" for a development build
+	OS      string `json:"os"`
+	Arch    string `json:"arch"`
+
+	DurationS float64 `json:"duration_s"` // wall time of the run
+	Samples   int64   `json:"samples"`    // probes of the search
+	Budget    int     `json:"budget"`
+	// Strategy is the probe of the search: "http", "syn" or "icmp",
+	// after any fallback to HTTP.
+	Strategy string `json:"strategy"`
+	Agents   int    `json:"agents"`     // agents of the re-probe
+	Download bool   `json:"download"`   // the top IPs were speed-tested
+	Periodic bool   `json:"periodic"`   // the run is one of an --interval loop
+	Resumed  bool   `json:"resumed"`    // the search resumed from --state
+	Failed   bool   `json:"failed"`     // the run ended with an error
+	Upload   bool   `json:"dns_upload"` // DNS upload was enabled
+
+	// Providers and Publishers are the kinds of DNS providers, e.g.
+	// "cloudflare", and of publishers, e.g. "git", that the run used.
+	Providers  []string `json:"providers,omitempty"`
+	Publishers []string `json:"publishers,omitempty"`
+}
+
+// Send posts r as JSON to url.
+func Send(ctx context.Context, url string, r Report) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := transport.Client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("telemetry endpoint returned %s", resp.Status)
+	}
+	return nil
+}
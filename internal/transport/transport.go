@@ -0,0 +1,104 @@
+// Package transport holds the HTTP transports shared across the tool, so that
+// clients created in different places reuse idle connections instead of
+// paying for a TCP and TLS handshake each.
+package transport
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// api is the transport of the API clients: DNS providers, notifiers and
+// publishers. It honors the proxy environment variables like
+// http.DefaultTransport, but keeps more idle connections per host, since a
+// DNS upload sends many requests to the same API in parallel.
+var api = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   16,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: 1 * time.Second,
+}
+
+// API returns the shared transport for API requests.
+func API() *http.Transport {
+	return api
+}
+
+// Client returns a client on the shared API transport.
+func Client() *http.Client {
+	return &http.Client{Transport: api}
+}
+
+// ClientOr returns c, or a client on the shared API transport if c is nil.
+func ClientOr(c *http.Client) *http.Client {
+	if c == nil {
+		return Client()
+	}
+	return c
+}
+
+// DirectConfig describes a transport that connects to the IPs in request
+// URLs without a proxy, as probes and speed tests do.
+type DirectConfig struct {
+	SNI string
+	// DialTimeout bounds the TCP connect, TLSTimeout the handshake and
+	// HeaderTimeout the wait for the response headers.
+	DialTimeout   time.Duration
+	TLSTimeout    time.Duration
+	HeaderTimeout time.Duration
+	// MaxIdlePerHost is the number of idle connections kept per IP.
+	MaxIdlePerHost int
+
+	// Dialer, if set, makes the TCP connections instead of a dialer with
+	// DialTimeout, e.g. to bind a source address or interface.
+	Dialer *net.Dialer
+}
+
+var (
+	directMu sync.Mutex
+	direct   = map[DirectConfig]*http.Transport{}
+)
+
+// Direct returns the transport for cfg, creating it on first use. Probers
+// with the same settings share it, so the agent handler, verify and
+// consecutive runs in one process reuse their connections.
+func Direct(cfg DirectConfig) *http.Transport {
+	directMu.Lock()
+	defer directMu.Unlock()
+	if t, ok := direct[cfg]; ok {
+		return t
+	}
+	dialer := cfg.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{
+			Timeout:   cfg.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}
+	}
+	t := &http.Transport{
+		Proxy:                 nil, // critical: ignore HTTP(S)_PROXY and NO_PROXY env vars
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          max(1024, 4*cfg.MaxIdlePerHost),
+		MaxIdleConnsPerHost:   cfg.MaxIdlePerHost,
+		IdleConnTimeout:       30 * time.Second,
+		TLSHandshakeTimeout:   cfg.TLSTimeout,
+		ResponseHeaderTimeout: cfg.HeaderTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig: &tls.Config{
+			ServerName: cfg.SNI,
+		},
+	}
+	direct[cfg] = t
+	return t
+}
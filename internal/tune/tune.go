@@ -0,0 +1,101 @@
+// Package tune derives the default probe concurrency, probe rate and
+// speed-test parallelism from the machine mcis runs on, so that the same
+// defaults neither overload a single-core router on a 4G uplink nor leave a
+// 32-core server idle.
+package tune
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Link is the kind of network link behind the default route.
+type Link string
+
+const (
+	LinkUnknown  Link = ""
+	LinkEthernet Link = "ethernet"
+	LinkWiFi     Link = "wifi"
+	LinkCellular Link = "cellular"
+)
+
+// ParseLink parses a link type as given on the command line; "auto" (or
+// "") is LinkUnknown, which Detect replaces with the detected link.
+func ParseLink(s string) (Link, error) {
+	switch l := Link(s); l {
+	case "auto", LinkUnknown:
+		return LinkUnknown, nil
+	case LinkEthernet, LinkWiFi, LinkCellular:
+		return l, nil
+	}
+	return LinkUnknown, fmt.Errorf("unknown link type %q (want auto, ethernet, wifi or cellular)", s)
+}
+
+// Hardware is what the defaults are derived from.
+type Hardware struct {
+	CPUs int
+	// Memory is the memory available to the process in bytes, 0 if unknown.
+	Memory uint64
+	Link   Link
+}
+
+// Detect inspects the machine. Memory and link detection are best effort:
+// only Linux reports them, elsewhere they stay unknown.
+func Detect() Hardware {
+	return Hardware{
+		CPUs:   runtime.GOMAXPROCS(0),
+		Memory: availableMemory(),
+		Link:   detectLink(),
+	}
+}
+
+func (h Hardware) String() string {
+	mem := "unknown memory"
+	if h.Memory > 0 {
+		mem = fmt.Sprintf("%.1f GiB available", float64(h.Memory)/(1<<30))
+	}
+	link := h.Link
+	if link == LinkUnknown {
+		link = "unknown"
+	}
+	return fmt.Sprintf("%d CPUs, %s, %s link", h.CPUs, mem, link)
+}
+
+// Defaults are tuned values for the flags that depend on the machine.
+type Defaults struct {
+	Concurrency         int
+	PPS                 float64 // 0 = unlimited
+	DownloadConcurrency int
+}
+
+// probeMemory is roughly what one in-flight HTTP trace probe holds: the TLS
+// connection, its buffers and the goroutines serving it.
+const probeMemory = 128 << 10
+
+// Defaults derives the defaults for h: 50 probes in flight per CPU, no more
+// than a quarter of the available memory can hold, and less on the links
+// that drop packets when flooded with new connections.
+func (h Hardware) Defaults() Defaults {
+	d := Defaults{
+		Concurrency:         min(max(50*h.CPUs, 32), 1000),
+		DownloadConcurrency: 1,
+	}
+	if h.Memory > 0 {
+		d.Concurrency = min(d.Concurrency, int(h.Memory/4/probeMemory))
+	}
+	switch h.Link {
+	case LinkCellular:
+		d.Concurrency = min(d.Concurrency, 64)
+		d.PPS = 50
+	case LinkWiFi:
+		d.Concurrency = min(d.Concurrency, 256)
+		d.PPS = 300
+	case LinkEthernet:
+		// One TCP flow rarely fills a fast server uplink.
+		if h.CPUs >= 8 && h.Memory >= 1<<30 {
+			d.DownloadConcurrency = 2
+		}
+	}
+	d.Concurrency = max(d.Concurrency, 16)
+	return d
+}
@@ -0,0 +1,107 @@
+//go:build linux
+
+package tune
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// availableMemory is MemAvailable, or what is left below the cgroup's limit
+// when that is lower, e.g. in a container.
+func availableMemory() uint64 {
+	var avail uint64
+	if data, err := os.ReadFile("/proc/meminfo"); err == nil {
+		for line := range strings.Lines(string(data)) {
+			if f := strings.Fields(line); len(f) >= 2 && f[0] == "MemAvailable:" {
+				kb, _ := strconv.ParseUint(f[1], 10, 64)
+				avail = kb << 10
+			}
+		}
+	}
+	limit, err1 := readUint("/sys/fs/cgroup/memory.max")
+	used, err2 := readUint("/sys/fs/cgroup/memory.current")
+	if err1 == nil && err2 == nil && limit > used && (avail == 0 || limit-used < avail) {
+		avail = limit - used
+	}
+	return avail
+}
+
+func readUint(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(bytes.TrimSpace(data)), 10, 64)
+}
+
+// detectLink classifies the interface of the default route.
+func detectLink() Link {
+	iface := defaultInterface()
+	if iface == "" {
+		return LinkUnknown
+	}
+	dir := filepath.Join("/sys/class/net", iface)
+	if exists(filepath.Join(dir, "wireless")) || exists(filepath.Join(dir, "phy80211")) {
+		return LinkWiFi
+	}
+	if uevent, err := os.ReadFile(filepath.Join(dir, "uevent")); err == nil && bytes.Contains(uevent, []byte("DEVTYPE=wwan")) {
+		return LinkCellular
+	}
+	// Modems and tethered phones that do not set DEVTYPE.
+	for _, prefix := range []string{"wwan", "rmnet", "ccmni", "usb"} {
+		if strings.HasPrefix(iface, prefix) {
+			return LinkCellular
+		}
+	}
+	// ARPHRD_ETHER; PPP and tunnels could be anything underneath.
+	if t, err := readUint(filepath.Join(dir, "type")); err == nil && t == 1 {
+		return LinkEthernet
+	}
+	return LinkUnknown
+}
+
+// defaultInterface returns the interface of the IPv4 default route, or of
+// the IPv6 one on IPv6-only hosts.
+func defaultInterface() string {
+	// Iface Destination Gateway ...
+	if iface := scanRoutes("/proc/net/route", func(f []string) string {
+		if len(f) > 1 && f[1] == "00000000" {
+			return f[0]
+		}
+		return ""
+	}); iface != "" {
+		return iface
+	}
+	// Destination PrefixLen Source SrcLen NextHop Metric RefCnt Use Flags Iface
+	return scanRoutes("/proc/net/ipv6_route", func(f []string) string {
+		if len(f) == 10 && f[0] == strings.Repeat("0", 32) && f[1] == "00" && f[9] != "lo" {
+			return f[9]
+		}
+		return ""
+	})
+}
+
+func scanRoutes(path string, match func([]string) string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if iface := match(strings.Fields(sc.Text())); iface != "" {
+			return iface
+		}
+	}
+	return ""
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package tune
+
+func availableMemory() uint64 { return 0 }
+
+func detectLink() Link { return LinkUnknown }
@@ -0,0 +1,38 @@
+//go:build !windows
+
+package winsvc
+
+import "context"
+
+// Install is only available on Windows.
+func Install(name, displayName, exe string, args []string) error { return ErrNotSupported }
+
+// Uninstall is only available on Windows.
+func Uninstall(name string) error { return ErrNotSupported }
+
+// Start is only available on Windows.
+func Start(name string) error { return ErrNotSupported }
+
+// Stop is only available on Windows.
+func Stop(name string) error { return ErrNotSupported }
+
+// Run is only available on Windows.
+func Run(name string, run func(ctx context.Context) error) error { return ErrNotSupported }
+
+// EventLog is only available on Windows.
+type EventLog struct{}
+
+// OpenEventLog is only available on Windows.
+func OpenEventLog(source string) (*EventLog, error) { return nil, ErrNotSupported }
+
+// Info is only available on Windows.
+func (l *EventLog) Info(msg string) error { return ErrNotSupported }
+
+// Error is only available on Windows.
+func (l *EventLog) Error(msg string) error { return ErrNotSupported }
+
+// Close is only available on Windows.
+func (l *EventLog) Close() error { return nil }
+
+// Supported reports whether service management is available on this platform.
+func Supported() bool { return false }
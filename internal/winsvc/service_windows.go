@@ -0,0 +1,397 @@
+//go:build windows
+
+package winsvc
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	advapi32 = syscall.NewLazyDLL("advapi32.dll")
+
+	procOpenSCManagerW                = advapi32.NewProc("OpenSCManagerW")
+	procCreateServiceW                = advapi32.NewProc("CreateServiceW")
+	procOpenServiceW                  = advapi32.NewProc("OpenServiceW")
+	procDeleteService                 = advapi32.NewProc("DeleteService")
+	procCloseServiceHandle            = advapi32.NewProc("CloseServiceHandle")
+	procStartServiceW                 = advapi32.NewProc("StartServiceW")
+	procControlService                = advapi32.NewProc("ControlService")
+	procStartServiceCtrlDispatcherW   = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerExW = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus              = advapi32.NewProc("SetServiceStatus")
+	procRegisterEventSourceW          = advapi32.NewProc("RegisterEventSourceW")
+	procDeregisterEventSource         = advapi32.NewProc("DeregisterEventSource")
+	procReportEventW                  = advapi32.NewProc("ReportEventW")
+	procRegCreateKeyExW               = advapi32.NewProc("RegCreateKeyExW")
+	procRegSetValueExW                = advapi32.NewProc("RegSetValueExW")
+	procRegDeleteKeyW                 = advapi32.NewProc("RegDeleteKeyW")
+	procRegCloseKey                   = advapi32.NewProc("RegCloseKey")
+)
+
+const (
+	scManagerAllAccess = 0xF003F
+	serviceAllAccess   = 0xF01FF
+
+	serviceWin32OwnProcess = 0x10
+	serviceAutoStart       = 2
+	serviceErrorNormal     = 1
+
+	serviceControlStop        = 1
+	serviceControlInterrogate = 4
+	serviceControlShutdown    = 5
+
+	serviceStopped      = 1
+	serviceStartPending = 2
+	serviceStopPending  = 3
+	serviceRunning      = 4
+
+	serviceAcceptStop     = 1
+	serviceAcceptShutdown = 4
+
+	eventlogErrorType       = 1
+	eventlogWarningType     = 2
+	eventlogInformationType = 4
+
+	errorCallNotImplemented    = 120
+	errorServiceSpecificError  = 1066
+	serviceSpecificExitFailure = 1
+
+	hkeyLocalMachine = 0x80000002
+	keyAllAccess     = 0xF003F
+	regExpandSZ      = 2
+	regDWORD         = 4
+
+	eventLogKey = `SYSTEM\CurrentControlSet\Services\EventLog\Application\`
+)
+
+type serviceStatus struct {
+	ServiceType             uint32
+	CurrentState            uint32
+	ControlsAccepted        uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	CheckPoint              uint32
+	WaitHint                uint32
+}
+
+type serviceTableEntry struct {
+	ServiceName *uint16
+	ServiceProc uintptr
+}
+
+// utf16 converts s for a Windows API call; it fails on a NUL in s. The
+// caller keeps the result in a variable and converts it to uintptr only in
+// the argument list of the call, so that it stays alive until the call
+// returns.
+func utf16(s string) (*uint16, error) {
+	return syscall.UTF16PtrFromString(s)
+}
+
+func openManager() (uintptr, error) {
+	h, _, err := procOpenSCManagerW.Call(0, 0, scManagerAllAccess)
+	if h == 0 {
+		return 0, err
+	}
+	return h, nil
+}
+
+func openService(name string) (mgr, svc uintptr, err error) {
+	mgr, err = openManager()
+	if err != nil {
+		return 0, 0, err
+	}
+	n, err := utf16(name)
+	if err != nil {
+		procCloseServiceHandle.Call(mgr)
+		return 0, 0, err
+	}
+	svc, _, err = procOpenServiceW.Call(mgr, uintptr(unsafe.Pointer(n)), serviceAllAccess)
+	runtime.KeepAlive(n)
+	if svc == 0 {
+		procCloseServiceHandle.Call(mgr)
+		return 0, 0, err
+	}
+	return mgr, svc, nil
+}
+
+// Install registers an auto-start service running exe with args, and an
+// event log source of the same name.
+func Install(name, displayName, exe string, args []string) error {
+	mgr, err := openManager()
+	if err != nil {
+		return err
+	}
+	defer procCloseServiceHandle.Call(mgr)
+
+	cmd := make([]string, 0, len(args)+1)
+	cmd = append(cmd, syscall.EscapeArg(exe))
+	for _, a := range args {
+		cmd = append(cmd, syscall.EscapeArg(a))
+	}
+
+	n, err := utf16(name)
+	if err != nil {
+		return err
+	}
+	dn, err := utf16(displayName)
+	if err != nil {
+		return err
+	}
+	bin, err := utf16(strings.Join(cmd, " "))
+	if err != nil {
+		return err
+	}
+	svc, _, err := procCreateServiceW.Call(mgr,
+		uintptr(unsafe.Pointer(n)), uintptr(unsafe.Pointer(dn)), serviceAllAccess,
+		serviceWin32OwnProcess, serviceAutoStart, serviceErrorNormal,
+		uintptr(unsafe.Pointer(bin)), 0, 0, 0, 0, 0)
+	runtime.KeepAlive(n)
+	runtime.KeepAlive(dn)
+	runtime.KeepAlive(bin)
+	if svc == 0 {
+		return err
+	}
+	procCloseServiceHandle.Call(svc)
+
+	return installEventSource(name)
+}
+
+// Uninstall removes the service and its event log source.
+func Uninstall(name string) error {
+	mgr, svc, err := openService(name)
+	if err != nil {
+		return err
+	}
+	defer procCloseServiceHandle.Call(mgr)
+	defer procCloseServiceHandle.Call(svc)
+
+	if r, _, err := procDeleteService.Call(svc); r == 0 {
+		return err
+	}
+	if key, err := utf16(eventLogKey + name); err == nil {
+		procRegDeleteKeyW.Call(hkeyLocalMachine, uintptr(unsafe.Pointer(key)))
+		runtime.KeepAlive(key)
+	}
+	return nil
+}
+
+// Start asks the service manager to start the service.
+func Start(name string) error {
+	mgr, svc, err := openService(name)
+	if err != nil {
+		return err
+	}
+	defer procCloseServiceHandle.Call(mgr)
+	defer procCloseServiceHandle.Call(svc)
+
+	if r, _, err := procStartServiceW.Call(svc, 0, 0); r == 0 {
+		return err
+	}
+	return nil
+}
+
+// Stop sends a stop request to the service.
+func Stop(name string) error {
+	mgr, svc, err := openService(name)
+	if err != nil {
+		return err
+	}
+	defer procCloseServiceHandle.Call(mgr)
+	defer procCloseServiceHandle.Call(svc)
+
+	var st serviceStatus
+	if r, _, err := procControlService.Call(svc, serviceControlStop, uintptr(unsafe.Pointer(&st))); r == 0 {
+		return err
+	}
+	return nil
+}
+
+// installEventSource registers name as an event source that uses the generic
+// EventCreate.exe message file, so logged strings display as-is.
+func installEventSource(name string) error {
+	path, err := utf16(eventLogKey + name)
+	if err != nil {
+		return err
+	}
+	var key uintptr
+	r, _, _ := procRegCreateKeyExW.Call(hkeyLocalMachine, uintptr(unsafe.Pointer(path)),
+		0, 0, 0, keyAllAccess, 0, uintptr(unsafe.Pointer(&key)), 0)
+	runtime.KeepAlive(path)
+	if r != 0 {
+		return syscall.Errno(r)
+	}
+	defer procRegCloseKey.Call(key)
+
+	msgFile, _ := syscall.UTF16FromString(`%SystemRoot%\System32\EventCreate.exe`)
+	msgName, _ := utf16("EventMessageFile")
+	r, _, _ = procRegSetValueExW.Call(key, uintptr(unsafe.Pointer(msgName)), 0, regExpandSZ,
+		uintptr(unsafe.Pointer(&msgFile[0])), uintptr(len(msgFile)*2))
+	runtime.KeepAlive(msgName)
+	runtime.KeepAlive(msgFile)
+	if r != 0 {
+		return syscall.Errno(r)
+	}
+	types := uint32(eventlogErrorType | eventlogWarningType | eventlogInformationType)
+	typesName, _ := utf16("TypesSupported")
+	r, _, _ = procRegSetValueExW.Call(key, uintptr(unsafe.Pointer(typesName)), 0, regDWORD,
+		uintptr(unsafe.Pointer(&types)), 4)
+	runtime.KeepAlive(typesName)
+	if r != 0 {
+		return syscall.Errno(r)
+	}
+	return nil
+}
+
+// EventLog writes messages to the Windows Application event log.
+type EventLog struct {
+	h uintptr
+}
+
+// OpenEventLog opens the event source installed for the service.
+func OpenEventLog(source string) (*EventLog, error) {
+	src, err := utf16(source)
+	if err != nil {
+		return nil, err
+	}
+	h, _, err := procRegisterEventSourceW.Call(0, uintptr(unsafe.Pointer(src)))
+	runtime.KeepAlive(src)
+	if h == 0 {
+		return nil, err
+	}
+	return &EventLog{h: h}, nil
+}
+
+func (l *EventLog) report(etype uint16, msg string) error {
+	s, err := utf16(strings.ReplaceAll(msg, "\x00", ""))
+	if err != nil {
+		return err
+	}
+	strs := [1]*uint16{s}
+	r, _, err := procReportEventW.Call(l.h, uintptr(etype), 0, 1, 0, 1, 0, uintptr(unsafe.Pointer(&strs[0])), 0)
+	runtime.KeepAlive(strs)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// Info logs an informational event.
+func (l *EventLog) Info(msg string) error { return l.report(eventlogInformationType, msg) }
+
+// Error logs an error event.
+func (l *EventLog) Error(msg string) error { return l.report(eventlogErrorType, msg) }
+
+// Close releases the event source.
+func (l *EventLog) Close() error {
+	procDeregisterEventSource.Call(l.h)
+	return nil
+}
+
+// running is the service driven by Run. The service control manager only
+// ever hosts one service per process here. mu guards cancel, which the
+// control handler calls from the dispatcher's thread.
+var running struct {
+	name   *uint16
+	run    func(ctx context.Context) error
+	status uintptr
+	err    error
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+// Run connects to the service control manager and calls run until it
+// returns or the service is stopped, in which case ctx is canceled. It must
+// be called from a process started by the service manager.
+func Run(name string, run func(ctx context.Context) error) error {
+	n, err := utf16(name)
+	if err != nil {
+		return err
+	}
+	running.name = n
+	running.run = run
+
+	table := []serviceTableEntry{
+		{ServiceName: running.name, ServiceProc: syscall.NewCallback(serviceMain)},
+		{},
+	}
+	r, _, err := procStartServiceCtrlDispatcherW.Call(uintptr(unsafe.Pointer(&table[0])))
+	runtime.KeepAlive(table)
+	if r == 0 {
+		return err
+	}
+	return running.err
+}
+
+// setStatus reports the state of the service. A nonzero exitCode is
+// reported as a service-specific error code.
+func setStatus(state, accepts, waitHint, exitCode uint32) {
+	st := serviceStatus{
+		ServiceType:      serviceWin32OwnProcess,
+		CurrentState:     state,
+		ControlsAccepted: accepts,
+		WaitHint:         waitHint,
+	}
+	if exitCode != 0 {
+		st.Win32ExitCode = errorServiceSpecificError
+		st.ServiceSpecificExitCode = exitCode
+	}
+	procSetServiceStatus.Call(running.status, uintptr(unsafe.Pointer(&st)))
+}
+
+func serviceMain(argc, argv uintptr) uintptr {
+	// The context exists before the control handler is registered, so a
+	// stop that arrives at once still finds something to cancel.
+	ctx, cancel := context.WithCancel(context.Background())
+	running.mu.Lock()
+	running.cancel = cancel
+	running.mu.Unlock()
+
+	h, _, err := procRegisterServiceCtrlHandlerExW.Call(uintptr(unsafe.Pointer(running.name)), syscall.NewCallback(controlHandler), 0)
+	if h == 0 {
+		cancel()
+		running.err = err
+		return 0
+	}
+	running.status = h
+
+	setStatus(serviceStartPending, 0, uint32((10 * time.Second).Milliseconds()), 0)
+	setStatus(serviceRunning, serviceAcceptStop|serviceAcceptShutdown, 0, 0)
+
+	running.err = running.run(ctx)
+	cancel()
+
+	var exitCode uint32
+	if running.err != nil && !errors.Is(running.err, context.Canceled) {
+		exitCode = serviceSpecificExitFailure
+	}
+	setStatus(serviceStopped, 0, 0, exitCode)
+	return 0
+}
+
+func controlHandler(ctrl, eventType, eventData, context uintptr) uintptr {
+	switch ctrl {
+	case serviceControlStop, serviceControlShutdown:
+		setStatus(serviceStopPending, 0, uint32((30 * time.Second).Milliseconds()), 0)
+		running.mu.Lock()
+		cancel := running.cancel
+		running.mu.Unlock()
+		if cancel != nil {
+			cancel()
+		}
+		return 0
+	case serviceControlInterrogate:
+		return 0
+	}
+	return errorCallNotImplemented
+}
+
+// Supported reports whether service management is available on this platform.
+func Supported() bool { return true }
@@ -0,0 +1,10 @@
+// Package winsvc runs mcis as a Windows service: it installs and controls the
+// service through the service control manager, answers control requests and
+// logs to the Windows event log. On other platforms every call fails with
+// ErrNotSupported.
+package winsvc
+
+import "errors"
+
+// ErrNotSupported is returned on platforms without Windows services.
+var ErrNotSupported = errors.New("windows services are not supported on this platform")
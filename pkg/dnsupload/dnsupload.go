@@ -0,0 +1,119 @@
+// Package dnsupload publishes IPs as the A and AAAA records of a DNS name at
+// Cloudflare or Vercel, the way mcis --dns-provider does, for Go programs
+// that embed it.
+//
+//	p, err := dnsupload.New(dnsupload.Config{
+//		Provider: "cloudflare",
+//		Token:    token,
+//		Zone:     zoneID,
+//	})
+//	if err != nil { ... }
+//	err = dnsupload.Upload(ctx, p, "cf", ips)
+//
+// The package API is stable; the provider implementations behind it may
+// change between releases.
+package dnsupload
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/netip"
+	"slices"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/dns"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/ratelimit"
+)
+
+// Provider manages the records of one zone.
+type Provider = dns.Provider
+
+// Record is an A or AAAA record, as returned by Provider.ListRecords.
+type Record = dns.Record
+
+// Updater is implemented by the providers that can change a record in
+// place; see CanUpdate.
+type Updater = dns.Updater
+
+// Namer is implemented by the providers that can tell the full domain name
+// of a subdomain.
+type Namer = dns.Namer
+
+// APIError is an error response of a provider's API, with its HTTP status
+// and provider error code.
+type APIError = dns.APIError
+
+// Config configures a provider. Empty credentials fall back to the same
+// environment variables as the mcis command: CF_API_TOKEN and CF_ZONE_ID,
+// or VERCEL_TOKEN, VERCEL_DOMAIN and VERCEL_TEAM_ID.
+type Config struct {
+	// Provider is one of ProviderNames.
+	Provider string
+	Token    string
+	// Zone is the zone ID at Cloudflare and the domain at Vercel.
+	Zone string
+	// TeamID is the Vercel team, if any.
+	TeamID string
+
+	// RequestsPerSecond paces the API requests (0 = unlimited).
+	RequestsPerSecond float64
+	// HTTPClient, if set, sends the API requests, e.g. through a proxy of
+	// the caller's choosing.
+	HTTPClient *http.Client
+	// APIBase, if set, is the base URL of the provider's API, e.g. a
+	// gateway in front of it, instead of the public one.
+	APIBase string
+	// CAFile, if set, is a PEM file of the CAs that verify the API server
+	// instead of the system roots; it is ignored with HTTPClient.
+	CAFile string
+}
+
+// ProviderNames lists the providers New accepts.
+func ProviderNames() []string {
+	return slices.Clone(dns.ProviderNames)
+}
+
+// New creates the provider of cfg.
+func New(cfg Config) (Provider, error) {
+	rate := cfg.RequestsPerSecond
+	return dns.NewProvider(dns.Config{
+		Provider:   cfg.Provider,
+		Token:      cfg.Token,
+		Zone:       cfg.Zone,
+		TeamID:     cfg.TeamID,
+		Limiter:    ratelimit.New(rate, int(math.Ceil(rate))),
+		HTTPClient: cfg.HTTPClient,
+		APIBase:    cfg.APIBase,
+		CAFile:     cfg.CAFile,
+	})
+}
+
+// Upload replaces the A records of subdomain with the IPv4 addresses in ips
+// and its AAAA records with the IPv6 ones. A family without addresses
+// keeps its records. Each step is logged to slog.Default() at debug level.
+func Upload(ctx context.Context, p Provider, subdomain string, ips []netip.Addr) error {
+	return dns.Upload(ctx, p, subdomain, ips)
+}
+
+// CanUpdate reports whether p can change records in place with
+// UpdateRecord.
+func CanUpdate(p Provider) bool {
+	return dns.CanUpdate(p)
+}
+
+// UpdateRecord points rec at ip, an address of the same family. It fails
+// with errors.ErrUnsupported if p cannot update records.
+func UpdateRecord(ctx context.Context, p Provider, rec Record, ip netip.Addr) error {
+	return dns.UpdateRecord(ctx, p, rec, ip)
+}
+
+// IsRetryable reports whether err, as returned by a provider, is worth
+// retrying later: rate limiting, a server-side failure or a network error.
+func IsRetryable(err error) bool {
+	return dns.IsRetryable(err)
+}
+
+// Prune deletes all A and AAAA records of subdomain.
+func Prune(ctx context.Context, p Provider, subdomain string) error {
+	return dns.Prune(ctx, p, subdomain)
+}
@@ -0,0 +1,209 @@
+// Package search embeds the mcis IP search in other Go programs: it probes
+// the IPs of a set of CIDRs with HTTPS requests and finds the fastest ones,
+// the way the mcis command does, without exec'ing it.
+//
+//	s, err := search.New(search.Config{
+//		CIDRs: []netip.Prefix{netip.MustParsePrefix("104.16.0.0/13")},
+//		Host:  "example.com",
+//	})
+//	if err != nil { ... }
+//	top, err := s.Run(ctx)
+//
+// The package API is stable; the engine behind it may change between
+// releases.
+package search
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/cidr"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/engine"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/logging"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/probe"
+	"github.com/Leo-Mu/montecarlo-ip-searcher/internal/ratelimit"
+)
+
+// Result is one probed IP, as mcis writes it with --out jsonl.
+type Result = engine.TopResult
+
+// Stats aggregates every probe of a search.
+type Stats = engine.Stats
+
+// Progress is a point-in-time view of a running search.
+type Progress = engine.Progress
+
+// ParseError is a malformed entry of a range list, with its position.
+type ParseError = cidr.ParseError
+
+// ParseRanges parses a list of CIDRs, single IPs and ranges such as
+// 1.0.0.1-1.0.0.255, separated by commas, spaces or newlines, with # comments,
+// into the prefixes of Config.CIDRs; a range becomes the fewest prefixes
+// that cover it exactly. Errors are *ParseError.
+func ParseRanges(s string) ([]netip.Prefix, error) {
+	return cidr.ParseRanges(s)
+}
+
+// Config configures a search. Zero values take the defaults of the mcis
+// command.
+type Config struct {
+	// CIDRs are the prefixes to search, IPv4 and IPv6 alike; ParseRanges
+	// reads them from text.
+	CIDRs []netip.Prefix
+
+	// Budget is the number of IPs to probe (default 2000).
+	Budget int
+	// TopN is the number of results to return (default 20).
+	TopN int
+	// Concurrency is the number of probes in flight (default 200).
+	Concurrency int
+	// PPS caps the probes started per second (0 = unlimited).
+	PPS float64
+
+	// Host is the TLS server name and HTTP Host header of the probes
+	// (default example.com); SNI and HostHeader override either one.
+	Host       string
+	SNI        string
+	HostHeader string
+	// Path is the HTTP path probed (default /cdn-cgi/trace).
+	Path string
+	// Timeout bounds one probe request (default 3s).
+	Timeout time.Duration
+	// Rounds is the number of requests per IP (default 6), of which the
+	// first SkipFirst, which pay for the handshakes, are not counted
+	// (default 1; negative counts every round).
+	Rounds    int
+	SkipFirst int
+	// Dialer, if set, makes the probe connections, e.g. from a given source
+	// address or interface.
+	Dialer *net.Dialer
+
+	// Colo keeps only the results from these CDN colos, ColoExclude drops
+	// those from these ones; both are upper-case codes such as "HKG".
+	Colo        []string
+	ColoExclude []string
+
+	// Seed makes the sampling reproducible (0 = random).
+	Seed int64
+
+	// OnResult, if set, is called with every probe result, from one
+	// goroutine at a time.
+	OnResult func(Result)
+
+	// Logger receives the progress of the search at slog.LevelDebug and a
+	// line per probe at LevelTrace (default slog.Default()).
+	Logger *slog.Logger
+}
+
+// LevelTrace is the level of the per-probe log lines, below
+// slog.LevelDebug.
+const LevelTrace = logging.LevelTrace
+
+// Searcher runs one search.
+type Searcher struct {
+	eng *engine.Engine
+	req engine.Request
+	res engine.Response
+}
+
+// New validates cfg and prepares the search.
+func New(cfg Config) (*Searcher, error) {
+	if len(cfg.CIDRs) == 0 {
+		return nil, errors.New("search: no CIDRs")
+	}
+	for _, p := range cfg.CIDRs {
+		if !p.IsValid() {
+			return nil, errors.New("search: invalid prefix in CIDRs")
+		}
+	}
+	if cfg.Host == "" {
+		cfg.Host = "example.com"
+	}
+	if cfg.SNI == "" {
+		cfg.SNI = cfg.Host
+	}
+	if cfg.HostHeader == "" {
+		cfg.HostHeader = cfg.Host
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 3 * time.Second
+	}
+	if cfg.Rounds <= 0 {
+		cfg.Rounds = 6
+	}
+	switch {
+	case cfg.SkipFirst == 0:
+		cfg.SkipFirst = 1
+	case cfg.SkipFirst < 0:
+		cfg.SkipFirst = 0
+	}
+
+	ecfg := engine.DefaultConfig()
+	ecfg.OnResult = cfg.OnResult
+	ecfg.Logger = cfg.Logger
+	ecfg.Seed = cfg.Seed
+	ecfg.ColoAllow, ecfg.ColoBlock = cfg.Colo, cfg.ColoExclude
+	ecfg.Limiter = ratelimit.New(cfg.PPS, 1)
+	if cfg.Budget > 0 {
+		ecfg.Budget = cfg.Budget
+	}
+	if cfg.TopN > 0 {
+		ecfg.TopN = cfg.TopN
+	}
+	if cfg.Concurrency > 0 {
+		ecfg.Concurrency = cfg.Concurrency
+	}
+	if err := ecfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	probeCfg := probe.Config{
+		Timeout:    cfg.Timeout,
+		SNI:        cfg.SNI,
+		HostHeader: cfg.HostHeader,
+		Path:       cfg.Path,
+		Rounds:     cfg.Rounds,
+		SkipFirst:  cfg.SkipFirst,
+		Dialer:     cfg.Dialer,
+	}
+	return &Searcher{
+		eng: engine.New(ecfg, probeCfg),
+		req: engine.Request{CIDRs: cfg.CIDRs, Probe: probeCfg},
+	}, nil
+}
+
+// Run searches and returns the best results, fastest first. Canceling ctx
+// stops the search early; Run then returns the best results so far and
+// ctx's error.
+func (s *Searcher) Run(ctx context.Context) ([]Result, error) {
+	res, err := s.eng.Run(ctx, s.req)
+	if err != nil {
+		return nil, err
+	}
+	s.res = res
+	return res.Top, ctx.Err()
+}
+
+// Progress reports how far the search is. It is safe to call while Run is
+// in progress.
+func (s *Searcher) Progress() Progress {
+	return s.eng.Progress()
+}
+
+// Stats returns the statistics over all probes of the last Run.
+func (s *Searcher) Stats() Stats {
+	return s.res.Stats
+}
+
+// Search is New followed by Run.
+func Search(ctx context.Context, cfg Config) ([]Result, error) {
+	s, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return s.Run(ctx)
+}